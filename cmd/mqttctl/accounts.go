@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"mosquitto-plugin/internal/pluginutil"
+)
+
+const (
+	selectAccountsSQL = `
+SELECT user_name, clientid, password_hash, salt, enabled, created_at
+FROM mqtt_accounts`
+
+	insertAccountSQL = `
+INSERT INTO mqtt_accounts (user_name, clientid, password_hash, salt, enabled)
+VALUES ($1, $2, $3, $4, $5)`
+
+	updateAccountEnabledSQL  = `UPDATE mqtt_accounts SET enabled=$2 WHERE user_name=$1`
+	updateAccountPasswordSQL = `UPDATE mqtt_accounts SET password_hash=$2, salt=$3 WHERE user_name=$1`
+	updateAccountClientIDSQL = `UPDATE mqtt_accounts SET clientid=$2 WHERE user_name=$1`
+	deleteAccountSQL         = `DELETE FROM mqtt_accounts WHERE user_name=$1`
+)
+
+// upsertAccountSQL 返回 -update 使用的 upsert：密文与盐总是覆盖，clientid/enabled 仅在输入提供时覆盖，
+// 否则保留已有账户的值。
+func upsertAccountSQL(clientID, enabled bool) string {
+	set := []string{"password_hash = EXCLUDED.password_hash", "salt = EXCLUDED.salt"}
+	if clientID {
+		set = append(set, "clientid = EXCLUDED.clientid")
+	}
+	if enabled {
+		set = append(set, "enabled = EXCLUDED.enabled")
+	}
+	return insertAccountSQL + `
+ON CONFLICT (user_name) DO UPDATE
+  SET ` + strings.Join(set, ",\n      ")
+}
+
+// generatedPasswordLen 是随机密码的默认长度。
+const generatedPasswordLen = 20
+
+// passwordAlphabet 去掉了易混淆字符（0/O、1/l/I）。
+const passwordAlphabet = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// account 对应 mqtt_accounts 的一行。
+type account struct {
+	UserName     string
+	ClientID     string
+	PasswordHash string
+	Salt         string
+	Enabled      int16
+	CreatedAt    time.Time
+}
+
+func runAccounts(args []string) error {
+	return subcommand("accounts", args, map[string]func([]string) error{
+		"create":        accountsCreate,
+		"enable":        func(args []string) error { return accountsSetEnabled("enable", args, 1) },
+		"disable":       func(args []string) error { return accountsSetEnabled("disable", args, 0) },
+		"set-password":  accountsSetPassword,
+		"bind-clientid": accountsBindClientID,
+		"list":          accountsList,
+		"show":          accountsShow,
+		"delete":        accountsDelete,
+		"import":        accountsImport,
+		"export":        accountsExport,
+	}, []string{"create", "enable", "disable", "set-password", "bind-clientid", "list", "show", "delete", "import", "export"})
+}
+
+// passwordFlags 描述密码来源：-password、-password-stdin 或 -generate（三选一）。
+type passwordFlags struct {
+	password string
+	stdin    bool
+	generate bool
+}
+
+func (f *passwordFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.password, "password", "", "明文密码（会出现在 shell 历史中，建议使用 -password-stdin 或 -generate）")
+	fs.BoolVar(&f.stdin, "password-stdin", false, "从标准输入读取密码（第一行）")
+	fs.BoolVar(&f.generate, "generate", false, "生成随机密码并输出")
+}
+
+// resolve 返回明文密码；generated=true 时调用方需要把密码输出给用户。
+func (f *passwordFlags) resolve(stdin io.Reader) (password string, generated bool, err error) {
+	n := 0
+	for _, set := range []bool{f.password != "", f.stdin, f.generate} {
+		if set {
+			n++
+		}
+	}
+	if n != 1 {
+		return "", false, errors.New("exactly one of -password, -password-stdin, -generate is required")
+	}
+	switch {
+	case f.generate:
+		p, err := generatePassword(generatedPasswordLen)
+		return p, true, err
+	case f.stdin:
+		line, err := bufio.NewReader(stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", false, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			return "", false, errors.New("empty password on stdin")
+		}
+		return line, false, nil
+	default:
+		return f.password, false, nil
+	}
+}
+
+// generatePassword 使用 crypto/rand 生成随机密码。
+func generatePassword(n int) (string, error) {
+	max := big.NewInt(int64(len(passwordAlphabet)))
+	b := make([]byte, n)
+	for i := range b {
+		idx, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = passwordAlphabet[idx.Int64()]
+	}
+	return string(b), nil
+}
+
+// userFlag 注册必填的 -user 参数。
+func userFlag(fs *flag.FlagSet) *string {
+	return fs.String("user", "", "用户名（mqtt_accounts.user_name，必填）")
+}
+
+func requireUser(user string) error {
+	if user == "" {
+		return errors.New("-user is required")
+	}
+	return nil
+}
+
+// execAccount 执行单行更新，账户不存在时返回错误。
+func execAccount(ctx context.Context, conn *pgx.Conn, user, sql string, args ...any) error {
+	tag, err := conn.Exec(ctx, sql, append([]any{user}, args...)...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("account %q not found", user)
+	}
+	return nil
+}
+
+func accountsCreate(args []string) error {
+	fs := flag.NewFlagSet("accounts create", flag.ContinueOnError)
+	var db dbFlags
+	var pw passwordFlags
+	db.register(fs)
+	pw.register(fs)
+	user := userFlag(fs)
+	clientID := fs.String("clientid", "", "绑定的 client_id（默认不限制）")
+	disabled := fs.Bool("disabled", false, "创建为禁用状态")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireUser(*user); err != nil {
+		return err
+	}
+	password, generated, err := pw.resolve(os.Stdin)
+	if err != nil {
+		return err
+	}
+	hash, salt, err := pluginutil.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	enabled := int16(1)
+	if *disabled {
+		enabled = 0
+	}
+
+	conn, ctx, done, err := db.connect()
+	if err != nil {
+		return err
+	}
+	defer done()
+	if _, err := conn.Exec(ctx, insertAccountSQL, *user, pluginutil.OptionalString(*clientID), hash, salt, enabled); err != nil {
+		if isUniqueViolation(err) {
+			return fmt.Errorf("account %q already exists", *user)
+		}
+		return err
+	}
+	fmt.Fprintf(stdout, "created %s\n", *user)
+	if generated {
+		fmt.Fprintf(stdout, "password: %s\n", password)
+	}
+	return nil
+}
+
+func accountsSetEnabled(name string, args []string, enabled int16) error {
+	fs := flag.NewFlagSet("accounts "+name, flag.ContinueOnError)
+	var db dbFlags
+	db.register(fs)
+	user := userFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireUser(*user); err != nil {
+		return err
+	}
+	conn, ctx, done, err := db.connect()
+	if err != nil {
+		return err
+	}
+	defer done()
+	if err := execAccount(ctx, conn, *user, updateAccountEnabledSQL, enabled); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%sd %s\n", name, *user)
+	return nil
+}
+
+func accountsSetPassword(args []string) error {
+	fs := flag.NewFlagSet("accounts set-password", flag.ContinueOnError)
+	var db dbFlags
+	var pw passwordFlags
+	db.register(fs)
+	pw.register(fs)
+	user := userFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireUser(*user); err != nil {
+		return err
+	}
+	password, generated, err := pw.resolve(os.Stdin)
+	if err != nil {
+		return err
+	}
+	hash, salt, err := pluginutil.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	conn, ctx, done, err := db.connect()
+	if err != nil {
+		return err
+	}
+	defer done()
+	if err := execAccount(ctx, conn, *user, updateAccountPasswordSQL, hash, salt); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "password updated for %s\n", *user)
+	if generated {
+		fmt.Fprintf(stdout, "password: %s\n", password)
+	}
+	return nil
+}
+
+func accountsBindClientID(args []string) error {
+	fs := flag.NewFlagSet("accounts bind-clientid", flag.ContinueOnError)
+	var db dbFlags
+	db.register(fs)
+	user := userFlag(fs)
+	clientID := fs.String("clientid", "", "绑定的 client_id")
+	unbind := fs.Bool("unbind", false, "解除绑定（允许任意 client_id）")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireUser(*user); err != nil {
+		return err
+	}
+	if (*clientID == "") == !*unbind {
+		return errors.New("exactly one of -clientid, -unbind is required")
+	}
+	conn, ctx, done, err := db.connect()
+	if err != nil {
+		return err
+	}
+	defer done()
+	if err := execAccount(ctx, conn, *user, updateAccountClientIDSQL, pluginutil.OptionalString(*clientID)); err != nil {
+		return err
+	}
+	if *unbind {
+		fmt.Fprintf(stdout, "unbound %s\n", *user)
+	} else {
+		fmt.Fprintf(stdout, "bound %s to %s\n", *user, *clientID)
+	}
+	return nil
+}
+
+// queryAccounts 读取账户，where 为空时返回全部。
+func queryAccounts(ctx context.Context, conn *pgx.Conn, where string, args ...any) ([]account, error) {
+	sql := selectAccountsSQL
+	if where != "" {
+		sql += "\nWHERE " + where
+	}
+	rows, err := conn.Query(ctx, sql+"\nORDER BY user_name", args...)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (account, error) {
+		var a account
+		var clientID *string
+		err := row.Scan(&a.UserName, &clientID, &a.PasswordHash, &a.Salt, &a.Enabled, &a.CreatedAt)
+		if clientID != nil {
+			a.ClientID = *clientID
+		}
+		return a, err
+	})
+}
+
+func accountsList(args []string) error {
+	fs := flag.NewFlagSet("accounts list", flag.ContinueOnError)
+	var db dbFlags
+	db.register(fs)
+	state := fs.String("state", "all", "过滤：all / enabled / disabled")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	var where string
+	switch *state {
+	case "all":
+	case "enabled":
+		where = "enabled <> 0"
+	case "disabled":
+		where = "enabled = 0"
+	default:
+		return fmt.Errorf("invalid -state %q", *state)
+	}
+	conn, ctx, done, err := db.connect()
+	if err != nil {
+		return err
+	}
+	defer done()
+	accounts, err := queryAccounts(ctx, conn, where)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "USER\tCLIENTID\tENABLED\tCREATED_AT")
+	for _, a := range accounts {
+		fmt.Fprintf(w, "%s\t%s\t%t\t%s\n", a.UserName, orDash(a.ClientID), a.Enabled != 0, a.CreatedAt.UTC().Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+func accountsShow(args []string) error {
+	fs := flag.NewFlagSet("accounts show", flag.ContinueOnError)
+	var db dbFlags
+	db.register(fs)
+	user := userFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireUser(*user); err != nil {
+		return err
+	}
+	conn, ctx, done, err := db.connect()
+	if err != nil {
+		return err
+	}
+	defer done()
+	accounts, err := queryAccounts(ctx, conn, "user_name=$1", *user)
+	if err != nil {
+		return err
+	}
+	if len(accounts) == 0 {
+		return fmt.Errorf("account %q not found", *user)
+	}
+	a := accounts[0]
+	fmt.Fprintf(stdout, "user_name:  %s\nclientid:   %s\nenabled:    %t\ncreated_at: %s\n",
+		a.UserName, orDash(a.ClientID), a.Enabled != 0, a.CreatedAt.UTC().Format(time.RFC3339))
+	return nil
+}
+
+func accountsDelete(args []string) error {
+	fs := flag.NewFlagSet("accounts delete", flag.ContinueOnError)
+	var db dbFlags
+	db.register(fs)
+	user := userFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireUser(*user); err != nil {
+		return err
+	}
+	conn, ctx, done, err := db.connect()
+	if err != nil {
+		return err
+	}
+	defer done()
+	if err := execAccount(ctx, conn, *user, deleteAccountSQL); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "deleted %s\n", *user)
+	return nil
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// isUniqueViolation 判断是否为唯一约束冲突（23505）。
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"mosquitto-plugin/internal/pluginutil"
+)
+
+// accountsExportHeader 是 export 输出的列，import 可直接读回。
+var accountsExportHeader = []string{"user_name", "clientid", "password_hash", "salt", "enabled", "created_at"}
+
+// importRow 是 CSV 中的一行账户。
+type importRow struct {
+	Line      int
+	UserName  string
+	ClientID  string
+	Password  string
+	Hash      string
+	Salt      string
+	Enabled   int16
+	Generated bool
+	// HasClientID/HasEnabled 表示 CSV 含对应列；-update 时缺少的列不覆盖已有账户。
+	HasClientID bool
+	HasEnabled  bool
+}
+
+// parseAccountsCSV 解析带表头的账户 CSV。
+// 必须包含 user_name；密码取 password（明文，导入时哈希）或 password_hash+salt（已有密文，
+// mosquitto 格式的 $6$/$7$ 密文自带盐，salt 为空），
+// 两者都为空时 generate=true 生成随机密码，否则报错。enabled 为空时新账户默认启用。
+func parseAccountsCSV(r io.Reader, generate bool) ([]importRow, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("empty csv")
+		}
+		return nil, err
+	}
+	cols := map[string]int{}
+	for i, h := range header {
+		name := strings.ToLower(strings.TrimSpace(h))
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		cols[name] = i
+	}
+	if _, ok := cols["user_name"]; !ok {
+		return nil, errors.New("csv header must contain user_name")
+	}
+	_, hasClientID := cols["clientid"]
+	_, hasEnabled := cols["enabled"]
+	get := func(rec []string, name string) string {
+		if i, ok := cols[name]; ok && i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+
+	var out []importRow
+	seen := map[string]int{}
+	for {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		row := importRow{
+			Line:        line,
+			UserName:    get(rec, "user_name"),
+			ClientID:    get(rec, "clientid"),
+			Password:    get(rec, "password"),
+			Hash:        get(rec, "password_hash"),
+			Salt:        get(rec, "salt"),
+			Enabled:     1,
+			HasClientID: hasClientID,
+			HasEnabled:  hasEnabled,
+		}
+		if row.UserName == "" {
+			return nil, fmt.Errorf("line %d: empty user_name", line)
+		}
+		if prev, dup := seen[row.UserName]; dup {
+			return nil, fmt.Errorf("line %d: duplicate user_name %q (first at line %d)", line, row.UserName, prev)
+		}
+		seen[row.UserName] = line
+		if v := get(rec, "enabled"); v != "" {
+			enabled, ok := pluginutil.ParseBoolOption(v)
+			if !ok {
+				return nil, fmt.Errorf("line %d: invalid enabled %q", line, v)
+			}
+			if !enabled {
+				row.Enabled = 0
+			}
+		}
+		switch {
+		case row.Password != "":
+			if row.Hash != "" {
+				return nil, fmt.Errorf("line %d: password and password_hash are mutually exclusive", line)
+			}
+		case row.Hash != "":
+			if row.Salt == "" && !pluginutil.IsMosquittoHash(row.Hash) {
+				return nil, fmt.Errorf("line %d: password_hash requires salt", line)
+			}
+		case generate:
+			p, err := generatePassword(generatedPasswordLen)
+			if err != nil {
+				return nil, err
+			}
+			row.Password, row.Generated = p, true
+		default:
+			return nil, fmt.Errorf("line %d: no password for %q (use -generate)", line, row.UserName)
+		}
+		if row.Password != "" {
+			if row.Hash, row.Salt, err = pluginutil.HashPassword(row.Password); err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, row)
+	}
+	return out, nil
+}
+
+// writeAccountsCSV 输出 export 格式的 CSV。
+func writeAccountsCSV(w io.Writer, accounts []account) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(accountsExportHeader); err != nil {
+		return err
+	}
+	for _, a := range accounts {
+		if err := cw.Write([]string{
+			a.UserName,
+			a.ClientID,
+			a.PasswordHash,
+			a.Salt,
+			strconv.Itoa(int(a.Enabled)),
+			a.CreatedAt.UTC().Format(time.RFC3339),
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func accountsImport(args []string) error {
+	fs := flag.NewFlagSet("accounts import", flag.ContinueOnError)
+	var db dbFlags
+	db.register(fs)
+	file := fs.String("file", "", "CSV 文件（- 表示标准输入，必填）")
+	update := fs.Bool("update", false, "已存在的账户覆盖更新（默认冲突即失败）")
+	generate := fs.Bool("generate", false, "未提供密码的行生成随机密码")
+	passwordsOut := fs.String("passwords-out", "", "生成的密码写入该 CSV 文件（默认标准输出）")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return errors.New("-file is required")
+	}
+	in := io.Reader(os.Stdin)
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	rows, err := parseAccountsCSV(in, *generate)
+	if err != nil {
+		return err
+	}
+
+	conn, ctx, done, err := db.connect()
+	if err != nil {
+		return err
+	}
+	defer done()
+	// 全部导入在一个事务内，任一行失败则整体回滚。
+	err = pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		for _, r := range rows {
+			sql := insertAccountSQL
+			if *update {
+				sql = upsertAccountSQL(r.HasClientID, r.HasEnabled)
+			}
+			if _, err := tx.Exec(ctx, sql, r.UserName, pluginutil.OptionalString(r.ClientID), r.Hash, r.Salt, r.Enabled); err != nil {
+				if isUniqueViolation(err) {
+					return fmt.Errorf("line %d: account %q already exists (use -update)", r.Line, r.UserName)
+				}
+				return fmt.Errorf("line %d: %w", r.Line, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "imported %d accounts\n", len(rows))
+	return writeGeneratedPasswords(rows, *passwordsOut)
+}
+
+// writeGeneratedPasswords 输出生成的密码（user_name,password），没有生成时不输出。
+func writeGeneratedPasswords(rows []importRow, path string) error {
+	var generated [][]string
+	for _, r := range rows {
+		if r.Generated {
+			generated = append(generated, []string{r.UserName, r.Password})
+		}
+	}
+	if len(generated) == 0 {
+		return nil
+	}
+	out := stdout
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	cw := csv.NewWriter(out)
+	_ = cw.Write([]string{"user_name", "password"})
+	_ = cw.WriteAll(generated)
+	return cw.Error()
+}
+
+func accountsExport(args []string) error {
+	fs := flag.NewFlagSet("accounts export", flag.ContinueOnError)
+	var db dbFlags
+	db.register(fs)
+	file := fs.String("file", "", "输出文件（默认标准输出）")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	conn, ctx, done, err := db.connect()
+	if err != nil {
+		return err
+	}
+	defer done()
+	accounts, err := queryAccounts(ctx, conn, "")
+	if err != nil {
+		return err
+	}
+	out := stdout
+	if *file != "" {
+		// 导出包含密文与盐，按敏感文件权限创建。
+		f, err := os.OpenFile(*file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	return writeAccountsCSV(out, accounts)
+}
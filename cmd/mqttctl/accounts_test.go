@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"mosquitto-plugin/internal/pluginutil"
+)
+
+func TestGeneratePassword(t *testing.T) {
+	p, err := generatePassword(generatedPasswordLen)
+	if err != nil {
+		t.Fatalf("generatePassword: %v", err)
+	}
+	if len(p) != generatedPasswordLen {
+		t.Fatalf("len = %d", len(p))
+	}
+	for _, c := range p {
+		if !strings.ContainsRune(passwordAlphabet, c) {
+			t.Fatalf("unexpected char %q", c)
+		}
+	}
+	q, _ := generatePassword(generatedPasswordLen)
+	if p == q {
+		t.Fatal("passwords should be random")
+	}
+}
+
+func TestPasswordFlagsResolve(t *testing.T) {
+	if _, _, err := (&passwordFlags{}).resolve(nil); err == nil {
+		t.Fatal("missing password source should fail")
+	}
+	if _, _, err := (&passwordFlags{password: "x", generate: true}).resolve(nil); err == nil {
+		t.Fatal("multiple password sources should fail")
+	}
+	p, generated, err := (&passwordFlags{stdin: true}).resolve(strings.NewReader("s3cret\r\nignored\n"))
+	if err != nil || p != "s3cret" || generated {
+		t.Fatalf("stdin resolve = %q, %v, %v", p, generated, err)
+	}
+	p, generated, err = (&passwordFlags{generate: true}).resolve(nil)
+	if err != nil || len(p) != generatedPasswordLen || !generated {
+		t.Fatalf("generate resolve = %q, %v, %v", p, generated, err)
+	}
+}
+
+func TestParseAccountsCSV(t *testing.T) {
+	in := "\ufeffuser_name,clientid,password,password_hash,salt,enabled\n" +
+		"alice,,alice-pw,,,\n" +
+		"bob,dev-1,,abcd,s1,0\n" +
+		"carol,,,,,yes\n"
+	rows, err := parseAccountsCSV(strings.NewReader(in), true)
+	if err != nil {
+		t.Fatalf("parseAccountsCSV: %v", err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("rows = %d", len(rows))
+	}
+	alice, bob, carol := rows[0], rows[1], rows[2]
+	if !pluginutil.VerifyPassword("alice-pw", alice.Hash, alice.Salt) || alice.Enabled != 1 || alice.Generated {
+		t.Fatalf("alice = %+v", alice)
+	}
+	if bob.ClientID != "dev-1" || bob.Hash != "abcd" || bob.Salt != "s1" || bob.Enabled != 0 || bob.Line != 3 {
+		t.Fatalf("bob = %+v", bob)
+	}
+	if !carol.Generated || !pluginutil.VerifyPassword(carol.Password, carol.Hash, carol.Salt) {
+		t.Fatalf("carol = %+v", carol)
+	}
+	if !alice.HasClientID || !alice.HasEnabled {
+		t.Fatalf("columns not detected: %+v", alice)
+	}
+
+	// 缺少 clientid/enabled 列时 -update 保留已有值（例如不会重新启用已禁用的账户）。
+	rows, err = parseAccountsCSV(strings.NewReader("user_name,password\ndave,dave-pw\n"), false)
+	if err != nil {
+		t.Fatalf("parseAccountsCSV: %v", err)
+	}
+	if dave := rows[0]; dave.HasClientID || dave.HasEnabled || dave.Enabled != 1 {
+		t.Fatalf("dave = %+v", dave)
+	}
+}
+
+func TestParseAccountsCSVErrors(t *testing.T) {
+	cases := map[string]string{
+		"no header":         "",
+		"missing user_name": "name,password\nalice,x\n",
+		"empty user":        "user_name,password\n,x\n",
+		"duplicate":         "user_name,password\nalice,x\nalice,y\n",
+		"no password":       "user_name,password\nalice,\n",
+		"hash without salt": "user_name,password_hash\nalice,abcd\n",
+		"both passwords":    "user_name,password,password_hash,salt\nalice,x,abcd,s\n",
+		"bad enabled":       "user_name,password,enabled\nalice,x,maybe\n",
+	}
+	for name, in := range cases {
+		if _, err := parseAccountsCSV(strings.NewReader(in), false); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
+
+func TestAccountsCSVRoundTrip(t *testing.T) {
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	var buf bytes.Buffer
+	err := writeAccountsCSV(&buf, []account{
+		{UserName: "alice", PasswordHash: "h1", Salt: "s1", Enabled: 1, CreatedAt: created},
+		{UserName: "bob", ClientID: "dev-1", PasswordHash: "h2", Salt: "s2", Enabled: 0, CreatedAt: created},
+		// 从 password_file 导入的 mosquitto 密文自带盐，salt 为空。
+		{UserName: "carol", PasswordHash: "$7$101$c2FsdA==$aGFzaA==", Enabled: 1, CreatedAt: created},
+	})
+	if err != nil {
+		t.Fatalf("writeAccountsCSV: %v", err)
+	}
+	want := "user_name,clientid,password_hash,salt,enabled,created_at\n" +
+		"alice,,h1,s1,1,2026-01-02T03:04:05Z\n" +
+		"bob,dev-1,h2,s2,0,2026-01-02T03:04:05Z\n" +
+		"carol,,$7$101$c2FsdA==$aGFzaA==,,1,2026-01-02T03:04:05Z\n"
+	if buf.String() != want {
+		t.Fatalf("csv = %q", buf.String())
+	}
+
+	rows, err := parseAccountsCSV(&buf, false)
+	if err != nil {
+		t.Fatalf("re-import: %v", err)
+	}
+	if rows[0].Hash != "h1" || rows[1].ClientID != "dev-1" || rows[1].Enabled != 0 || rows[2].Hash != "$7$101$c2FsdA==$aGFzaA==" || rows[2].Salt != "" {
+		t.Fatalf("re-imported rows = %+v", rows)
+	}
+}
+
+func TestUpsertAccountSQL(t *testing.T) {
+	full := upsertAccountSQL(true, true)
+	for _, col := range []string{"password_hash", "salt", "clientid", "enabled"} {
+		if !strings.Contains(full, col+" = EXCLUDED."+col) {
+			t.Fatalf("full upsert should update %s:\n%s", col, full)
+		}
+	}
+	// 输入未提供 clientid/enabled 时保留已有值。
+	partial := upsertAccountSQL(false, false)
+	if strings.Contains(partial, "clientid = ") || strings.Contains(partial, "enabled = ") || !strings.Contains(partial, "salt = EXCLUDED.salt") {
+		t.Fatalf("partial upsert:\n%s", partial)
+	}
+}
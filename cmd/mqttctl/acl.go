@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"mosquitto-plugin/internal/pluginutil"
+	"mosquitto-plugin/internal/schema"
+)
+
+func runACL(args []string) error {
+	return subcommand("acl", args, map[string]func([]string) error{
+		"test": aclTest,
+	}, []string{"test"})
+}
+
+// aclSource 是按 broker 顺序检查的一个 ACL 来源。
+type aclSource struct {
+	Name  string
+	Check func(aclRequest) aclVerdict
+}
+
+// aclDecision 是一次访问检查的最终结果；Notes 记录返回 defer 的来源等判定过程。
+type aclDecision struct {
+	Access string
+	Result string
+	Source string
+	Rule   string
+	Notes  []string
+}
+
+// checkDollarTopic 对应 broker 在 ACL 之前对 $ 主题的检查：
+// $SYS 不允许客户端发布，$share 只能用于订阅。ok=false 时已给出拒绝结果。
+func checkDollarTopic(req aclRequest) (aclVerdict, bool) {
+	switch {
+	case req.Topic == "$SYS" || strings.HasPrefix(req.Topic, "$SYS/"):
+		if req.Access == accessWrite {
+			return aclVerdict{verdictDeny, "$SYS topics are read-only for clients"}, false
+		}
+	case strings.HasPrefix(req.Topic, "$share/"):
+		if req.Access != accessSubscribe {
+			return aclVerdict{verdictDeny, "$share is only valid in subscriptions"}, false
+		}
+	}
+	return aclVerdict{}, true
+}
+
+// evaluateACL 按 broker 的顺序判定：先做 $ 主题检查，再依次询问各来源，
+// 第一个非 defer 的结果生效；全部 defer 时拒绝，未配置任何来源时放行。
+func evaluateACL(req aclRequest, sources []aclSource) aclDecision {
+	d := aclDecision{Access: req.Access}
+	if v, ok := checkDollarTopic(req); !ok {
+		d.Result, d.Source, d.Rule = v.Verdict, "broker", v.Rule
+		return d
+	}
+	if req.Access == accessSubscribe && strings.HasPrefix(req.Topic, "$share/") {
+		// 共享订阅按去掉 $share/<group>/ 之后的过滤器检查。
+		filter, ok := pluginutil.StripSharePrefix(req.Topic)
+		if !ok {
+			d.Result, d.Source, d.Rule = verdictDeny, "broker", "invalid shared subscription"
+			return d
+		}
+		req.Topic = filter
+		d.Notes = append(d.Notes, "shared subscription checked as "+req.Topic)
+	}
+	for _, s := range sources {
+		v := s.Check(req)
+		if v.Verdict != verdictDefer {
+			d.Result, d.Source, d.Rule = v.Verdict, s.Name, v.Rule
+			return d
+		}
+		d.Notes = append(d.Notes, s.Name+" deferred: "+v.Rule)
+	}
+	if len(sources) == 0 {
+		d.Result, d.Source, d.Rule = verdictAllow, "broker", "no ACL configured"
+	} else {
+		d.Result, d.Source, d.Rule = verdictDeny, "broker", "all ACL sources deferred"
+	}
+	return d
+}
+
+func aclTest(args []string) error {
+	fs := flag.NewFlagSet("acl test", flag.ContinueOnError)
+	var db dbFlags
+	db.register(fs)
+	aclPath := fs.String("acl-file", "", "mosquitto acl_file 路径")
+	useDB := fs.Bool("db", false, "同时检查 mqtt_acl_* 表中的 ACL（插件 ACL，先于 acl_file）")
+	user := fs.String("user", "", "username（空表示匿名客户端）")
+	client := fs.String("client", "", "client id（默认与 -user 相同）")
+	access := fs.String("access", "all", "访问类型：write / read / subscribe / all")
+	format := fs.String("format", formatTable, "输出格式：table / json / csv")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: mqttctl acl test [-acl-file file] [-db] [-user name] [-client id] [-access write|read|subscribe|all] <topic>")
+		return errUsage
+	}
+	if err := validFormat(*format); err != nil {
+		return err
+	}
+	if *aclPath == "" && !*useDB {
+		return errors.New("-acl-file and/or -db is required")
+	}
+	topic := fs.Arg(0)
+	wildcard := strings.ContainsAny(topic, "+#")
+	var accesses []string
+	switch *access {
+	case "all":
+		for _, a := range accessTypes {
+			// 带通配符的过滤器只能用于订阅。
+			if a == accessSubscribe || !wildcard {
+				accesses = append(accesses, a)
+			}
+		}
+	case accessWrite, accessRead:
+		if wildcard {
+			return fmt.Errorf("%s access needs a topic without wildcards", *access)
+		}
+		accesses = []string{*access}
+	case accessSubscribe:
+		accesses = []string{*access}
+	default:
+		return fmt.Errorf("invalid -access %q (write, read, subscribe, all)", *access)
+	}
+	clientID := *client
+	if clientID == "" {
+		clientID = *user
+	}
+
+	var sources []aclSource
+	if *useDB {
+		conn, ctx, done, err := db.connect()
+		if err != nil {
+			return err
+		}
+		defer done()
+		if _, err := schema.CheckVersion(ctx, conn, schema.ACLVersion); err != nil {
+			return err
+		}
+		acl, err := loadDBACL(ctx, conn, *user)
+		if err != nil {
+			return fmt.Errorf("load acl: %w", err)
+		}
+		sources = append(sources, aclSource{Name: "db", Check: acl.check})
+	}
+	if *aclPath != "" {
+		f, err := os.Open(*aclPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		acl, err := parseACLFile(f)
+		if err != nil {
+			return fmt.Errorf("read %s: %w", *aclPath, err)
+		}
+		sources = append(sources, aclSource{Name: "acl_file", Check: acl.check})
+	}
+
+	rs := resultSet{Columns: []string{"access", "result", "source", "rule", "notes"}}
+	for _, a := range accesses {
+		d := evaluateACL(aclRequest{Username: *user, ClientID: clientID, Topic: topic, Access: a}, sources)
+		rs.Rows = append(rs.Rows, []any{d.Access, d.Result, d.Source, d.Rule, strings.Join(d.Notes, "; ")})
+	}
+	return writeResult(stdout, *format, rs)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/jackc/pgx/v5"
+
+	"mosquitto-plugin/internal/pluginutil"
+	"mosquitto-plugin/internal/schema"
+)
+
+// selectACLEntriesSQL 取账户直接绑定的角色规则与经由组继承的角色规则。
+const selectACLEntriesSQL = `
+SELECT ''::text AS group_name, 0 AS group_priority, ar.role_name, ar.priority, r.acl_type, r.topic, r.priority, r.allow
+FROM mqtt_acl_account_roles ar
+JOIN mqtt_acl_rules r ON r.role_name = ar.role_name
+WHERE ar.user_name = $1
+UNION ALL
+SELECT ag.group_name, ag.priority, gr.role_name, gr.priority, r.acl_type, r.topic, r.priority, r.allow
+FROM mqtt_acl_account_groups ag
+JOIN mqtt_acl_group_roles gr ON gr.group_name = ag.group_name
+JOIN mqtt_acl_rules r ON r.role_name = gr.role_name
+WHERE ag.user_name = $1`
+
+const (
+	selectACLAccountSQL  = `SELECT enabled FROM mqtt_accounts WHERE user_name=$1`
+	selectACLDefaultsSQL = `SELECT access, allow FROM mqtt_acl_defaults`
+)
+
+// dynsecDefaultAccess 是 mqtt_acl_defaults 缺行时的默认值（与 dynamic-security 一致）。
+var dynsecDefaultAccess = map[string]bool{
+	schema.ACLPublishClientSend:    false,
+	schema.ACLPublishClientReceive: true,
+	schema.ACLAccessSubscribe:      false,
+	schema.ACLAccessUnsubscribe:    true,
+}
+
+// dbACLEntry 是账户可用的一条规则及其来源（直接绑定的角色或组内角色）。
+type dbACLEntry struct {
+	// Group 为空表示账户直接绑定的角色。
+	Group         string
+	GroupPriority int
+	Role          string
+	RolePriority  int
+	Rule          aclRule
+}
+
+// dbACL 是一个账户在 mqtt_acl_* 表中的 ACL。
+type dbACL struct {
+	// Found 为 false 时账户不存在，按插件约定返回 DEFER。
+	Found    bool
+	Enabled  bool
+	Entries  []dbACLEntry
+	Defaults map[string]bool
+}
+
+// loadDBACL 读取账户的 ACL 规则与全局默认访问。
+func loadDBACL(ctx context.Context, conn *pgx.Conn, user string) (*dbACL, error) {
+	acl := &dbACL{Defaults: map[string]bool{}}
+	if user == "" {
+		return acl, nil
+	}
+	var enabled int16
+	if err := conn.QueryRow(ctx, selectACLAccountSQL, user).Scan(&enabled); errors.Is(err, pgx.ErrNoRows) {
+		return acl, nil
+	} else if err != nil {
+		return nil, err
+	}
+	acl.Found, acl.Enabled = true, enabled == 1
+	rows, err := conn.Query(ctx, selectACLEntriesSQL, user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e dbACLEntry
+		if err := rows.Scan(&e.Group, &e.GroupPriority, &e.Role, &e.RolePriority, &e.Rule.Type, &e.Rule.Topic, &e.Rule.Priority, &e.Rule.Allow); err != nil {
+			return nil, err
+		}
+		acl.Entries = append(acl.Entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows, err = conn.Query(ctx, selectACLDefaultsSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var access string
+		var allow bool
+		if err := rows.Scan(&access, &allow); err != nil {
+			return nil, err
+		}
+		acl.Defaults[access] = allow
+	}
+	return acl, rows.Err()
+}
+
+// subscribeTypeOrder 为同一角色内 subscribe 规则的检查顺序：先 literal 后 pattern。
+func subscribeTypeOrder(t string) int {
+	if t == schema.ACLSubscribePattern {
+		return 1
+	}
+	return 0
+}
+
+// sortEntries 按 dynamic-security 的顺序排列：直接角色优先，其后按组优先级；
+// 组内/账户内按角色优先级，角色内按规则优先级，均为数值大者优先。
+func (a *dbACL) sortEntries() {
+	sort.SliceStable(a.Entries, func(i, j int) bool {
+		x, y := a.Entries[i], a.Entries[j]
+		if (x.Group == "") != (y.Group == "") {
+			return x.Group == ""
+		}
+		if x.GroupPriority != y.GroupPriority {
+			return x.GroupPriority > y.GroupPriority
+		}
+		if x.Group != y.Group {
+			return x.Group < y.Group
+		}
+		if x.RolePriority != y.RolePriority {
+			return x.RolePriority > y.RolePriority
+		}
+		if x.Role != y.Role {
+			return x.Role < y.Role
+		}
+		if o1, o2 := subscribeTypeOrder(x.Rule.Type), subscribeTypeOrder(y.Rule.Type); o1 != o2 {
+			return o1 < o2
+		}
+		return x.Rule.Priority > y.Rule.Priority
+	})
+}
+
+// check 按 dynamic-security 语义判定：第一条匹配的规则决定 allow/deny，全部不匹配时取默认访问。
+func (a *dbACL) check(req aclRequest) aclVerdict {
+	if !a.Found {
+		return aclVerdict{verdictDefer, "account not found"}
+	}
+	if !a.Enabled {
+		return aclVerdict{verdictDeny, "account disabled"}
+	}
+	var defaultKey string
+	var types []string
+	switch req.Access {
+	case accessWrite:
+		defaultKey, types = schema.ACLPublishClientSend, []string{schema.ACLPublishClientSend}
+	case accessRead:
+		defaultKey, types = schema.ACLPublishClientReceive, []string{schema.ACLPublishClientReceive}
+	default:
+		defaultKey, types = schema.ACLAccessSubscribe, []string{schema.ACLSubscribeLiteral, schema.ACLSubscribePattern}
+	}
+	a.sortEntries()
+	for _, e := range a.Entries {
+		if !slices.Contains(types, e.Rule.Type) {
+			continue
+		}
+		topic := expandACLTopic(e.Rule.Topic, req)
+		var ok bool
+		switch e.Rule.Type {
+		case schema.ACLSubscribeLiteral:
+			ok = topic == req.Topic
+		case schema.ACLSubscribePattern:
+			ok = filterCovers(topic, req.Topic)
+		default:
+			ok = pluginutil.TopicMatches(topic, req.Topic)
+		}
+		if !ok {
+			continue
+		}
+		verdict := verdictDeny
+		if e.Rule.Allow {
+			verdict = verdictAllow
+		}
+		via := "role " + e.Role
+		if e.Group != "" {
+			via = "group " + e.Group + " / " + via
+		}
+		rule := fmt.Sprintf("%s: %s %s priority %d allow=%t", via, e.Rule.Type, e.Rule.Topic, e.Rule.Priority, e.Rule.Allow)
+		if topic != e.Rule.Topic {
+			rule += " (" + topic + ")"
+		}
+		return aclVerdict{verdict, rule}
+	}
+	allow, ok := a.Defaults[defaultKey]
+	source := "mqtt_acl_defaults"
+	if !ok {
+		allow, source = dynsecDefaultAccess[defaultKey], "built-in default"
+	}
+	verdict := verdictDeny
+	if allow {
+		verdict = verdictAllow
+	}
+	return aclVerdict{verdict, fmt.Sprintf("no matching rule, %s %s=%t", source, defaultKey, allow)}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"mosquitto-plugin/internal/pluginutil"
+)
+
+// 访问类型（mqttctl acl test -access）。
+const (
+	accessRead      = "read"
+	accessWrite     = "write"
+	accessSubscribe = "subscribe"
+)
+
+var accessTypes = []string{accessWrite, accessRead, accessSubscribe}
+
+// acl_file 中的访问位。
+const (
+	aclBitRead  = 1
+	aclBitWrite = 2
+)
+
+// aclFileRule 是 acl_file 中的一条 topic/pattern 规则。
+type aclFileRule struct {
+	Line int
+	// Pattern 为 true 时是 pattern 行（对所有客户端生效，支持 %u/%c）。
+	Pattern bool
+	// User 为 topic 行所在的 user 段；Anonymous 表示第一个 user 行之前的 topic（仅匿名客户端）。
+	User      string
+	Anonymous bool
+	// Bits 为 0 表示 deny。
+	Bits  int
+	Topic string
+	Text  string
+}
+
+// aclFile 是解析后的 acl_file。
+type aclFile struct {
+	Topics   []aclFileRule
+	Patterns []aclFileRule
+}
+
+// parseACLAccess 解析 topic/pattern 行：可选的访问类型 + 主题（主题可含空格，缺省访问为 readwrite）。
+func parseACLAccess(rest string) (int, string) {
+	word, topic, _ := strings.Cut(rest, " ")
+	switch word {
+	case "read":
+		return aclBitRead, strings.TrimSpace(topic)
+	case "write":
+		return aclBitWrite, strings.TrimSpace(topic)
+	case "readwrite":
+		return aclBitRead | aclBitWrite, strings.TrimSpace(topic)
+	case "deny":
+		return 0, strings.TrimSpace(topic)
+	default:
+		return aclBitRead | aclBitWrite, rest
+	}
+}
+
+// parseACLFile 按 mosquitto 的语法解析 acl_file（user / topic / pattern）。
+func parseACLFile(r io.Reader) (*aclFile, error) {
+	var f aclFile
+	sc := bufio.NewScanner(r)
+	line := 0
+	user, anonymous := "", true
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(strings.ReplaceAll(sc.Text(), "\t", " "))
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		directive, rest, _ := strings.Cut(text, " ")
+		rest = strings.TrimSpace(rest)
+		switch directive {
+		case "user":
+			if rest == "" {
+				return nil, fmt.Errorf("line %d: missing username", line)
+			}
+			user, anonymous = rest, false
+		case "topic", "pattern":
+			bits, topic := parseACLAccess(rest)
+			if topic == "" {
+				return nil, fmt.Errorf("line %d: missing topic", line)
+			}
+			rule := aclFileRule{Line: line, Bits: bits, Topic: topic, Text: text}
+			if directive == "pattern" {
+				rule.Pattern = true
+				f.Patterns = append(f.Patterns, rule)
+			} else {
+				rule.User, rule.Anonymous = user, anonymous
+				f.Topics = append(f.Topics, rule)
+			}
+		default:
+			return nil, fmt.Errorf("line %d: invalid line %q", line, text)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// aclRequest 是一次访问检查。
+type aclRequest struct {
+	Username string
+	ClientID string
+	Topic    string
+	Access   string
+}
+
+// 单个 ACL 来源的判定结果。
+const (
+	verdictAllow = "allow"
+	verdictDeny  = "deny"
+	verdictDefer = "defer"
+)
+
+// aclVerdict 是单个 ACL 来源的判定及依据。
+type aclVerdict struct {
+	Verdict string
+	Rule    string
+}
+
+// ruleMatches 判断规则主题是否覆盖请求：subscribe 要求规则覆盖整个订阅过滤器，read/write 按具体主题匹配。
+func ruleMatches(ruleTopic string, req aclRequest) bool {
+	if req.Access == accessSubscribe {
+		return filterCovers(ruleTopic, req.Topic)
+	}
+	return pluginutil.TopicMatches(ruleTopic, req.Topic)
+}
+
+// accessBit 返回请求需要的访问位：subscribe 需要 read。
+func accessBit(access string) int {
+	if access == accessWrite {
+		return aclBitWrite
+	}
+	return aclBitRead
+}
+
+// check 按 mosquitto 内建 ACL 的顺序判定：用户 deny → 用户允许 → pattern deny → pattern 允许 → 拒绝。
+func (f *aclFile) check(req aclRequest) aclVerdict {
+	var own []aclFileRule
+	for _, r := range f.Topics {
+		if (req.Username == "" && r.Anonymous) || (req.Username != "" && !r.Anonymous && r.User == req.Username) {
+			own = append(own, r)
+		}
+	}
+	where := func(r aclFileRule, topic string) string {
+		s := fmt.Sprintf("line %d: %s", r.Line, r.Text)
+		if topic != r.Topic {
+			s += " (" + topic + ")"
+		}
+		return s
+	}
+	for _, r := range own {
+		if r.Bits == 0 && ruleMatches(r.Topic, req) {
+			return aclVerdict{verdictDeny, where(r, r.Topic)}
+		}
+	}
+	for _, r := range own {
+		if r.Bits&accessBit(req.Access) != 0 && ruleMatches(r.Topic, req) {
+			return aclVerdict{verdictAllow, where(r, r.Topic)}
+		}
+	}
+	if len(f.Patterns) > 0 {
+		// mosquitto 拒绝 username/client id 含通配符的客户端使用 pattern，避免越权匹配。
+		if strings.ContainsAny(req.Username, "+#") || strings.ContainsAny(req.ClientID, "+#") {
+			return aclVerdict{verdictDeny, "username or client id contains + or #"}
+		}
+		type expanded struct {
+			rule  aclFileRule
+			topic string
+		}
+		var patterns []expanded
+		for _, r := range f.Patterns {
+			if req.Username == "" && strings.Contains(r.Topic, "%u") {
+				continue
+			}
+			patterns = append(patterns, expanded{r, expandACLTopic(r.Topic, req)})
+		}
+		for _, p := range patterns {
+			if p.rule.Bits == 0 && ruleMatches(p.topic, req) {
+				return aclVerdict{verdictDeny, where(p.rule, p.topic)}
+			}
+		}
+		for _, p := range patterns {
+			if p.rule.Bits&accessBit(req.Access) != 0 && ruleMatches(p.topic, req) {
+				return aclVerdict{verdictAllow, where(p.rule, p.topic)}
+			}
+		}
+	}
+	return aclVerdict{verdictDeny, "no matching rule"}
+}
+
+// expandACLTopic 替换 %u / %c。
+func expandACLTopic(topic string, req aclRequest) string {
+	return strings.NewReplacer("%u", req.Username, "%c", req.ClientID).Replace(topic)
+}
+
+// filterCovers 报告 ACL 过滤器是否覆盖订阅过滤器可能匹配的全部主题。
+func filterCovers(acl, sub string) bool {
+	if acl == "" || sub == "" {
+		return false
+	}
+	a, s := strings.Split(acl, "/"), strings.Split(sub, "/")
+	if strings.HasPrefix(sub, "$") && (a[0] == "+" || a[0] == "#") {
+		return false
+	}
+	for i, level := range a {
+		if level == "#" {
+			return i == len(a)-1
+		}
+		if i >= len(s) || s[i] == "#" {
+			return false
+		}
+		if level != "+" && level != s[i] {
+			return false
+		}
+	}
+	return len(a) == len(s)
+}
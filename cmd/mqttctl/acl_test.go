@@ -0,0 +1,172 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFilterCovers(t *testing.T) {
+	cases := []struct {
+		acl, sub string
+		want     bool
+	}{
+		{"a/#", "a/#", true},
+		{"a/#", "a/+/c", true},
+		{"a/+", "a/+", true},
+		{"a/+", "a/#", false},
+		{"a/b", "a/+", false},
+		{"#", "a/#", true},
+		{"#", "$SYS/#", false},
+		{"v1/d/dev01/#", "v1/d/+/up", false},
+		{"v1/#", "v1", true},
+	}
+	for _, c := range cases {
+		if got := filterCovers(c.acl, c.sub); got != c.want {
+			t.Errorf("filterCovers(%q, %q) = %v, want %v", c.acl, c.sub, got, c.want)
+		}
+	}
+}
+
+func loadSampleACLFile(t *testing.T) *aclFile {
+	t.Helper()
+	f, err := os.Open("../../config/acl_file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	acl, err := parseACLFile(f)
+	if err != nil {
+		t.Fatalf("parseACLFile: %v", err)
+	}
+	return acl
+}
+
+func TestACLFileSample(t *testing.T) {
+	acl := loadSampleACLFile(t)
+	if len(acl.Patterns) != 1 || len(acl.Topics) != 3 {
+		t.Fatalf("patterns=%d topics=%d", len(acl.Patterns), len(acl.Topics))
+	}
+	cases := []struct {
+		user, client, topic, access string
+		want                        string
+		rule                        string
+	}{
+		{"dev01", "dev01", "v1/d/dev01/up", accessWrite, verdictAllow, "line 3"},
+		{"dev01", "dev01", "v1/d/dev02/up", accessWrite, verdictDeny, "no matching rule"},
+		{"dev01", "dev01", "v1/d/+/up", accessSubscribe, verdictDeny, "no matching rule"},
+		{"dev01", "dev01", "v1/d/dev01/#", accessSubscribe, verdictAllow, "line 3"},
+		{"_ops", "ops-1", "$SYS/broker/uptime", accessRead, verdictAllow, "line 8"},
+		{"_ops", "ops-1", "$SYS/broker/uptime", accessWrite, verdictDeny, "no matching rule"},
+		{"app_web", "web-1", "v1/d/dev01/down", accessWrite, verdictAllow, "line 14"},
+		{"app_web", "web-1", "#", accessSubscribe, verdictDeny, "no matching rule"},
+		{"dev01", "dev+", "v1/d/dev+/up", accessWrite, verdictDeny, "contains + or #"},
+	}
+	for _, c := range cases {
+		v := acl.check(aclRequest{Username: c.user, ClientID: c.client, Topic: c.topic, Access: c.access})
+		if v.Verdict != c.want || !strings.Contains(v.Rule, c.rule) {
+			t.Errorf("%s/%s %s %s = %+v, want %s (%s)", c.user, c.client, c.access, c.topic, v, c.want, c.rule)
+		}
+	}
+}
+
+func TestACLFileSections(t *testing.T) {
+	const conf = `
+topic read public/#
+user alice
+topic deny secret/alice/#
+topic secret/#
+pattern write out/%u/#
+pattern deny out/%u/blocked
+`
+	acl, err := parseACLFile(strings.NewReader(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		user, topic, access, want string
+	}{
+		// 第一个 user 行之前的 topic 只对匿名客户端生效。
+		{"", "public/news", accessRead, verdictAllow},
+		{"alice", "public/news", accessRead, verdictDeny},
+		// 用户段的 deny 先于允许。
+		{"alice", "secret/alice/x", accessRead, verdictDeny},
+		{"alice", "secret/bob/x", accessWrite, verdictAllow},
+		// 匿名客户端不使用含 %u 的 pattern。
+		{"", "out//x", accessWrite, verdictDeny},
+		{"bob", "out/bob/x", accessWrite, verdictAllow},
+		{"bob", "out/bob/x", accessRead, verdictDeny},
+		{"bob", "out/bob/blocked", accessWrite, verdictDeny},
+	}
+	for _, c := range cases {
+		v := acl.check(aclRequest{Username: c.user, ClientID: "c1", Topic: c.topic, Access: c.access})
+		if v.Verdict != c.want {
+			t.Errorf("%q %s %s = %+v, want %s", c.user, c.access, c.topic, v, c.want)
+		}
+	}
+
+	for _, in := range []string{"user\n", "topic read\n", "allow all\n"} {
+		if _, err := parseACLFile(strings.NewReader(in)); err == nil {
+			t.Errorf("parseACLFile(%q) should fail", in)
+		}
+	}
+}
+
+func TestDBACLCheck(t *testing.T) {
+	acl := &dbACL{
+		Found:   true,
+		Enabled: true,
+		Entries: []dbACLEntry{
+			{Group: "devices", GroupPriority: 1, Role: "device", Rule: aclRule{Type: "publishClientSend", Topic: "v1/d/%c/#", Allow: true}},
+			{Group: "devices", GroupPriority: 1, Role: "device", Rule: aclRule{Type: "subscribePattern", Topic: "v1/d/%c/#", Allow: true}},
+			{Group: "blocked", GroupPriority: 5, Role: "mute", Rule: aclRule{Type: "publishClientSend", Topic: "v1/d/+/alarm", Allow: false}},
+			{Role: "own", Rule: aclRule{Type: "publishClientSend", Topic: "v1/d/dev01/alarm", Priority: 1, Allow: true}},
+			{Role: "own", Rule: aclRule{Type: "publishClientSend", Topic: "v1/d/dev01/#", Priority: 0, Allow: false}},
+		},
+		Defaults: map[string]bool{"publishClientReceive": false},
+	}
+	cases := []struct {
+		topic, access, want, rule string
+	}{
+		// 直接绑定的角色先于组，角色内按规则优先级。
+		{"v1/d/dev01/alarm", accessWrite, verdictAllow, "role own"},
+		{"v1/d/dev01/up", accessWrite, verdictDeny, "role own"},
+		{"v1/d/dev02/alarm", accessWrite, verdictDeny, "group blocked"},
+		{"v1/d/dev01/#", accessSubscribe, verdictAllow, "group devices"},
+		{"v1/#", accessSubscribe, verdictDeny, "built-in default subscribe=false"},
+		{"v1/d/dev01/down", accessRead, verdictDeny, "mqtt_acl_defaults publishClientReceive=false"},
+	}
+	for _, c := range cases {
+		v := acl.check(aclRequest{Username: "dev01", ClientID: "dev01", Topic: c.topic, Access: c.access})
+		if v.Verdict != c.want || !strings.Contains(v.Rule, c.rule) {
+			t.Errorf("%s %s = %+v, want %s (%s)", c.access, c.topic, v, c.want, c.rule)
+		}
+	}
+	if v := (&dbACL{}).check(aclRequest{Username: "ghost", Topic: "a", Access: accessWrite}); v.Verdict != verdictDefer {
+		t.Fatalf("unknown account should defer: %+v", v)
+	}
+}
+
+func TestEvaluateACL(t *testing.T) {
+	file := loadSampleACLFile(t)
+	sources := []aclSource{
+		{Name: "db", Check: (&dbACL{}).check},
+		{Name: "acl_file", Check: file.check},
+	}
+	d := evaluateACL(aclRequest{Username: "dev01", ClientID: "dev01", Topic: "v1/d/dev01/up", Access: accessWrite}, sources)
+	if d.Result != verdictAllow || d.Source != "acl_file" || len(d.Notes) != 1 || !strings.HasPrefix(d.Notes[0], "db deferred") {
+		t.Fatalf("decision = %+v", d)
+	}
+	d = evaluateACL(aclRequest{Username: "_ops", Topic: "$SYS/broker/uptime", Access: accessWrite}, sources)
+	if d.Result != verdictDeny || d.Source != "broker" {
+		t.Fatalf("$SYS write = %+v", d)
+	}
+	d = evaluateACL(aclRequest{Username: "app_web", Topic: "$share/g1/v1/#", Access: accessSubscribe}, sources)
+	if d.Result != verdictAllow || d.Source != "acl_file" {
+		t.Fatalf("shared subscription = %+v", d)
+	}
+	d = evaluateACL(aclRequest{Username: "ghost", Topic: "a", Access: accessRead}, sources[:1])
+	if d.Result != verdictDeny || d.Rule != "all ACL sources deferred" {
+		t.Fatalf("all deferred = %+v", d)
+	}
+}
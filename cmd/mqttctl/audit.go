@@ -0,0 +1,315 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"mosquitto-plugin/internal/schema"
+)
+
+func runAudit(args []string) error {
+	return subcommand("audit", args, map[string]func([]string) error{
+		"auth-failures": auditAuthFailures,
+		"timeline":      auditTimeline,
+		"online":        auditOnline,
+		"flapping":      auditFlapping,
+	}, []string{"auth-failures", "timeline", "online", "flapping"})
+}
+
+// auditFlags 是 audit 子命令的公共参数。
+type auditFlags struct {
+	db     dbFlags
+	format string
+	limit  int
+}
+
+func (f *auditFlags) register(fs *flag.FlagSet, limit int) {
+	f.db.register(fs)
+	fs.StringVar(&f.format, "format", formatTable, "输出格式：table / json / csv")
+	fs.IntVar(&f.limit, "limit", limit, "最多返回行数")
+}
+
+func (f *auditFlags) validate() error {
+	if f.limit <= 0 {
+		return errors.New("-limit must be positive")
+	}
+	return validFormat(f.format)
+}
+
+// rangeFlags 是时间范围参数：-from/-to（RFC3339 或 2006-01-02）优先，否则取最近 -since。
+type rangeFlags struct {
+	since    time.Duration
+	from, to string
+}
+
+func (f *rangeFlags) register(fs *flag.FlagSet, since time.Duration) {
+	fs.DurationVar(&f.since, "since", since, "最近一段时间（-from 未指定时生效）")
+	fs.StringVar(&f.from, "from", "", "起始时间（含），RFC3339 或 YYYY-MM-DD")
+	fs.StringVar(&f.to, "to", "", "结束时间（不含），RFC3339 或 YYYY-MM-DD，默认当前时间")
+}
+
+// resolve 返回 [from, to) 时间范围（UTC）。
+func (f *rangeFlags) resolve(now time.Time) (time.Time, time.Time, error) {
+	to := now.UTC()
+	if f.to != "" {
+		t, err := parseTimeArg(f.to)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid -to: %w", err)
+		}
+		to = t
+	}
+	var from time.Time
+	if f.from != "" {
+		t, err := parseTimeArg(f.from)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid -from: %w", err)
+		}
+		from = t
+	} else {
+		if f.since <= 0 {
+			return time.Time{}, time.Time{}, errors.New("-since must be positive")
+		}
+		from = to.Add(-f.since)
+	}
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, errors.New("time range is empty (from must be before to)")
+	}
+	return from, to, nil
+}
+
+func parseTimeArg(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.UTC(), nil
+	}
+	return time.ParseInLocation("2006-01-02", v, time.UTC)
+}
+
+// sqlBuilder 拼接 WHERE 条件，条件中的 ? 依次替换为 $n 占位符。
+type sqlBuilder struct {
+	where []string
+	args  []any
+}
+
+func (b *sqlBuilder) add(cond string, args ...any) {
+	for _, a := range args {
+		b.args = append(b.args, a)
+		cond = strings.Replace(cond, "?", "$"+strconv.Itoa(len(b.args)), 1)
+	}
+	b.where = append(b.where, cond)
+}
+
+// arg 追加一个参数并返回占位符（用于 LIMIT 等非 WHERE 位置）。
+func (b *sqlBuilder) arg(v any) string {
+	b.args = append(b.args, v)
+	return "$" + strconv.Itoa(len(b.args))
+}
+
+func (b *sqlBuilder) whereSQL() string {
+	if len(b.where) == 0 {
+		return ""
+	}
+	return "\nWHERE " + strings.Join(b.where, "\n  AND ")
+}
+
+// runAuditQuery 执行查询并按格式输出。
+func runAuditQuery(f *auditFlags, sql string, args []any) error {
+	conn, ctx, done, err := f.db.connect()
+	if err != nil {
+		return err
+	}
+	defer done()
+	rows, err := conn.Query(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	rs, err := collectResult(rows)
+	if err != nil {
+		return err
+	}
+	return writeResult(stdout, f.format, rs)
+}
+
+// authFailuresSQL 构造认证失败查询：by 为空时列出明细，user/ip 时按用户名/来源地址聚合。
+func authFailuresSQL(by, user, ip string, from, to time.Time, limit int) (string, []any, error) {
+	var b sqlBuilder
+	b.add("result = ?", schema.AuthResultFail)
+	b.add("ts >= ? AND ts < ?", from, to)
+	if user != "" {
+		b.add("username = ?", user)
+	}
+	if ip != "" {
+		b.add("peer = ?", ip)
+	}
+	table := "\nFROM " + schema.AuthEventsTable
+	switch by {
+	case "":
+		return `SELECT ts, username, client_id, peer, protocol, reason, backend` + table + b.whereSQL() +
+			"\nORDER BY ts DESC\nLIMIT " + b.arg(limit), b.args, nil
+	case "user":
+		return `SELECT username, count(*) AS failures, count(DISTINCT peer) AS peers,
+  string_agg(DISTINCT reason, ',') AS reasons, min(ts) AS first_ts, max(ts) AS last_ts` + table + b.whereSQL() +
+			"\nGROUP BY username\nORDER BY failures DESC, last_ts DESC\nLIMIT " + b.arg(limit), b.args, nil
+	case "ip":
+		return `SELECT peer, count(*) AS failures, count(DISTINCT username) AS users,
+  string_agg(DISTINCT reason, ',') AS reasons, min(ts) AS first_ts, max(ts) AS last_ts` + table + b.whereSQL() +
+			"\nGROUP BY peer\nORDER BY failures DESC, last_ts DESC\nLIMIT " + b.arg(limit), b.args, nil
+	default:
+		return "", nil, fmt.Errorf("invalid -by %q (user, ip)", by)
+	}
+}
+
+func auditAuthFailures(args []string) error {
+	fs := flag.NewFlagSet("audit auth-failures", flag.ContinueOnError)
+	var f auditFlags
+	var r rangeFlags
+	f.register(fs, 100)
+	r.register(fs, 24*time.Hour)
+	user := fs.String("user", "", "按用户名过滤")
+	ip := fs.String("ip", "", "按来源地址过滤（client_auth_events.peer）")
+	by := fs.String("by", "", "聚合维度：user / ip（默认列出明细）")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := f.validate(); err != nil {
+		return err
+	}
+	from, to, err := r.resolve(time.Now())
+	if err != nil {
+		return err
+	}
+	sql, sqlArgs, err := authFailuresSQL(*by, *user, *ip, from, to, f.limit)
+	if err != nil {
+		return err
+	}
+	return runAuditQuery(&f, sql, sqlArgs)
+}
+
+// timelineSQL 构造 client_id 的连接时间线；withAuth 时合并认证事件（event_type 为 auth_success/auth_fail）。
+func timelineSQL(clientID string, withAuth bool, from, to time.Time, limit int) (string, []any) {
+	var b sqlBuilder
+	b.add("client_id = ?", clientID)
+	b.add("ts >= ? AND ts < ?", from, to)
+	where := b.whereSQL()
+	sql := `SELECT ts, event_type, username, peer, protocol, reason_code, extra
+FROM ` + schema.ConnEventsTable + where
+	if withAuth {
+		sql += `
+UNION ALL
+SELECT ts, 'auth_' || result, username, peer, protocol, NULL, jsonb_build_object('reason', reason, 'backend', backend)
+FROM ` + schema.AuthEventsTable + where
+	}
+	return sql + "\nORDER BY ts\nLIMIT " + b.arg(limit), b.args
+}
+
+func auditTimeline(args []string) error {
+	fs := flag.NewFlagSet("audit timeline", flag.ContinueOnError)
+	var f auditFlags
+	var r rangeFlags
+	f.register(fs, 500)
+	r.register(fs, 7*24*time.Hour)
+	clientID := fs.String("client", "", "client_id（必填）")
+	withAuth := fs.Bool("auth", false, "同时列出认证事件")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *clientID == "" {
+		return errors.New("-client is required")
+	}
+	if err := f.validate(); err != nil {
+		return err
+	}
+	from, to, err := r.resolve(time.Now())
+	if err != nil {
+		return err
+	}
+	sql, sqlArgs := timelineSQL(*clientID, *withAuth, from, to, f.limit)
+	return runAuditQuery(&f, sql, sqlArgs)
+}
+
+// onlineSQL 构造在线客户端查询：sessions 读 client_sessions 最近事件，snapshot 读 conn-plugin 快照。
+func onlineSQL(source, user, node string, limit int) (string, []any, error) {
+	var b sqlBuilder
+	switch source {
+	case "sessions":
+		b.add("last_event_type = ?", schema.ConnEventConnect)
+		if user != "" {
+			b.add("username = ?", user)
+		}
+		return `SELECT client_id, username, last_peer AS peer, last_protocol AS protocol, last_connect_ts AS connected_at
+FROM ` + schema.SessionsTable + b.whereSQL() + "\nORDER BY client_id\nLIMIT " + b.arg(limit), b.args, nil
+	case "snapshot":
+		if user != "" {
+			b.add("c.username = ?", user)
+		}
+		if node != "" {
+			b.add("c.node_id = ?", node)
+		}
+		return `SELECT c.node_id, c.client_id, c.username, c.peer, c.protocol, c.connected_at, s.snapshot_ts
+FROM ` + schema.ActiveClientsTable + ` c
+JOIN ` + schema.ActiveSnapshotsTable + ` s USING (node_id)` + b.whereSQL() + "\nORDER BY c.client_id, c.node_id\nLIMIT " + b.arg(limit), b.args, nil
+	default:
+		return "", nil, fmt.Errorf("invalid -source %q (sessions, snapshot)", source)
+	}
+}
+
+func auditOnline(args []string) error {
+	fs := flag.NewFlagSet("audit online", flag.ContinueOnError)
+	var f auditFlags
+	f.register(fs, 1000)
+	source := fs.String("source", "sessions", "数据来源：sessions（client_sessions）/ snapshot（broker_active_clients）")
+	user := fs.String("user", "", "按用户名过滤")
+	node := fs.String("node", "", "按节点过滤（仅 snapshot）")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := f.validate(); err != nil {
+		return err
+	}
+	sql, sqlArgs, err := onlineSQL(*source, *user, *node, f.limit)
+	if err != nil {
+		return err
+	}
+	return runAuditQuery(&f, sql, sqlArgs)
+}
+
+// flappingSQL 按 flapping 事件数与 connect 次数排序客户端。
+func flappingSQL(from, to time.Time, limit int) (string, []any) {
+	var b sqlBuilder
+	b.add("event_type IN (?, ?)", schema.ConnEventConnect, schema.ConnEventFlapping)
+	b.add("ts >= ? AND ts < ?", from, to)
+	flapping := b.arg(schema.ConnEventFlapping)
+	connect := b.arg(schema.ConnEventConnect)
+	return `SELECT client_id,
+  count(*) FILTER (WHERE event_type = ` + flapping + `) AS flapping_events,
+  count(*) FILTER (WHERE event_type = ` + connect + `) AS connects,
+  max((extra->>'suppressed')::bigint) FILTER (WHERE event_type = ` + flapping + `) AS suppressed,
+  max(ts) AS last_ts
+FROM ` + schema.ConnEventsTable + b.whereSQL() + `
+GROUP BY client_id
+ORDER BY flapping_events DESC, connects DESC
+LIMIT ` + b.arg(limit), b.args
+}
+
+func auditFlapping(args []string) error {
+	fs := flag.NewFlagSet("audit flapping", flag.ContinueOnError)
+	var f auditFlags
+	var r rangeFlags
+	f.register(fs, 20)
+	r.register(fs, 24*time.Hour)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := f.validate(); err != nil {
+		return err
+	}
+	from, to, err := r.resolve(time.Now())
+	if err != nil {
+		return err
+	}
+	sql, sqlArgs := flappingSQL(from, to, f.limit)
+	return runAuditQuery(&f, sql, sqlArgs)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRangeFlagsResolve(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	from, to, err := (&rangeFlags{since: time.Hour}).resolve(now)
+	if err != nil || !from.Equal(now.Add(-time.Hour)) || !to.Equal(now) {
+		t.Fatalf("since range = %v %v %v", from, to, err)
+	}
+	from, to, err = (&rangeFlags{since: time.Hour, from: "2026-03-01", to: "2026-03-02T08:00:00+08:00"}).resolve(now)
+	if err != nil || !from.Equal(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)) || !to.Equal(time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("explicit range = %v %v %v", from, to, err)
+	}
+	for _, r := range []rangeFlags{
+		{from: "yesterday"},
+		{since: time.Hour, to: "bad"},
+		{from: "2026-03-02", to: "2026-03-01"},
+		{since: 0},
+	} {
+		if _, _, err := r.resolve(now); err == nil {
+			t.Fatalf("%+v: expected error", r)
+		}
+	}
+}
+
+func TestAuthFailuresSQL(t *testing.T) {
+	from, to := time.Unix(0, 0), time.Unix(60, 0)
+
+	sql, args, err := authFailuresSQL("", "alice", "10.0.0.1", from, to, 50)
+	if err != nil {
+		t.Fatalf("authFailuresSQL: %v", err)
+	}
+	for _, want := range []string{"FROM client_auth_events", "result = $1", "ts >= $2 AND ts < $3", "username = $4", "peer = $5", "LIMIT $6"} {
+		if !strings.Contains(sql, want) {
+			t.Fatalf("sql missing %q:\n%s", want, sql)
+		}
+	}
+	if len(args) != 6 || args[0] != "fail" || args[3] != "alice" || args[5] != 50 {
+		t.Fatalf("args = %v", args)
+	}
+
+	sql, args, err = authFailuresSQL("ip", "", "", from, to, 10)
+	if err != nil || !strings.Contains(sql, "GROUP BY peer") || !strings.Contains(sql, "LIMIT $4") || len(args) != 4 {
+		t.Fatalf("by ip sql = %s, args = %v, err = %v", sql, args, err)
+	}
+	if _, _, err := authFailuresSQL("client", "", "", from, to, 10); err == nil {
+		t.Fatal("invalid -by should fail")
+	}
+}
+
+func TestTimelineSQL(t *testing.T) {
+	sql, args := timelineSQL("dev-1", true, time.Unix(0, 0), time.Unix(60, 0), 10)
+	if !strings.Contains(sql, "FROM client_conn_events") || !strings.Contains(sql, "UNION ALL") ||
+		!strings.Contains(sql, "FROM client_auth_events") || !strings.Contains(sql, "LIMIT $4") {
+		t.Fatalf("sql = %s", sql)
+	}
+	if len(args) != 4 || args[0] != "dev-1" {
+		t.Fatalf("args = %v", args)
+	}
+	sql, _ = timelineSQL("dev-1", false, time.Unix(0, 0), time.Unix(60, 0), 10)
+	if strings.Contains(sql, "UNION") {
+		t.Fatalf("sql without auth = %s", sql)
+	}
+}
+
+func TestOnlineSQL(t *testing.T) {
+	sql, args, err := onlineSQL("sessions", "alice", "", 10)
+	if err != nil || !strings.Contains(sql, "FROM client_sessions") || args[0] != "connect" || args[1] != "alice" {
+		t.Fatalf("sessions sql = %s, args = %v, err = %v", sql, args, err)
+	}
+	sql, args, err = onlineSQL("snapshot", "", "node-1", 10)
+	if err != nil || !strings.Contains(sql, "JOIN broker_active_snapshots") || args[0] != "node-1" {
+		t.Fatalf("snapshot sql = %s, args = %v, err = %v", sql, args, err)
+	}
+	if _, _, err := onlineSQL("memory", "", "", 10); err == nil {
+		t.Fatal("invalid source should fail")
+	}
+}
+
+func TestWriteResult(t *testing.T) {
+	ts := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	rs := resultSet{
+		Columns: []string{"client_id", "connects", "last_ts", "extra"},
+		Rows: [][]any{
+			{"dev-1", int64(3), ts, map[string]any{"reason": "x"}},
+			{"dev,2", int64(1), nil, nil},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := writeResult(&buf, formatCSV, rs); err != nil {
+		t.Fatalf("csv: %v", err)
+	}
+	want := "client_id,connects,last_ts,extra\n" +
+		"dev-1,3,2026-01-02T03:04:05Z,\"{\"\"reason\"\":\"\"x\"\"}\"\n" +
+		"\"dev,2\",1,,\n"
+	if buf.String() != want {
+		t.Fatalf("csv = %q", buf.String())
+	}
+
+	buf.Reset()
+	if err := writeResult(&buf, formatJSON, rs); err != nil {
+		t.Fatalf("json: %v", err)
+	}
+	if !strings.Contains(buf.String(), `"last_ts": "2026-01-02T03:04:05Z"`) || !strings.Contains(buf.String(), `"last_ts": null`) {
+		t.Fatalf("json = %s", buf.String())
+	}
+
+	buf.Reset()
+	if err := writeResult(&buf, formatTable, rs); err != nil {
+		t.Fatalf("table: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "CLIENT_ID") || !strings.Contains(lines[2], "-") {
+		t.Fatalf("table = %q", buf.String())
+	}
+
+	if err := validFormat("yaml"); err == nil {
+		t.Fatal("yaml should be rejected")
+	}
+}
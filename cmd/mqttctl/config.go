@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"mosquitto-plugin/internal/pluginconf"
+)
+
+func runConfig(args []string) error {
+	return subcommand("config", args, map[string]func([]string) error{
+		"check": configCheck,
+	}, []string{"check"})
+}
+
+// configCheckResult 是 config check 的 JSON 输出。
+type configCheckResult struct {
+	File    string              `json:"file"`
+	Issues  []pluginconf.Issue  `json:"issues"`
+	Plugins []pluginconf.Report `json:"plugins"`
+}
+
+// counts 返回 error 与 warning 数量。
+func (r configCheckResult) counts() (errs, warns int) {
+	count := func(issues []pluginconf.Issue) {
+		for _, is := range issues {
+			if is.Severity == pluginconf.SeverityError {
+				errs++
+			} else {
+				warns++
+			}
+		}
+	}
+	count(r.Issues)
+	for _, p := range r.Plugins {
+		count(p.Issues)
+	}
+	return errs, warns
+}
+
+func configCheck(args []string) error {
+	fs := flag.NewFlagSet("config check", flag.ContinueOnError)
+	useEnv := fs.Bool("env", false, "按插件行为读取 PG_DSN / QUEUE_DSN 环境变量兜底")
+	strict := fs.Bool("strict", false, "存在 warning 时同样以非 0 退出")
+	format := fs.String("format", formatTable, "输出格式：table / json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: mqttctl config check [-env] [-strict] [-format table|json] <mosquitto.conf>")
+		return errUsage
+	}
+	if *format != formatTable && *format != formatJSON {
+		return fmt.Errorf("invalid -format %q (table, json)", *format)
+	}
+	path := fs.Arg(0)
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	conf, err := pluginconf.ParseMosquittoConf(f)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+	var getenv func(string) string
+	if *useEnv {
+		getenv = os.Getenv
+	}
+	res := configCheckResult{File: path}
+	res.Issues, res.Plugins = pluginconf.Check(conf, getenv)
+	if res.Issues == nil {
+		res.Issues = []pluginconf.Issue{}
+	}
+	for i := range res.Plugins {
+		if res.Plugins[i].Issues == nil {
+			res.Plugins[i].Issues = []pluginconf.Issue{}
+		}
+	}
+
+	if *format == formatJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(res); err != nil {
+			return err
+		}
+	} else if err := writeConfigCheck(stdout, res); err != nil {
+		return err
+	}
+	errs, warns := res.counts()
+	if errs > 0 || (warns > 0 && *strict) {
+		return errors.New("check failed")
+	}
+	return nil
+}
+
+// writeConfigCheck 按插件分组输出检查结果与生效配置（敏感值已脱敏）。
+func writeConfigCheck(w io.Writer, res configCheckResult) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	writeIssues := func(issues []pluginconf.Issue) {
+		for _, is := range issues {
+			where := ""
+			if is.Line > 0 {
+				where = fmt.Sprintf("line %d: ", is.Line)
+			}
+			if is.Key != "" {
+				where += is.Key + ": "
+			}
+			fmt.Fprintf(tw, "  [%s] %s%s\n", is.Severity, where, is.Message)
+		}
+	}
+	if len(res.Issues) > 0 {
+		fmt.Fprintf(tw, "%s\n", res.File)
+		writeIssues(res.Issues)
+	}
+	for i, p := range res.Plugins {
+		if i > 0 || len(res.Issues) > 0 {
+			fmt.Fprintln(tw)
+		}
+		name := p.Plugin
+		if name == "" {
+			name = "unknown"
+		}
+		fmt.Fprintf(tw, "plugin %s (line %d, %s)\n", p.Path, p.Line, name)
+		writeIssues(p.Issues)
+		for _, e := range p.Effective {
+			src := e.Source
+			if e.Line > 0 {
+				src = fmt.Sprintf("%s:%d", e.Source, e.Line)
+			}
+			fmt.Fprintf(tw, "  %s\t%s\t%s\n", e.Key, e.Value, src)
+		}
+	}
+	if len(res.Plugins) == 0 && len(res.Issues) == 0 {
+		fmt.Fprintln(tw, "no plugin configured")
+	}
+	errs, warns := res.counts()
+	fmt.Fprintf(tw, "\n%d error(s), %d warning(s)\n", errs, warns)
+	return tw.Flush()
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/jackc/pgx/v5"
+
+	"mosquitto-plugin/internal/pluginutil"
+	"mosquitto-plugin/internal/schema"
+)
+
+const (
+	insertACLRoleSQL = `INSERT INTO mqtt_acl_roles (role_name, description) VALUES ($1, $2)`
+	upsertACLRoleSQL = insertACLRoleSQL + `
+ON CONFLICT (role_name) DO UPDATE SET description = EXCLUDED.description`
+	deleteACLRulesSQL = `DELETE FROM mqtt_acl_rules WHERE role_name=$1`
+	insertACLRuleSQL  = `
+INSERT INTO mqtt_acl_rules (role_name, acl_type, topic, priority, allow)
+VALUES ($1, $2, $3, $4, $5)`
+
+	insertACLGroupSQL = `INSERT INTO mqtt_acl_groups (group_name, description) VALUES ($1, $2)`
+	upsertACLGroupSQL = insertACLGroupSQL + `
+ON CONFLICT (group_name) DO UPDATE SET description = EXCLUDED.description`
+	deleteACLGroupRolesSQL = `DELETE FROM mqtt_acl_group_roles WHERE group_name=$1`
+	insertACLGroupRoleSQL  = `INSERT INTO mqtt_acl_group_roles (group_name, role_name, priority) VALUES ($1, $2, $3)`
+
+	deleteACLAccountRolesSQL  = `DELETE FROM mqtt_acl_account_roles WHERE user_name=$1`
+	insertACLAccountRoleSQL   = `INSERT INTO mqtt_acl_account_roles (user_name, role_name, priority) VALUES ($1, $2, $3)`
+	deleteACLAccountGroupsSQL = `DELETE FROM mqtt_acl_account_groups WHERE user_name=$1`
+	insertACLAccountGroupSQL  = `INSERT INTO mqtt_acl_account_groups (user_name, group_name, priority) VALUES ($1, $2, $3)`
+
+	insertACLDefaultSQL = `INSERT INTO mqtt_acl_defaults (access, allow) VALUES ($1, $2)`
+	upsertACLDefaultSQL = insertACLDefaultSQL + `
+ON CONFLICT (access) DO UPDATE SET allow = EXCLUDED.allow`
+)
+
+func runImport(args []string) error {
+	return subcommand("import", args, map[string]func([]string) error{
+		"dynsec": func(args []string) error { return importFrom("dynsec", args, parseDynsec) },
+		"passwd": func(args []string) error { return importFrom("passwd", args, parsePasswdFile) },
+	}, []string{"dynsec", "passwd"})
+}
+
+// importFrom 解析输入文件并在一个事务内写入账户与 ACL 表。
+func importFrom(name string, args []string, parse func(io.Reader) (*importSet, error)) error {
+	fs := flag.NewFlagSet("import "+name, flag.ContinueOnError)
+	var db dbFlags
+	db.register(fs)
+	file := fs.String("file", "", "输入文件（- 表示标准输入，必填）")
+	update := fs.Bool("update", false, "已存在的账户/角色/组覆盖更新（默认冲突即失败）")
+	dryRun := fs.Bool("dry-run", false, "只解析并输出统计，不写数据库")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return errors.New("-file is required")
+	}
+	in := io.Reader(os.Stdin)
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	set, err := parse(in)
+	if err != nil {
+		return err
+	}
+	for _, w := range set.Warnings {
+		fmt.Fprintln(os.Stderr, "warning: "+w)
+	}
+	if *dryRun {
+		fmt.Fprintln(stdout, "would import "+set.summary())
+		return nil
+	}
+
+	conn, ctx, done, err := db.connect()
+	if err != nil {
+		return err
+	}
+	defer done()
+	required := schema.AuthPluginVersion
+	if set.ACL {
+		required = schema.ACLVersion
+	}
+	if _, err := schema.CheckVersion(ctx, conn, required); err != nil {
+		return err
+	}
+	if err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		return writeImportSet(ctx, tx, set, *update)
+	}); err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "imported "+set.summary())
+	return nil
+}
+
+// pick 按 update 选择 upsert 或 insert 语句。
+func pick(update bool, upsert, insert string) string {
+	if update {
+		return upsert
+	}
+	return insert
+}
+
+// conflict 将唯一约束冲突转换为可读错误。
+func conflict(err error, kind, name string) error {
+	if isUniqueViolation(err) {
+		return fmt.Errorf("%s %q already exists (use -update)", kind, name)
+	}
+	return fmt.Errorf("%s %q: %w", kind, name, err)
+}
+
+// writeImportSet 按依赖顺序写入：角色 → 组 → 账户 → 绑定关系 → 默认访问。
+// update 时替换已有角色的规则、组与账户的绑定关系；set.ACL=false 时不改动账户的绑定关系，
+// set.PasswordsOnly=true 时已有账户只更新密文。
+func writeImportSet(ctx context.Context, tx pgx.Tx, set *importSet, update bool) error {
+	for _, r := range set.Roles {
+		if _, err := tx.Exec(ctx, pick(update, upsertACLRoleSQL, insertACLRoleSQL), r.Name, pluginutil.OptionalString(r.Description)); err != nil {
+			return conflict(err, "role", r.Name)
+		}
+		if _, err := tx.Exec(ctx, deleteACLRulesSQL, r.Name); err != nil {
+			return err
+		}
+		for _, rule := range r.Rules {
+			if _, err := tx.Exec(ctx, insertACLRuleSQL, r.Name, rule.Type, rule.Topic, rule.Priority, rule.Allow); err != nil {
+				return fmt.Errorf("role %q: %w", r.Name, err)
+			}
+		}
+	}
+	for _, g := range set.Groups {
+		if _, err := tx.Exec(ctx, pick(update, upsertACLGroupSQL, insertACLGroupSQL), g.Name, pluginutil.OptionalString(g.Description)); err != nil {
+			return conflict(err, "group", g.Name)
+		}
+		if _, err := tx.Exec(ctx, deleteACLGroupRolesSQL, g.Name); err != nil {
+			return err
+		}
+		for _, ref := range g.Roles {
+			if _, err := tx.Exec(ctx, insertACLGroupRoleSQL, g.Name, ref.Name, ref.Priority); err != nil {
+				return fmt.Errorf("group %q: %w", g.Name, err)
+			}
+		}
+	}
+	accountSQL := pick(update, upsertAccountSQL(!set.PasswordsOnly, !set.PasswordsOnly), insertAccountSQL)
+	for _, a := range set.Accounts {
+		// mosquitto 密文自带盐，salt 列留空。
+		if _, err := tx.Exec(ctx, accountSQL, a.UserName, pluginutil.OptionalString(a.ClientID), a.Hash, "", a.Enabled); err != nil {
+			return conflict(err, "account", a.UserName)
+		}
+		if !set.ACL {
+			continue
+		}
+		if _, err := tx.Exec(ctx, deleteACLAccountRolesSQL, a.UserName); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, deleteACLAccountGroupsSQL, a.UserName); err != nil {
+			return err
+		}
+		for _, ref := range a.Roles {
+			if _, err := tx.Exec(ctx, insertACLAccountRoleSQL, a.UserName, ref.Name, ref.Priority); err != nil {
+				return fmt.Errorf("account %q: %w", a.UserName, err)
+			}
+		}
+		for _, ref := range a.Groups {
+			if _, err := tx.Exec(ctx, insertACLAccountGroupSQL, a.UserName, ref.Name, ref.Priority); err != nil {
+				return fmt.Errorf("account %q: %w", a.UserName, err)
+			}
+		}
+	}
+	accesses := make([]string, 0, len(set.Defaults))
+	for access := range set.Defaults {
+		accesses = append(accesses, access)
+	}
+	sort.Strings(accesses)
+	for _, access := range accesses {
+		if _, err := tx.Exec(ctx, pick(update, upsertACLDefaultSQL, insertACLDefaultSQL), access, set.Defaults[access]); err != nil {
+			return conflict(err, "default access", access)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+
+	"mosquitto-plugin/internal/pluginutil"
+	"mosquitto-plugin/internal/schema"
+)
+
+// aclRef 是账户/组到角色或账户到组的绑定。
+type aclRef struct {
+	Name     string
+	Priority int
+}
+
+// aclRule 对应 mqtt_acl_rules 的一行。
+type aclRule struct {
+	Type     string
+	Topic    string
+	Priority int
+	Allow    bool
+}
+
+// aclRole 对应 mqtt_acl_roles 及其规则。
+type aclRole struct {
+	Name        string
+	Description string
+	Rules       []aclRule
+}
+
+// aclGroup 对应 mqtt_acl_groups 及其角色绑定。
+type aclGroup struct {
+	Name        string
+	Description string
+	Roles       []aclRef
+}
+
+// importAccount 是待导入的账户（password_hash 保留 mosquitto 原始密文，salt 为空）。
+type importAccount struct {
+	UserName string
+	ClientID string
+	Hash     string
+	Enabled  int16
+	Roles    []aclRef
+	Groups   []aclRef
+}
+
+// importSet 是一次导入的全部内容。
+type importSet struct {
+	Accounts []importAccount
+	Roles    []aclRole
+	Groups   []aclGroup
+	// Defaults 为 mqtt_acl_defaults，nil 表示不导入。
+	Defaults map[string]bool
+	// ACL 为 true 时输入包含 ACL（dynsec），账户的角色/组绑定以输入为准。
+	ACL bool
+	// PasswordsOnly 为 true 时输入只有用户名与密文（password_file），-update 不改动已有账户的 clientid 与 enabled。
+	PasswordsOnly bool
+	// Warnings 是被跳过或需要人工确认的条目。
+	Warnings []string
+}
+
+func (s *importSet) warnf(format string, args ...any) {
+	s.Warnings = append(s.Warnings, fmt.Sprintf(format, args...))
+}
+
+// summary 返回导入内容统计。
+func (s *importSet) summary() string {
+	rules := 0
+	for _, r := range s.Roles {
+		rules += len(r.Rules)
+	}
+	return fmt.Sprintf("%d accounts, %d roles (%d rules), %d groups, %d default access entries",
+		len(s.Accounts), len(s.Roles), rules, len(s.Groups), len(s.Defaults))
+}
+
+// warnDeferredUser 提示 _ 前缀账户：authplugin 默认对其返回 DEFER，交由 password_file 认证。
+func (s *importSet) warnDeferredUser(user string) {
+	if strings.HasPrefix(user, "_") {
+		s.warnf("account %q: auth-plugin defers usernames starting with _ to password_file unless plugin_opt_defer_prefix is none", user)
+	}
+}
+
+// parsePasswdFile 解析 mosquitto_passwd 生成的 password_file（username:$6$... / $7$...）。
+// 无法校验的密文（如 crypt 格式）跳过并记录 warning，其余格式错误直接报错。
+func parsePasswdFile(r io.Reader) (*importSet, error) {
+	set := &importSet{PasswordsOnly: true}
+	seen := map[string]int{}
+	sc := bufio.NewScanner(r)
+	line := 0
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		if line == 1 {
+			text = strings.TrimPrefix(text, "\ufeff")
+		}
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(text, ":")
+		if !ok || user == "" || hash == "" {
+			return nil, fmt.Errorf("line %d: want username:hash", line)
+		}
+		if prev, dup := seen[user]; dup {
+			return nil, fmt.Errorf("line %d: duplicate username %q (first at line %d)", line, user, prev)
+		}
+		seen[user] = line
+		if _, err := pluginutil.ParseMosquittoHash(hash); err != nil {
+			set.warnf("line %d: account %q skipped: %v", line, user, err)
+			continue
+		}
+		set.warnDeferredUser(user)
+		set.Accounts = append(set.Accounts, importAccount{UserName: user, Hash: hash, Enabled: 1})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// dynsecConfig 是 mosquitto dynamic-security 插件的 JSON 配置（只取导入需要的字段）。
+type dynsecConfig struct {
+	DefaultACLAccess map[string]bool `json:"defaultACLAccess"`
+	Clients          []dynsecClient  `json:"clients"`
+	Groups           []dynsecGroup   `json:"groups"`
+	Roles            []dynsecRole    `json:"roles"`
+	AnonymousGroup   string          `json:"anonymousGroup"`
+}
+
+type dynsecClient struct {
+	Username   string      `json:"username"`
+	ClientID   string      `json:"clientid"`
+	Password   string      `json:"password"`
+	Salt       string      `json:"salt"`
+	Iterations int         `json:"iterations"`
+	Disabled   bool        `json:"disabled"`
+	Roles      []dynsecRef `json:"roles"`
+	Groups     []dynsecRef `json:"groups"`
+}
+
+type dynsecRef struct {
+	RoleName  string `json:"rolename"`
+	GroupName string `json:"groupname"`
+	Username  string `json:"username"`
+	Priority  int    `json:"priority"`
+}
+
+type dynsecGroup struct {
+	GroupName       string      `json:"groupname"`
+	TextName        string      `json:"textname"`
+	TextDescription string      `json:"textdescription"`
+	Roles           []dynsecRef `json:"roles"`
+	Clients         []dynsecRef `json:"clients"`
+}
+
+type dynsecRole struct {
+	RoleName        string      `json:"rolename"`
+	TextName        string      `json:"textname"`
+	TextDescription string      `json:"textdescription"`
+	ACLs            []dynsecACL `json:"acls"`
+}
+
+type dynsecACL struct {
+	ACLType  string `json:"acltype"`
+	Topic    string `json:"topic"`
+	Priority int    `json:"priority"`
+	Allow    bool   `json:"allow"`
+}
+
+// dynsecPasswordHash 将 dynsec 的 password/salt/iterations（PBKDF2-SHA512）转换为 $7$ 密文。
+func dynsecPasswordHash(c dynsecClient) (string, error) {
+	salt, err := base64.StdEncoding.DecodeString(c.Salt)
+	if err != nil {
+		return "", fmt.Errorf("invalid salt: %w", err)
+	}
+	hash, err := base64.StdEncoding.DecodeString(c.Password)
+	if err != nil {
+		return "", fmt.Errorf("invalid password: %w", err)
+	}
+	s := pluginutil.MosquittoHash{PBKDF2: true, Iterations: c.Iterations, Salt: salt, Hash: hash}.String()
+	// 再解析一次，校验迭代次数与摘要长度。
+	if _, err := pluginutil.ParseMosquittoHash(s); err != nil {
+		return "", err
+	}
+	return s, nil
+}
+
+func describe(textName, textDescription string) string {
+	if textDescription != "" {
+		return textDescription
+	}
+	return textName
+}
+
+// parseDynsec 解析 dynamic-security.json，校验角色/组引用与 acltype。
+func parseDynsec(r io.Reader) (*importSet, error) {
+	var cfg dynsecConfig
+	dec := json.NewDecoder(r)
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("decode dynamic-security json: %w", err)
+	}
+	set := &importSet{ACL: true}
+
+	if len(cfg.DefaultACLAccess) > 0 {
+		set.Defaults = map[string]bool{}
+		for access, allow := range cfg.DefaultACLAccess {
+			if !slices.Contains(schema.ACLAccesses, access) {
+				return nil, fmt.Errorf("defaultACLAccess: unknown access %q", access)
+			}
+			set.Defaults[access] = allow
+		}
+	}
+
+	roles := map[string]bool{}
+	for _, dr := range cfg.Roles {
+		if dr.RoleName == "" {
+			return nil, errors.New("role without rolename")
+		}
+		if roles[dr.RoleName] {
+			return nil, fmt.Errorf("duplicate role %q", dr.RoleName)
+		}
+		roles[dr.RoleName] = true
+		role := aclRole{Name: dr.RoleName, Description: describe(dr.TextName, dr.TextDescription)}
+		for _, a := range dr.ACLs {
+			if !slices.Contains(schema.ACLTypes, a.ACLType) {
+				return nil, fmt.Errorf("role %q: unknown acltype %q", dr.RoleName, a.ACLType)
+			}
+			if a.Topic == "" {
+				return nil, fmt.Errorf("role %q: %s acl without topic", dr.RoleName, a.ACLType)
+			}
+			role.Rules = append(role.Rules, aclRule{Type: a.ACLType, Topic: a.Topic, Priority: a.Priority, Allow: a.Allow})
+		}
+		set.Roles = append(set.Roles, role)
+	}
+
+	roleRefs := func(owner string, refs []dynsecRef) ([]aclRef, error) {
+		var out []aclRef
+		for _, ref := range refs {
+			if !roles[ref.RoleName] {
+				return nil, fmt.Errorf("%s: unknown role %q", owner, ref.RoleName)
+			}
+			out = append(out, aclRef{Name: ref.RoleName, Priority: ref.Priority})
+		}
+		return out, nil
+	}
+
+	groups := map[string]bool{}
+	// members 收集组内成员（group.clients），与 client.groups 合并。
+	members := map[string]map[string]int{}
+	for _, dg := range cfg.Groups {
+		if dg.GroupName == "" {
+			return nil, errors.New("group without groupname")
+		}
+		if groups[dg.GroupName] {
+			return nil, fmt.Errorf("duplicate group %q", dg.GroupName)
+		}
+		groups[dg.GroupName] = true
+		refs, err := roleRefs("group "+dg.GroupName, dg.Roles)
+		if err != nil {
+			return nil, err
+		}
+		set.Groups = append(set.Groups, aclGroup{Name: dg.GroupName, Description: describe(dg.TextName, dg.TextDescription), Roles: refs})
+		for _, c := range dg.Clients {
+			if members[c.Username] == nil {
+				members[c.Username] = map[string]int{}
+			}
+			members[c.Username][dg.GroupName] = c.Priority
+		}
+	}
+	if cfg.AnonymousGroup != "" {
+		set.warnf("anonymousGroup %q not imported: mqtt_accounts has no anonymous account", cfg.AnonymousGroup)
+	}
+
+	clients := map[string]bool{}
+	for _, dc := range cfg.Clients {
+		if dc.Username == "" {
+			return nil, errors.New("client without username")
+		}
+		if clients[dc.Username] {
+			return nil, fmt.Errorf("duplicate client %q", dc.Username)
+		}
+		clients[dc.Username] = true
+		acc := importAccount{UserName: dc.Username, ClientID: dc.ClientID, Enabled: 1}
+		if dc.Disabled {
+			acc.Enabled = 0
+		}
+		var err error
+		if acc.Roles, err = roleRefs("client "+dc.Username, dc.Roles); err != nil {
+			return nil, err
+		}
+		inGroup := map[string]bool{}
+		for _, ref := range dc.Groups {
+			if !groups[ref.GroupName] {
+				return nil, fmt.Errorf("client %q: unknown group %q", dc.Username, ref.GroupName)
+			}
+			inGroup[ref.GroupName] = true
+			acc.Groups = append(acc.Groups, aclRef{Name: ref.GroupName, Priority: ref.Priority})
+		}
+		extra := make([]string, 0, len(members[dc.Username]))
+		for g := range members[dc.Username] {
+			if !inGroup[g] {
+				extra = append(extra, g)
+			}
+		}
+		slices.Sort(extra)
+		for _, g := range extra {
+			acc.Groups = append(acc.Groups, aclRef{Name: g, Priority: members[dc.Username][g]})
+		}
+		delete(members, dc.Username)
+
+		if dc.Password == "" {
+			set.warnf("client %q skipped: no password (dynsec clients without password cannot use password auth)", dc.Username)
+			continue
+		}
+		if acc.Hash, err = dynsecPasswordHash(dc); err != nil {
+			return nil, fmt.Errorf("client %q: %w", dc.Username, err)
+		}
+		set.warnDeferredUser(acc.UserName)
+		set.Accounts = append(set.Accounts, acc)
+	}
+	// 剩余的组成员不在 clients 中，排序后一次列出。
+	if len(members) > 0 {
+		orphans := slices.Sorted(maps.Keys(members))
+		return nil, fmt.Errorf("group members are not clients: %q", orphans)
+	}
+	return set, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mosquitto-plugin/internal/pluginutil"
+)
+
+// dynsecSample 中 admin 的密码为 password（PBKDF2-SHA512，101 次迭代）。
+const dynsecSample = `{
+  "defaultACLAccess": {"publishClientSend": false, "publishClientReceive": true, "subscribe": false, "unsubscribe": true},
+  "clients": [
+    {
+      "username": "admin",
+      "clientid": "admin-cli",
+      "password": "uAhjSMFrFKPND0iWyTXsxET36hDBAvu7LqiX1au82iDOT9W7IG9XGjasDAepCB3nZMPp79k+PyCilDXRAZuIVA==",
+      "salt": "MDEyMzQ1Njc4OWFi",
+      "iterations": 101,
+      "roles": [{"rolename": "admin"}]
+    },
+    {
+      "username": "dev01",
+      "password": "uAhjSMFrFKPND0iWyTXsxET36hDBAvu7LqiX1au82iDOT9W7IG9XGjasDAepCB3nZMPp79k+PyCilDXRAZuIVA==",
+      "salt": "MDEyMzQ1Njc4OWFi",
+      "iterations": 101,
+      "disabled": true,
+      "groups": [{"groupname": "devices", "priority": 2}]
+    },
+    {"username": "certonly", "groups": [{"groupname": "devices"}]}
+  ],
+  "groups": [
+    {"groupname": "devices", "textname": "Devices", "roles": [{"rolename": "device", "priority": 1}], "clients": [{"username": "dev01"}, {"username": "certonly"}]},
+    {"groupname": "ops", "clients": [{"username": "admin", "priority": 5}]}
+  ],
+  "roles": [
+    {"rolename": "admin", "textdescription": "full access", "acls": [
+      {"acltype": "publishClientSend", "topic": "$CONTROL/dynamic-security/#", "allow": true},
+      {"acltype": "subscribePattern", "topic": "#", "priority": 3, "allow": true}
+    ]},
+    {"rolename": "device", "acls": [{"acltype": "publishClientSend", "topic": "v1/d/%c/#", "allow": true}]}
+  ],
+  "anonymousGroup": "anon"
+}`
+
+func TestParseDynsec(t *testing.T) {
+	set, err := parseDynsec(strings.NewReader(dynsecSample))
+	if err != nil {
+		t.Fatalf("parseDynsec: %v", err)
+	}
+	if !set.ACL || len(set.Accounts) != 2 || len(set.Roles) != 2 || len(set.Groups) != 2 || len(set.Defaults) != 4 {
+		t.Fatalf("unexpected set: %s", set.summary())
+	}
+	admin, dev := set.Accounts[0], set.Accounts[1]
+	if admin.ClientID != "admin-cli" || admin.Enabled != 1 || !reflect.DeepEqual(admin.Roles, []aclRef{{Name: "admin"}}) {
+		t.Fatalf("admin = %+v", admin)
+	}
+	if !reflect.DeepEqual(admin.Groups, []aclRef{{Name: "ops", Priority: 5}}) {
+		t.Fatalf("group membership from group.clients not merged: %+v", admin.Groups)
+	}
+	if !pluginutil.VerifyPassword("password", admin.Hash, "") || pluginutil.VerifyPassword("wrong", admin.Hash, "") {
+		t.Fatalf("converted hash should verify: %q", admin.Hash)
+	}
+	if dev.Enabled != 0 || !reflect.DeepEqual(dev.Groups, []aclRef{{Name: "devices", Priority: 2}}) {
+		t.Fatalf("dev01 = %+v", dev)
+	}
+	if r := set.Roles[0]; r.Description != "full access" || len(r.Rules) != 2 || r.Rules[1] != (aclRule{Type: "subscribePattern", Topic: "#", Priority: 3, Allow: true}) {
+		t.Fatalf("admin role = %+v", r)
+	}
+	if g := set.Groups[0]; g.Description != "Devices" || !reflect.DeepEqual(g.Roles, []aclRef{{Name: "device", Priority: 1}}) {
+		t.Fatalf("devices group = %+v", g)
+	}
+	if set.Defaults["publishClientReceive"] != true || set.Defaults["subscribe"] != false {
+		t.Fatalf("defaults = %v", set.Defaults)
+	}
+	warnings := strings.Join(set.Warnings, "\n")
+	if !strings.Contains(warnings, `"certonly" skipped`) || !strings.Contains(warnings, `anonymousGroup "anon"`) {
+		t.Fatalf("warnings = %q", warnings)
+	}
+}
+
+func TestParseDynsecInvalid(t *testing.T) {
+	cases := map[string]string{
+		"unknown acltype": `{"roles": [{"rolename": "r", "acls": [{"acltype": "publish", "topic": "a"}]}]}`,
+		"unknown role":    `{"clients": [{"username": "u", "roles": [{"rolename": "missing"}]}]}`,
+		"unknown group":   `{"groups": [{"groupname": "g", "clients": [{"username": "ghost"}]}]}`,
+		"bad access":      `{"defaultACLAccess": {"publish": true}}`,
+		"bad hash":        `{"clients": [{"username": "u", "password": "aGFzaA==", "salt": "c2FsdA==", "iterations": 101}]}`,
+		"duplicate role":  `{"roles": [{"rolename": "r"}, {"rolename": "r"}]}`,
+		"not json":        `clients:`,
+	}
+	for name, in := range cases {
+		if _, err := parseDynsec(strings.NewReader(in)); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+
+	// 所有不在 clients 中的组成员按用户名排序一次列出。
+	orphans := `{"groups": [{"groupname": "g", "clients": [{"username": "zed"}, {"username": "amy"}]},
+	  {"groupname": "h", "clients": [{"username": "bob"}]}]}`
+	for i := 0; i < 5; i++ {
+		_, err := parseDynsec(strings.NewReader(orphans))
+		if err == nil || err.Error() != `group members are not clients: ["amy" "bob" "zed"]` {
+			t.Fatalf("orphaned members error = %v", err)
+		}
+	}
+}
+
+func TestParsePasswdFile(t *testing.T) {
+	f, err := os.Open("../../config/password_file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	set, err := parsePasswdFile(f)
+	if err != nil {
+		t.Fatalf("parsePasswdFile: %v", err)
+	}
+	if set.ACL || !set.PasswordsOnly || len(set.Accounts) != 2 || set.Accounts[0].UserName != "_ops" || set.Accounts[1].UserName != "app_web" {
+		t.Fatalf("accounts = %+v", set.Accounts)
+	}
+	if !pluginutil.VerifyPassword("password", set.Accounts[0].Hash, "") {
+		t.Fatal("_ops hash should verify with the documented password")
+	}
+	if len(set.Warnings) != 1 || !strings.Contains(set.Warnings[0], "_ops") {
+		t.Fatalf("warnings = %v", set.Warnings)
+	}
+
+	set, err = parsePasswdFile(strings.NewReader("# comment\n\nlegacy:$5$c2FsdA==$aGFzaA==\n"))
+	if err != nil || len(set.Accounts) != 0 || len(set.Warnings) != 1 || !strings.Contains(set.Warnings[0], "$5$") {
+		t.Fatalf("unsupported hash should be skipped: %+v, %v", set, err)
+	}
+	for _, in := range []string{"nocolon\n", ":$7$1$x$y\n", "u:\n"} {
+		if _, err := parsePasswdFile(strings.NewReader(in)); err == nil {
+			t.Fatalf("parsePasswdFile(%q) should fail", in)
+		}
+	}
+	if _, err := parsePasswdFile(strings.NewReader("u:$7$1$x$y\nu:$7$1$x$y\n")); err == nil {
+		t.Fatal("duplicate username should fail")
+	}
+}
@@ -0,0 +1,114 @@
+// mqttctl 是插件配套的运维命令行工具。
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"mosquitto-plugin/internal/pluginutil"
+)
+
+// command 是一个顶层子命令。
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"migrate", "管理插件表结构（up / status / partition）", runMigrate},
+	{"accounts", "管理 mqtt_accounts 账户（create / list / import ...）", runAccounts},
+	{"audit", "查询认证失败、连接时间线、在线与抖动客户端", runAudit},
+	{"import", "从 dynamic-security.json / password_file 导入账户与 ACL（dynsec / passwd）", runImport},
+	{"config", "离线校验 mosquitto.conf 中的插件配置（check）", runConfig},
+	{"acl", "离线模拟 acl_file 与数据库 ACL 的访问判定（test）", runACL},
+}
+
+// errUsage 表示参数错误，仅输出用法并以 2 退出。
+var errUsage = errors.New("usage")
+
+var stdout io.Writer = os.Stdout
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	name, args := os.Args[1], os.Args[2:]
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		if err := c.run(args); err != nil {
+			if errors.Is(err, errUsage) || errors.Is(err, flag.ErrHelp) {
+				os.Exit(2)
+			}
+			fmt.Fprintln(os.Stderr, "mqttctl "+name+": "+err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: mqttctl <command> [arguments]")
+	fmt.Fprintln(os.Stderr)
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.usage)
+	}
+}
+
+// dbFlags 是访问 PostgreSQL 的公共参数，DSN 缺省取环境变量 PG_DSN（与插件一致）。
+type dbFlags struct {
+	dsn     string
+	timeout time.Duration
+}
+
+func (f *dbFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.dsn, "dsn", os.Getenv("PG_DSN"), "PostgreSQL DSN（默认 $PG_DSN）")
+	fs.DurationVar(&f.timeout, "timeout", 30*time.Second, "整体超时")
+}
+
+// connect 建立单个数据库连接；返回的 cancel 需与连接一起释放。
+func (f *dbFlags) connect() (*pgx.Conn, context.Context, context.CancelFunc, error) {
+	if f.dsn == "" {
+		return nil, nil, nil, errors.New("-dsn or PG_DSN must be set")
+	}
+	ctx, cancel := pluginutil.TimeoutContext(f.timeout)
+	conn, err := pgx.Connect(ctx, f.dsn)
+	if err != nil {
+		cancel()
+		return nil, nil, nil, fmt.Errorf("connect %s: %w", pluginutil.SafeDSN(f.dsn), err)
+	}
+	return conn, ctx, func() {
+		_ = conn.Close(context.Background())
+		cancel()
+	}, nil
+}
+
+// subcommand 在 args[0] 上分发二级子命令。
+func subcommand(prefix string, args []string, subs map[string]func([]string) error, order []string) error {
+	if len(args) > 0 {
+		if run, ok := subs[args[0]]; ok {
+			return run(args[1:])
+		}
+	}
+	fmt.Fprintf(os.Stderr, "usage: mqttctl %s <", prefix)
+	for i, name := range order {
+		if i > 0 {
+			fmt.Fprint(os.Stderr, "|")
+		}
+		fmt.Fprint(os.Stderr, name)
+	}
+	fmt.Fprintln(os.Stderr, "> [flags]")
+	return errUsage
+}
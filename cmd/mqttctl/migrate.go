@@ -0,0 +1,191 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"mosquitto-plugin/internal/pluginutil"
+	"mosquitto-plugin/internal/schema"
+)
+
+func runMigrate(args []string) error {
+	return subcommand("migrate", args, map[string]func([]string) error{
+		"up":        migrateUp,
+		"status":    migrateStatus,
+		"partition": migratePartition,
+	}, []string{"up", "status", "partition"})
+}
+
+// migrateUp 执行待执行迁移；-dry-run 只输出 SQL。
+func migrateUp(args []string) error {
+	fs := flag.NewFlagSet("migrate up", flag.ContinueOnError)
+	var db dbFlags
+	db.register(fs)
+	target := fs.Int("to", 0, "目标版本（默认最新）")
+	dryRun := fs.Bool("dry-run", false, "只输出待执行的 SQL，不修改数据库（未指定 DSN 时输出全部迁移）")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	ms, err := schema.Migrations()
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		current := 0
+		if db.dsn != "" {
+			conn, ctx, done, err := db.connect()
+			if err != nil {
+				return err
+			}
+			defer done()
+			if current, err = schema.CurrentVersion(ctx, conn); err != nil {
+				return err
+			}
+		}
+		for _, m := range schema.Pending(ms, current, *target) {
+			fmt.Fprintf(stdout, "-- %04d_%s\n%s\n", m.Version, m.Name, m.SQL)
+		}
+		return nil
+	}
+
+	conn, ctx, done, err := db.connect()
+	if err != nil {
+		return err
+	}
+	defer done()
+	applied, err := schema.Up(ctx, conn, *target)
+	for _, m := range applied {
+		fmt.Fprintf(stdout, "applied %04d_%s\n", m.Version, m.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		fmt.Fprintln(stdout, "schema is up to date")
+	}
+	return nil
+}
+
+// migrateStatus 列出每个迁移的执行状态。
+func migrateStatus(args []string) error {
+	fs := flag.NewFlagSet("migrate status", flag.ContinueOnError)
+	var db dbFlags
+	db.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	ms, err := schema.Migrations()
+	if err != nil {
+		return err
+	}
+	conn, ctx, done, err := db.connect()
+	if err != nil {
+		return err
+	}
+	defer done()
+	applied, err := schema.Status(ctx, conn)
+	if err != nil {
+		return err
+	}
+	at := map[int]string{}
+	for _, a := range applied {
+		at[a.Version] = a.AppliedAt.UTC().Format("2006-01-02T15:04:05Z")
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED_AT")
+	for _, m := range ms {
+		state := at[m.Version]
+		if state == "" {
+			state = "pending"
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\n", m.Version, m.Name, state)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "\nauth-plugin requires %d, conn-plugin requires %d\n", schema.AuthPluginVersion, schema.ConnPluginVersion)
+	return nil
+}
+
+// migratePartition 把事件表转换为按 ts 分区的表（partition_period 的前置步骤）；已分区的表跳过。
+func migratePartition(args []string) error {
+	fs := flag.NewFlagSet("migrate partition", flag.ContinueOnError)
+	var db dbFlags
+	db.register(fs)
+	periodFlag := fs.String("period", "", "分区粒度 daily|monthly，需与插件 partition_period 一致（必填）")
+	table := fs.String("table", "", "只转换指定表（"+strings.Join(schema.PartitionTables, " / ")+"，默认全部）")
+	dryRun := fs.Bool("dry-run", false, "只输出转换 SQL，不修改数据库（未指定 DSN 时按默认约束/序列名输出）")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	period, ok := pluginutil.ParsePartitionPeriod(*periodFlag)
+	if !ok {
+		return fmt.Errorf("-period must be daily or monthly")
+	}
+	tables := schema.PartitionTables
+	if *table != "" {
+		// PartitionSQL 依赖事件表的列与索引名，其他表不能转换。
+		if !slices.Contains(schema.PartitionTables, *table) {
+			return fmt.Errorf("-table must be one of %s", strings.Join(schema.PartitionTables, ", "))
+		}
+		tables = []string{*table}
+	}
+	now := time.Now()
+
+	if *dryRun && db.dsn == "" {
+		for _, t := range tables {
+			pkey, seq := t+"_pkey", t+"_id_seq"
+			fmt.Fprintf(stdout, "-- %s (assumed defaults: primary key %s, sequence %s; set -dsn to read them from the database)\n", t, pkey, seq)
+			for _, sql := range schema.PartitionSQL(t, period, now, pkey, seq) {
+				fmt.Fprintf(stdout, "%s;\n", sql)
+			}
+		}
+		return nil
+	}
+
+	conn, ctx, done, err := db.connect()
+	if err != nil {
+		return err
+	}
+	defer done()
+	if *dryRun {
+		for _, t := range tables {
+			partitioned, err := schema.Partitioned(ctx, conn, t)
+			if err != nil {
+				return err
+			}
+			if partitioned {
+				fmt.Fprintf(stdout, "-- %s is already partitioned\n", t)
+				continue
+			}
+			pkey, seq, err := schema.PartitionKeys(ctx, conn, t)
+			if err != nil {
+				return fmt.Errorf("%s: %w", t, err)
+			}
+			fmt.Fprintf(stdout, "-- %s\n", t)
+			for _, sql := range schema.PartitionSQL(t, period, now, pkey, seq) {
+				fmt.Fprintf(stdout, "%s;\n", sql)
+			}
+		}
+		return nil
+	}
+	for _, t := range tables {
+		stmts, err := schema.Partition(ctx, conn, t, period, now)
+		if err != nil {
+			return fmt.Errorf("%s: %w", t, err)
+		}
+		if stmts == nil {
+			fmt.Fprintf(stdout, "%s is already partitioned\n", t)
+			continue
+		}
+		fmt.Fprintf(stdout, "partitioned %s (existing rows kept in %s)\n", t,
+			pluginutil.PartitionName(t, period, period.Floor(now)))
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMigratePartitionFlags(t *testing.T) {
+	t.Setenv("PG_DSN", "")
+	old := stdout
+	t.Cleanup(func() { stdout = old })
+	var buf bytes.Buffer
+	stdout = &buf
+
+	// 非事件表在连接数据库前拒绝。
+	err := migratePartition([]string{"-period", "monthly", "-table", "mqtt_accounts"})
+	if err == nil || !strings.Contains(err.Error(), "client_auth_events, client_conn_events") {
+		t.Fatalf("unknown table error = %v", err)
+	}
+	if err := migratePartition([]string{"-period", "hourly", "-dry-run"}); err == nil {
+		t.Fatal("invalid period should be rejected")
+	}
+
+	// 未指定 DSN 时按默认约束/序列名输出，并注明是假定值。
+	if err := migratePartition([]string{"-period", "daily", "-table", "client_conn_events", "-dry-run"}); err != nil {
+		t.Fatalf("dry-run: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"assumed defaults: primary key client_conn_events_pkey, sequence client_conn_events_id_seq",
+		`DROP CONSTRAINT "client_conn_events_pkey";`,
+		"ALTER SEQUENCE client_conn_events_id_seq OWNED BY",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("dry-run output missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "client_auth_events") {
+		t.Fatalf("-table should limit output:\n%s", out)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// 输出格式。
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+	formatCSV   = "csv"
+)
+
+// resultSet 是查询结果：列名取 SQL 中的别名，值保留数据库原始类型。
+type resultSet struct {
+	Columns []string
+	Rows    [][]any
+}
+
+// collectResult 读取全部行。
+func collectResult(rows pgx.Rows) (resultSet, error) {
+	defer rows.Close()
+	var rs resultSet
+	for _, fd := range rows.FieldDescriptions() {
+		rs.Columns = append(rs.Columns, fd.Name)
+	}
+	for rows.Next() {
+		values, err := rows.Values()
+		if err != nil {
+			return rs, err
+		}
+		rs.Rows = append(rs.Rows, values)
+	}
+	return rs, rows.Err()
+}
+
+func validFormat(format string) error {
+	switch format {
+	case formatTable, formatJSON, formatCSV:
+		return nil
+	default:
+		return fmt.Errorf("invalid -format %q (table, json, csv)", format)
+	}
+}
+
+// writeResult 按格式输出结果。
+func writeResult(w io.Writer, format string, rs resultSet) error {
+	switch format {
+	case formatJSON:
+		objs := make([]map[string]any, 0, len(rs.Rows))
+		for _, row := range rs.Rows {
+			obj := make(map[string]any, len(rs.Columns))
+			for i, col := range rs.Columns {
+				obj[col] = row[i]
+			}
+			objs = append(objs, obj)
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(objs)
+	case formatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(rs.Columns); err != nil {
+			return err
+		}
+		for _, row := range rs.Rows {
+			rec := make([]string, len(row))
+			for i, v := range row {
+				rec[i] = cellString(v, "")
+			}
+			if err := cw.Write(rec); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.ToUpper(strings.Join(rs.Columns, "\t")))
+		for _, row := range rs.Rows {
+			rec := make([]string, len(row))
+			for i, v := range row {
+				// 表格中去掉换行/制表符，避免破坏对齐。
+				rec[i] = strings.NewReplacer("\t", " ", "\n", " ").Replace(cellString(v, "-"))
+			}
+			fmt.Fprintln(tw, strings.Join(rec, "\t"))
+		}
+		return tw.Flush()
+	}
+}
+
+// cellString 把单元格转为文本；nil 输出 null。
+func cellString(v any, null string) string {
+	switch x := v.(type) {
+	case nil:
+		return null
+	case string:
+		return x
+	case time.Time:
+		return x.UTC().Format(time.RFC3339)
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case int32:
+		return strconv.FormatInt(int64(x), 10)
+	case int16:
+		return strconv.FormatInt(int64(x), 10)
+	case bool:
+		return strconv.FormatBool(x)
+	case map[string]any, []any:
+		b, err := json.Marshal(x)
+		if err != nil {
+			return fmt.Sprint(x)
+		}
+		return string(b)
+	default:
+		return fmt.Sprint(x)
+	}
+}
@@ -0,0 +1,224 @@
+package pluginconf
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"mosquitto-plugin/internal/pluginutil"
+)
+
+// OptPrefix 是 mosquitto.conf 中插件配置项的前缀。
+const OptPrefix = "plugin_opt_"
+
+// Severity 是检查结果的级别。
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Issue 是一条检查结果；Line 为 0 表示不对应具体行（如缺失必填项）。
+type Issue struct {
+	Severity Severity `json:"severity"`
+	Line     int      `json:"line,omitempty"`
+	Key      string   `json:"key,omitempty"`
+	Message  string   `json:"message"`
+}
+
+// Setting 是 mosquitto.conf 中的一行 plugin_opt_*（Key 不含前缀）。
+type Setting struct {
+	Line  int
+	Key   string
+	Value string
+}
+
+// PluginBlock 是一个 plugin 行及其后的 plugin_opt_* 行。
+type PluginBlock struct {
+	Line     int
+	Path     string
+	Settings []Setting
+}
+
+// Conf 是从 mosquitto.conf 中提取的插件配置。
+type Conf struct {
+	Plugins []PluginBlock
+	// Orphans 是出现在任何 plugin 行之前的 plugin_opt_*，mosquitto 会拒绝启动。
+	Orphans []Setting
+}
+
+// ParseMosquittoConf 按 mosquitto 的规则提取插件配置：
+// plugin_opt_* 归属于其前最近的 plugin / auth_plugin / global_plugin 行。
+func ParseMosquittoConf(r io.Reader) (*Conf, error) {
+	var conf Conf
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	line := 0
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		if line == 1 {
+			text = strings.TrimPrefix(text, "\ufeff")
+		}
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		directive, value := text, ""
+		if i := strings.IndexAny(text, " \t"); i >= 0 {
+			directive, value = text[:i], strings.TrimSpace(text[i+1:])
+		}
+		switch {
+		case directive == "plugin" || directive == "auth_plugin" || directive == "global_plugin":
+			conf.Plugins = append(conf.Plugins, PluginBlock{Line: line, Path: value})
+		case strings.HasPrefix(directive, OptPrefix) || strings.HasPrefix(directive, "auth_opt_"):
+			key := strings.TrimPrefix(strings.TrimPrefix(directive, OptPrefix), "auth_opt_")
+			s := Setting{Line: line, Key: key, Value: value}
+			if len(conf.Plugins) == 0 {
+				conf.Orphans = append(conf.Orphans, s)
+				continue
+			}
+			last := &conf.Plugins[len(conf.Plugins)-1]
+			last.Settings = append(last.Settings, s)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return &conf, nil
+}
+
+// EffectiveOption 是一个配置项的生效值。
+type EffectiveOption struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Source string `json:"source"` // conf / env / default
+	Line   int    `json:"line,omitempty"`
+}
+
+// Report 是一个插件的检查结果。
+type Report struct {
+	Line      int               `json:"line"`
+	Path      string            `json:"path"`
+	Plugin    string            `json:"plugin,omitempty"`
+	Issues    []Issue           `json:"issues"`
+	Effective []EffectiveOption `json:"effective"`
+}
+
+// Check 校验 conf 中的插件配置。getenv 用于 DSN 的环境变量兜底，为 nil 时不读取环境变量。
+// 未识别的插件（非本仓库构建产物）只列出配置项，不做校验。
+func Check(conf *Conf, getenv func(string) string) (orphans []Issue, reports []Report) {
+	for _, s := range conf.Orphans {
+		orphans = append(orphans, Issue{Severity: SeverityError, Line: s.Line, Key: s.Key, Message: "plugin option before any plugin line"})
+	}
+	seen := map[*Spec]int{}
+	for _, b := range conf.Plugins {
+		spec := SpecFor(b.Path)
+		rep := Report{Line: b.Line, Path: b.Path}
+		if spec == nil {
+			rep.Issues = append(rep.Issues, Issue{Severity: SeverityWarning, Line: b.Line, Message: "unknown plugin, options not validated"})
+			for _, s := range b.Settings {
+				rep.Effective = append(rep.Effective, EffectiveOption{Key: s.Key, Value: s.Value, Source: "conf", Line: s.Line})
+			}
+			reports = append(reports, rep)
+			continue
+		}
+		rep.Plugin = spec.Name
+		if prev, dup := seen[spec]; dup {
+			rep.Issues = append(rep.Issues, Issue{Severity: SeverityError, Line: b.Line, Message: fmt.Sprintf("%s already loaded at line %d", spec.Name, prev)})
+		}
+		seen[spec] = b.Line
+		rep.Issues = append(rep.Issues, checkBlock(spec, b, getenv, &rep.Effective)...)
+		reports = append(reports, rep)
+	}
+	return orphans, reports
+}
+
+func checkBlock(spec *Spec, b PluginBlock, getenv func(string) string, effective *[]EffectiveOption) []Issue {
+	var issues []Issue
+	set := map[string]Setting{}
+	for _, s := range b.Settings {
+		opt, ok := spec.Lookup(s.Key)
+		if !ok {
+			issues = append(issues, Issue{Severity: SeverityWarning, Line: s.Line, Key: s.Key, Message: UnknownKeyMessage(spec, s.Key)})
+			continue
+		}
+		if prev, dup := set[s.Key]; dup {
+			issues = append(issues, Issue{Severity: SeverityWarning, Line: s.Line, Key: s.Key, Message: fmt.Sprintf("duplicate option (line %d overridden)", prev.Line)})
+		}
+		set[s.Key] = s
+		if opt.Check != nil {
+			if err := opt.Check(s.Value); err != nil {
+				issues = append(issues, Issue{Severity: SeverityError, Line: s.Line, Key: s.Key, Message: fmt.Sprintf("invalid value %q: %v", maskValue(opt, s.Value), err)})
+			}
+		}
+	}
+
+	values := map[string]string{}
+	for _, opt := range spec.Options {
+		e := EffectiveOption{Key: opt.Key}
+		if s, ok := set[opt.Key]; ok {
+			e.Value, e.Source, e.Line = s.Value, "conf", s.Line
+		} else if opt.Key == spec.DSNKey && getenv != nil && getenv(spec.DSNEnv) != "" {
+			e.Value, e.Source = getenv(spec.DSNEnv), "env"
+			if opt.Check != nil {
+				if err := opt.Check(e.Value); err != nil {
+					issues = append(issues, Issue{Severity: SeverityError, Key: opt.Key, Message: fmt.Sprintf("invalid env %s: %v", spec.DSNEnv, err)})
+				}
+			}
+		} else if opt.Default != "" {
+			e.Value, e.Source = opt.Default, "default"
+		} else {
+			continue
+		}
+		values[opt.Key] = e.Value
+		e.Value = maskValue(opt, e.Value)
+		*effective = append(*effective, e)
+	}
+	if spec.Validate != nil {
+		issues = append(issues, spec.Validate(values)...)
+	}
+	return issues
+}
+
+func maskValue(opt Option, v string) string {
+	if !opt.Secret || v == "" {
+		return v
+	}
+	if strings.Contains(v, "://") {
+		if !strings.Contains(v, ",") {
+			return pluginutil.SafeDSN(v)
+		}
+		// 逗号分隔的 URL 列表（如 queue_nats_url）逐个脱敏。
+		urls := SplitList(v)
+		for i, u := range urls {
+			urls[i] = pluginutil.SafeDSN(u)
+		}
+		return strings.Join(urls, ",")
+	}
+	return "xxxxx"
+}
+
+// UnknownKeyMessage 返回未知配置项的提示；key 属于其他插件时指出所属插件。
+func UnknownKeyMessage(spec *Spec, key string) string {
+	for _, other := range Specs() {
+		if other == spec {
+			continue
+		}
+		if _, ok := other.Lookup(key); ok {
+			return fmt.Sprintf("unknown option (belongs to %s)", other.Name)
+		}
+	}
+	return "unknown option"
+}
+
+// HasErrors 报告 issues 中是否存在 error 级别的结果。
+func HasErrors(issues []Issue) bool {
+	for _, is := range issues {
+		if is.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
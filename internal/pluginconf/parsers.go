@@ -0,0 +1,307 @@
+package pluginconf
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+
+	"mosquitto-plugin/internal/pluginutil"
+)
+
+// FailMode 控制 queue-plugin 发布失败时的处理策略。
+type FailMode int
+
+const (
+	FailModeDrop FailMode = iota
+	FailModeBlock
+	FailModeDisconnect
+)
+
+// ParseFailMode 解析 queue_fail_mode（drop/block/disconnect）。
+func ParseFailMode(v string) (FailMode, bool) {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "drop":
+		return FailModeDrop, true
+	case "block":
+		return FailModeBlock, true
+	case "disconnect":
+		return FailModeDisconnect, true
+	default:
+		return FailModeDrop, false
+	}
+}
+
+// String 将失败策略转回配置字符串。
+func (m FailMode) String() string {
+	switch m {
+	case FailModeBlock:
+		return "block"
+	case FailModeDisconnect:
+		return "disconnect"
+	default:
+		return "drop"
+	}
+}
+
+// queue-plugin 的发送后端（queue_backend）。
+const (
+	QueueBackendRabbitMQ = "rabbitmq"
+	QueueBackendKafka    = "kafka"
+	QueueBackendNATS     = "nats"
+)
+
+// QueueBackends 是 queue_backend 支持的全部后端。
+var QueueBackends = []string{QueueBackendRabbitMQ, QueueBackendKafka, QueueBackendNATS}
+
+// CheckQueueBackend 校验 queue_backend（已归一化为小写）。
+func CheckQueueBackend(v string) error {
+	if !slices.Contains(QueueBackends, v) {
+		return fmt.Errorf("unsupported backend %q (expected %s)", v, strings.Join(QueueBackends, ", "))
+	}
+	return nil
+}
+
+// ParseKafkaBrokers 解析 queue_kafka_brokers（逗号分隔的 host:port）。
+func ParseKafkaBrokers(v string) ([]string, error) {
+	brokers := SplitList(v)
+	if len(brokers) == 0 {
+		return nil, errors.New("must list at least one broker")
+	}
+	for _, b := range brokers {
+		if host, port, err := net.SplitHostPort(b); err != nil || host == "" || port == "" {
+			return nil, fmt.Errorf("broker %q must be host:port", b)
+		}
+	}
+	return brokers, nil
+}
+
+// natsURLSchemes 是 queue_nats_url 支持的 URL scheme。
+var natsURLSchemes = []string{"nats", "tls", "ws", "wss"}
+
+// ParseNATSURLs 解析 queue_nats_url（逗号分隔的 nats://host:port，也支持 tls/ws/wss）。
+func ParseNATSURLs(v string) ([]string, error) {
+	urls := SplitList(v)
+	if len(urls) == 0 {
+		return nil, errors.New("must list at least one server")
+	}
+	for _, s := range urls {
+		u, err := url.Parse(s)
+		if err != nil || u.Host == "" || !slices.Contains(natsURLSchemes, u.Scheme) {
+			return nil, fmt.Errorf("server %q must be a URL like nats://host:4222", pluginutil.SafeDSN(s))
+		}
+	}
+	return urls, nil
+}
+
+// ParseKafkaKey 把 queue_kafka_key 转换为键模板：client_id、topic、none（不设置键）为简写，其余按模板解析。
+func ParseKafkaKey(v string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "client_id":
+		return "{client_id}", nil
+	case "topic":
+		return "{topic}", nil
+	case "none", "":
+		return "", nil
+	}
+	if _, err := pluginutil.ParseKeyTemplate(v); err != nil {
+		return "", err
+	}
+	return v, nil
+}
+
+// queue_kafka_acks 的取值：all 等待全部同步副本，leader 只等待 leader，none 不等待。
+const (
+	KafkaAcksAll    = "all"
+	KafkaAcksLeader = "leader"
+	KafkaAcksNone   = "none"
+)
+
+// ParseKafkaAcks 解析 queue_kafka_acks，也接受 Kafka 的数字写法（-1/1/0）。
+func ParseKafkaAcks(v string) (string, bool) {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case KafkaAcksAll, "-1":
+		return KafkaAcksAll, true
+	case KafkaAcksLeader, "1":
+		return KafkaAcksLeader, true
+	case KafkaAcksNone, "0":
+		return KafkaAcksNone, true
+	default:
+		return "", false
+	}
+}
+
+// KafkaCompressions 是 queue_kafka_compression 支持的压缩算法。
+var KafkaCompressions = []string{"none", "gzip", "snappy", "lz4", "zstd"}
+
+// ParseKafkaCompression 解析 queue_kafka_compression。
+func ParseKafkaCompression(v string) (string, bool) {
+	v = strings.ToLower(strings.TrimSpace(v))
+	return v, slices.Contains(KafkaCompressions, v)
+}
+
+// KafkaSASLMechanisms 是 queue_kafka_sasl_mechanism 支持的机制。
+var KafkaSASLMechanisms = []string{"plain", "scram-sha-256", "scram-sha-512"}
+
+// ParseKafkaSASLMechanism 解析 queue_kafka_sasl_mechanism。
+func ParseKafkaSASLMechanism(v string) (string, bool) {
+	v = strings.ToLower(strings.TrimSpace(v))
+	return v, slices.Contains(KafkaSASLMechanisms, v)
+}
+
+// ExchangeTypes 是 queue_exchange_type 支持的 exchange 类型。
+var ExchangeTypes = []string{"direct", "topic", "fanout", "headers"}
+
+// CheckExchangeType 校验 queue_exchange_type（已归一化为小写）。
+func CheckExchangeType(v string) error {
+	if !slices.Contains(ExchangeTypes, v) {
+		return fmt.Errorf("unsupported exchange_type %q (expected %s)", v, strings.Join(ExchangeTypes, ", "))
+	}
+	return nil
+}
+
+// queue_payload_encoding 的取值。
+const (
+	PayloadJSON         = "json"
+	PayloadJSONOrBase64 = "json_or_base64"
+	PayloadBase64       = "base64"
+	PayloadText         = "text"
+	PayloadRaw          = "raw"
+)
+
+// PayloadEncodings 是 queue_payload_encoding 支持的全部取值。
+var PayloadEncodings = []string{PayloadJSON, PayloadJSONOrBase64, PayloadBase64, PayloadText, PayloadRaw}
+
+// ParsePayloadEncoding 解析 queue_payload_encoding。
+func ParsePayloadEncoding(v string) (string, bool) {
+	v = strings.ToLower(strings.TrimSpace(v))
+	return v, slices.Contains(PayloadEncodings, v)
+}
+
+// queue_ordering 的取值：none 不保证顺序，client 保证同一 client_id 的消息按序发送。
+const (
+	OrderingNone   = "none"
+	OrderingClient = "client"
+)
+
+// ParseOrdering 解析 queue_ordering。
+func ParseOrdering(v string) (string, bool) {
+	switch v = strings.ToLower(strings.TrimSpace(v)); v {
+	case OrderingNone, OrderingClient:
+		return v, true
+	default:
+		return "", false
+	}
+}
+
+// QueueBinding 是 queue_declare_queues 中的一项：队列名与绑定键。
+type QueueBinding struct {
+	Queue string
+	Key   string
+}
+
+// ParseQueueBindings 解析 queue_declare_queues（逗号分隔的 queue[=binding]）。
+func ParseQueueBindings(v string) ([]QueueBinding, error) {
+	var out []QueueBinding
+	for _, item := range SplitList(v) {
+		name, key, _ := strings.Cut(item, "=")
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil, fmt.Errorf("missing queue name in %q", item)
+		}
+		out = append(out, QueueBinding{Queue: name, Key: strings.TrimSpace(key)})
+	}
+	return out, nil
+}
+
+// HeaderArgs 把 headers exchange 的绑定键（h1=v1&h2=v2）解析为需全部匹配的头部；空绑定匹配全部消息。
+func (b QueueBinding) HeaderArgs() (map[string]string, error) {
+	out := map[string]string{}
+	if b.Key == "" {
+		return out, nil
+	}
+	for _, pair := range strings.Split(b.Key, "&") {
+		k, v, ok := strings.Cut(pair, "=")
+		if k = strings.TrimSpace(k); !ok || k == "" {
+			return nil, fmt.Errorf("queue %s: headers binding must be h1=v1&h2=v2, got %q", b.Queue, b.Key)
+		}
+		out[k] = strings.TrimSpace(v)
+	}
+	return out, nil
+}
+
+// ParseQueueType 解析 queue_declare_queue_type（classic/quorum）。
+func ParseQueueType(v string) (string, bool) {
+	switch t := strings.ToLower(strings.TrimSpace(v)); t {
+	case "classic", "quorum":
+		return t, true
+	default:
+		return "", false
+	}
+}
+
+// auth-plugin 认证链（auth_chain）中的后端名。
+const (
+	AuthBackendCache    = "cache"
+	AuthBackendPostgres = "postgres"
+	AuthBackendFile     = "file"
+	AuthBackendHTTP     = "http"
+)
+
+// AuthBackends 是 auth_chain 支持的全部后端。
+var AuthBackends = []string{AuthBackendCache, AuthBackendPostgres, AuthBackendFile, AuthBackendHTTP}
+
+// ParseAuthChain 解析 auth_chain（逗号分隔、按顺序执行），后端不可重复。
+func ParseAuthChain(v string) ([]string, error) {
+	var chain []string
+	for _, name := range strings.Split(v, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if !slices.Contains(AuthBackends, name) {
+			return nil, fmt.Errorf("unknown backend %q (expected %s)", name, strings.Join(AuthBackends, ", "))
+		}
+		if slices.Contains(chain, name) {
+			return nil, fmt.Errorf("duplicate backend %q", name)
+		}
+		chain = append(chain, name)
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("must list at least one backend")
+	}
+	if len(chain) == 1 && chain[0] == AuthBackendCache {
+		return nil, errors.New("cache needs another backend after it")
+	}
+	return chain, nil
+}
+
+// SplitList 解析逗号分隔的列表，去掉空白与空项。
+func SplitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// ParseTopicFilters 解析逗号分隔的 MQTT 主题过滤器（queue_include_topics 等）。
+// 共享订阅写法 $share/<group>/<filter> 按 <filter> 处理。
+func ParseTopicFilters(v string) ([]string, error) {
+	var out []string
+	for _, item := range SplitList(v) {
+		filter, ok := pluginutil.StripSharePrefix(item)
+		if !ok {
+			return nil, fmt.Errorf("invalid shared subscription %q", item)
+		}
+		if err := pluginutil.ValidateTopicFilter(filter); err != nil {
+			return nil, fmt.Errorf("invalid topic filter %q: %w", item, err)
+		}
+		out = append(out, filter)
+	}
+	return out, nil
+}
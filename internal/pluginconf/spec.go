@@ -0,0 +1,491 @@
+// Package pluginconf 描述各插件支持的 plugin_opt_* 配置项，供插件初始化与 mqttctl config check 共用。
+package pluginconf
+
+import (
+	"errors"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"mosquitto-plugin/internal/pluginutil"
+)
+
+// Option 描述一个配置项（不含 plugin_opt_ 前缀）。
+type Option struct {
+	Key     string
+	Default string
+	// Secret=true 时输出脱敏：URL 经 SafeDSN 遮盖密码，其他取值整体遮盖。
+	Secret bool
+	// Check 使用与插件相同的解析函数校验取值；nil 表示任意字符串。
+	Check func(value string) error
+}
+
+// Spec 描述一个插件的全部配置项。
+type Spec struct {
+	// Name 为构建产物名（build/<Name>）。
+	Name string
+	// DSNKey 为 DSN 配置项，DSNEnv 为其环境变量兜底。
+	DSNKey  string
+	DSNEnv  string
+	Options []Option
+	// Validate 做跨字段校验，values 为生效值（含默认值与环境变量）。
+	Validate func(values map[string]string) []Issue
+}
+
+// Lookup 按 key 查找配置项。
+func (s *Spec) Lookup(key string) (Option, bool) {
+	for _, o := range s.Options {
+		if o.Key == key {
+			return o, true
+		}
+	}
+	return Option{}, false
+}
+
+// Specs 返回全部插件的配置描述。
+func Specs() []*Spec {
+	return []*Spec{Auth, Conn, Queue}
+}
+
+// SpecFor 按插件路径（build/auth-plugin、auth-plugin.so 等）识别插件。
+func SpecFor(path string) *Spec {
+	base := path
+	if i := strings.LastIndexAny(base, `/\`); i >= 0 {
+		base = base[i+1:]
+	}
+	for _, ext := range []string{".so", ".dylib", ".dll"} {
+		base = strings.TrimSuffix(base, ext)
+	}
+	for _, s := range Specs() {
+		if base == s.Name {
+			return s
+		}
+	}
+	return nil
+}
+
+func checkTimeoutMS(v string) error {
+	if _, ok := pluginutil.ParseTimeoutMS(v); !ok {
+		return errors.New("must be a positive integer (milliseconds)")
+	}
+	return nil
+}
+
+func checkBool(v string) error {
+	if _, ok := pluginutil.ParseBoolOption(v); !ok {
+		return errors.New("must be a boolean (true/false, 1/0, yes/no, on/off)")
+	}
+	return nil
+}
+
+func checkPositiveInt(v string) error {
+	if _, ok := pluginutil.ParsePositiveInt(v); !ok {
+		return errors.New("must be a positive integer")
+	}
+	return nil
+}
+
+func checkQoS(v string) error {
+	if _, ok := pluginutil.ParseQoS(v); !ok {
+		return errors.New("must be 0, 1 or 2")
+	}
+	return nil
+}
+
+func checkPGDSN(v string) error {
+	_, err := pgxpool.ParseConfig(v)
+	return err
+}
+
+func checkAMQPDSN(v string) error {
+	_, err := amqp.ParseURI(v)
+	return err
+}
+
+// checkNonEmpty 与插件一致：空值保留默认值，非空值归一化为小写后校验。
+func checkNonEmpty(v string, check func(string) error) error {
+	if v == "" {
+		return nil
+	}
+	return check(strings.ToLower(strings.TrimSpace(v)))
+}
+
+func checkAuthChain(v string) error {
+	_, err := ParseAuthChain(v)
+	return err
+}
+
+func checkKeyTemplate(v string) error {
+	_, err := pluginutil.ParseKeyTemplate(v)
+	return err
+}
+
+func checkKafkaBrokers(v string) error {
+	_, err := ParseKafkaBrokers(v)
+	return err
+}
+
+func checkNATSURLs(v string) error {
+	_, err := ParseNATSURLs(v)
+	return err
+}
+
+func checkKafkaKey(v string) error {
+	_, err := ParseKafkaKey(v)
+	return err
+}
+
+func checkKafkaAcks(v string) error {
+	if _, ok := ParseKafkaAcks(v); !ok {
+		return errors.New("must be all, leader or none")
+	}
+	return nil
+}
+
+func checkKafkaCompression(v string) error {
+	if _, ok := ParseKafkaCompression(v); !ok {
+		return errors.New("must be one of " + strings.Join(KafkaCompressions, ", "))
+	}
+	return nil
+}
+
+func checkKafkaSASLMechanism(v string) error {
+	if _, ok := ParseKafkaSASLMechanism(v); !ok {
+		return errors.New("must be one of " + strings.Join(KafkaSASLMechanisms, ", "))
+	}
+	return nil
+}
+
+func checkQueueBindings(v string) error {
+	_, err := ParseQueueBindings(v)
+	return err
+}
+
+func checkPayloadEncoding(v string) error {
+	if _, ok := ParsePayloadEncoding(v); !ok {
+		return errors.New("must be one of " + strings.Join(PayloadEncodings, ", "))
+	}
+	return nil
+}
+
+func checkOrdering(v string) error {
+	if _, ok := ParseOrdering(v); !ok {
+		return errors.New("must be none or client")
+	}
+	return nil
+}
+
+func checkQueueType(v string) error {
+	if _, ok := ParseQueueType(v); !ok {
+		return errors.New("must be classic or quorum")
+	}
+	return nil
+}
+
+func checkTopicFilters(v string) error {
+	_, err := ParseTopicFilters(v)
+	return err
+}
+
+func checkHTTPURL(v string) error {
+	u, err := url.Parse(v)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("must be an http:// or https:// URL")
+	}
+	return nil
+}
+
+func checkFailMode(v string) error {
+	if _, ok := ParseFailMode(v); !ok {
+		return errors.New("must be drop, block or disconnect")
+	}
+	return nil
+}
+
+// partitionOptions 返回分区维护配置项，prefix 为插件前缀（conn-plugin 为 conn_）。
+func partitionOptions(prefix string) []Option {
+	check := func(name string) func(string) error {
+		return func(v string) error {
+			cfg := pluginutil.NewPartitionConfig("")
+			if _, ok := pluginutil.ApplyPartitionOption(&cfg, name, v); !ok {
+				return errors.New("invalid value")
+			}
+			return nil
+		}
+	}
+	return []Option{
+		{Key: prefix + "partition_period", Check: check("partition_period")},
+		{Key: prefix + "partition_premake", Default: strconv.Itoa(pluginutil.DefaultPartitionPremake), Check: check("partition_premake")},
+		{Key: prefix + "partition_retention_days", Check: check("partition_retention_days")},
+		{Key: prefix + "partition_retention_mode", Default: "drop", Check: check("partition_retention_mode")},
+		{Key: prefix + "partition_check_ms", Default: strconv.Itoa(int(pluginutil.DefaultPartitionCheckInterval / time.Millisecond)), Check: check("partition_check_ms")},
+	}
+}
+
+// requireDSN 校验 DSN 已配置（含环境变量兜底）。
+func requireDSN(s *Spec, values map[string]string) []Issue {
+	if values[s.DSNKey] == "" {
+		return []Issue{{Severity: SeverityError, Key: s.DSNKey, Message: "must be set (or env " + s.DSNEnv + ")"}}
+	}
+	return nil
+}
+
+// Auth 是 auth-plugin 的配置项。
+var Auth = &Spec{
+	Name:   "auth-plugin",
+	DSNKey: "pg_dsn",
+	DSNEnv: "PG_DSN",
+	Options: append([]Option{
+		{Key: "pg_dsn", Secret: true, Check: checkPGDSN},
+		{Key: "timeout_ms", Default: "1500", Check: checkTimeoutMS},
+		{Key: "fail_open", Default: "false", Check: checkBool},
+		{Key: "schema_check", Default: "true", Check: checkBool},
+		{Key: "defer_prefix", Default: "_"},
+		{Key: "auth_chain", Default: "postgres", Check: checkAuthChain},
+		{Key: "auth_cache_ttl_ms", Default: "60000", Check: checkTimeoutMS},
+		{Key: "auth_cache_size", Default: "10000", Check: checkPositiveInt},
+		{Key: "auth_file"},
+		{Key: "auth_http_url", Secret: true, Check: checkHTTPURL},
+		{Key: "auth_http_token", Secret: true},
+		{Key: "auth_http_timeout_ms", Check: checkTimeoutMS},
+	}, partitionOptions("")...),
+}
+
+// Conn 是 conn-plugin 的配置项。
+var Conn = &Spec{
+	Name:   "conn-plugin",
+	DSNKey: "conn_pg_dsn",
+	DSNEnv: "PG_DSN",
+	Options: append([]Option{
+		{Key: "conn_pg_dsn", Secret: true, Check: checkPGDSN},
+		{Key: "conn_timeout_ms", Default: "1000", Check: checkTimeoutMS},
+		{Key: "conn_schema_check", Default: "true", Check: checkBool},
+		{Key: "conn_track_messages", Default: "false", Check: checkBool},
+		{Key: "conn_presence_topic"},
+		{Key: "conn_presence_qos", Default: "1", Check: checkQoS},
+		{Key: "conn_flap_threshold", Check: checkPositiveInt},
+		{Key: "conn_flap_window_ms", Default: "60000", Check: checkTimeoutMS},
+		{Key: "conn_flap_suppress", Default: "false", Check: checkBool},
+		{Key: "conn_flap_summary_every", Default: "20", Check: checkPositiveInt},
+		{Key: "conn_flap_quarantine_ms", Check: checkTimeoutMS},
+		{Key: "conn_flap_quarantine_standalone", Default: "false", Check: checkBool},
+		{Key: "conn_flap_topic"},
+		{Key: "conn_snapshot_interval_ms", Check: checkTimeoutMS},
+		{Key: "conn_node_id"},
+	}, partitionOptions("conn_")...),
+}
+
+// Queue 是 queue-plugin 的配置项。
+var Queue = &Spec{
+	Name:   "queue-plugin",
+	DSNKey: "queue_dsn",
+	DSNEnv: "QUEUE_DSN",
+	Options: []Option{
+		{Key: "queue_backend", Default: "rabbitmq", Check: func(v string) error { return checkNonEmpty(v, CheckQueueBackend) }},
+		{Key: "queue_dsn", Secret: true, Check: checkAMQPDSN},
+		{Key: "queue_exchange"},
+		{Key: "queue_exchange_type", Default: "direct", Check: func(v string) error { return checkNonEmpty(v, CheckExchangeType) }},
+		{Key: "queue_routing_key", Check: checkKeyTemplate},
+		{Key: "queue_declare", Default: "false", Check: checkBool},
+		{Key: "queue_declare_queues", Check: checkQueueBindings},
+		{Key: "queue_declare_queue_type", Default: "classic", Check: checkQueueType},
+		{Key: "queue_declare_dlx"},
+		{Key: "queue_timeout_ms", Check: checkTimeoutMS},
+		{Key: "queue_enqueue_timeout_ms", Default: "1000", Check: checkTimeoutMS},
+		{Key: "queue_publish_timeout_ms", Default: "1000", Check: checkTimeoutMS},
+		{Key: "queue_fail_mode", Default: "drop", Check: checkFailMode},
+		{Key: "queue_payload_encoding", Default: PayloadJSON, Check: checkPayloadEncoding},
+		{Key: "queue_confirm", Default: "false", Check: checkBool},
+		{Key: "queue_confirm_timeout_ms", Default: "5000", Check: checkTimeoutMS},
+		{Key: "queue_retry_max", Default: "3", Check: checkPositiveInt},
+		{Key: "queue_retry_backoff_ms", Default: "200", Check: checkTimeoutMS},
+		{Key: "queue_retry_backoff_max_ms", Default: "5000", Check: checkTimeoutMS},
+		{Key: "queue_stats_interval_ms", Check: checkTimeoutMS},
+		{Key: "queue_buffer", Default: "4096", Check: checkPositiveInt},
+		{Key: "queue_workers", Default: "1", Check: checkPositiveInt},
+		{Key: "queue_channels", Check: checkPositiveInt},
+		{Key: "queue_ordering", Default: OrderingClient, Check: checkOrdering},
+		{Key: "queue_shutdown_drain_ms", Check: checkTimeoutMS},
+		{Key: "queue_spool_dir"},
+		{Key: "queue_spool_max_bytes", Default: "1073741824", Check: checkPositiveInt},
+		{Key: "queue_spool_segment_bytes", Default: "67108864", Check: checkPositiveInt},
+		{Key: "queue_kafka_brokers", Check: checkKafkaBrokers},
+		{Key: "queue_kafka_topic", Check: checkKeyTemplate},
+		{Key: "queue_kafka_key", Default: "client_id", Check: checkKafkaKey},
+		{Key: "queue_kafka_acks", Default: KafkaAcksAll, Check: checkKafkaAcks},
+		{Key: "queue_kafka_idempotent", Default: "true", Check: checkBool},
+		{Key: "queue_kafka_compression", Default: "none", Check: checkKafkaCompression},
+		{Key: "queue_kafka_tls", Default: "false", Check: checkBool},
+		{Key: "queue_kafka_tls_ca_file"},
+		{Key: "queue_kafka_tls_cert_file"},
+		{Key: "queue_kafka_tls_key_file"},
+		{Key: "queue_kafka_sasl_mechanism", Check: checkKafkaSASLMechanism},
+		{Key: "queue_kafka_sasl_username"},
+		{Key: "queue_kafka_sasl_password", Secret: true},
+		{Key: "queue_nats_url", Secret: true, Check: checkNATSURLs},
+		{Key: "queue_nats_subject", Check: checkKeyTemplate},
+		{Key: "queue_nats_creds_file"},
+		{Key: "queue_nats_tls", Default: "false", Check: checkBool},
+		{Key: "queue_nats_tls_ca_file"},
+		{Key: "queue_nats_tls_cert_file"},
+		{Key: "queue_nats_tls_key_file"},
+		{Key: "queue_include_topics", Check: checkTopicFilters},
+		{Key: "queue_exclude_topics", Check: checkTopicFilters},
+		{Key: "queue_include_users"},
+		{Key: "queue_exclude_users"},
+		{Key: "queue_include_clients"},
+		{Key: "queue_exclude_clients"},
+		{Key: "queue_include_retained", Default: "true", Check: checkBool},
+	},
+}
+
+func init() {
+	Auth.Validate = func(values map[string]string) []Issue {
+		issues := requireDSN(Auth, values)
+		backends, err := ParseAuthChain(values["auth_chain"])
+		if err != nil {
+			return issues
+		}
+		for _, req := range []struct{ backend, key string }{{AuthBackendFile, "auth_file"}, {AuthBackendHTTP, "auth_http_url"}} {
+			if slices.Contains(backends, req.backend) && values[req.key] == "" {
+				issues = append(issues, Issue{Severity: SeverityError, Key: req.key, Message: "must be set when auth_chain includes " + req.backend})
+			}
+		}
+		return issues
+	}
+	Conn.Validate = func(values map[string]string) []Issue {
+		issues := requireDSN(Conn, values)
+		if values["conn_flap_threshold"] == "" {
+			for _, key := range []string{"conn_flap_suppress", "conn_flap_quarantine_ms", "conn_flap_topic"} {
+				if v := values[key]; v != "" && v != "false" {
+					issues = append(issues, Issue{Severity: SeverityWarning, Key: key, Message: "has no effect without conn_flap_threshold"})
+				}
+			}
+		}
+		if standalone, _ := pluginutil.ParseBoolOption(values["conn_flap_quarantine_standalone"]); standalone && values["conn_flap_quarantine_ms"] == "" {
+			issues = append(issues, Issue{Severity: SeverityWarning, Key: "conn_flap_quarantine_standalone", Message: "has no effect without conn_flap_quarantine_ms"})
+		}
+		return issues
+	}
+	Queue.Validate = func(values map[string]string) []Issue {
+		var issues []Issue
+		backend := strings.ToLower(strings.TrimSpace(values["queue_backend"]))
+		switch backend {
+		case QueueBackendKafka:
+			issues = validateKafka(values)
+		case QueueBackendNATS:
+			issues = validateNATS(values)
+		default:
+			issues = requireDSN(Queue, values)
+			if values["queue_exchange"] == "" {
+				issues = append(issues, Issue{Severity: SeverityError, Key: "queue_exchange", Message: "must be set"})
+			}
+		}
+		if backend == QueueBackendKafka || backend == QueueBackendNATS {
+			for _, key := range rabbitmqOnlyOptions {
+				if opt, _ := Queue.Lookup(key); values[key] != opt.Default {
+					issues = append(issues, Issue{Severity: SeverityWarning, Key: key, Message: "has no effect with queue_backend=" + backend})
+				}
+			}
+		}
+		for _, b := range []string{QueueBackendKafka, QueueBackendNATS} {
+			if b == backend {
+				continue
+			}
+			for _, opt := range Queue.Options {
+				if strings.HasPrefix(opt.Key, "queue_"+b+"_") && values[opt.Key] != opt.Default {
+					issues = append(issues, Issue{Severity: SeverityWarning, Key: opt.Key, Message: "has no effect without queue_backend=" + b})
+				}
+			}
+		}
+		if confirm, _ := pluginutil.ParseBoolOption(values["queue_confirm"]); !confirm {
+			for _, key := range []string{"queue_confirm_timeout_ms", "queue_retry_max", "queue_retry_backoff_ms", "queue_retry_backoff_max_ms"} {
+				if opt, _ := Queue.Lookup(key); values[key] != opt.Default {
+					issues = append(issues, Issue{Severity: SeverityWarning, Key: key, Message: "has no effect without queue_confirm=true"})
+				}
+			}
+		}
+		if values["queue_spool_dir"] == "" {
+			for _, key := range []string{"queue_spool_max_bytes", "queue_spool_segment_bytes"} {
+				if opt, _ := Queue.Lookup(key); values[key] != opt.Default {
+					issues = append(issues, Issue{Severity: SeverityWarning, Key: key, Message: "has no effect without queue_spool_dir"})
+				}
+			}
+		}
+		if declare, _ := pluginutil.ParseBoolOption(values["queue_declare"]); !declare {
+			for _, key := range []string{"queue_declare_queues", "queue_declare_dlx"} {
+				if values[key] != "" {
+					issues = append(issues, Issue{Severity: SeverityWarning, Key: key, Message: "has no effect without queue_declare=true"})
+				}
+			}
+		} else if strings.ToLower(strings.TrimSpace(values["queue_exchange_type"])) == "headers" {
+			bindings, _ := ParseQueueBindings(values["queue_declare_queues"])
+			for _, b := range bindings {
+				if _, err := b.HeaderArgs(); err != nil {
+					issues = append(issues, Issue{Severity: SeverityError, Key: "queue_declare_queues", Message: err.Error()})
+				}
+			}
+		}
+		return issues
+	}
+}
+
+// rabbitmqOnlyOptions 是 queue_backend=kafka/nats 时不生效的 RabbitMQ 配置项。
+var rabbitmqOnlyOptions = []string{"queue_dsn", "queue_exchange", "queue_exchange_type", "queue_routing_key", "queue_declare", "queue_channels"}
+
+// validateKafka 校验 queue_backend=kafka 的必填项与组合。
+func validateKafka(values map[string]string) []Issue {
+	var issues []Issue
+	for _, key := range []string{"queue_kafka_brokers", "queue_kafka_topic"} {
+		if values[key] == "" {
+			issues = append(issues, Issue{Severity: SeverityError, Key: key, Message: "must be set"})
+		}
+	}
+	acks, _ := ParseKafkaAcks(values["queue_kafka_acks"])
+	if idempotent, _ := pluginutil.ParseBoolOption(values["queue_kafka_idempotent"]); idempotent && acks != KafkaAcksAll {
+		issues = append(issues, Issue{Severity: SeverityWarning, Key: "queue_kafka_idempotent", Message: "requires queue_kafka_acks=all, idempotent writes disabled"})
+	}
+	if tls, _ := pluginutil.ParseBoolOption(values["queue_kafka_tls"]); !tls {
+		for _, key := range []string{"queue_kafka_tls_ca_file", "queue_kafka_tls_cert_file", "queue_kafka_tls_key_file"} {
+			if values[key] != "" {
+				issues = append(issues, Issue{Severity: SeverityWarning, Key: key, Message: "has no effect without queue_kafka_tls=true"})
+			}
+		}
+	}
+	if (values["queue_kafka_tls_cert_file"] == "") != (values["queue_kafka_tls_key_file"] == "") {
+		issues = append(issues, Issue{Severity: SeverityError, Key: "queue_kafka_tls_cert_file", Message: "queue_kafka_tls_cert_file and queue_kafka_tls_key_file must be set together"})
+	}
+	if values["queue_kafka_sasl_mechanism"] != "" && (values["queue_kafka_sasl_username"] == "" || values["queue_kafka_sasl_password"] == "") {
+		issues = append(issues, Issue{Severity: SeverityError, Key: "queue_kafka_sasl_mechanism", Message: "requires queue_kafka_sasl_username and queue_kafka_sasl_password"})
+	}
+	return issues
+}
+
+// validateNATS 校验 queue_backend=nats 的必填项与组合。
+func validateNATS(values map[string]string) []Issue {
+	var issues []Issue
+	for _, key := range []string{"queue_nats_url", "queue_nats_subject"} {
+		if values[key] == "" {
+			issues = append(issues, Issue{Severity: SeverityError, Key: key, Message: "must be set"})
+		}
+	}
+	if tls, _ := pluginutil.ParseBoolOption(values["queue_nats_tls"]); !tls {
+		for _, key := range []string{"queue_nats_tls_ca_file", "queue_nats_tls_cert_file", "queue_nats_tls_key_file"} {
+			if values[key] != "" {
+				issues = append(issues, Issue{Severity: SeverityWarning, Key: key, Message: "has no effect without queue_nats_tls=true"})
+			}
+		}
+	}
+	if (values["queue_nats_tls_cert_file"] == "") != (values["queue_nats_tls_key_file"] == "") {
+		issues = append(issues, Issue{Severity: SeverityError, Key: "queue_nats_tls_cert_file", Message: "queue_nats_tls_cert_file and queue_nats_tls_key_file must be set together"})
+	}
+	return issues
+}
@@ -1,12 +1,44 @@
 package pluginutil
 
 import (
+	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
 )
 
+// saltBytes 是 NewSalt 生成的随机字节数。
+const saltBytes = 16
+
 // SHA256PwdSalt 使用盐对密码做 SHA-256，并返回十六进制字符串。
 func SHA256PwdSalt(password, salt string) string {
 	sum := sha256.Sum256([]byte(password + salt))
 	return hex.EncodeToString(sum[:])
 }
+
+// NewSalt 生成随机盐（十六进制）。
+func NewSalt() (string, error) {
+	b := make([]byte, saltBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// HashPassword 生成随机盐并返回 mqtt_accounts 使用的 password_hash 与 salt。
+func HashPassword(password string) (hash, salt string, err error) {
+	salt, err = NewSalt()
+	if err != nil {
+		return "", "", err
+	}
+	return SHA256PwdSalt(password, salt), salt, nil
+}
+
+// VerifyPassword 校验明文密码与 mqtt_accounts 中的 password_hash/salt 是否匹配。
+// password_hash 为 mosquitto 格式（$7$...，自 password_file / dynsec 导入）时忽略 salt 列。
+func VerifyPassword(password, hash, salt string) bool {
+	if IsMosquittoHash(hash) {
+		return verifyMosquittoHash(password, hash)
+	}
+	return subtle.ConstantTimeCompare([]byte(SHA256PwdSalt(password, salt)), []byte(hash)) == 1
+}
@@ -10,3 +10,71 @@ func TestSHA256PwdSalt(t *testing.T) {
 		t.Fatalf("SHA256PwdSalt mismatch: got %q want %q", got, want)
 	}
 }
+
+func TestHashPasswordVerify(t *testing.T) {
+	t.Parallel()
+
+	hash, salt, err := HashPassword("s3cret")
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	if len(salt) != 2*saltBytes || hash != SHA256PwdSalt("s3cret", salt) {
+		t.Fatalf("unexpected hash/salt: %q %q", hash, salt)
+	}
+	if !VerifyPassword("s3cret", hash, salt) {
+		t.Fatal("VerifyPassword should accept the original password")
+	}
+	if VerifyPassword("wrong", hash, salt) || VerifyPassword("s3cret", hash, salt+"x") {
+		t.Fatal("VerifyPassword should reject wrong password or salt")
+	}
+	_, salt2, _ := HashPassword("s3cret")
+	if salt == salt2 {
+		t.Fatal("salts should be random")
+	}
+}
+
+// opsHash 取自 config/password_file（mosquitto_passwd 生成，密码为 password）。
+const opsHash = "$7$1000$R3XtB/U3TLTqS01Qny/aCkWRuRyCfeLKszGd1h01/mmUAGvLUsemLVWxZ845RtsDwA1gEdX2H9h+cd83VSq4ag==$8P1yCu5uDzdMXUtvcn9TaATi2cDqXVi793IWvfWkK283pIvBhIF3v9vmLA/MNJ3PzhP3blu14iRjEAsEZ6lx7A=="
+
+func TestVerifyMosquittoHash(t *testing.T) {
+	t.Parallel()
+
+	if !VerifyPassword("password", opsHash, "") {
+		t.Fatal("VerifyPassword should accept mosquitto_passwd hash")
+	}
+	if VerifyPassword("Password", opsHash, "") || VerifyPassword("password", opsHash[:len(opsHash)-4], "") {
+		t.Fatal("VerifyPassword should reject wrong password or truncated hash")
+	}
+	h, err := ParseMosquittoHash(opsHash)
+	if err != nil {
+		t.Fatalf("ParseMosquittoHash: %v", err)
+	}
+	if !h.PBKDF2 || h.Iterations != 1000 || len(h.Salt) != 64 || h.String() != opsHash {
+		t.Fatalf("unexpected parse result: %d %d %q", h.Iterations, len(h.Salt), h.String())
+	}
+}
+
+// sha512Hash 为 $6$ 格式（mosquitto 1.x），密码为 password。
+const sha512Hash = "$6$YWJjZGVmZ2hpamts$H1lO+pqB+PFRkTndJd8DTn+8FqO1YYPMzfvhZwnJhrwMenzZ+jkyfYTUGX/NQIKcWwWnw7fslZIwR99AaWZglA=="
+
+func TestVerifyMosquittoSHA512Hash(t *testing.T) {
+	t.Parallel()
+
+	if !VerifyPassword("password", sha512Hash, "") || VerifyPassword("password1", sha512Hash, "") {
+		t.Fatal("VerifyPassword should verify $6$ hash")
+	}
+	h, err := ParseMosquittoHash(sha512Hash)
+	if err != nil || h.PBKDF2 || string(h.Salt) != "abcdefghijkl" || h.String() != sha512Hash {
+		t.Fatalf("ParseMosquittoHash = %+v, %v", h, err)
+	}
+}
+
+func TestParseMosquittoHashInvalid(t *testing.T) {
+	t.Parallel()
+
+	for _, in := range []string{"", "plain", "$7$", "$7$0$c2FsdA==$aGFzaA==", "$7$10$!!$aGFzaA==", "$7$10$c2FsdA==$aGFzaA==", "$6$c2FsdA==", "$6$c2FsdA==$aGFzaA==", "$5$abc$def"} {
+		if _, err := ParseMosquittoHash(in); err == nil {
+			t.Fatalf("ParseMosquittoHash(%q) should fail", in)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package pluginutil
+
+import (
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// mosquitto_passwd / dynamic-security 的密文前缀。
+const (
+	// MosquittoSHA512Prefix：$6$<base64 salt>$<base64 sha512(password + salt)>（mosquitto 1.x 默认）。
+	MosquittoSHA512Prefix = "$6$"
+	// MosquittoPBKDF2Prefix：$7$<iterations>$<base64 salt>$<base64 pbkdf2-sha512>（mosquitto 2.x 默认）。
+	MosquittoPBKDF2Prefix = "$7$"
+)
+
+// mosquittoHashLen 是 mosquitto 保存的摘要长度（SHA-512）。
+const mosquittoHashLen = sha512.Size
+
+// MosquittoHash 是解析后的 mosquitto 密文。
+type MosquittoHash struct {
+	// PBKDF2 为 false 时是 $6$（单次 SHA-512），Iterations 无意义。
+	PBKDF2     bool
+	Iterations int
+	Salt       []byte
+	Hash       []byte
+}
+
+// IsMosquittoHash 报告 password_hash 是否为 mosquitto 格式（此时 salt 列不参与校验）。
+func IsMosquittoHash(hash string) bool {
+	return strings.HasPrefix(hash, MosquittoPBKDF2Prefix) || strings.HasPrefix(hash, MosquittoSHA512Prefix)
+}
+
+// ParseMosquittoHash 解析 password_file 中冒号后的密文。
+func ParseMosquittoHash(s string) (MosquittoHash, error) {
+	var h MosquittoHash
+	var parts []string
+	switch {
+	case strings.HasPrefix(s, MosquittoPBKDF2Prefix):
+		parts = strings.Split(s[len(MosquittoPBKDF2Prefix):], "$")
+		if len(parts) != 3 {
+			return MosquittoHash{}, errors.New("malformed $7$ hash")
+		}
+		iterations, err := strconv.Atoi(parts[0])
+		if err != nil || iterations <= 0 {
+			return MosquittoHash{}, fmt.Errorf("invalid iterations %q", parts[0])
+		}
+		h.PBKDF2, h.Iterations = true, iterations
+		parts = parts[1:]
+	case strings.HasPrefix(s, MosquittoSHA512Prefix):
+		parts = strings.Split(s[len(MosquittoSHA512Prefix):], "$")
+		if len(parts) != 2 {
+			return MosquittoHash{}, errors.New("malformed $6$ hash")
+		}
+	case strings.HasPrefix(s, "$"):
+		prefix, _, _ := strings.Cut(s[1:], "$")
+		return MosquittoHash{}, fmt.Errorf("unsupported hash type $%s$", prefix)
+	default:
+		return MosquittoHash{}, errors.New("not a mosquitto hash")
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil || len(salt) == 0 {
+		return MosquittoHash{}, errors.New("invalid salt encoding")
+	}
+	hash, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil || len(hash) != mosquittoHashLen {
+		return MosquittoHash{}, errors.New("invalid hash encoding")
+	}
+	h.Salt, h.Hash = salt, hash
+	return h, nil
+}
+
+// String 返回 $6$ / $7$ 格式密文，与 mosquitto_passwd 输出一致。
+func (h MosquittoHash) String() string {
+	salt := base64.StdEncoding.EncodeToString(h.Salt) + "$" + base64.StdEncoding.EncodeToString(h.Hash)
+	if !h.PBKDF2 {
+		return MosquittoSHA512Prefix + salt
+	}
+	return MosquittoPBKDF2Prefix + strconv.Itoa(h.Iterations) + "$" + salt
+}
+
+// Verify 校验明文密码（常量时间比较）。
+func (h MosquittoHash) Verify(password string) bool {
+	var sum []byte
+	if h.PBKDF2 {
+		sum = pbkdf2.Key([]byte(password), h.Salt, h.Iterations, len(h.Hash), sha512.New)
+	} else {
+		d := sha512.New()
+		d.Write([]byte(password))
+		d.Write(h.Salt)
+		sum = d.Sum(nil)
+	}
+	return subtle.ConstantTimeCompare(sum, h.Hash) == 1
+}
+
+// verifyMosquittoHash 校验 mosquitto 格式密文，格式非法时视为不匹配。
+func verifyMosquittoHash(password, hash string) bool {
+	h, err := ParseMosquittoHash(hash)
+	if err != nil {
+		return false
+	}
+	return h.Verify(password)
+}
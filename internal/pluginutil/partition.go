@@ -0,0 +1,316 @@
+package pluginutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PartitionPeriod 表示按时间分区的粒度。
+type PartitionPeriod int
+
+const (
+	PartitionNone PartitionPeriod = iota
+	PartitionDaily
+	PartitionMonthly
+)
+
+// DefaultPartitionPremake 是默认提前创建的未来分区数量。
+const DefaultPartitionPremake = 3
+
+// DefaultPartitionCheckInterval 是默认的分区维护间隔。
+const DefaultPartitionCheckInterval = time.Hour
+
+// PartitionTimeout 是单次分区维护的超时（DDL 需要等待表锁，独立于写库超时）。
+const PartitionTimeout = 30 * time.Second
+
+// ParsePartitionPeriod 解析分区粒度配置（daily/monthly）。
+func ParsePartitionPeriod(v string) (PartitionPeriod, bool) {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "daily", "day":
+		return PartitionDaily, true
+	case "monthly", "month":
+		return PartitionMonthly, true
+	default:
+		return PartitionNone, false
+	}
+}
+
+func (p PartitionPeriod) String() string {
+	switch p {
+	case PartitionDaily:
+		return "daily"
+	case PartitionMonthly:
+		return "monthly"
+	default:
+		return "none"
+	}
+}
+
+func (p PartitionPeriod) nameLayout() string {
+	if p == PartitionDaily {
+		return "20060102"
+	}
+	return "200601"
+}
+
+// Floor 返回 t 所在分区的起始时间（UTC）。
+func (p PartitionPeriod) Floor(t time.Time) time.Time {
+	t = t.UTC()
+	if p == PartitionDaily {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// Next 返回 start 之后下一个分区的起始时间。
+func (p PartitionPeriod) Next(start time.Time) time.Time {
+	if p == PartitionDaily {
+		return start.AddDate(0, 0, 1)
+	}
+	return start.AddDate(0, 1, 0)
+}
+
+// PartitionConfig 描述一张按 ts 分区的事件表的维护策略。
+type PartitionConfig struct {
+	Table   string
+	Period  PartitionPeriod
+	Premake int
+	// Retention<=0 表示永久保留。
+	Retention time.Duration
+	// Detach=true 时过期分区仅 DETACH（保留为独立表），否则 DROP。
+	Detach bool
+	// CheckInterval 是 MOSQ_EVT_TICK 中执行维护的间隔。
+	CheckInterval time.Duration
+}
+
+// NewPartitionConfig 返回带默认值的分区配置（未设置 Period 时不启用）。
+func NewPartitionConfig(table string) PartitionConfig {
+	return PartitionConfig{
+		Table:         table,
+		Premake:       DefaultPartitionPremake,
+		CheckInterval: DefaultPartitionCheckInterval,
+	}
+}
+
+// ApplyPartitionOption 解析分区相关配置项（name 不含插件前缀）。
+// known=false 表示不是分区配置项；ok=false 表示取值非法，配置保持不变。
+func ApplyPartitionOption(cfg *PartitionConfig, name, value string) (known bool, ok bool) {
+	switch name {
+	case "partition_period":
+		period, ok := ParsePartitionPeriod(value)
+		if ok {
+			cfg.Period = period
+		}
+		return true, ok
+	case "partition_premake":
+		n, ok := ParsePositiveInt(value)
+		if ok {
+			cfg.Premake = n
+		}
+		return true, ok
+	case "partition_retention_days":
+		n, ok := ParsePositiveInt(value)
+		if ok {
+			cfg.Retention = time.Duration(n) * 24 * time.Hour
+		}
+		return true, ok
+	case "partition_retention_mode":
+		switch strings.ToLower(strings.TrimSpace(value)) {
+		case "drop":
+			cfg.Detach = false
+		case "detach":
+			cfg.Detach = true
+		default:
+			return true, false
+		}
+		return true, true
+	case "partition_check_ms":
+		dur, ok := ParseTimeoutMS(value)
+		if ok {
+			cfg.CheckInterval = dur
+		}
+		return true, ok
+	default:
+		return false, false
+	}
+}
+
+// Enabled 返回是否启用分区维护。
+func (c PartitionConfig) Enabled() bool {
+	return c.Table != "" && c.Period != PartitionNone
+}
+
+// PartitionRange 是一个待创建的分区。
+type PartitionRange struct {
+	Name  string
+	Start time.Time
+	End   time.Time
+}
+
+// PartitionPlan 是一次维护需要执行的动作。
+type PartitionPlan struct {
+	Create []PartitionRange
+	Expire []string
+}
+
+// PartitionName 返回分区表名，例如 client_conn_events_p202601 / client_conn_events_p20260102。
+func PartitionName(table string, period PartitionPeriod, start time.Time) string {
+	return table + "_p" + start.UTC().Format(period.nameLayout())
+}
+
+// parsePartitionStart 从分区表名解析起始时间；非本工具命名的分区（如 DEFAULT 分区）返回 false。
+func parsePartitionStart(table string, period PartitionPeriod, name string) (time.Time, bool) {
+	suffix, ok := strings.CutPrefix(name, table+"_p")
+	if !ok || len(suffix) != len(period.nameLayout()) {
+		return time.Time{}, false
+	}
+	start, err := time.ParseInLocation(period.nameLayout(), suffix, time.UTC)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return start, true
+}
+
+// PlanPartitions 根据现有分区计算需要创建（当前 + Premake 个未来分区）与过期的分区。
+func PlanPartitions(cfg PartitionConfig, now time.Time, existing []string) PartitionPlan {
+	var plan PartitionPlan
+	if !cfg.Enabled() {
+		return plan
+	}
+	have := make(map[string]bool, len(existing))
+	for _, name := range existing {
+		have[name] = true
+	}
+
+	start := cfg.Period.Floor(now)
+	for i := 0; i <= cfg.Premake; i++ {
+		end := cfg.Period.Next(start)
+		name := PartitionName(cfg.Table, cfg.Period, start)
+		if !have[name] {
+			plan.Create = append(plan.Create, PartitionRange{Name: name, Start: start, End: end})
+		}
+		start = end
+	}
+
+	if cfg.Retention > 0 {
+		cutoff := now.UTC().Add(-cfg.Retention)
+		for _, name := range existing {
+			start, ok := parsePartitionStart(cfg.Table, cfg.Period, name)
+			if !ok {
+				continue
+			}
+			// 分区整体早于保留期才过期，避免删除仍包含有效数据的分区。
+			if !cfg.Period.Next(start).After(cutoff) {
+				plan.Expire = append(plan.Expire, name)
+			}
+		}
+		sort.Strings(plan.Expire)
+	}
+	return plan
+}
+
+// PartitionLockKey 返回表对应的 advisory lock 键，多个 Broker 节点使用相同键互斥。
+func PartitionLockKey(table string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte("mosquitto-plugin/partition/" + table))
+	return int64(h.Sum64())
+}
+
+// PartitionResult 是一次维护的执行结果。
+type PartitionResult struct {
+	// Locked=false 表示其他节点正在维护，本次跳过。
+	Locked  bool
+	Created []string
+	Expired []string
+}
+
+const (
+	selectPartitionedSQL = `SELECT relkind = 'p' FROM pg_class WHERE oid = to_regclass($1)`
+	listPartitionsSQL    = `
+SELECT c.relname
+FROM pg_inherits i
+JOIN pg_class c ON c.oid = i.inhrelid
+WHERE i.inhparent = to_regclass($1)
+`
+)
+
+// MaintainPartitions 在 advisory lock 保护下创建未来分区并处理过期分区。
+// 表必须已按 ts 建为分区表（PARTITION BY RANGE (ts)）。
+func MaintainPartitions(ctx context.Context, pool *pgxpool.Pool, cfg PartitionConfig, now time.Time) (PartitionResult, error) {
+	var res PartitionResult
+	if !cfg.Enabled() {
+		return res, nil
+	}
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return res, err
+	}
+	defer conn.Release()
+
+	key := PartitionLockKey(cfg.Table)
+	if err := conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, key).Scan(&res.Locked); err != nil {
+		return res, err
+	}
+	if !res.Locked {
+		return res, nil
+	}
+	defer func() {
+		// 会话级锁需显式释放；使用独立 context，避免 ctx 超时后锁残留在连接上。
+		unlockCtx, cancel := TimeoutContext(5 * time.Second)
+		defer cancel()
+		if _, err := conn.Exec(unlockCtx, `SELECT pg_advisory_unlock($1)`, key); err != nil {
+			conn.Conn().Close(unlockCtx)
+		}
+	}()
+
+	var partitioned bool
+	if err := conn.QueryRow(ctx, selectPartitionedSQL, cfg.Table).Scan(&partitioned); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return res, fmt.Errorf("table %s does not exist", cfg.Table)
+		}
+		return res, err
+	}
+	if !partitioned {
+		return res, fmt.Errorf("table %s is not partitioned", cfg.Table)
+	}
+
+	rows, err := conn.Query(ctx, listPartitionsSQL, cfg.Table)
+	if err != nil {
+		return res, err
+	}
+	existing, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return res, err
+	}
+
+	plan := PlanPartitions(cfg, now, existing)
+	parent := pgx.Identifier{cfg.Table}.Sanitize()
+	for _, r := range plan.Create {
+		sql := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s PARTITION OF %s FOR VALUES FROM ('%s') TO ('%s')`,
+			pgx.Identifier{r.Name}.Sanitize(), parent,
+			r.Start.Format(time.RFC3339), r.End.Format(time.RFC3339))
+		if _, err := conn.Exec(ctx, sql); err != nil {
+			return res, fmt.Errorf("create partition %s: %w", r.Name, err)
+		}
+		res.Created = append(res.Created, r.Name)
+	}
+	for _, name := range plan.Expire {
+		sql := `DROP TABLE IF EXISTS ` + pgx.Identifier{name}.Sanitize()
+		if cfg.Detach {
+			sql = fmt.Sprintf(`ALTER TABLE %s DETACH PARTITION %s`, parent, pgx.Identifier{name}.Sanitize())
+		}
+		if _, err := conn.Exec(ctx, sql); err != nil {
+			return res, fmt.Errorf("expire partition %s: %w", name, err)
+		}
+		res.Expired = append(res.Expired, name)
+	}
+	return res, nil
+}
@@ -0,0 +1,114 @@
+package pluginutil
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParsePartitionPeriod(t *testing.T) {
+	cases := map[string]PartitionPeriod{"daily": PartitionDaily, " Monthly ": PartitionMonthly, "month": PartitionMonthly}
+	for in, want := range cases {
+		if got, ok := ParsePartitionPeriod(in); !ok || got != want {
+			t.Fatalf("ParsePartitionPeriod(%q) = %v, %v", in, got, ok)
+		}
+	}
+	if _, ok := ParsePartitionPeriod("weekly"); ok {
+		t.Fatal("weekly should be rejected")
+	}
+}
+
+func TestPlanPartitionsMonthlyCreate(t *testing.T) {
+	cfg := PartitionConfig{Table: "client_conn_events", Period: PartitionMonthly, Premake: 2}
+	now := time.Date(2026, 12, 15, 10, 0, 0, 0, time.UTC)
+
+	plan := PlanPartitions(cfg, now, []string{"client_conn_events_p202612"})
+	if len(plan.Create) != 2 {
+		t.Fatalf("create = %+v", plan.Create)
+	}
+	if plan.Create[0].Name != "client_conn_events_p202701" ||
+		!plan.Create[0].Start.Equal(time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)) ||
+		!plan.Create[0].End.Equal(time.Date(2027, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("create[0] = %+v", plan.Create[0])
+	}
+	if plan.Create[1].Name != "client_conn_events_p202702" {
+		t.Fatalf("create[1] = %+v", plan.Create[1])
+	}
+	if len(plan.Expire) != 0 {
+		t.Fatalf("expire without retention = %v", plan.Expire)
+	}
+}
+
+func TestPlanPartitionsDailyRetention(t *testing.T) {
+	cfg := PartitionConfig{Table: "client_auth_events", Period: PartitionDaily, Premake: 1, Retention: 2 * 24 * time.Hour}
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	existing := []string{
+		"client_auth_events_p20260309",
+		"client_auth_events_p20260307",
+		"client_auth_events_p20260308", // 结束于 03-09 00:00，仍晚于截止时间 03-08 12:00
+		"client_auth_events_default",
+		"client_auth_events_p2026030", // 非法后缀，忽略
+		"client_auth_events_p20260310",
+		"client_auth_events_p20260311",
+	}
+
+	plan := PlanPartitions(cfg, now, existing)
+	if len(plan.Create) != 0 {
+		t.Fatalf("create = %+v", plan.Create)
+	}
+	if want := []string{"client_auth_events_p20260307"}; !reflect.DeepEqual(plan.Expire, want) {
+		t.Fatalf("expire = %v, want %v", plan.Expire, want)
+	}
+}
+
+func TestPlanPartitionsDisabled(t *testing.T) {
+	plan := PlanPartitions(NewPartitionConfig("client_conn_events"), time.Now(), nil)
+	if len(plan.Create) != 0 || len(plan.Expire) != 0 {
+		t.Fatalf("disabled plan = %+v", plan)
+	}
+}
+
+func TestApplyPartitionOption(t *testing.T) {
+	cfg := NewPartitionConfig("client_conn_events")
+	steps := []struct {
+		name, value string
+		ok          bool
+	}{
+		{"partition_period", "daily", true},
+		{"partition_premake", "7", true},
+		{"partition_premake", "-1", false},
+		{"partition_retention_days", "30", true},
+		{"partition_retention_mode", "detach", true},
+		{"partition_retention_mode", "truncate", false},
+		{"partition_check_ms", "60000", true},
+	}
+	for _, s := range steps {
+		known, ok := ApplyPartitionOption(&cfg, s.name, s.value)
+		if !known || ok != s.ok {
+			t.Fatalf("ApplyPartitionOption(%s=%s) = %v, %v", s.name, s.value, known, ok)
+		}
+	}
+	want := PartitionConfig{
+		Table:         "client_conn_events",
+		Period:        PartitionDaily,
+		Premake:       7,
+		Retention:     30 * 24 * time.Hour,
+		Detach:        true,
+		CheckInterval: time.Minute,
+	}
+	if cfg != want {
+		t.Fatalf("cfg = %+v, want %+v", cfg, want)
+	}
+	if known, _ := ApplyPartitionOption(&cfg, "pg_dsn", "x"); known {
+		t.Fatal("pg_dsn should not be a partition option")
+	}
+}
+
+func TestPartitionLockKeyPerTable(t *testing.T) {
+	if PartitionLockKey("client_conn_events") != PartitionLockKey("client_conn_events") {
+		t.Fatal("lock key should be stable")
+	}
+	if PartitionLockKey("client_conn_events") == PartitionLockKey("client_auth_events") {
+		t.Fatal("lock keys should differ per table")
+	}
+}
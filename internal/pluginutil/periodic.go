@@ -0,0 +1,47 @@
+package pluginutil
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PeriodicJob 在 MOSQ_EVT_TICK 中按间隔异步执行后台任务：到期且上一次执行结束后才启动新的一轮。
+// Start 仅由 Broker 主线程调用；Interval<=0 表示禁用。
+type PeriodicJob struct {
+	Interval time.Duration
+
+	next    time.Time
+	running atomic.Bool
+	wg      sync.WaitGroup
+}
+
+// Enabled 返回任务是否启用。
+func (j *PeriodicJob) Enabled() bool {
+	return j != nil && j.Interval > 0
+}
+
+// Start 在任务到期时于 goroutine 中执行 fn，避免阻塞 Broker 主循环。
+func (j *PeriodicJob) Start(now time.Time, fn func()) bool {
+	if !j.Enabled() || now.Before(j.next) {
+		return false
+	}
+	if !j.running.CompareAndSwap(false, true) {
+		return false
+	}
+	j.next = now.Add(j.Interval)
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+		defer j.running.Store(false)
+		fn()
+	}()
+	return true
+}
+
+// Wait 等待进行中的任务结束。
+func (j *PeriodicJob) Wait() {
+	if j != nil {
+		j.wg.Wait()
+	}
+}
@@ -0,0 +1,54 @@
+package pluginutil
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPeriodicJobInterval(t *testing.T) {
+	j := &PeriodicJob{Interval: time.Minute}
+	var runs atomic.Int32
+	run := func() { runs.Add(1) }
+
+	now := time.Now()
+	if !j.Start(now, run) {
+		t.Fatal("first tick should start a job")
+	}
+	j.Wait()
+	if j.Start(now.Add(30*time.Second), run) {
+		t.Fatal("job before interval should not start")
+	}
+	if !j.Start(now.Add(time.Minute), run) {
+		t.Fatal("job after interval should start")
+	}
+	j.Wait()
+	if got := runs.Load(); got != 2 {
+		t.Fatalf("runs = %d, want 2", got)
+	}
+}
+
+func TestPeriodicJobSkipsWhileRunning(t *testing.T) {
+	j := &PeriodicJob{Interval: time.Millisecond}
+	release := make(chan struct{})
+	now := time.Now()
+	if !j.Start(now, func() { <-release }) {
+		t.Fatal("first job should start")
+	}
+	if j.Start(now.Add(time.Second), func() {}) {
+		t.Fatal("job should not overlap a running job")
+	}
+	close(release)
+	j.Wait()
+	if !j.Start(now.Add(2*time.Second), func() {}) {
+		t.Fatal("job should start after previous run finished")
+	}
+	j.Wait()
+}
+
+func TestPeriodicJobDisabled(t *testing.T) {
+	j := &PeriodicJob{}
+	if j.Enabled() || j.Start(time.Now(), func() { t.Fatal("job should not run") }) {
+		t.Fatal("disabled job should not start")
+	}
+}
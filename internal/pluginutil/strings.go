@@ -53,6 +53,24 @@ func ParseTimeoutMS(v string) (time.Duration, bool) {
 	return time.Duration(n) * time.Millisecond, true
 }
 
+// ParsePositiveInt 解析正整数配置值（如数量、阈值）。
+func ParsePositiveInt(v string) (int, bool) {
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// ParseQoS 解析 MQTT QoS 配置值（0/1/2）。
+func ParseQoS(v string) (int, bool) {
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil || n < 0 || n > 2 {
+		return 0, false
+	}
+	return n, true
+}
+
 // ProtocolString 将协议版本号转为字符串。
 func ProtocolString(version int) string {
 	switch version {
@@ -0,0 +1,140 @@
+package pluginutil
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// KeyFields 是渲染 KeyTemplate 时可用的消息字段。
+type KeyFields struct {
+	Topic    string
+	Username string
+	ClientID string
+	// UserProperty 返回 MQTT v5 用户属性（同名取第一个）；nil 表示没有用户属性。
+	UserProperty func(key string) (string, bool)
+}
+
+type keyPartKind int
+
+const (
+	keyPartLiteral keyPartKind = iota
+	keyPartTopic
+	keyPartTopicDotted
+	keyPartTopicLevel
+	keyPartUsername
+	keyPartClientID
+	keyPartUserProp
+)
+
+type keyPart struct {
+	kind keyPartKind
+	// text 为字面量或用户属性名。
+	text  string
+	level int
+}
+
+// KeyTemplate 是按消息渲染的键模板（routing key 等），占位符：
+// {topic}、{topic_dotted}（/ 替换为 .）、{topic[N]}（第 N 层，从 0 开始）、
+// {username}、{client_id}、{user_prop:NAME}。缺失的字段渲染为空串。
+type KeyTemplate struct {
+	parts []keyPart
+}
+
+// ParseKeyTemplate 解析模板；未知占位符或不成对的花括号返回错误。
+func ParseKeyTemplate(s string) (*KeyTemplate, error) {
+	t := &KeyTemplate{}
+	for s != "" {
+		open := strings.IndexAny(s, "{}")
+		if open < 0 {
+			t.parts = append(t.parts, keyPart{kind: keyPartLiteral, text: s})
+			break
+		}
+		if s[open] == '}' {
+			return nil, fmt.Errorf("unexpected } at %q", s[open:])
+		}
+		if open > 0 {
+			t.parts = append(t.parts, keyPart{kind: keyPartLiteral, text: s[:open]})
+		}
+		end := strings.IndexByte(s[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed placeholder %q", s[open:])
+		}
+		p, err := parseKeyPlaceholder(s[open+1 : open+end])
+		if err != nil {
+			return nil, err
+		}
+		t.parts = append(t.parts, p)
+		s = s[open+end+1:]
+	}
+	return t, nil
+}
+
+func parseKeyPlaceholder(name string) (keyPart, error) {
+	switch name {
+	case "topic":
+		return keyPart{kind: keyPartTopic}, nil
+	case "topic_dotted":
+		return keyPart{kind: keyPartTopicDotted}, nil
+	case "username":
+		return keyPart{kind: keyPartUsername}, nil
+	case "client_id":
+		return keyPart{kind: keyPartClientID}, nil
+	}
+	if prop, ok := strings.CutPrefix(name, "user_prop:"); ok && prop != "" {
+		return keyPart{kind: keyPartUserProp, text: prop}, nil
+	}
+	if idx, ok := strings.CutPrefix(name, "topic["); ok && strings.HasSuffix(idx, "]") {
+		n, err := strconv.Atoi(strings.TrimSuffix(idx, "]"))
+		if err == nil && n >= 0 {
+			return keyPart{kind: keyPartTopicLevel, level: n}, nil
+		}
+	}
+	return keyPart{}, fmt.Errorf("unknown placeholder {%s}", name)
+}
+
+// Static 报告模板是否不含占位符（可直接使用字面量，无需逐条渲染）。
+func (t *KeyTemplate) Static() bool {
+	for _, p := range t.parts {
+		if p.kind != keyPartLiteral {
+			return false
+		}
+	}
+	return true
+}
+
+// Render 按消息字段渲染模板。
+func (t *KeyTemplate) Render(f KeyFields) string {
+	if len(t.parts) == 1 && t.parts[0].kind == keyPartLiteral {
+		return t.parts[0].text
+	}
+	var b strings.Builder
+	var levels []string
+	for _, p := range t.parts {
+		switch p.kind {
+		case keyPartLiteral:
+			b.WriteString(p.text)
+		case keyPartTopic:
+			b.WriteString(f.Topic)
+		case keyPartTopicDotted:
+			b.WriteString(strings.ReplaceAll(f.Topic, "/", "."))
+		case keyPartTopicLevel:
+			if levels == nil {
+				levels = strings.Split(f.Topic, "/")
+			}
+			if p.level < len(levels) {
+				b.WriteString(levels[p.level])
+			}
+		case keyPartUsername:
+			b.WriteString(f.Username)
+		case keyPartClientID:
+			b.WriteString(f.ClientID)
+		case keyPartUserProp:
+			if f.UserProperty != nil {
+				v, _ := f.UserProperty(p.text)
+				b.WriteString(v)
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,54 @@
+package pluginutil
+
+import "testing"
+
+func TestKeyTemplateRender(t *testing.T) {
+	t.Parallel()
+	fields := KeyFields{
+		Topic:    "v1/d/dev01/up",
+		Username: "alice",
+		ClientID: "dev01",
+		UserProperty: func(key string) (string, bool) {
+			if key == "tenant" {
+				return "acme", true
+			}
+			return "", false
+		},
+	}
+	cases := []struct {
+		tmpl, want string
+	}{
+		{"mqtt.messages", "mqtt.messages"},
+		{"", ""},
+		{"mqtt.{topic_dotted}", "mqtt.v1.d.dev01.up"},
+		{"{username}.{topic[1]}", "alice.d"},
+		{"{topic[0]}/{topic[9]}", "v1/"},
+		{"tenant.{user_prop:tenant}", "tenant.acme"},
+		{"tenant.{user_prop:region}", "tenant."},
+		{"{client_id}:{topic}", "dev01:v1/d/dev01/up"},
+	}
+	for _, c := range cases {
+		tmpl, err := ParseKeyTemplate(c.tmpl)
+		if err != nil {
+			t.Fatalf("ParseKeyTemplate(%q): %v", c.tmpl, err)
+		}
+		if got := tmpl.Render(fields); got != c.want {
+			t.Errorf("Render(%q) = %q, want %q", c.tmpl, got, c.want)
+		}
+	}
+}
+
+func TestParseKeyTemplateErrors(t *testing.T) {
+	t.Parallel()
+	for _, s := range []string{"{topic", "topic}", "{host}", "{topic[-1]}", "{topic[x]}", "{user_prop:}"} {
+		if _, err := ParseKeyTemplate(s); err == nil {
+			t.Errorf("ParseKeyTemplate(%q) should fail", s)
+		}
+	}
+	if tmpl, _ := ParseKeyTemplate("a.b"); !tmpl.Static() {
+		t.Error("literal template should be static")
+	}
+	if tmpl, _ := ParseKeyTemplate("a.{topic}"); tmpl.Static() {
+		t.Error("template with placeholder should not be static")
+	}
+}
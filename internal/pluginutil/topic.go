@@ -0,0 +1,56 @@
+package pluginutil
+
+import (
+	"errors"
+	"strings"
+)
+
+// TopicMatches 报告过滤器（可含 +/#）是否匹配具体主题；首层通配符不匹配 $ 开头的主题。
+func TopicMatches(filter, topic string) bool {
+	if filter == "" || topic == "" {
+		return false
+	}
+	f, t := strings.Split(filter, "/"), strings.Split(topic, "/")
+	if strings.HasPrefix(topic, "$") && (f[0] == "+" || f[0] == "#") {
+		return false
+	}
+	for i, level := range f {
+		if level == "#" {
+			return i == len(f)-1
+		}
+		if i >= len(t) || (level != "+" && level != t[i]) {
+			return false
+		}
+	}
+	return len(f) == len(t)
+}
+
+// ValidateTopicFilter 校验订阅过滤器：+ 必须独占一层，# 必须独占最后一层。
+func ValidateTopicFilter(filter string) error {
+	if filter == "" {
+		return errors.New("topic filter is empty")
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if strings.ContainsAny(level, "+#") && len(level) != 1 {
+			return errors.New("wildcard must occupy a whole level")
+		}
+		if level == "#" && i != len(levels)-1 {
+			return errors.New("# must be the last level")
+		}
+	}
+	return nil
+}
+
+// StripSharePrefix 去掉共享订阅前缀 $share/<group>/，返回实际过滤器；ok=false 表示格式不完整。
+func StripSharePrefix(filter string) (string, bool) {
+	rest, found := strings.CutPrefix(filter, "$share/")
+	if !found {
+		return filter, true
+	}
+	group, f, found := strings.Cut(rest, "/")
+	if !found || group == "" || f == "" || strings.ContainsAny(group, "+#") {
+		return "", false
+	}
+	return f, true
+}
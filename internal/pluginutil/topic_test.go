@@ -0,0 +1,62 @@
+package pluginutil
+
+import "testing"
+
+func TestTopicMatches(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		filter, topic string
+		want          bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/+", "a/b", true},
+		{"a/+", "a/b/c", false},
+		{"a/#", "a", true},
+		{"a/#", "a/b/c", true},
+		{"#", "a/b", true},
+		{"#", "$SYS/broker", false},
+		{"+/broker", "$SYS/broker", false},
+		{"$SYS/#", "$SYS/broker", true},
+		{"a/#/c", "a/b/c", false},
+		{"a/b", "a/b/", false},
+	}
+	for _, c := range cases {
+		if got := TopicMatches(c.filter, c.topic); got != c.want {
+			t.Errorf("TopicMatches(%q, %q) = %v, want %v", c.filter, c.topic, got, c.want)
+		}
+	}
+}
+
+func TestValidateTopicFilter(t *testing.T) {
+	t.Parallel()
+	for _, f := range []string{"a/b", "+/b/#", "#", "$SYS/#", "a//b"} {
+		if err := ValidateTopicFilter(f); err != nil {
+			t.Errorf("ValidateTopicFilter(%q) = %v", f, err)
+		}
+	}
+	for _, f := range []string{"", "a/b#", "a/#/c", "a+/b"} {
+		if err := ValidateTopicFilter(f); err == nil {
+			t.Errorf("ValidateTopicFilter(%q) should fail", f)
+		}
+	}
+}
+
+func TestStripSharePrefix(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		in, want string
+		ok       bool
+	}{
+		{"$share/g1/v1/#", "v1/#", true},
+		{"v1/#", "v1/#", true},
+		{"$share/g1", "", false},
+		{"$share//v1", "", false},
+		{"$share/g+/v1", "", false},
+	}
+	for _, c := range cases {
+		got, ok := StripSharePrefix(c.in)
+		if got != c.want || ok != c.ok {
+			t.Errorf("StripSharePrefix(%q) = (%q, %v), want (%q, %v)", c.in, got, ok, c.want, c.ok)
+		}
+	}
+}
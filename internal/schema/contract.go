@@ -0,0 +1,58 @@
+package schema
+
+// 表名与枚举值是插件写入与 mqttctl 查询共同遵守的列约定，修改时需同步迁移脚本中的 CHECK 约束。
+const (
+	AccountsTable        = "mqtt_accounts"
+	AuthEventsTable      = "client_auth_events"
+	ConnEventsTable      = "client_conn_events"
+	SessionsTable        = "client_sessions"
+	ActiveClientsTable   = "broker_active_clients"
+	ActiveSnapshotsTable = "broker_active_snapshots"
+
+	ACLRolesTable         = "mqtt_acl_roles"
+	ACLRulesTable         = "mqtt_acl_rules"
+	ACLGroupsTable        = "mqtt_acl_groups"
+	ACLGroupRolesTable    = "mqtt_acl_group_roles"
+	ACLAccountRolesTable  = "mqtt_acl_account_roles"
+	ACLAccountGroupsTable = "mqtt_acl_account_groups"
+	ACLDefaultsTable      = "mqtt_acl_defaults"
+)
+
+// client_auth_events.result
+const (
+	AuthResultSuccess = "success"
+	AuthResultFail    = "fail"
+)
+
+// client_conn_events.event_type
+const (
+	ConnEventConnect    = "connect"
+	ConnEventDisconnect = "disconnect"
+	ConnEventFlapping   = "flapping"
+	ConnEventTakeover   = "takeover"
+)
+
+// ConnEventTypes 是 client_conn_events.event_type 的全部取值。
+var ConnEventTypes = []string{ConnEventConnect, ConnEventDisconnect, ConnEventFlapping, ConnEventTakeover}
+
+// mqtt_acl_rules.acl_type（与 dynamic-security 的 acltype 一致）
+const (
+	ACLPublishClientSend    = "publishClientSend"
+	ACLPublishClientReceive = "publishClientReceive"
+	ACLSubscribeLiteral     = "subscribeLiteral"
+	ACLSubscribePattern     = "subscribePattern"
+	ACLUnsubscribeLiteral   = "unsubscribeLiteral"
+	ACLUnsubscribePattern   = "unsubscribePattern"
+)
+
+// ACLTypes 是 mqtt_acl_rules.acl_type 的全部取值。
+var ACLTypes = []string{ACLPublishClientSend, ACLPublishClientReceive, ACLSubscribeLiteral, ACLSubscribePattern, ACLUnsubscribeLiteral, ACLUnsubscribePattern}
+
+// mqtt_acl_defaults.access
+const (
+	ACLAccessSubscribe   = "subscribe"
+	ACLAccessUnsubscribe = "unsubscribe"
+)
+
+// ACLAccesses 是 mqtt_acl_defaults.access 的全部取值。
+var ACLAccesses = []string{ACLPublishClientSend, ACLPublishClientReceive, ACLAccessSubscribe, ACLAccessUnsubscribe}
@@ -0,0 +1,84 @@
+-- 认证账户、认证事件、连接事件与会话表（auth-plugin / conn-plugin）。
+-- 使用 IF NOT EXISTS，已按文档手工建表的环境可直接纳管。
+
+CREATE TABLE IF NOT EXISTS mqtt_accounts (
+  user_name     TEXT PRIMARY KEY,
+  clientid      TEXT,
+  password_hash TEXT NOT NULL,
+  salt          TEXT NOT NULL,
+  enabled       SMALLINT NOT NULL DEFAULT 1,
+  created_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- 统一 enabled 类型：插件按 int16 读取，手工建表常见 BOOLEAN/INTEGER。
+DO $$
+BEGIN
+  IF (SELECT data_type FROM information_schema.columns
+      WHERE table_schema = current_schema() AND table_name = 'mqtt_accounts' AND column_name = 'enabled') = 'boolean' THEN
+    ALTER TABLE mqtt_accounts ALTER COLUMN enabled DROP DEFAULT;
+    ALTER TABLE mqtt_accounts ALTER COLUMN enabled TYPE SMALLINT USING enabled::int;
+  ELSE
+    ALTER TABLE mqtt_accounts ALTER COLUMN enabled TYPE SMALLINT;
+  END IF;
+  ALTER TABLE mqtt_accounts ALTER COLUMN enabled SET DEFAULT 1;
+END $$;
+
+CREATE TABLE IF NOT EXISTS client_auth_events (
+  id        BIGSERIAL PRIMARY KEY,
+  ts        TIMESTAMPTZ NOT NULL,
+  result    TEXT NOT NULL CHECK (result IN ('success', 'fail')),
+  reason    TEXT NOT NULL,
+  client_id TEXT,
+  username  TEXT,
+  peer      TEXT,
+  protocol  TEXT
+);
+
+CREATE INDEX IF NOT EXISTS client_auth_events_client_ts_idx
+  ON client_auth_events (client_id, ts DESC);
+
+CREATE INDEX IF NOT EXISTS client_auth_events_ts_idx
+  ON client_auth_events (ts DESC);
+
+CREATE TABLE IF NOT EXISTS client_conn_events (
+  id          BIGSERIAL PRIMARY KEY,
+  ts          TIMESTAMPTZ NOT NULL,
+  event_type  TEXT NOT NULL,
+  client_id   TEXT NOT NULL,
+  username    TEXT,
+  peer        TEXT,
+  protocol    TEXT,
+  reason_code INTEGER,
+  extra       JSONB
+);
+
+ALTER TABLE client_conn_events DROP CONSTRAINT IF EXISTS client_conn_events_event_type_check;
+ALTER TABLE client_conn_events ADD CONSTRAINT client_conn_events_event_type_check
+  CHECK (event_type IN ('connect', 'disconnect', 'flapping', 'takeover'));
+
+CREATE INDEX IF NOT EXISTS client_conn_events_client_ts_idx
+  ON client_conn_events (client_id, ts DESC);
+
+CREATE INDEX IF NOT EXISTS client_conn_events_ts_idx
+  ON client_conn_events (ts DESC);
+
+CREATE TABLE IF NOT EXISTS client_sessions (
+  client_id           TEXT PRIMARY KEY,
+  username            TEXT,
+  last_event_ts       TIMESTAMPTZ NOT NULL,
+  last_event_type     TEXT NOT NULL CHECK (last_event_type IN ('connect', 'disconnect')),
+  last_connect_ts     TIMESTAMPTZ,
+  last_disconnect_ts  TIMESTAMPTZ,
+  last_peer           TEXT,
+  last_protocol       TEXT,
+  last_reason_code    INTEGER,
+  extra               JSONB
+);
+
+ALTER TABLE client_sessions
+  ADD COLUMN IF NOT EXISTS total_connected_ms BIGINT NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS total_msgs_published BIGINT NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS total_bytes_published BIGINT NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS client_sessions_ts_idx
+  ON client_sessions (last_event_ts DESC);
@@ -0,0 +1,18 @@
+-- conn-plugin 在线连接快照（conn_snapshot_interval_ms）。
+
+CREATE TABLE IF NOT EXISTS broker_active_clients (
+  node_id      TEXT        NOT NULL,
+  client_id    TEXT        NOT NULL,
+  username     TEXT,
+  peer         TEXT,
+  protocol     TEXT,
+  connected_at TIMESTAMPTZ NOT NULL,
+  snapshot_ts  TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY (node_id, client_id)
+);
+
+CREATE TABLE IF NOT EXISTS broker_active_snapshots (
+  node_id      TEXT        PRIMARY KEY,
+  snapshot_ts  TIMESTAMPTZ NOT NULL,
+  client_count INTEGER     NOT NULL
+);
@@ -0,0 +1,53 @@
+-- ACL 表：按 dynamic-security 的模型保存角色、组与规则（mqttctl import / acl test）。
+-- 账户沿用 mqtt_accounts；账户与组均可绑定多个角色，priority 越大越先匹配。
+
+CREATE TABLE IF NOT EXISTS mqtt_acl_roles (
+  role_name   TEXT PRIMARY KEY,
+  description TEXT,
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS mqtt_acl_rules (
+  id        BIGSERIAL PRIMARY KEY,
+  role_name TEXT    NOT NULL REFERENCES mqtt_acl_roles (role_name) ON DELETE CASCADE,
+  acl_type  TEXT    NOT NULL CHECK (acl_type IN ('publishClientSend', 'publishClientReceive', 'subscribeLiteral', 'subscribePattern', 'unsubscribeLiteral', 'unsubscribePattern')),
+  topic     TEXT    NOT NULL,
+  priority  INTEGER NOT NULL DEFAULT 0,
+  allow     BOOLEAN NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS mqtt_acl_rules_role_idx
+  ON mqtt_acl_rules (role_name);
+
+CREATE TABLE IF NOT EXISTS mqtt_acl_groups (
+  group_name  TEXT PRIMARY KEY,
+  description TEXT,
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS mqtt_acl_group_roles (
+  group_name TEXT    NOT NULL REFERENCES mqtt_acl_groups (group_name) ON DELETE CASCADE,
+  role_name  TEXT    NOT NULL REFERENCES mqtt_acl_roles (role_name) ON DELETE CASCADE,
+  priority   INTEGER NOT NULL DEFAULT 0,
+  PRIMARY KEY (group_name, role_name)
+);
+
+CREATE TABLE IF NOT EXISTS mqtt_acl_account_roles (
+  user_name TEXT    NOT NULL REFERENCES mqtt_accounts (user_name) ON DELETE CASCADE,
+  role_name TEXT    NOT NULL REFERENCES mqtt_acl_roles (role_name) ON DELETE CASCADE,
+  priority  INTEGER NOT NULL DEFAULT 0,
+  PRIMARY KEY (user_name, role_name)
+);
+
+CREATE TABLE IF NOT EXISTS mqtt_acl_account_groups (
+  user_name  TEXT    NOT NULL REFERENCES mqtt_accounts (user_name) ON DELETE CASCADE,
+  group_name TEXT    NOT NULL REFERENCES mqtt_acl_groups (group_name) ON DELETE CASCADE,
+  priority   INTEGER NOT NULL DEFAULT 0,
+  PRIMARY KEY (user_name, group_name)
+);
+
+-- 未命中任何规则时的默认结果（对应 dynsec defaultACLAccess）。
+CREATE TABLE IF NOT EXISTS mqtt_acl_defaults (
+  access TEXT    PRIMARY KEY CHECK (access IN ('publishClientSend', 'publishClientReceive', 'subscribe', 'unsubscribe')),
+  allow  BOOLEAN NOT NULL
+);
@@ -0,0 +1,3 @@
+-- auth-plugin 认证链（auth_chain）：记录作出决定的后端，所有后端均返回 next 时为 NULL。
+
+ALTER TABLE client_auth_events ADD COLUMN IF NOT EXISTS backend TEXT;
@@ -0,0 +1,112 @@
+package schema
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"mosquitto-plugin/internal/pluginutil"
+)
+
+// PartitionTables 是插件可按 partition_* 选项维护分区的事件表。
+var PartitionTables = []string{AuthEventsTable, ConnEventsTable}
+
+const (
+	selectRelKindSQL    = `SELECT relkind = 'p' FROM pg_class WHERE oid = to_regclass($1)`
+	selectPrimaryKeySQL = `SELECT conname FROM pg_constraint WHERE conrelid = to_regclass($1) AND contype = 'p'`
+	selectIDSequenceSQL = `SELECT COALESCE(pg_get_serial_sequence($1, 'id'), '')`
+)
+
+// PartitionSQL 返回把 0001 建立的普通事件表转换为按 ts 分区的表的语句：
+// 原表改名为 now 所在周期的分区（范围 MINVALUE 到下一周期起点），新建同结构的分区表并挂载它。
+// 之后插件从下一周期起创建新分区；历史数据随该分区整体过期（DROP/DETACH）。
+// pkey/seq 为原表的主键约束名与 id 序列，为空时跳过对应语句。
+func PartitionSQL(table string, period pluginutil.PartitionPeriod, now time.Time, pkey, seq string) []string {
+	start := period.Floor(now)
+	legacy := pluginutil.PartitionName(table, period, start)
+	t, l := pgx.Identifier{table}.Sanitize(), pgx.Identifier{legacy}.Sanitize()
+
+	stmts := []string{fmt.Sprintf(`ALTER TABLE %s RENAME TO %s`, t, l)}
+	// 索引名在 schema 内唯一，先让出给新表。
+	for _, suffix := range []string{"_client_ts_idx", "_ts_idx"} {
+		stmts = append(stmts, fmt.Sprintf(`ALTER INDEX IF EXISTS %s RENAME TO %s`,
+			pgx.Identifier{table + suffix}.Sanitize(), pgx.Identifier{legacy + suffix}.Sanitize()))
+	}
+	// 分区表的主键必须包含分区键。
+	if pkey != "" {
+		stmts = append(stmts, fmt.Sprintf(`ALTER TABLE %s DROP CONSTRAINT %s`, l, pgx.Identifier{pkey}.Sanitize()))
+	}
+	stmts = append(stmts,
+		fmt.Sprintf(`ALTER TABLE %s ADD PRIMARY KEY (id, ts)`, l),
+		fmt.Sprintf(`CREATE TABLE %s (LIKE %s INCLUDING DEFAULTS INCLUDING CONSTRAINTS, PRIMARY KEY (id, ts)) PARTITION BY RANGE (ts)`, t, l),
+	)
+	// id 沿用原序列，归属改到新表，避免过期分区被 DROP 时连带删除序列。
+	if seq != "" {
+		stmts = append(stmts, fmt.Sprintf(`ALTER SEQUENCE %s OWNED BY %s.id`, seq, t))
+	}
+	stmts = append(stmts,
+		fmt.Sprintf(`CREATE INDEX %s ON %s (client_id, ts DESC)`, pgx.Identifier{table + "_client_ts_idx"}.Sanitize(), t),
+		fmt.Sprintf(`CREATE INDEX %s ON %s (ts DESC)`, pgx.Identifier{table + "_ts_idx"}.Sanitize(), t),
+		fmt.Sprintf(`ALTER TABLE %s ATTACH PARTITION %s FOR VALUES FROM (MINVALUE) TO ('%s')`, t, l, period.Next(start).Format(time.RFC3339)),
+	)
+	return stmts
+}
+
+// Partitioned 报告 table 是否已是分区表；表不存在时返回错误。
+func Partitioned(ctx context.Context, q Querier, table string) (bool, error) {
+	var partitioned bool
+	if err := q.QueryRow(ctx, selectRelKindSQL, table).Scan(&partitioned); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, fmt.Errorf("table %s does not exist (run `mqttctl migrate up`)", table)
+		}
+		return false, err
+	}
+	return partitioned, nil
+}
+
+// PartitionKeys 查询 table 的主键约束名与 id 序列，即 PartitionSQL 的 pkey/seq；不存在时为空。
+func PartitionKeys(ctx context.Context, q Querier, table string) (pkey, seq string, err error) {
+	if err := q.QueryRow(ctx, selectPrimaryKeySQL, table).Scan(&pkey); err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return "", "", err
+	}
+	if err := q.QueryRow(ctx, selectIDSequenceSQL, table).Scan(&seq); err != nil {
+		return "", "", err
+	}
+	return pkey, seq, nil
+}
+
+// Partition 把 table 转换为按 ts 分区的表，返回执行的语句；已是分区表时返回 nil。
+// 在与插件分区维护相同的 advisory lock 下于一个事务中执行，挂载原表时会扫描整表校验范围。
+func Partition(ctx context.Context, conn *pgx.Conn, table string, period pluginutil.PartitionPeriod, now time.Time) ([]string, error) {
+	key := pluginutil.PartitionLockKey(table)
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, key); err != nil {
+		return nil, err
+	}
+	defer func() { _, _ = conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, key) }()
+
+	var stmts []string
+	err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		partitioned, err := Partitioned(ctx, tx, table)
+		if err != nil || partitioned {
+			return err
+		}
+		pkey, seq, err := PartitionKeys(ctx, tx, table)
+		if err != nil {
+			return err
+		}
+		stmts = PartitionSQL(table, period, now, pkey, seq)
+		for _, sql := range stmts {
+			if _, err := tx.Exec(ctx, sql); err != nil {
+				return fmt.Errorf("%s: %w", sql, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stmts, nil
+}
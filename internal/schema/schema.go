@@ -0,0 +1,212 @@
+// Package schema 维护插件使用的 PostgreSQL 表结构（内嵌的版本化迁移）。
+package schema
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+// 各插件要求的最低 schema 版本，表结构变化时同步提升。
+const (
+	AuthPluginVersion = 4
+	ConnPluginVersion = 2
+	// ACLVersion 是 mqttctl 读写 ACL 表要求的版本。
+	ACLVersion = 3
+)
+
+// migrateLockKey 是执行迁移时使用的 advisory lock 键，避免多个实例并发迁移。
+const migrateLockKey = int64(0x6d717474_73636865) // "mqttsche"
+
+const createMigrationsTableSQL = `
+CREATE TABLE IF NOT EXISTS mqtt_schema_migrations (
+  version    INTEGER PRIMARY KEY,
+  name       TEXT NOT NULL,
+  applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+)`
+
+const selectAppliedSQL = `SELECT version, name, applied_at FROM mqtt_schema_migrations ORDER BY version`
+
+const selectCurrentVersionSQL = `
+SELECT CASE WHEN to_regclass('mqtt_schema_migrations') IS NULL THEN 0
+       ELSE (SELECT COALESCE(max(version), 0) FROM mqtt_schema_migrations) END`
+
+const insertAppliedSQL = `INSERT INTO mqtt_schema_migrations (version, name) VALUES ($1, $2)`
+
+// Migration 是一个版本化迁移（migrations/NNNN_name.sql）。
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// Migrations 返回按版本排序的内嵌迁移。
+func Migrations() ([]Migration, error) {
+	return loadMigrations(migrationFS, "migrations")
+}
+
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []Migration
+	seen := map[int]string{}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".sql") {
+			continue
+		}
+		base := strings.TrimSuffix(e.Name(), ".sql")
+		num, name, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(num)
+		if !ok || err != nil || version <= 0 || name == "" {
+			return nil, fmt.Errorf("invalid migration file name %q (want NNNN_name.sql)", e.Name())
+		}
+		if prev, dup := seen[version]; dup {
+			return nil, fmt.Errorf("duplicate migration version %d: %s, %s", version, prev, e.Name())
+		}
+		seen[version] = e.Name()
+		body, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, Migration{Version: version, Name: name, SQL: string(body)})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	for i, m := range out {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration versions must be contiguous from 1, got %d at position %d", m.Version, i+1)
+		}
+	}
+	return out, nil
+}
+
+// Latest 返回内嵌迁移的最新版本。
+func Latest() int {
+	ms, err := Migrations()
+	if err != nil || len(ms) == 0 {
+		return 0
+	}
+	return ms[len(ms)-1].Version
+}
+
+// Pending 返回高于 current 且不超过 target 的迁移；target<=0 表示最新版本。
+func Pending(ms []Migration, current, target int) []Migration {
+	var out []Migration
+	for _, m := range ms {
+		if m.Version <= current {
+			continue
+		}
+		if target > 0 && m.Version > target {
+			break
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// Querier 是 CurrentVersion/CheckVersion 需要的最小数据库接口（*pgxpool.Pool、*pgx.Conn 均满足）。
+type Querier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// CurrentVersion 返回数据库当前 schema 版本；未执行过迁移时返回 0。
+func CurrentVersion(ctx context.Context, q Querier) (int, error) {
+	var v int
+	if err := q.QueryRow(ctx, selectCurrentVersionSQL).Scan(&v); err != nil {
+		return 0, err
+	}
+	return v, nil
+}
+
+// ErrIncompatible 表示数据库 schema 版本低于插件要求。
+var ErrIncompatible = errors.New("schema version incompatible")
+
+// CheckVersion 校验数据库 schema 版本不低于 required。
+// 数据库版本高于本程序内嵌的最新版本时视为兼容（迁移只做向后兼容的变更）。
+func CheckVersion(ctx context.Context, q Querier, required int) (int, error) {
+	current, err := CurrentVersion(ctx, q)
+	if err != nil {
+		return 0, err
+	}
+	if current < required {
+		return current, fmt.Errorf("%w: database at version %d, requires %d (run `mqttctl migrate up`)", ErrIncompatible, current, required)
+	}
+	return current, nil
+}
+
+// Applied 是已执行的迁移记录。
+type Applied struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time
+}
+
+// Status 读取已执行的迁移记录；迁移表不存在时返回空。
+func Status(ctx context.Context, conn *pgx.Conn) ([]Applied, error) {
+	current, err := CurrentVersion(ctx, conn)
+	if err != nil || current == 0 {
+		return nil, err
+	}
+	rows, err := conn.Query(ctx, selectAppliedSQL)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Applied, error) {
+		var a Applied
+		err := row.Scan(&a.Version, &a.Name, &a.AppliedAt)
+		return a, err
+	})
+}
+
+// Up 在 advisory lock 保护下依次执行待执行迁移（每个迁移一个事务），返回已执行的迁移。
+func Up(ctx context.Context, conn *pgx.Conn, target int) ([]Migration, error) {
+	ms, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, migrateLockKey); err != nil {
+		return nil, err
+	}
+	defer func() { _, _ = conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, migrateLockKey) }()
+
+	if _, err := conn.Exec(ctx, createMigrationsTableSQL); err != nil {
+		return nil, err
+	}
+	current, err := CurrentVersion(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, m := range Pending(ms, current, target) {
+		if err := apply(ctx, conn, m); err != nil {
+			return done, fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+func apply(ctx context.Context, conn *pgx.Conn, m Migration) error {
+	return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		// 无参数时 pgx 使用简单协议，迁移脚本可包含多条语句。
+		if _, err := tx.Exec(ctx, m.SQL); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, insertAppliedSQL, m.Version, m.Name)
+		return err
+	})
+}
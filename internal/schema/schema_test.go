@@ -0,0 +1,178 @@
+package schema
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"mosquitto-plugin/internal/pluginutil"
+)
+
+func TestMigrationsEmbedded(t *testing.T) {
+	ms, err := Migrations()
+	if err != nil {
+		t.Fatalf("Migrations: %v", err)
+	}
+	if len(ms) == 0 || Latest() != ms[len(ms)-1].Version {
+		t.Fatalf("unexpected migrations: %d, latest %d", len(ms), Latest())
+	}
+	if ConnPluginVersion > Latest() || AuthPluginVersion > Latest() || ACLVersion > Latest() {
+		t.Fatalf("plugin versions exceed latest migration %d", Latest())
+	}
+	for _, table := range []string{"mqtt_accounts", "client_auth_events", "client_conn_events", "client_sessions"} {
+		if !strings.Contains(ms[0].SQL, "CREATE TABLE IF NOT EXISTS "+table+" ") {
+			t.Fatalf("0001 should create %s", table)
+		}
+	}
+}
+
+func TestMigrationsMatchContract(t *testing.T) {
+	ms, err := Migrations()
+	if err != nil {
+		t.Fatalf("Migrations: %v", err)
+	}
+	var all strings.Builder
+	for _, m := range ms {
+		all.WriteString(m.SQL)
+	}
+	sql := all.String()
+	in := func(column string, values []string) string {
+		quoted := make([]string, len(values))
+		for i, v := range values {
+			quoted[i] = "'" + v + "'"
+		}
+		return column + " IN (" + strings.Join(quoted, ", ") + ")"
+	}
+	for _, check := range []string{in("event_type", ConnEventTypes), in("acl_type", ACLTypes), in("access", ACLAccesses)} {
+		if !strings.Contains(sql, check) {
+			t.Fatalf("migrations should contain %q", check)
+		}
+	}
+	if check := "result IN ('" + AuthResultSuccess + "', '" + AuthResultFail + "')"; !strings.Contains(sql, check) {
+		t.Fatalf("migrations should contain %q", check)
+	}
+	for _, table := range []string{AccountsTable, AuthEventsTable, ConnEventsTable, SessionsTable, ActiveClientsTable,
+		ACLRolesTable, ACLRulesTable, ACLGroupsTable, ACLGroupRolesTable, ACLAccountRolesTable, ACLAccountGroupsTable, ACLDefaultsTable} {
+		if !strings.Contains(sql, "CREATE TABLE IF NOT EXISTS "+table+" ") {
+			t.Fatalf("migrations should create %s", table)
+		}
+	}
+}
+
+func TestLoadMigrationsValidation(t *testing.T) {
+	cases := map[string]fstest.MapFS{
+		"bad name":   {"m/abc.sql": {Data: []byte("")}},
+		"duplicate":  {"m/0001_a.sql": {}, "m/1_b.sql": {}},
+		"gap":        {"m/0001_a.sql": {}, "m/0003_c.sql": {}},
+		"zero":       {"m/0000_a.sql": {}},
+		"empty name": {"m/0001_.sql": {}},
+	}
+	for name, fsys := range cases {
+		if _, err := loadMigrations(fsys, "m"); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+
+	ms, err := loadMigrations(fstest.MapFS{
+		"m/0002_b.sql": {Data: []byte("B")},
+		"m/0001_a.sql": {Data: []byte("A")},
+		"m/README.md":  {Data: []byte("ignored")},
+	}, "m")
+	if err != nil {
+		t.Fatalf("loadMigrations: %v", err)
+	}
+	if len(ms) != 2 || ms[0].Name != "a" || ms[1].SQL != "B" {
+		t.Fatalf("migrations = %+v", ms)
+	}
+}
+
+func TestPending(t *testing.T) {
+	ms := []Migration{{Version: 1}, {Version: 2}, {Version: 3}}
+	versions := func(ms []Migration) []int {
+		var out []int
+		for _, m := range ms {
+			out = append(out, m.Version)
+		}
+		return out
+	}
+	if got := versions(Pending(ms, 0, 0)); len(got) != 3 {
+		t.Fatalf("pending from 0 = %v", got)
+	}
+	if got := versions(Pending(ms, 1, 2)); len(got) != 1 || got[0] != 2 {
+		t.Fatalf("pending 1->2 = %v", got)
+	}
+	if got := Pending(ms, 3, 0); len(got) != 0 {
+		t.Fatalf("pending at latest = %v", got)
+	}
+}
+
+func TestPartitionSQL(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	stmts := PartitionSQL(ConnEventsTable, pluginutil.PartitionMonthly, now, "client_conn_events_pkey", "public.client_conn_events_id_seq")
+	all := strings.Join(stmts, "\n")
+	for _, want := range []string{
+		`ALTER TABLE "client_conn_events" RENAME TO "client_conn_events_p202610"`,
+		`ALTER INDEX IF EXISTS "client_conn_events_ts_idx" RENAME TO "client_conn_events_p202610_ts_idx"`,
+		`ALTER TABLE "client_conn_events_p202610" DROP CONSTRAINT "client_conn_events_pkey"`,
+		`ALTER TABLE "client_conn_events_p202610" ADD PRIMARY KEY (id, ts)`,
+		`PRIMARY KEY (id, ts)) PARTITION BY RANGE (ts)`,
+		`ALTER SEQUENCE public.client_conn_events_id_seq OWNED BY "client_conn_events".id`,
+		`CREATE INDEX "client_conn_events_client_ts_idx" ON "client_conn_events" (client_id, ts DESC)`,
+	} {
+		if !strings.Contains(all, want) {
+			t.Fatalf("missing %q in:\n%s", want, all)
+		}
+	}
+	// 原表作为当前周期分区挂载，插件从下一周期起建新分区。
+	last := stmts[len(stmts)-1]
+	if want := `FOR VALUES FROM (MINVALUE) TO ('2026-11-01T00:00:00Z')`; !strings.HasSuffix(last, want) {
+		t.Fatalf("attach = %q", last)
+	}
+
+	daily := strings.Join(PartitionSQL(AuthEventsTable, pluginutil.PartitionDaily, now, "", ""), "\n")
+	if strings.Contains(daily, "DROP CONSTRAINT") || strings.Contains(daily, "ALTER SEQUENCE") {
+		t.Fatalf("empty pkey/seq should be skipped:\n%s", daily)
+	}
+	if !strings.Contains(daily, `"client_auth_events_p20261018"`) || !strings.Contains(daily, "TO ('2026-10-19T00:00:00Z')") {
+		t.Fatalf("daily partition:\n%s", daily)
+	}
+}
+
+type fakeRow struct {
+	v   int
+	err error
+}
+
+func (r fakeRow) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	*dest[0].(*int) = r.v
+	return nil
+}
+
+type fakeQuerier struct{ row fakeRow }
+
+func (q fakeQuerier) QueryRow(context.Context, string, ...any) pgx.Row { return q.row }
+
+func TestCheckVersion(t *testing.T) {
+	ctx := context.Background()
+	if v, err := CheckVersion(ctx, fakeQuerier{fakeRow{v: 2}}, 2); err != nil || v != 2 {
+		t.Fatalf("CheckVersion equal = %d, %v", v, err)
+	}
+	if _, err := CheckVersion(ctx, fakeQuerier{fakeRow{v: 5}}, 2); err != nil {
+		t.Fatalf("newer schema should be compatible: %v", err)
+	}
+	if _, err := CheckVersion(ctx, fakeQuerier{fakeRow{v: 0}}, 1); !errors.Is(err, ErrIncompatible) {
+		t.Fatalf("older schema err = %v", err)
+	}
+	dbErr := errors.New("boom")
+	if _, err := CheckVersion(ctx, fakeQuerier{fakeRow{err: dbErr}}, 1); !errors.Is(err, dbErr) {
+		t.Fatalf("db error = %v", err)
+	}
+}
@@ -10,6 +10,7 @@ package main
 typedef int (*mosq_event_cb)(int event, void *event_data, void *userdata);
 
 int basic_auth_cb_c(int event, void *event_data, void *userdata);
+int tick_cb_c(int event, void *event_data, void *userdata);
 
 int register_event_callback(mosquitto_plugin_id_t *id, int event, mosq_event_cb cb);
 int unregister_event_callback(mosquitto_plugin_id_t *id, int event, mosq_event_cb cb);
@@ -18,6 +19,7 @@ void go_mosq_log(int level, const char* msg);
 import "C"
 
 import (
+	"errors"
 	"os"
 	"runtime/debug"
 	"strings"
@@ -26,7 +28,9 @@ import (
 
 	"github.com/jackc/pgx/v5/pgxpool"
 
+	"mosquitto-plugin/internal/pluginconf"
 	"mosquitto-plugin/internal/pluginutil"
+	"mosquitto-plugin/internal/schema"
 )
 
 var pid *C.mosquitto_plugin_id_t
@@ -108,6 +112,13 @@ func go_mosq_plugin_init(id *C.mosquitto_plugin_id_t, userdata *unsafe.Pointer,
 	pgDSN = ""
 	timeout = defaultTimeout
 	failOpen = false
+	schemaCheck = true
+	deferPrefix = defaultDeferPrefix
+	chainCfg := authChainConfig{cacheTTL: defaultAuthCacheTTL, cacheSize: defaultAuthCacheSize}
+	chainValue := defaultAuthChain
+	httpTimeoutSet := false
+	partitionCfg = pluginutil.NewPartitionConfig(authEventsTable)
+	partitions = &pluginutil.PeriodicJob{}
 	poolMu.Lock()
 	if pool != nil {
 		pool.Close()
@@ -135,6 +146,46 @@ func go_mosq_plugin_init(id *C.mosquitto_plugin_id_t, userdata *unsafe.Pointer,
 			} else {
 				log(mosqLogWarning, "auth-plugin: invalid fail_open", map[string]any{"value": value, "fail_open": failOpen})
 			}
+		case "schema_check":
+			if parsed, ok := pluginutil.ParseBoolOption(value); ok {
+				schemaCheck = parsed
+			} else {
+				log(mosqLogWarning, "auth-plugin: invalid schema_check", map[string]any{"value": value, "schema_check": schemaCheck})
+			}
+		case "defer_prefix":
+			deferPrefix = parseDeferPrefix(value)
+		case "auth_chain":
+			chainValue = value
+		case "auth_cache_ttl_ms":
+			if dur, ok := pluginutil.ParseTimeoutMS(value); ok {
+				chainCfg.cacheTTL = dur
+			} else {
+				log(mosqLogWarning, "auth-plugin: invalid auth_cache_ttl_ms", map[string]any{"value": value, "auth_cache_ttl_ms": int(chainCfg.cacheTTL / time.Millisecond)})
+			}
+		case "auth_cache_size":
+			if n, ok := pluginutil.ParsePositiveInt(value); ok {
+				chainCfg.cacheSize = n
+			} else {
+				log(mosqLogWarning, "auth-plugin: invalid auth_cache_size", map[string]any{"value": value, "auth_cache_size": chainCfg.cacheSize})
+			}
+		case "auth_file":
+			chainCfg.filePath = value
+		case "auth_http_url":
+			chainCfg.httpURL = value
+		case "auth_http_token":
+			chainCfg.httpToken = value
+		case "auth_http_timeout_ms":
+			if dur, ok := pluginutil.ParseTimeoutMS(value); ok {
+				chainCfg.httpTimeout, httpTimeoutSet = dur, true
+			} else {
+				log(mosqLogWarning, "auth-plugin: invalid auth_http_timeout_ms", map[string]any{"value": value})
+			}
+		default:
+			if known, valid := pluginutil.ApplyPartitionOption(&partitionCfg, key, value); !known {
+				log(mosqLogWarning, "auth-plugin: "+pluginconf.UnknownKeyMessage(pluginconf.Auth, key), map[string]any{"key": key})
+			} else if !valid {
+				log(mosqLogWarning, "auth-plugin: invalid "+key, map[string]any{"value": value})
+			}
 		}
 	}
 	if pgDSN == "" {
@@ -146,20 +197,57 @@ func go_mosq_plugin_init(id *C.mosquitto_plugin_id_t, userdata *unsafe.Pointer,
 		return C.MOSQ_ERR_UNKNOWN
 	}
 
-	log(mosqLogInfo, "auth-plugin: initializing", map[string]any{"pg_dsn": pluginutil.SafeDSN(pgDSN), "timeout_ms": int(timeout / time.Millisecond), "fail_open": failOpen})
+	backends, err := pluginconf.ParseAuthChain(chainValue)
+	if err != nil {
+		log(mosqLogError, "auth-plugin: invalid auth_chain", map[string]any{"value": chainValue, "error": err.Error()})
+		return C.MOSQ_ERR_UNKNOWN
+	}
+	chainCfg.backends = backends
+	if !httpTimeoutSet {
+		chainCfg.httpTimeout = timeout
+	}
+	built, err := buildAuthChain(chainCfg)
+	if err != nil {
+		log(mosqLogError, "auth-plugin: invalid auth_chain", map[string]any{"value": chainValue, "error": err.Error()})
+		return C.MOSQ_ERR_UNKNOWN
+	}
+	chain = built
+
+	if partitionCfg.Enabled() {
+		partitions.Interval = partitionCfg.CheckInterval
+	}
+
+	log(mosqLogInfo, "auth-plugin: initializing", map[string]any{"pg_dsn": pluginutil.SafeDSN(pgDSN), "timeout_ms": int(timeout / time.Millisecond), "fail_open": failOpen, "defer_prefix": deferPrefix, "auth_chain": chain.names(), "partition_period": partitionCfg.Period.String()})
 
 	// 数据库暂不可用时不阻塞插件加载
 	ctx, cancel := pluginutil.TimeoutContext(timeout)
 	defer cancel()
-	_, err := ensureAuthPool(ctx)
+	p, err := ensureAuthPool(ctx)
 	if err != nil {
 		log(mosqLogWarning, "auth-plugin: initial pg connection failed", map[string]any{"error": err.Error()})
+	} else if schemaCheck {
+		version, err := schema.CheckVersion(ctx, p, schema.AuthPluginVersion)
+		if errors.Is(err, schema.ErrIncompatible) {
+			log(mosqLogError, "auth-plugin: incompatible database schema", map[string]any{"error": err.Error()})
+			return C.MOSQ_ERR_UNKNOWN
+		}
+		if err != nil {
+			log(mosqLogWarning, "auth-plugin: schema version check failed", map[string]any{"error": err.Error()})
+		} else {
+			log(mosqLogInfo, "auth-plugin: schema version ok", map[string]any{"version": version, "required": schema.AuthPluginVersion})
+		}
 	}
 
 	// 注册回调
 	if rc := C.register_event_callback(pid, C.MOSQ_EVT_BASIC_AUTH, C.mosq_event_cb(C.basic_auth_cb_c)); rc != C.MOSQ_ERR_SUCCESS {
 		return rc
 	}
+	if partitions.Enabled() {
+		if rc := C.register_event_callback(pid, C.MOSQ_EVT_TICK, C.mosq_event_cb(C.tick_cb_c)); rc != C.MOSQ_ERR_SUCCESS {
+			C.unregister_event_callback(pid, C.MOSQ_EVT_BASIC_AUTH, C.mosq_event_cb(C.basic_auth_cb_c))
+			return rc
+		}
+	}
 
 	log(mosqLogInfo, "auth-plugin: plugin initialized")
 	return C.MOSQ_ERR_SUCCESS
@@ -170,6 +258,10 @@ func go_mosq_plugin_init(id *C.mosquitto_plugin_id_t, userdata *unsafe.Pointer,
 //export go_mosq_plugin_cleanup
 func go_mosq_plugin_cleanup(userdata unsafe.Pointer, opts *C.struct_mosquitto_opt, optCount C.int) C.int {
 	C.unregister_event_callback(pid, C.MOSQ_EVT_BASIC_AUTH, C.mosq_event_cb(C.basic_auth_cb_c))
+	if partitions.Enabled() {
+		C.unregister_event_callback(pid, C.MOSQ_EVT_TICK, C.mosq_event_cb(C.tick_cb_c))
+	}
+	partitions.Wait()
 	poolMu.Lock()
 	defer poolMu.Unlock()
 	if pool != nil {
@@ -180,6 +272,15 @@ func go_mosq_plugin_cleanup(userdata unsafe.Pointer, opts *C.struct_mosquitto_op
 	return C.MOSQ_ERR_SUCCESS
 }
 
+// parseDeferPrefix 解析 defer_prefix：none 表示不再按前缀分流（mosquitto 不允许空的选项值）。
+func parseDeferPrefix(v string) string {
+	v = strings.TrimSpace(v)
+	if strings.EqualFold(v, "none") {
+		return ""
+	}
+	return v
+}
+
 func authResultCode(allow bool) C.int {
 	if allow {
 		return C.MOSQ_ERR_SUCCESS
@@ -188,25 +289,24 @@ func authResultCode(allow bool) C.int {
 }
 
 func runBasicAuth(info pluginutil.ClientInfo, password string) C.int {
-	if info.Username == "" || strings.HasPrefix(info.Username, "_") {
+	if info.Username == "" || (deferPrefix != "" && strings.HasPrefix(info.Username, deferPrefix)) {
 		return C.MOSQ_ERR_PLUGIN_DEFER
 	}
-	dbAllow, dbReason, err := dbAuthFn(info.Username, password, info.ClientID)
-	allow, result, reason := dbAllow, authResultFail, dbReason
-	if err != nil {
-		warnLogger("auth-plugin auth error", map[string]any{"error": err.Error()})
-		reason = authReasonDBError
+	out := chain.run(info, password)
+	allow, result, reason := out.allow, authResultFail, out.reason
+	if out.err != nil {
+		warnLogger("auth-plugin auth error", map[string]any{"backend": out.backend, "error": out.err.Error()})
 		if failOpen {
-			infoLogger("auth-plugin: fail_open allow auth", map[string]any{"reason": authReasonDBError})
+			infoLogger("auth-plugin: fail_open allow auth", map[string]any{"backend": out.backend, "reason": reason})
 			allow = true
-			result = authResultSuccess
-			reason = authReasonDBErrorFailOpen
+			reason += authReasonFailOpenSuffix
 		}
-	} else if allow {
+	}
+	if allow {
 		result = authResultSuccess
 	}
 
-	if err := recordAuthEventFn(info, result, reason); err != nil {
+	if err := recordAuthEventFn(info, result, reason, out.backend); err != nil {
 		warnLogger("auth-plugin auth event log failed", map[string]any{"error": err.Error()})
 	}
 	return authResultCode(allow)
@@ -226,4 +326,15 @@ func basic_auth_cb_c(event C.int, event_data unsafe.Pointer, userdata unsafe.Poi
 	return runBasicAuth(info, password)
 }
 
+// tick_cb_c 按间隔维护 client_auth_events 的时间分区。
+//
+//export tick_cb_c
+func tick_cb_c(event C.int, event_data unsafe.Pointer, userdata unsafe.Pointer) C.int {
+	now := time.Now()
+	partitions.Start(now, func() {
+		maintainPartitions(now)
+	})
+	return C.MOSQ_ERR_SUCCESS
+}
+
 func main() {}
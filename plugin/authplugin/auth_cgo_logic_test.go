@@ -33,6 +33,7 @@ func TestRunBasicAuth(t *testing.T) {
 		wantCode        int
 		wantEventResult string
 		wantEventReason string
+		wantBackend     string
 	}
 
 	tests := []testCase{
@@ -44,6 +45,7 @@ func TestRunBasicAuth(t *testing.T) {
 			wantCode:        int(authResultCode(true)),
 			wantEventResult: authResultSuccess,
 			wantEventReason: authReasonOK,
+			wantBackend:     "postgres",
 		},
 		{
 			name:            "deny",
@@ -53,6 +55,17 @@ func TestRunBasicAuth(t *testing.T) {
 			wantCode:        int(authResultCode(false)),
 			wantEventResult: authResultFail,
 			wantEventReason: authReasonInvalidPassword,
+			wantBackend:     "postgres",
+		},
+		{
+			// 链中只有 postgres 时，账户不存在仍按拒绝记录，但没有后端作出决定。
+			name:            "user not found",
+			failOpenValue:   false,
+			dbAllow:         false,
+			dbReason:        authReasonUserNotFound,
+			wantCode:        int(authResultCode(false)),
+			wantEventResult: authResultFail,
+			wantEventReason: authReasonUserNotFound,
 		},
 		{
 			name:            "db error fail closed",
@@ -61,6 +74,7 @@ func TestRunBasicAuth(t *testing.T) {
 			wantCode:        int(authResultCode(false)),
 			wantEventResult: authResultFail,
 			wantEventReason: authReasonDBError,
+			wantBackend:     "postgres",
 		},
 		{
 			name:            "db error fail open",
@@ -69,6 +83,7 @@ func TestRunBasicAuth(t *testing.T) {
 			wantCode:        int(authResultCode(true)),
 			wantEventResult: authResultSuccess,
 			wantEventReason: authReasonDBErrorFailOpen,
+			wantBackend:     "postgres",
 		},
 		{
 			name:            "record error does not change decision",
@@ -79,6 +94,7 @@ func TestRunBasicAuth(t *testing.T) {
 			wantCode:        int(authResultCode(true)),
 			wantEventResult: authResultSuccess,
 			wantEventReason: authReasonOK,
+			wantBackend:     "postgres",
 		},
 	}
 
@@ -94,7 +110,7 @@ func TestRunBasicAuth(t *testing.T) {
 			}
 
 			called := false
-			recordAuthEventFn = func(info pluginutil.ClientInfo, result, reason string) error {
+			recordAuthEventFn = func(info pluginutil.ClientInfo, result, reason, backend string) error {
 				called = true
 				if info.ClientID != "c1" || info.Username != "alice" {
 					t.Fatalf("unexpected info: %+v", info)
@@ -102,6 +118,9 @@ func TestRunBasicAuth(t *testing.T) {
 				if result != tc.wantEventResult || reason != tc.wantEventReason {
 					t.Fatalf("event mismatch: got %q/%q want %q/%q", result, reason, tc.wantEventResult, tc.wantEventReason)
 				}
+				if backend != tc.wantBackend {
+					t.Fatalf("backend mismatch: got %q want %q", backend, tc.wantBackend)
+				}
 				return tc.recordErr
 			}
 
@@ -131,7 +150,7 @@ func TestRunBasicAuthDefer(t *testing.T) {
 		t.Fatal("dbAuth should not be called for defer")
 		return false, "", nil
 	}
-	recordAuthEventFn = func(info pluginutil.ClientInfo, result, reason string) error {
+	recordAuthEventFn = func(info pluginutil.ClientInfo, result, reason, backend string) error {
 		t.Fatal("recordAuthEvent should not be called for defer")
 		return nil
 	}
@@ -161,3 +180,38 @@ func TestBasicAuthCbNilEventData(t *testing.T) {
 		t.Fatalf("code mismatch for nil event_data: got=%d want=%d", int(got), want)
 	}
 }
+
+func TestRunBasicAuthDeferPrefix(t *testing.T) {
+	origDBAuth := dbAuthFn
+	origRecord := recordAuthEventFn
+	origPrefix := deferPrefix
+	t.Cleanup(func() {
+		dbAuthFn = origDBAuth
+		recordAuthEventFn = origRecord
+		deferPrefix = origPrefix
+	})
+	var called []string
+	dbAuthFn = func(username, password, clientID string) (bool, string, error) {
+		called = append(called, username)
+		return true, authReasonOK, nil
+	}
+	recordAuthEventFn = func(info pluginutil.ClientInfo, result, reason, backend string) error { return nil }
+
+	// defer_prefix none：_ 前缀账户也由数据库认证（$6$/$7$ 密文已导入 mqtt_accounts）。
+	deferPrefix = parseDeferPrefix(" None ")
+	if got := runBasicAuth(pluginutil.ClientInfo{ClientID: "c1", Username: "_ops"}, "pwd"); got != authResultCode(true) {
+		t.Fatalf("expected success, got=%d", int(got))
+	}
+	if got := runBasicAuth(pluginutil.ClientInfo{ClientID: "c1"}, "pwd"); int(got) != mosqErrDefer {
+		t.Fatalf("empty username should still defer, got=%d", int(got))
+	}
+
+	deferPrefix = parseDeferPrefix("local-")
+	if got := runBasicAuth(pluginutil.ClientInfo{ClientID: "c1", Username: "local-admin"}, "pwd"); int(got) != mosqErrDefer {
+		t.Fatalf("custom prefix should defer, got=%d", int(got))
+	}
+	runBasicAuth(pluginutil.ClientInfo{ClientID: "c1", Username: "_ops"}, "pwd")
+	if len(called) != 2 || called[0] != "_ops" || called[1] != "_ops" {
+		t.Fatalf("dbAuth calls = %v", called)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"mosquitto-plugin/internal/pluginconf"
+	"mosquitto-plugin/internal/pluginutil"
+)
+
+// authVerdict 是认证链中单个后端的结论。
+type authVerdict int
+
+const (
+	// authNext 表示该后端不认识此用户，交给下一个后端。
+	authNext authVerdict = iota
+	authAllow
+	authDeny
+)
+
+// Authenticator 是认证链中的一个后端。新增后端只需实现该接口并在 buildAuthChain 中注册。
+type Authenticator interface {
+	// Name 返回后端名，记录到 client_auth_events.backend。
+	Name() string
+	// Authenticate 返回结论与原因；err 非空表示后端不可用（按 fail_open 处理），此时 reason 为错误原因。
+	Authenticate(info pluginutil.ClientInfo, password string) (authVerdict, string, error)
+}
+
+// authObserver 由需要感知最终结论的后端实现（cache 用于写入其他后端的 allow 结果）。
+type authObserver interface {
+	Observe(info pluginutil.ClientInfo, password string)
+}
+
+// authChain 按顺序执行的后端列表。
+type authChain []Authenticator
+
+// authOutcome 是认证链的执行结果。
+type authOutcome struct {
+	allow  bool
+	reason string
+	// backend 为作出决定的后端；所有后端都返回 next 时为空。
+	backend string
+	err     error
+}
+
+// run 依次调用后端，第一个 allow/deny 或错误即为结果；全部 next 时拒绝并沿用最后一个原因。
+func (c authChain) run(info pluginutil.ClientInfo, password string) authOutcome {
+	out := authOutcome{reason: authReasonUserNotFound}
+	for _, a := range c {
+		verdict, reason, err := a.Authenticate(info, password)
+		if err != nil {
+			return authOutcome{reason: reason, backend: a.Name(), err: err}
+		}
+		out.reason = reason
+		if verdict == authNext {
+			continue
+		}
+		out.allow, out.backend = verdict == authAllow, a.Name()
+		if out.allow {
+			for _, o := range c {
+				if obs, ok := o.(authObserver); ok && o != a {
+					obs.Observe(info, password)
+				}
+			}
+		}
+		return out
+	}
+	return out
+}
+
+// postgresAuthenticator 查询 mqtt_accounts；账户不存在时交给下一个后端。
+type postgresAuthenticator struct{}
+
+func (postgresAuthenticator) Name() string { return pluginconf.AuthBackendPostgres }
+
+func (postgresAuthenticator) Authenticate(info pluginutil.ClientInfo, password string) (authVerdict, string, error) {
+	allow, reason, err := dbAuthFn(info.Username, password, info.ClientID)
+	switch {
+	case err != nil:
+		return authDeny, authReasonDBError, err
+	case allow:
+		return authAllow, reason, nil
+	case reason == authReasonUserNotFound:
+		return authNext, reason, nil
+	default:
+		return authDeny, reason, nil
+	}
+}
+
+// cacheAuthenticator 缓存其他后端的 allow 结果：命中且密码一致时直接放行，否则交给下一个后端。
+// 只缓存 allow，修改密码后旧密码最多在 TTL 内仍可登录，禁用账户同理。
+type cacheAuthenticator struct {
+	ttl  time.Duration
+	size int
+	now  func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]authCacheEntry
+}
+
+type authCacheEntry struct {
+	sum     [sha256.Size]byte
+	expires time.Time
+}
+
+func newCacheAuthenticator(ttl time.Duration, size int) *cacheAuthenticator {
+	return &cacheAuthenticator{ttl: ttl, size: size, now: time.Now, entries: map[string]authCacheEntry{}}
+}
+
+func (c *cacheAuthenticator) Name() string { return pluginconf.AuthBackendCache }
+
+func cacheKey(info pluginutil.ClientInfo) string {
+	return info.Username + "\x00" + info.ClientID
+}
+
+func (c *cacheAuthenticator) Authenticate(info pluginutil.ClientInfo, password string) (authVerdict, string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := cacheKey(info)
+	e, ok := c.entries[key]
+	if !ok {
+		return authNext, authReasonCacheMiss, nil
+	}
+	if !c.now().Before(e.expires) {
+		delete(c.entries, key)
+		return authNext, authReasonCacheMiss, nil
+	}
+	if e.sum != sha256.Sum256([]byte(password)) {
+		return authNext, authReasonCacheMiss, nil
+	}
+	return authAllow, authReasonOK, nil
+}
+
+func (c *cacheAuthenticator) Observe(info pluginutil.ClientInfo, password string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if len(c.entries) >= c.size {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		// 仍然已满时任意淘汰一条。
+		for k := range c.entries {
+			if len(c.entries) < c.size {
+				break
+			}
+			delete(c.entries, k)
+		}
+	}
+	c.entries[cacheKey(info)] = authCacheEntry{sum: sha256.Sum256([]byte(password)), expires: now.Add(c.ttl)}
+}
+
+// authChainConfig 是构建认证链所需的配置。
+type authChainConfig struct {
+	backends    []string
+	cacheTTL    time.Duration
+	cacheSize   int
+	filePath    string
+	httpURL     string
+	httpToken   string
+	httpTimeout time.Duration
+}
+
+// buildAuthChain 按 auth_chain 顺序创建后端。
+func buildAuthChain(cfg authChainConfig) (authChain, error) {
+	var out authChain
+	for _, name := range cfg.backends {
+		switch name {
+		case pluginconf.AuthBackendCache:
+			out = append(out, newCacheAuthenticator(cfg.cacheTTL, cfg.cacheSize))
+		case pluginconf.AuthBackendPostgres:
+			out = append(out, postgresAuthenticator{})
+		case pluginconf.AuthBackendFile:
+			if cfg.filePath == "" {
+				return nil, errors.New("auth_file must be set when auth_chain includes file")
+			}
+			a, err := newFileAuthenticator(cfg.filePath)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, a)
+		case pluginconf.AuthBackendHTTP:
+			if cfg.httpURL == "" {
+				return nil, errors.New("auth_http_url must be set when auth_chain includes http")
+			}
+			out = append(out, newHTTPAuthenticator(cfg.httpURL, cfg.httpToken, cfg.httpTimeout))
+		default:
+			return nil, fmt.Errorf("unknown backend %q", name)
+		}
+	}
+	return out, nil
+}
+
+// names 返回后端名列表（用于日志）。
+func (c authChain) names() []string {
+	out := make([]string, len(c))
+	for i, a := range c {
+		out[i] = a.Name()
+	}
+	return out
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"mosquitto-plugin/internal/pluginutil"
+)
+
+// fakeAuthenticator 返回固定结论并记录调用次数。
+type fakeAuthenticator struct {
+	name    string
+	verdict authVerdict
+	reason  string
+	err     error
+	calls   int
+}
+
+func (f *fakeAuthenticator) Name() string { return f.name }
+
+func (f *fakeAuthenticator) Authenticate(pluginutil.ClientInfo, string) (authVerdict, string, error) {
+	f.calls++
+	return f.verdict, f.reason, f.err
+}
+
+func TestAuthChainRun(t *testing.T) {
+	info := pluginutil.ClientInfo{Username: "alice", ClientID: "c1"}
+
+	first := &fakeAuthenticator{name: "a", verdict: authNext, reason: authReasonUserNotFound}
+	second := &fakeAuthenticator{name: "b", verdict: authDeny, reason: authReasonInvalidPassword}
+	third := &fakeAuthenticator{name: "c", verdict: authAllow, reason: authReasonOK}
+	out := authChain{first, second, third}.run(info, "pwd")
+	if out.allow || out.backend != "b" || out.reason != authReasonInvalidPassword || out.err != nil {
+		t.Fatalf("outcome = %+v", out)
+	}
+	if first.calls != 1 || second.calls != 1 || third.calls != 0 {
+		t.Fatalf("calls = %d/%d/%d", first.calls, second.calls, third.calls)
+	}
+
+	// 全部 next：拒绝，沿用最后一个原因，没有后端作出决定。
+	out = authChain{&fakeAuthenticator{name: "a", reason: authReasonCacheMiss}, &fakeAuthenticator{name: "b", reason: authReasonUserNotFound}}.run(info, "pwd")
+	if out.allow || out.backend != "" || out.reason != authReasonUserNotFound {
+		t.Fatalf("all next = %+v", out)
+	}
+
+	// 后端错误立即结束，由调用方按 fail_open 处理。
+	failing := &fakeAuthenticator{name: "http", verdict: authDeny, reason: authReasonHTTPError, err: errors.New("timeout")}
+	out = authChain{failing, third}.run(info, "pwd")
+	if out.err == nil || out.backend != "http" || out.reason != authReasonHTTPError || third.calls != 0 {
+		t.Fatalf("error outcome = %+v", out)
+	}
+}
+
+func TestAuthChainCache(t *testing.T) {
+	now := time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC)
+	cache := newCacheAuthenticator(time.Minute, 2)
+	cache.now = func() time.Time { return now }
+	backend := &fakeAuthenticator{name: "postgres", verdict: authAllow, reason: authReasonOK}
+	c := authChain{cache, backend}
+	alice := pluginutil.ClientInfo{Username: "alice", ClientID: "c1"}
+
+	if out := c.run(alice, "pwd"); !out.allow || out.backend != "postgres" {
+		t.Fatalf("first auth = %+v", out)
+	}
+	if out := c.run(alice, "pwd"); !out.allow || out.backend != "cache" || backend.calls != 1 {
+		t.Fatalf("cached auth = %+v, calls=%d", out, backend.calls)
+	}
+	// 密码不同或 client id 不同时不命中缓存。
+	backend.verdict, backend.reason = authDeny, authReasonInvalidPassword
+	if out := c.run(alice, "other"); out.allow || out.backend != "postgres" {
+		t.Fatalf("wrong password = %+v", out)
+	}
+	if out := c.run(pluginutil.ClientInfo{Username: "alice", ClientID: "c2"}, "pwd"); out.allow {
+		t.Fatalf("other client id = %+v", out)
+	}
+	now = now.Add(time.Minute)
+	if out := c.run(alice, "pwd"); out.allow || out.backend != "postgres" {
+		t.Fatalf("expired entry = %+v", out)
+	}
+	if len(cache.entries) != 0 {
+		t.Fatalf("expired entry not removed: %v", cache.entries)
+	}
+
+	backend.verdict = authAllow
+	for _, user := range []string{"u1", "u2", "u3"} {
+		c.run(pluginutil.ClientInfo{Username: user}, "pwd")
+	}
+	if len(cache.entries) != 2 {
+		t.Fatalf("cache size = %d, want 2", len(cache.entries))
+	}
+}
+
+func TestPostgresAuthenticator(t *testing.T) {
+	origDBAuth := dbAuthFn
+	t.Cleanup(func() { dbAuthFn = origDBAuth })
+
+	cases := []struct {
+		allow       bool
+		reason      string
+		err         error
+		wantVerdict authVerdict
+		wantReason  string
+	}{
+		{true, authReasonOK, nil, authAllow, authReasonOK},
+		{false, authReasonUserNotFound, nil, authNext, authReasonUserNotFound},
+		{false, authReasonUserDisabled, nil, authDeny, authReasonUserDisabled},
+		{false, "", errors.New("db down"), authDeny, authReasonDBError},
+	}
+	for _, tc := range cases {
+		dbAuthFn = func(string, string, string) (bool, string, error) { return tc.allow, tc.reason, tc.err }
+		verdict, reason, err := postgresAuthenticator{}.Authenticate(pluginutil.ClientInfo{Username: "alice"}, "pwd")
+		if verdict != tc.wantVerdict || reason != tc.wantReason || (err != nil) != (tc.err != nil) {
+			t.Fatalf("%s: got %v/%q/%v", tc.reason, verdict, reason, err)
+		}
+	}
+}
+
+func TestBuildAuthChain(t *testing.T) {
+	c, err := buildAuthChain(authChainConfig{backends: []string{"cache", "postgres", "http"}, cacheTTL: time.Second, cacheSize: 10, httpURL: "http://127.0.0.1/auth"})
+	if err != nil {
+		t.Fatalf("buildAuthChain: %v", err)
+	}
+	if names := c.names(); len(names) != 3 || names[0] != "cache" || names[2] != "http" {
+		t.Fatalf("names = %v", names)
+	}
+	for _, cfg := range []authChainConfig{
+		{backends: []string{"file"}},
+		{backends: []string{"http"}},
+		{backends: []string{"file"}, filePath: "/nonexistent/passwd"},
+	} {
+		if _, err := buildAuthChain(cfg); err == nil {
+			t.Fatalf("buildAuthChain(%+v) should fail", cfg)
+		}
+	}
+}
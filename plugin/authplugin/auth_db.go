@@ -32,7 +32,7 @@ var fetchAuthAccount = func(ctx context.Context, username, clientID string) (aut
 	return acc, nil
 }
 
-var insertAuthEvent = func(ctx context.Context, info pluginutil.ClientInfo, result, reason string) error {
+var insertAuthEvent = func(ctx context.Context, info pluginutil.ClientInfo, result, reason, backend string) error {
 	p, err := ensureAuthPool(ctx)
 	if err != nil {
 		return err
@@ -42,6 +42,7 @@ var insertAuthEvent = func(ctx context.Context, info pluginutil.ClientInfo, resu
 		time.Now().UTC(),
 		result,
 		reason,
+		pluginutil.OptionalString(backend),
 		pluginutil.OptionalString(info.ClientID),
 		pluginutil.OptionalString(info.Username),
 		pluginutil.OptionalString(info.Peer),
@@ -80,16 +81,35 @@ func dbAuth(username, password, clientID string) (bool, string, error) {
 	if acc.enabled == 0 {
 		return false, authReasonUserDisabled, nil
 	}
-	if acc.passwordHash != pluginutil.SHA256PwdSalt(password, acc.salt) {
+	if !pluginutil.VerifyPassword(password, acc.passwordHash, acc.salt) {
 		return false, authReasonInvalidPassword, nil
 	}
 
 	return true, authReasonOK, nil
 }
 
-// recordAuthEvent 写入认证事件。
-func recordAuthEvent(info pluginutil.ClientInfo, result, reason string) error {
+// recordAuthEvent 写入认证事件；backend 为作出决定的后端，为空时写 NULL。
+func recordAuthEvent(info pluginutil.ClientInfo, result, reason, backend string) error {
 	ctx, cancel := pluginutil.TimeoutContext(timeout)
 	defer cancel()
-	return insertAuthEvent(ctx, info, result, reason)
+	return insertAuthEvent(ctx, info, result, reason, backend)
+}
+
+// maintainPartitions 为 client_auth_events 创建未来分区并处理过期分区。
+func maintainPartitions(now time.Time) {
+	ctx, cancel := pluginutil.TimeoutContext(pluginutil.PartitionTimeout)
+	defer cancel()
+
+	p, err := ensureAuthPool(ctx)
+	if err != nil {
+		warnLogger("auth-plugin: partition maintenance skipped", map[string]any{"error": err.Error()})
+		return
+	}
+	res, err := pluginutil.MaintainPartitions(ctx, p, partitionCfg, now)
+	if len(res.Created) > 0 || len(res.Expired) > 0 {
+		infoLogger("auth-plugin: partitions maintained", map[string]any{"table": partitionCfg.Table, "created": res.Created, "expired": res.Expired, "detach": partitionCfg.Detach})
+	}
+	if err != nil {
+		warnLogger("auth-plugin: partition maintenance failed", map[string]any{"table": partitionCfg.Table, "error": err.Error()})
+	}
 }
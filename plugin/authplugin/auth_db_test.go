@@ -94,6 +94,17 @@ func TestDBAuth(t *testing.T) {
 			wantReason: authReasonOK,
 			wantFetch:  true,
 		},
+		{
+			// config/password_file 中 _ops 的密文，密码为 password。
+			name:       "mosquitto pbkdf2 hash",
+			username:   "ops",
+			password:   "password",
+			clientID:   "c1",
+			account:    authAccount{passwordHash: "$7$1000$R3XtB/U3TLTqS01Qny/aCkWRuRyCfeLKszGd1h01/mmUAGvLUsemLVWxZ845RtsDwA1gEdX2H9h+cd83VSq4ag==$8P1yCu5uDzdMXUtvcn9TaATi2cDqXVi793IWvfWkK283pIvBhIF3v9vmLA/MNJ3PzhP3blu14iRjEAsEZ6lx7A==", enabled: 1},
+			wantAllow:  true,
+			wantReason: authReasonOK,
+			wantFetch:  true,
+		},
 	}
 
 	for _, tc := range tests {
@@ -152,7 +163,7 @@ func TestRecordAuthEvent(t *testing.T) {
 		Protocol: "MQTT/5.0",
 	}
 	called := false
-	insertAuthEvent = func(ctx context.Context, info pluginutil.ClientInfo, result, reason string) error {
+	insertAuthEvent = func(ctx context.Context, info pluginutil.ClientInfo, result, reason, backend string) error {
 		called = true
 		if _, ok := ctx.Deadline(); !ok {
 			t.Fatal("recordAuthEvent should pass timeout context")
@@ -160,13 +171,13 @@ func TestRecordAuthEvent(t *testing.T) {
 		if info != wantInfo {
 			t.Fatalf("info mismatch: got=%+v want=%+v", info, wantInfo)
 		}
-		if result != authResultSuccess || reason != authReasonOK {
-			t.Fatalf("result/reason mismatch: got=%q/%q", result, reason)
+		if result != authResultSuccess || reason != authReasonOK || backend != "file" {
+			t.Fatalf("result/reason/backend mismatch: got=%q/%q/%q", result, reason, backend)
 		}
 		return nil
 	}
 
-	if err := recordAuthEvent(wantInfo, authResultSuccess, authReasonOK); err != nil {
+	if err := recordAuthEvent(wantInfo, authResultSuccess, authReasonOK, "file"); err != nil {
 		t.Fatalf("recordAuthEvent returned error: %v", err)
 	}
 	if !called {
@@ -179,11 +190,11 @@ func TestRecordAuthEventError(t *testing.T) {
 	t.Cleanup(func() { insertAuthEvent = origInsert })
 
 	wantErr := errors.New("insert failed")
-	insertAuthEvent = func(context.Context, pluginutil.ClientInfo, string, string) error {
+	insertAuthEvent = func(context.Context, pluginutil.ClientInfo, string, string, string) error {
 		return wantErr
 	}
 
-	err := recordAuthEvent(pluginutil.ClientInfo{}, authResultFail, authReasonDBError)
+	err := recordAuthEvent(pluginutil.ClientInfo{}, authResultFail, authReasonDBError, "postgres")
 	if !errors.Is(err, wantErr) {
 		t.Fatalf("error mismatch: got=%v want=%v", err, wantErr)
 	}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"mosquitto-plugin/internal/pluginconf"
+	"mosquitto-plugin/internal/pluginutil"
+)
+
+// htpasswdSHAPrefix 是 htpasswd -s 生成的 {SHA} 密文前缀（base64(sha1(password))）。
+const htpasswdSHAPrefix = "{SHA}"
+
+// fileHashSupported 报告 auth_file 能否校验该密文：bcrypt（htpasswd -B）、{SHA} 与 mosquitto $6$/$7$。
+func fileHashSupported(hash string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return true
+	case strings.HasPrefix(hash, htpasswdSHAPrefix):
+		return true
+	default:
+		_, err := pluginutil.ParseMosquittoHash(hash)
+		return err == nil
+	}
+}
+
+// verifyFileHash 校验 auth_file 中的密文。
+func verifyFileHash(password, hash string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, htpasswdSHAPrefix):
+		sum := sha1.Sum([]byte(password))
+		want := base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(want), []byte(hash[len(htpasswdSHAPrefix):])) == 1
+	default:
+		return pluginutil.IsMosquittoHash(hash) && pluginutil.VerifyPassword(password, hash, "")
+	}
+}
+
+// fileAuthenticator 读取 htpasswd 风格的 username:hash 文件；文件修改后在下次认证时重新加载。
+// 文件中没有该用户时交给下一个后端。
+type fileAuthenticator struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	users   map[string]string
+}
+
+func newFileAuthenticator(path string) (*fileAuthenticator, error) {
+	a := &fileAuthenticator{path: path}
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *fileAuthenticator) Name() string { return pluginconf.AuthBackendFile }
+
+// reload 在文件修改时间变化时重新读取；调用方持有 mu（初始化时除外）。
+func (a *fileAuthenticator) reload() error {
+	st, err := os.Stat(a.path)
+	if err != nil {
+		return err
+	}
+	if a.users != nil && st.ModTime().Equal(a.modTime) {
+		return nil
+	}
+	f, err := os.Open(a.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	users := map[string]string{}
+	sc := bufio.NewScanner(f)
+	line := 0
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(text, ":")
+		if !ok || user == "" || hash == "" {
+			return fmt.Errorf("%s line %d: want username:hash", a.path, line)
+		}
+		if !fileHashSupported(hash) {
+			warnLogger("auth-plugin: auth_file entry skipped, unsupported hash", map[string]any{"file": a.path, "line": line, "username": user})
+			continue
+		}
+		users[user] = hash
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	a.users, a.modTime = users, st.ModTime()
+	return nil
+}
+
+func (a *fileAuthenticator) Authenticate(info pluginutil.ClientInfo, password string) (authVerdict, string, error) {
+	a.mu.Lock()
+	if err := a.reload(); err != nil {
+		// 重新加载失败时沿用上一次的内容。
+		warnLogger("auth-plugin: auth_file reload failed", map[string]any{"file": a.path, "error": err.Error()})
+	}
+	hash, ok := a.users[info.Username]
+	a.mu.Unlock()
+	switch {
+	case !ok:
+		return authNext, authReasonUserNotFound, nil
+	case password == "":
+		return authDeny, authReasonMissingCreds, nil
+	case !verifyFileHash(password, hash):
+		return authDeny, authReasonInvalidPassword, nil
+	default:
+		return authAllow, authReasonOK, nil
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"mosquitto-plugin/internal/pluginutil"
+)
+
+// 以下密文的明文密码均为 password。
+const sampleAuthFile = `# htpasswd -B / htpasswd -s / mosquitto_passwd
+bob:$2y$04$mNQABhMSrUNtWXxqLHiTseAJOD4TbuTygixGWQMqzD6HxB2lBudYa
+carol:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=
+dave:$6$YWJjZGVmZ2hpamts$H1lO+pqB+PFRkTndJd8DTn+8FqO1YYPMzfvhZwnJhrwMenzZ+jkyfYTUGX/NQIKcWwWnw7fslZIwR99AaWZglA==
+legacy:$apr1$abc$def
+`
+
+func TestFileAuthenticator(t *testing.T) {
+	origWarnLogger := warnLogger
+	t.Cleanup(func() { warnLogger = origWarnLogger })
+	var warnings []string
+	warnLogger = func(msg string, _ map[string]any) { warnings = append(warnings, msg) }
+
+	path := filepath.Join(t.TempDir(), "passwd")
+	if err := os.WriteFile(path, []byte(sampleAuthFile), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	a, err := newFileAuthenticator(path)
+	if err != nil {
+		t.Fatalf("newFileAuthenticator: %v", err)
+	}
+	if len(a.users) != 3 || len(warnings) != 1 {
+		t.Fatalf("users=%v warnings=%v", a.users, warnings)
+	}
+
+	cases := []struct {
+		user, password string
+		want           authVerdict
+		reason         string
+	}{
+		{"bob", "password", authAllow, authReasonOK},
+		{"carol", "password", authAllow, authReasonOK},
+		{"dave", "password", authAllow, authReasonOK},
+		{"bob", "wrong", authDeny, authReasonInvalidPassword},
+		{"carol", "", authDeny, authReasonMissingCreds},
+		{"legacy", "password", authNext, authReasonUserNotFound},
+		{"erin", "password", authNext, authReasonUserNotFound},
+	}
+	for _, tc := range cases {
+		verdict, reason, err := a.Authenticate(pluginutil.ClientInfo{Username: tc.user}, tc.password)
+		if err != nil || verdict != tc.want || reason != tc.reason {
+			t.Fatalf("%s/%s: got %v/%q/%v", tc.user, tc.password, verdict, reason, err)
+		}
+	}
+
+	// 文件修改后重新加载。
+	if err := os.WriteFile(path, []byte("erin:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if verdict, _, _ := a.Authenticate(pluginutil.ClientInfo{Username: "erin"}, "password"); verdict != authAllow {
+		t.Fatalf("reloaded user should be allowed, got %v", verdict)
+	}
+	if verdict, _, _ := a.Authenticate(pluginutil.ClientInfo{Username: "bob"}, "password"); verdict != authNext {
+		t.Fatalf("removed user should be next, got %v", verdict)
+	}
+
+	if err := os.WriteFile(path, []byte("nocolon\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newFileAuthenticator(path); err == nil {
+		t.Fatal("malformed file should fail")
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"mosquitto-plugin/internal/pluginconf"
+	"mosquitto-plugin/internal/pluginutil"
+)
+
+// httpAuthRequest 是 POST 给 auth_http_url 的 JSON。
+type httpAuthRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	ClientID string `json:"clientid"`
+	Peer     string `json:"peer,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+}
+
+// httpAuthenticator 调用 HTTP webhook：200 放行，401/403 拒绝，404 交给下一个后端，其他状态码视为后端错误。
+type httpAuthenticator struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func newHTTPAuthenticator(url, token string, timeout time.Duration) *httpAuthenticator {
+	return &httpAuthenticator{url: url, token: token, client: &http.Client{Timeout: timeout}}
+}
+
+func (a *httpAuthenticator) Name() string { return pluginconf.AuthBackendHTTP }
+
+func (a *httpAuthenticator) Authenticate(info pluginutil.ClientInfo, password string) (authVerdict, string, error) {
+	body, err := json.Marshal(httpAuthRequest{
+		Username: info.Username,
+		Password: password,
+		ClientID: info.ClientID,
+		Peer:     info.Peer,
+		Protocol: info.Protocol,
+	})
+	if err != nil {
+		return authDeny, authReasonHTTPError, err
+	}
+	req, err := http.NewRequest(http.MethodPost, a.url, bytes.NewReader(body))
+	if err != nil {
+		return authDeny, authReasonHTTPError, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if a.token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return authDeny, authReasonHTTPError, err
+	}
+	defer resp.Body.Close()
+	// 读完响应体以便复用连接。
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return authAllow, authReasonOK, nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return authDeny, authReasonHTTPDenied, nil
+	case http.StatusNotFound:
+		return authNext, authReasonUserNotFound, nil
+	default:
+		return authDeny, authReasonHTTPError, fmt.Errorf("auth webhook returned %s", resp.Status)
+	}
+}
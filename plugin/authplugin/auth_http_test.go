@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"mosquitto-plugin/internal/pluginutil"
+)
+
+func TestHTTPAuthenticator(t *testing.T) {
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Authorization") != "Bearer tok" {
+			t.Errorf("unexpected request: %s %q", r.Method, r.Header.Get("Authorization"))
+		}
+		var body httpAuthRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+		want := httpAuthRequest{Username: "alice", Password: "pwd", ClientID: "c1", Peer: "10.0.0.1", Protocol: "MQTT/5.0"}
+		if body != want {
+			t.Errorf("body = %+v", body)
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	a := newHTTPAuthenticator(srv.URL, "tok", time.Second)
+	info := pluginutil.ClientInfo{Username: "alice", ClientID: "c1", Peer: "10.0.0.1", Protocol: "MQTT/5.0"}
+	cases := []struct {
+		status  int
+		want    authVerdict
+		reason  string
+		wantErr bool
+	}{
+		{http.StatusOK, authAllow, authReasonOK, false},
+		{http.StatusNoContent, authAllow, authReasonOK, false},
+		{http.StatusForbidden, authDeny, authReasonHTTPDenied, false},
+		{http.StatusUnauthorized, authDeny, authReasonHTTPDenied, false},
+		{http.StatusNotFound, authNext, authReasonUserNotFound, false},
+		{http.StatusInternalServerError, authDeny, authReasonHTTPError, true},
+	}
+	for _, tc := range cases {
+		status = tc.status
+		verdict, reason, err := a.Authenticate(info, "pwd")
+		if verdict != tc.want || reason != tc.reason || (err != nil) != tc.wantErr {
+			t.Fatalf("status %d: got %v/%q/%v", tc.status, verdict, reason, err)
+		}
+	}
+
+	srv.Close()
+	if _, reason, err := a.Authenticate(info, "pwd"); err == nil || reason != authReasonHTTPError {
+		t.Fatalf("unreachable webhook: %q/%v", reason, err)
+	}
+}
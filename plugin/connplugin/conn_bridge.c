@@ -1,4 +1,8 @@
+#include <stdlib.h>
+#include <string.h>
 #include <mosquitto.h>
+#include <openssl/crypto.h>
+#include <openssl/x509.h>
 
 /*
  * Mosquitto <-> Go bridge for the connection event plugin.
@@ -35,3 +39,39 @@ int unregister_event_callback(mosquitto_plugin_id_t *id, int event, mosq_event_c
 void go_mosq_log(int level, const char* msg) {
     mosquitto_log_printf(level, "%s", msg);
 }
+
+/*
+ * 连接元数据：一次调用读取 keepalive、clean session、传输协议与客户端证书主题。
+ * cert_subject 由 malloc 分配，调用方负责 free。
+ */
+struct conn_client_meta {
+    int keepalive;
+    int clean_session;
+    int transport;
+    char *cert_subject;
+};
+
+void conn_client_meta_read(const struct mosquitto *client, struct conn_client_meta *out) {
+    X509 *cert;
+    char *subject;
+
+    memset(out, 0, sizeof(*out));
+    if (client == NULL) {
+        return;
+    }
+    out->keepalive = mosquitto_client_keepalive(client);
+    out->clean_session = mosquitto_client_clean_session(client) ? 1 : 0;
+    out->transport = mosquitto_client_protocol(client);
+
+    /* mosquitto_client_certificate 返回的证书需由调用方 X509_free。 */
+    cert = (X509 *)mosquitto_client_certificate(client);
+    if (cert == NULL) {
+        return;
+    }
+    subject = X509_NAME_oneline(X509_get_subject_name(cert), NULL, 0);
+    if (subject != NULL) {
+        out->cert_subject = strdup(subject);
+        OPENSSL_free(subject);
+    }
+    X509_free(cert);
+}
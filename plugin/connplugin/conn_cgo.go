@@ -1,16 +1,28 @@
 package main
 
 /*
-#cgo darwin pkg-config: libmosquitto libcjson
+#cgo darwin pkg-config: libmosquitto libcjson openssl
 #cgo darwin LDFLAGS: -Wl,-undefined,dynamic_lookup
-#cgo linux  pkg-config: libmosquitto libcjson
+#cgo linux  pkg-config: libmosquitto libcjson openssl
 #include <stdlib.h>
 #include <mosquitto.h>
 
 typedef int (*mosq_event_cb)(int event, void *event_data, void *userdata);
 
+struct conn_client_meta {
+    int keepalive;
+    int clean_session;
+    int transport;
+    char *cert_subject;
+};
+
+void conn_client_meta_read(const struct mosquitto *client, struct conn_client_meta *out);
+
 int connect_cb_c(int event, void *event_data, void *userdata);
 int disconnect_cb_c(int event, void *event_data, void *userdata);
+int message_cb_c(int event, void *event_data, void *userdata);
+int tick_cb_c(int event, void *event_data, void *userdata);
+int basic_auth_cb_c(int event, void *event_data, void *userdata);
 
 int register_event_callback(mosquitto_plugin_id_t *id, int event, mosq_event_cb cb);
 int unregister_event_callback(mosquitto_plugin_id_t *id, int event, mosq_event_cb cb);
@@ -19,14 +31,18 @@ void go_mosq_log(int level, const char* msg);
 import "C"
 
 import (
+	"errors"
 	"os"
 	"runtime/debug"
+	"strings"
 	"time"
 	"unsafe"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 
+	"mosquitto-plugin/internal/pluginconf"
 	"mosquitto-plugin/internal/pluginutil"
+	"mosquitto-plugin/internal/schema"
 )
 
 var pid *C.mosquitto_plugin_id_t
@@ -67,65 +83,117 @@ func connKey(client *C.struct_mosquitto) (uintptr, bool) {
 	return uintptr(unsafe.Pointer(client)), true
 }
 
-func setConnectedByKey(key uintptr, on bool) {
-	activeConnMu.Lock()
-	if on {
-		activeConn[key] = struct{}{}
-	} else {
-		delete(activeConn, key)
+func clientInfoFromClient(client *C.struct_mosquitto) pluginutil.ClientInfo {
+	info := pluginutil.ClientInfo{}
+	if client == nil {
+		return info
 	}
-	activeConnMu.Unlock()
+	info.ClientID = cstr(C.mosquitto_client_id(client))
+	info.Username = cstr(C.mosquitto_client_username(client))
+	info.Peer = cstr(C.mosquitto_client_address(client))
+	info.Protocol = pluginutil.ProtocolString(int(C.mosquitto_client_protocol_version(client)))
+	return info
 }
 
-func connectedByKey(key uintptr) bool {
-	activeConnMu.Lock()
-	_, ok := activeConn[key]
-	activeConnMu.Unlock()
-	return ok
+// connExtraFromClient 读取写入 extra 列的连接元数据。
+func connExtraFromClient(client *C.struct_mosquitto) connExtra {
+	if client == nil {
+		return connExtra{}
+	}
+	var meta C.struct_conn_client_meta
+	C.conn_client_meta_read(client, &meta)
+	extra := connExtra{
+		Keepalive:    int(meta.keepalive),
+		CleanSession: meta.clean_session != 0,
+		Transport:    transportString(int(meta.transport)),
+	}
+	if meta.cert_subject != nil {
+		extra.CertSubject = C.GoString(meta.cert_subject)
+		C.free(unsafe.Pointer(meta.cert_subject))
+	}
+	return extra
 }
 
-func takeConnectedByKey(key uintptr) bool {
-	activeConnMu.Lock()
-	_, ok := activeConn[key]
-	if ok {
-		delete(activeConn, key)
+// transportString 将 mosquitto_client_protocol 的返回值转为字符串。
+func transportString(protocol int) string {
+	switch protocol {
+	case int(C.mp_mqtt):
+		return "mqtt"
+	case int(C.mp_mqttsn):
+		return "mqttsn"
+	case int(C.mp_websockets):
+		return "websockets"
+	default:
+		return ""
 	}
-	activeConnMu.Unlock()
-	return ok
 }
 
-func setConnected(client *C.struct_mosquitto, on bool) {
-	key, ok := connKey(client)
+// brokerPublish 通过 mosquitto_broker_publish_copy 向订阅者发布消息，不依赖数据库可用性。
+func brokerPublish(topic string, payload []byte, qos int, retain bool) C.int {
+	ctopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(ctopic))
+	cpayload := C.CBytes(payload)
+	defer C.free(cpayload)
+	return C.mosquitto_broker_publish_copy(nil, ctopic, C.int(len(payload)), cpayload, C.int(qos), C.bool(retain), nil)
+}
+
+// publishPresence 发布 retained 在线状态。
+func publishPresence(state string, info pluginutil.ClientInfo, reasonCode *int) {
+	topic, ok := presenceTopic(presenceTopicTemplate, info)
 	if !ok {
 		return
 	}
-	setConnectedByKey(key, on)
+	payload, err := presencePayload(state, info, reasonCode, time.Now())
+	if err != nil {
+		warnLogger("conn-plugin: build presence payload failed", map[string]any{"error": err.Error()})
+		return
+	}
+	if rc := brokerPublish(topic, payload, presenceQoS, true); rc != C.MOSQ_ERR_SUCCESS {
+		warnLogger("conn-plugin: publish presence failed", map[string]any{"topic": topic, "state": state, "rc": int(rc)})
+	}
 }
 
-func handleDisconnectByKey(key uintptr, record func() error) {
-	// 原子地 “检查并清除” 连接状态，避免并发下重复记录 disconnect。
-	if !takeConnectedByKey(key) {
-		if pluginutil.ShouldSample(&debugSkipCounter, debugSampleEvery) {
-			debugLogger("conn-plugin: skip disconnect record", map[string]any{"client_ptr": key})
-		}
+// publishFlapStatus 将当前 flapping 客户端列表以 retained 消息发布到 conn_flap_topic。
+func publishFlapStatus() {
+	if flapStatusTopic == "" {
+		return
+	}
+	payload, err := flapStatusPayload(flaps.flappingClients(), time.Now())
+	if err != nil {
+		warnLogger("conn-plugin: build flapping status failed", map[string]any{"error": err.Error()})
 		return
 	}
+	if rc := brokerPublish(flapStatusTopic, payload, presenceQoS, true); rc != C.MOSQ_ERR_SUCCESS {
+		warnLogger("conn-plugin: publish flapping status failed", map[string]any{"topic": flapStatusTopic, "rc": int(rc)})
+	}
+}
 
-	if err := record(); err != nil {
-		warnLogger("conn-plugin: record disconnect event failed", map[string]any{"error": err.Error()})
+// eventCallback 描述一个已注册的 Mosquitto 事件回调。
+type eventCallback struct {
+	event C.int
+	cb    C.mosq_event_cb
+}
+
+var registeredCallbacks []eventCallback
+
+// registerCallbacks 按顺序注册回调；任一失败时回滚已注册的回调。
+func registerCallbacks(cbs []eventCallback) C.int {
+	for _, c := range cbs {
+		if rc := C.register_event_callback(pid, c.event, c.cb); rc != C.MOSQ_ERR_SUCCESS {
+			unregisterCallbacks()
+			return rc
+		}
+		registeredCallbacks = append(registeredCallbacks, c)
 	}
+	return C.MOSQ_ERR_SUCCESS
 }
 
-func clientInfoFromClient(client *C.struct_mosquitto) pluginutil.ClientInfo {
-	info := pluginutil.ClientInfo{}
-	if client == nil {
-		return info
+func unregisterCallbacks() {
+	for i := len(registeredCallbacks) - 1; i >= 0; i-- {
+		c := registeredCallbacks[i]
+		C.unregister_event_callback(pid, c.event, c.cb)
 	}
-	info.ClientID = cstr(C.mosquitto_client_id(client))
-	info.Username = cstr(C.mosquitto_client_username(client))
-	info.Peer = cstr(C.mosquitto_client_address(client))
-	info.Protocol = pluginutil.ProtocolString(int(C.mosquitto_client_protocol_version(client)))
-	return info
+	registeredCallbacks = nil
 }
 
 //export go_mosq_plugin_version
@@ -149,9 +217,20 @@ func go_mosq_plugin_init(id *C.mosquitto_plugin_id_t, userdata *unsafe.Pointer,
 		}
 	}()
 
+	unregisterCallbacks()
 	pid = id
 	pgDSN = ""
 	timeout = defaultTimeout
+	trackMessages = false
+	schemaCheck = true
+	presenceTopicTemplate = ""
+	presenceQoS = defaultPresenceQoS
+	flapCfg = flapConfig{window: defaultFlapWindow, summaryEvery: defaultFlapSummaryEvery}
+	flapStatusTopic = ""
+	snapshots = &pluginutil.PeriodicJob{}
+	nodeID = defaultNodeID()
+	partitionCfg = pluginutil.NewPartitionConfig(connEventsTable)
+	partitions = &pluginutil.PeriodicJob{}
 	debugSkipCounter = 0
 	debugRecordCounter = 0
 	poolMu.Lock()
@@ -160,9 +239,7 @@ func go_mosq_plugin_init(id *C.mosquitto_plugin_id_t, userdata *unsafe.Pointer,
 		pool = nil
 	}
 	poolMu.Unlock()
-	activeConnMu.Lock()
-	activeConn = map[uintptr]struct{}{}
-	activeConnMu.Unlock()
+	resetActiveConns()
 
 	if env := os.Getenv("PG_DSN"); env != "" {
 		pgDSN = env
@@ -178,6 +255,85 @@ func go_mosq_plugin_init(id *C.mosquitto_plugin_id_t, userdata *unsafe.Pointer,
 			} else {
 				log(mosqLogWarning, "conn-plugin: invalid conn_timeout_ms", map[string]any{"value": value, "timeout_ms": int(timeout / time.Millisecond)})
 			}
+		case "conn_track_messages":
+			if parsed, ok := pluginutil.ParseBoolOption(value); ok {
+				trackMessages = parsed
+			} else {
+				log(mosqLogWarning, "conn-plugin: invalid conn_track_messages", map[string]any{"value": value, "track_messages": trackMessages})
+			}
+		case "conn_schema_check":
+			if parsed, ok := pluginutil.ParseBoolOption(value); ok {
+				schemaCheck = parsed
+			} else {
+				log(mosqLogWarning, "conn-plugin: invalid conn_schema_check", map[string]any{"value": value, "schema_check": schemaCheck})
+			}
+		case "conn_presence_topic":
+			presenceTopicTemplate = strings.TrimSpace(value)
+		case "conn_presence_qos":
+			if qos, ok := parsePresenceQoS(value); ok {
+				presenceQoS = qos
+			} else {
+				log(mosqLogWarning, "conn-plugin: invalid conn_presence_qos", map[string]any{"value": value, "presence_qos": presenceQoS})
+			}
+		case "conn_flap_threshold":
+			if n, ok := pluginutil.ParsePositiveInt(value); ok {
+				flapCfg.threshold = n
+			} else {
+				log(mosqLogWarning, "conn-plugin: invalid conn_flap_threshold", map[string]any{"value": value, "flap_threshold": flapCfg.threshold})
+			}
+		case "conn_flap_window_ms":
+			if dur, ok := pluginutil.ParseTimeoutMS(value); ok {
+				flapCfg.window = dur
+			} else {
+				log(mosqLogWarning, "conn-plugin: invalid conn_flap_window_ms", map[string]any{"value": value, "flap_window_ms": int(flapCfg.window / time.Millisecond)})
+			}
+		case "conn_flap_suppress":
+			if parsed, ok := pluginutil.ParseBoolOption(value); ok {
+				flapCfg.suppress = parsed
+			} else {
+				log(mosqLogWarning, "conn-plugin: invalid conn_flap_suppress", map[string]any{"value": value, "flap_suppress": flapCfg.suppress})
+			}
+		case "conn_flap_summary_every":
+			if n, ok := pluginutil.ParsePositiveInt(value); ok {
+				flapCfg.summaryEvery = n
+			} else {
+				log(mosqLogWarning, "conn-plugin: invalid conn_flap_summary_every", map[string]any{"value": value, "flap_summary_every": flapCfg.summaryEvery})
+			}
+		case "conn_flap_quarantine_ms":
+			if dur, ok := pluginutil.ParseTimeoutMS(value); ok {
+				flapCfg.quarantine = dur
+			} else {
+				log(mosqLogWarning, "conn-plugin: invalid conn_flap_quarantine_ms", map[string]any{"value": value, "flap_quarantine_ms": int(flapCfg.quarantine / time.Millisecond)})
+			}
+		case "conn_flap_quarantine_standalone":
+			if parsed, ok := pluginutil.ParseBoolOption(value); ok {
+				flapCfg.standalone = parsed
+			} else {
+				log(mosqLogWarning, "conn-plugin: invalid conn_flap_quarantine_standalone", map[string]any{"value": value, "flap_quarantine_standalone": flapCfg.standalone})
+			}
+		case "conn_flap_topic":
+			flapStatusTopic = strings.TrimSpace(value)
+		case "conn_snapshot_interval_ms":
+			if dur, ok := pluginutil.ParseTimeoutMS(value); ok {
+				snapshots.Interval = dur
+			} else {
+				log(mosqLogWarning, "conn-plugin: invalid conn_snapshot_interval_ms", map[string]any{"value": value, "snapshot_interval_ms": int(snapshots.Interval / time.Millisecond)})
+			}
+		case "conn_node_id":
+			if v := strings.TrimSpace(value); v != "" {
+				nodeID = v
+			}
+		default:
+			name, prefixed := strings.CutPrefix(key, "conn_")
+			known, valid := false, false
+			if prefixed {
+				known, valid = pluginutil.ApplyPartitionOption(&partitionCfg, name, value)
+			}
+			if !known {
+				log(mosqLogWarning, "conn-plugin: "+pluginconf.UnknownKeyMessage(pluginconf.Conn, key), map[string]any{"key": key})
+			} else if !valid {
+				log(mosqLogWarning, "conn-plugin: invalid "+key, map[string]any{"value": value})
+			}
 		}
 	}
 
@@ -190,20 +346,58 @@ func go_mosq_plugin_init(id *C.mosquitto_plugin_id_t, userdata *unsafe.Pointer,
 		return C.MOSQ_ERR_UNKNOWN
 	}
 
-	log(mosqLogInfo, "conn-plugin: initializing", map[string]any{"pg_dsn": pluginutil.SafeDSN(pgDSN), "timeout_ms": int(timeout / time.Millisecond)})
+	flaps = newFlapTracker(flapCfg)
+	if partitionCfg.Enabled() {
+		partitions.Interval = partitionCfg.CheckInterval
+	}
+
+	log(mosqLogInfo, "conn-plugin: initializing", map[string]any{
+		"pg_dsn":               pluginutil.SafeDSN(pgDSN),
+		"timeout_ms":           int(timeout / time.Millisecond),
+		"track_messages":       trackMessages,
+		"presence_topic":       presenceTopicTemplate,
+		"presence_qos":         presenceQoS,
+		"flap_threshold":       flapCfg.threshold,
+		"flap_window_ms":       int(flapCfg.window / time.Millisecond),
+		"flap_suppress":        flapCfg.suppress,
+		"flap_quarantine_ms":   int(flapCfg.quarantine / time.Millisecond),
+		"flap_standalone":      flapCfg.standalone,
+		"snapshot_interval_ms": int(snapshots.Interval / time.Millisecond),
+		"node_id":              nodeID,
+		"partition_period":     partitionCfg.Period.String(),
+	})
 
 	ctx, cancel := pluginutil.TimeoutContext(timeout)
 	defer cancel()
-	_, err := ensureConnPool(ctx)
+	p, err := ensureConnPool(ctx)
 	if err != nil {
 		log(mosqLogWarning, "conn-plugin: initial pg connection failed", map[string]any{"error": err.Error()})
+	} else if schemaCheck {
+		version, err := schema.CheckVersion(ctx, p, schema.ConnPluginVersion)
+		if errors.Is(err, schema.ErrIncompatible) {
+			log(mosqLogError, "conn-plugin: incompatible database schema", map[string]any{"error": err.Error()})
+			return C.MOSQ_ERR_UNKNOWN
+		}
+		if err != nil {
+			log(mosqLogWarning, "conn-plugin: schema version check failed", map[string]any{"error": err.Error()})
+		} else {
+			log(mosqLogInfo, "conn-plugin: schema version ok", map[string]any{"version": version, "required": schema.ConnPluginVersion})
+		}
 	}
 
-	if rc := C.register_event_callback(pid, C.MOSQ_EVT_CONNECT, C.mosq_event_cb(C.connect_cb_c)); rc != C.MOSQ_ERR_SUCCESS {
-		return rc
+	callbacks := []eventCallback{
+		{C.MOSQ_EVT_CONNECT, C.mosq_event_cb(C.connect_cb_c)},
+		{C.MOSQ_EVT_DISCONNECT, C.mosq_event_cb(C.disconnect_cb_c)},
+	}
+	if trackMessages {
+		callbacks = append(callbacks, eventCallback{C.MOSQ_EVT_MESSAGE, C.mosq_event_cb(C.message_cb_c)})
+	}
+	// TICK 始终注册：暂存的断开连接需要在接管判定窗口过后写入。
+	callbacks = append(callbacks, eventCallback{C.MOSQ_EVT_TICK, C.mosq_event_cb(C.tick_cb_c)})
+	if flaps.enabled() && flapCfg.quarantine > 0 {
+		callbacks = append(callbacks, eventCallback{C.MOSQ_EVT_BASIC_AUTH, C.mosq_event_cb(C.basic_auth_cb_c)})
 	}
-	if rc := C.register_event_callback(pid, C.MOSQ_EVT_DISCONNECT, C.mosq_event_cb(C.disconnect_cb_c)); rc != C.MOSQ_ERR_SUCCESS {
-		C.unregister_event_callback(pid, C.MOSQ_EVT_CONNECT, C.mosq_event_cb(C.connect_cb_c))
+	if rc := registerCallbacks(callbacks); rc != C.MOSQ_ERR_SUCCESS {
 		return rc
 	}
 
@@ -213,8 +407,17 @@ func go_mosq_plugin_init(id *C.mosquitto_plugin_id_t, userdata *unsafe.Pointer,
 
 //export go_mosq_plugin_cleanup
 func go_mosq_plugin_cleanup(userdata unsafe.Pointer, opts *C.struct_mosquitto_opt, optCount C.int) C.int {
-	C.unregister_event_callback(pid, C.MOSQ_EVT_CONNECT, C.mosq_event_cb(C.connect_cb_c))
-	C.unregister_event_callback(pid, C.MOSQ_EVT_DISCONNECT, C.mosq_event_cb(C.disconnect_cb_c))
+	unregisterCallbacks()
+	flushDisconnects(time.Now(), true)
+
+	snapshots.Wait()
+	partitions.Wait()
+	if snapshots.Enabled() {
+		// Broker 停止后本节点不再有在线连接，写入空快照避免残留过期数据。
+		if err := writeActiveSnapshot(nodeID, nil, time.Now().UTC()); err != nil {
+			log(mosqLogWarning, "conn-plugin: clear active clients snapshot failed", map[string]any{"error": err.Error()})
+		}
+	}
 
 	poolMu.Lock()
 	if pool != nil {
@@ -223,9 +426,7 @@ func go_mosq_plugin_cleanup(userdata unsafe.Pointer, opts *C.struct_mosquitto_op
 	}
 	poolMu.Unlock()
 
-	activeConnMu.Lock()
-	activeConn = map[uintptr]struct{}{}
-	activeConnMu.Unlock()
+	resetActiveConns()
 
 	log(mosqLogInfo, "conn-plugin: plugin cleaned up")
 	return C.MOSQ_ERR_SUCCESS
@@ -238,8 +439,46 @@ func connect_cb_c(event C.int, event_data unsafe.Pointer, userdata unsafe.Pointe
 		return C.MOSQ_ERR_SUCCESS
 	}
 
-	setConnected(ed.client, true)
-	if err := recordEvent(clientInfoFromClient(ed.client), connEventTypeConnect, nil); err != nil {
+	key, ok := connKey(ed.client)
+	if !ok {
+		return C.MOSQ_ERR_SUCCESS
+	}
+	info := clientInfoFromClient(ed.client)
+	now := time.Now()
+	// Mosquitto 接管时先触发旧连接的 DISCONNECT：先写入暂存的旧连接断开事件，再记录接管与新连接。
+	recent, takeover := claimDisconnect(info.ClientID, info.Peer, now)
+	if recent != nil {
+		if err := finishDisconnect(recent); err != nil {
+			warnLogger("conn-plugin: record disconnect event failed", map[string]any{"error": err.Error()})
+		}
+	}
+	decision := flaps.observeConnect(info.ClientID, now)
+	previous := addConnectedByKey(key, &connState{info: info, connectedAt: now, suppressed: decision.suppress})
+	if previous == nil && takeover {
+		previous = recent.state
+	}
+	publishPresence(presenceStateOnline, info, nil)
+	if previous != nil {
+		if pluginutil.ShouldSample(&debugTakeoverCounter, takeoverLogSampleEvery) {
+			warnLogger("conn-plugin: client id takeover", map[string]any{"client_id": info.ClientID, "old_peer": previous.info.Peer, "new_peer": info.Peer})
+		}
+		if err := recordAuxEvent(info, connEventTypeTakeover, newTakeoverExtra(previous, info)); err != nil {
+			warnLogger("conn-plugin: record takeover event failed", map[string]any{"error": err.Error()})
+		}
+	}
+	if decision.entered {
+		warnLogger("conn-plugin: client flapping", map[string]any{"client_id": info.ClientID, "connects": decision.connects, "window_ms": int(flapCfg.window / time.Millisecond), "quarantine_ms": int(flapCfg.quarantine / time.Millisecond)})
+		publishFlapStatus()
+	}
+	if decision.summary {
+		if err := recordAuxEvent(info, connEventTypeFlapping, decision.extra(flapCfg)); err != nil {
+			warnLogger("conn-plugin: record flapping event failed", map[string]any{"error": err.Error()})
+		}
+	}
+	if decision.suppress {
+		return C.MOSQ_ERR_SUCCESS
+	}
+	if err := recordEvent(info, connEventTypeConnect, nil, connExtraFromClient(ed.client)); err != nil {
 		log(mosqLogWarning, "conn-plugin: record connect event failed", map[string]any{"error": err.Error()})
 	}
 	return C.MOSQ_ERR_SUCCESS
@@ -255,10 +494,109 @@ func disconnect_cb_c(event C.int, event_data unsafe.Pointer, userdata unsafe.Poi
 	if !ok {
 		return C.MOSQ_ERR_SUCCESS
 	}
-	handleDisconnectByKey(key, func() error {
-		return recordEvent(clientInfoFromClient(ed.client), connEventTypeDisconnect, int(ed.reason))
+	handleDisconnectByKey(key, func(state *connState) error {
+		c := &closedConn{state: state, info: clientInfoFromClient(ed.client), reason: int(ed.reason), at: time.Now()}
+		c.extra = connExtraFromClient(ed.client)
+		c.extra.setSessionStats(state, c.at)
+		if state.takenOver || c.info.ClientID == "" {
+			return finishDisconnect(c)
+		}
+		// 暂存 takeoverWindow，等待可能紧随其后的同 client_id CONNECT；过期后由 tick 按普通断开写入。
+		if evicted := parkDisconnect(c); evicted != nil {
+			return finishDisconnect(evicted)
+		}
+		return nil
 	})
 	return C.MOSQ_ERR_SUCCESS
 }
 
+// finishDisconnect 发布 offline 在线状态并写入 disconnect 事件。
+func finishDisconnect(c *closedConn) error {
+	// 被接管的旧连接不发布 offline，避免覆盖新连接的 online 状态。
+	if !c.state.takenOver {
+		publishPresence(presenceStateOffline, c.info, &c.reason)
+	}
+	if c.state.suppressed {
+		return nil
+	}
+	c.extra.setTakeover(c.state)
+	return recordEvent(c.info, connEventTypeDisconnect, c.reason, c.extra)
+}
+
+// flushDisconnects 写入超过 takeoverWindow（all=true 时为全部）的暂存断开连接。
+func flushDisconnects(now time.Time, all bool) {
+	for _, c := range expiredDisconnects(now, all) {
+		if err := finishDisconnect(c); err != nil {
+			warnLogger("conn-plugin: record disconnect event failed", map[string]any{"error": err.Error()})
+		}
+	}
+}
+
+// message_cb_c 仅用于累计连接的上行消息数与字节数，不影响消息投递。
+//
+//export message_cb_c
+func message_cb_c(event C.int, event_data unsafe.Pointer, userdata unsafe.Pointer) C.int {
+	ed := (*C.struct_mosquitto_evt_message)(event_data)
+	if ed == nil {
+		return C.MOSQ_ERR_SUCCESS
+	}
+	if key, ok := connKey(ed.client); ok {
+		addPublishedByKey(key, int(ed.payloadlen))
+	}
+	return C.MOSQ_ERR_SUCCESS
+}
+
+// tick_cb_c 写入已过接管判定窗口的断开事件，定期清理 flapping 滑动窗口，并按间隔写入在线连接快照、维护事件表分区。
+//
+//export tick_cb_c
+func tick_cb_c(event C.int, event_data unsafe.Pointer, userdata unsafe.Pointer) C.int {
+	now := time.Now()
+	flushDisconnects(now, false)
+	if recovered := flaps.prune(now); len(recovered) > 0 {
+		log(mosqLogInfo, "conn-plugin: clients no longer flapping", map[string]any{"client_ids": recovered})
+		publishFlapStatus()
+	}
+	partitions.Start(now, func() {
+		maintainPartitions(now)
+	})
+	snapshots.Start(now, func() {
+		if err := writeActiveSnapshot(nodeID, activeClientsSnapshot(), now.UTC()); err != nil {
+			warnLogger("conn-plugin: write active clients snapshot failed", map[string]any{"error": err.Error()})
+		}
+	})
+	return C.MOSQ_ERR_SUCCESS
+}
+
+// defaultNodeID 默认使用主机名标识 Broker 节点。
+func defaultNodeID() string {
+	if host, err := os.Hostname(); err == nil && host != "" {
+		return host
+	}
+	return "mosquitto"
+}
+
+// basic_auth_cb_c 拒绝处于隔离冷却期的 client_id，其余交给后续认证插件；
+// conn_flap_quarantine_standalone=true 时没有后续认证，直接放行。
+//
+//export basic_auth_cb_c
+func basic_auth_cb_c(event C.int, event_data unsafe.Pointer, userdata unsafe.Pointer) C.int {
+	pass := C.int(C.MOSQ_ERR_PLUGIN_DEFER)
+	if flapCfg.standalone {
+		pass = C.MOSQ_ERR_SUCCESS
+	}
+	ed := (*C.struct_mosquitto_evt_basic_auth)(event_data)
+	if ed == nil || ed.client == nil {
+		return pass
+	}
+	clientID := cstr(C.mosquitto_client_id(ed.client))
+	until, ok := flaps.quarantined(clientID, time.Now())
+	if !ok {
+		return pass
+	}
+	if pluginutil.ShouldSample(&debugQuarantineCounter, debugSampleEvery) {
+		warnLogger("conn-plugin: reject quarantined client", map[string]any{"client_id": clientID, "until": until.UTC().Format(time.RFC3339)})
+	}
+	return C.MOSQ_ERR_AUTH
+}
+
 func main() {}
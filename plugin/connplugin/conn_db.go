@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"mosquitto-plugin/internal/pluginutil"
@@ -20,7 +22,12 @@ func ensureConnPool(ctx context.Context) (*pgxpool.Pool, error) {
 	return p, nil
 }
 
-func recordEvent(info pluginutil.ClientInfo, eventType string, reasonCode any) error {
+func recordEvent(info pluginutil.ClientInfo, eventType string, reasonCode any, extra connExtra) error {
+	extraJSON, err := json.Marshal(extra)
+	if err != nil {
+		return err
+	}
+
 	ctx, cancel := pluginutil.TimeoutContext(timeout)
 	defer cancel()
 
@@ -29,6 +36,7 @@ func recordEvent(info pluginutil.ClientInfo, eventType string, reasonCode any) e
 		return err
 	}
 
+	durationMS, msgs, bytes := extra.sessionTotals()
 	ts := time.Now().UTC()
 	connectTS, disconnectTS := any(nil), any(nil)
 	if eventType == connEventTypeConnect {
@@ -45,9 +53,12 @@ func recordEvent(info pluginutil.ClientInfo, eventType string, reasonCode any) e
 		pluginutil.OptionalString(info.Peer),
 		pluginutil.OptionalString(info.Protocol),
 		reasonCode,
-		nil,
+		extraJSON,
 		connectTS,
 		disconnectTS,
+		durationMS,
+		msgs,
+		bytes,
 	)
 	if err != nil {
 		return err
@@ -57,3 +68,99 @@ func recordEvent(info pluginutil.ClientInfo, eventType string, reasonCode any) e
 	}
 	return nil
 }
+
+// recordAuxEvent 写入仅追加到 client_conn_events 的辅助事件（不更新 client_sessions）。
+func recordAuxEvent(info pluginutil.ClientInfo, eventType string, extra any) error {
+	extraJSON, err := json.Marshal(extra)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := pluginutil.TimeoutContext(timeout)
+	defer cancel()
+
+	p, err := ensureConnPool(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = p.Exec(ctx, insertEventSQL,
+		time.Now().UTC(),
+		eventType,
+		info.ClientID,
+		pluginutil.OptionalString(info.Username),
+		pluginutil.OptionalString(info.Peer),
+		pluginutil.OptionalString(info.Protocol),
+		extraJSON,
+	)
+	return err
+}
+
+// writeActiveSnapshot 在一个事务内替换本节点的在线连接快照。
+func writeActiveSnapshot(node string, rows []activeClientRow, ts time.Time) error {
+	ctx, cancel := pluginutil.TimeoutContext(timeout)
+	defer cancel()
+
+	p, err := ensureConnPool(ctx)
+	if err != nil {
+		return err
+	}
+	tx, err := p.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if _, err := tx.Exec(ctx, deleteActiveClientsSQL, node); err != nil {
+		return err
+	}
+	if len(rows) > 0 {
+		_, err = tx.CopyFrom(ctx,
+			pgx.Identifier{"broker_active_clients"},
+			[]string{"node_id", "client_id", "username", "peer", "protocol", "connected_at", "snapshot_ts"},
+			pgx.CopyFromSlice(len(rows), func(i int) ([]any, error) {
+				r := rows[i]
+				return []any{
+					node,
+					r.ClientID,
+					pluginutil.OptionalString(r.Username),
+					pluginutil.OptionalString(r.Peer),
+					pluginutil.OptionalString(r.Protocol),
+					r.ConnectedAt.UTC(),
+					ts,
+				}, nil
+			}),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(ctx, upsertActiveSnapshotSQL, node, ts, len(rows)); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	if pluginutil.ShouldSample(&debugSnapshotCounter, snapshotLogSampleEvery) {
+		log(mosqLogDebug, "conn-plugin: active clients snapshot written", map[string]any{"node_id": node, "clients": len(rows)})
+	}
+	return nil
+}
+
+// maintainPartitions 为 client_conn_events 创建未来分区并处理过期分区。
+func maintainPartitions(now time.Time) {
+	ctx, cancel := pluginutil.TimeoutContext(pluginutil.PartitionTimeout)
+	defer cancel()
+
+	p, err := ensureConnPool(ctx)
+	if err != nil {
+		warnLogger("conn-plugin: partition maintenance skipped", map[string]any{"error": err.Error()})
+		return
+	}
+	res, err := pluginutil.MaintainPartitions(ctx, p, partitionCfg, now)
+	if len(res.Created) > 0 || len(res.Expired) > 0 {
+		log(mosqLogInfo, "conn-plugin: partitions maintained", map[string]any{"table": partitionCfg.Table, "created": res.Created, "expired": res.Expired, "detach": partitionCfg.Detach})
+	}
+	if err != nil {
+		warnLogger("conn-plugin: partition maintenance failed", map[string]any{"table": partitionCfg.Table, "error": err.Error()})
+	}
+}
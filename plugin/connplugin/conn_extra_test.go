@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestConnExtraJSON(t *testing.T) {
+	data, err := json.Marshal(connExtra{Keepalive: 60, CleanSession: true, Transport: "mqtt"})
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %v", err)
+	}
+	want := `{"keepalive":60,"clean_session":true,"transport":"mqtt"}`
+	if string(data) != want {
+		t.Fatalf("extra mismatch: got=%s want=%s", data, want)
+	}
+
+	data, err = json.Marshal(connExtra{CertSubject: "/CN=dev-1"})
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %v", err)
+	}
+	want = `{"keepalive":0,"clean_session":false,"cert_subject":"/CN=dev-1"}`
+	if string(data) != want {
+		t.Fatalf("extra mismatch: got=%s want=%s", data, want)
+	}
+}
+
+func TestTransportString(t *testing.T) {
+	cases := map[int]string{0: "mqtt", 1: "mqttsn", 2: "websockets", 99: ""}
+	for in, want := range cases {
+		if got := transportString(in); got != want {
+			t.Fatalf("transportString(%d) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFlapWindow       = 60 * time.Second
+	defaultFlapSummaryEvery = 20
+	flapPruneInterval       = time.Second
+)
+
+// flapConfig 控制重连抖动（flapping）检测；threshold<=0 表示关闭。
+type flapConfig struct {
+	window       time.Duration
+	threshold    int
+	suppress     bool
+	summaryEvery int
+	quarantine   time.Duration
+	// standalone 表示 Broker 没有其他认证来源（认证插件或 password_file）：未隔离的客户端由本插件直接放行，
+	// 否则全部返回 defer 会被 Mosquitto 拒绝。
+	standalone bool
+}
+
+// flapEntry 记录单个 client_id 在滑动窗口内的 connect 时间。
+type flapEntry struct {
+	connects        []time.Time
+	flapping        bool
+	since           time.Time
+	suppressed      uint64
+	quarantineUntil time.Time
+}
+
+// flapDecision 是一次 connect 观测后的处理结论。
+type flapDecision struct {
+	// entered 表示本次 connect 使客户端进入 flapping 状态。
+	entered bool
+	// suppress 表示本次 connect/disconnect 不单独写事件。
+	suppress bool
+	// summary 表示需要写入一条 flapping 汇总事件。
+	summary         bool
+	connects        int
+	suppressed      uint64
+	quarantineUntil time.Time
+}
+
+// flapExtra 是 flapping 事件写入 extra 列的内容。
+type flapExtra struct {
+	Connects        int    `json:"connects_in_window"`
+	WindowMS        int64  `json:"window_ms"`
+	Suppressed      uint64 `json:"suppressed"`
+	QuarantineUntil string `json:"quarantine_until,omitempty"`
+}
+
+func (d flapDecision) extra(cfg flapConfig) flapExtra {
+	e := flapExtra{
+		Connects:   d.connects,
+		WindowMS:   cfg.window.Milliseconds(),
+		Suppressed: d.suppressed,
+	}
+	if !d.quarantineUntil.IsZero() {
+		e.QuarantineUntil = d.quarantineUntil.UTC().Format(time.RFC3339)
+	}
+	return e
+}
+
+// flapStatus 是对外暴露的 flapping 客户端信息。
+type flapStatus struct {
+	ClientID        string `json:"client_id"`
+	Connects        int    `json:"connects_in_window"`
+	Since           string `json:"since"`
+	Suppressed      uint64 `json:"suppressed"`
+	QuarantineUntil string `json:"quarantine_until,omitempty"`
+}
+
+// flapTracker 按 client_id 维护 connect 滑动窗口。
+type flapTracker struct {
+	mu        sync.Mutex
+	cfg       flapConfig
+	entries   map[string]*flapEntry
+	lastPrune time.Time
+}
+
+func newFlapTracker(cfg flapConfig) *flapTracker {
+	return &flapTracker{cfg: cfg, entries: map[string]*flapEntry{}}
+}
+
+func (t *flapTracker) enabled() bool {
+	return t != nil && t.cfg.threshold > 0
+}
+
+// observeConnect 记录一次 connect 并返回是否进入/处于 flapping 状态。
+func (t *flapTracker) observeConnect(clientID string, now time.Time) flapDecision {
+	if !t.enabled() || clientID == "" {
+		return flapDecision{}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e := t.entries[clientID]
+	if e == nil {
+		e = &flapEntry{}
+		t.entries[clientID] = e
+	}
+	e.connects = append(trimConnects(e.connects, now.Add(-t.cfg.window)), now)
+	// 只需保留阈值数量的时间点即可判断是否超限，避免内存随重连次数增长。
+	if len(e.connects) > t.cfg.threshold {
+		e.connects = e.connects[len(e.connects)-t.cfg.threshold:]
+	}
+
+	d := flapDecision{connects: len(e.connects)}
+	if !e.flapping {
+		if len(e.connects) < t.cfg.threshold {
+			return d
+		}
+		e.flapping = true
+		e.since = now
+		e.suppressed = 0
+		if t.cfg.quarantine > 0 {
+			e.quarantineUntil = now.Add(t.cfg.quarantine)
+		}
+		d.entered = true
+		d.summary = true
+		d.quarantineUntil = e.quarantineUntil
+		return d
+	}
+
+	if t.cfg.suppress {
+		e.suppressed++
+		d.suppress = true
+		d.suppressed = e.suppressed
+		d.summary = t.cfg.summaryEvery > 0 && e.suppressed%uint64(t.cfg.summaryEvery) == 0
+	}
+	d.quarantineUntil = e.quarantineUntil
+	return d
+}
+
+// quarantined 判断 client_id 是否处于隔离冷却期。
+func (t *flapTracker) quarantined(clientID string, now time.Time) (time.Time, bool) {
+	if !t.enabled() || t.cfg.quarantine <= 0 || clientID == "" {
+		return time.Time{}, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e := t.entries[clientID]
+	if e == nil || !now.Before(e.quarantineUntil) {
+		return time.Time{}, false
+	}
+	return e.quarantineUntil, true
+}
+
+// prune 清理窗口外的记录，返回离开 flapping 状态的 client_id；最多每秒执行一次。
+func (t *flapTracker) prune(now time.Time) []string {
+	if !t.enabled() {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.lastPrune.IsZero() && now.Sub(t.lastPrune) < flapPruneInterval {
+		return nil
+	}
+	t.lastPrune = now
+
+	var recovered []string
+	cutoff := now.Add(-t.cfg.window)
+	for id, e := range t.entries {
+		e.connects = trimConnects(e.connects, cutoff)
+		if now.Before(e.quarantineUntil) {
+			continue
+		}
+		if e.flapping && len(e.connects) < t.cfg.threshold {
+			e.flapping = false
+			recovered = append(recovered, id)
+		}
+		if !e.flapping && len(e.connects) == 0 {
+			delete(t.entries, id)
+		}
+	}
+	sort.Strings(recovered)
+	return recovered
+}
+
+// flappingClients 返回当前处于 flapping 状态的客户端（按 client_id 排序）。
+func (t *flapTracker) flappingClients() []flapStatus {
+	if !t.enabled() {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]flapStatus, 0)
+	for id, e := range t.entries {
+		if !e.flapping {
+			continue
+		}
+		st := flapStatus{
+			ClientID:   id,
+			Connects:   len(e.connects),
+			Since:      e.since.UTC().Format(time.RFC3339),
+			Suppressed: e.suppressed,
+		}
+		if !e.quarantineUntil.IsZero() {
+			st.QuarantineUntil = e.quarantineUntil.UTC().Format(time.RFC3339)
+		}
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ClientID < out[j].ClientID })
+	return out
+}
+
+func trimConnects(connects []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(connects) && connects[i].Before(cutoff) {
+		i++
+	}
+	return connects[i:]
+}
+
+// flapStatusPayload 生成发布到 conn_flap_topic 的 flapping 客户端列表。
+func flapStatusPayload(clients []flapStatus, now time.Time) ([]byte, error) {
+	if clients == nil {
+		clients = []flapStatus{}
+	}
+	return json.Marshal(struct {
+		TS      string       `json:"ts"`
+		Clients []flapStatus `json:"clients"`
+	}{TS: now.UTC().Format(time.RFC3339), Clients: clients})
+}
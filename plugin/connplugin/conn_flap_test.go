@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFlapTrackerDisabled(t *testing.T) {
+	tr := newFlapTracker(flapConfig{window: time.Minute})
+	now := time.Unix(1000, 0)
+	for i := 0; i < 10; i++ {
+		if d := tr.observeConnect("c1", now); d.entered || d.suppress || d.summary {
+			t.Fatalf("disabled tracker should not flag connects: %+v", d)
+		}
+	}
+	if _, ok := tr.quarantined("c1", now); ok {
+		t.Fatal("disabled tracker should not quarantine")
+	}
+}
+
+func TestFlapTrackerEntersAndSuppresses(t *testing.T) {
+	tr := newFlapTracker(flapConfig{window: time.Minute, threshold: 3, suppress: true, summaryEvery: 2})
+	now := time.Unix(1000, 0)
+
+	for i := 0; i < 2; i++ {
+		if d := tr.observeConnect("c1", now.Add(time.Duration(i)*time.Second)); d.entered || d.suppress {
+			t.Fatalf("connect %d should not flag: %+v", i, d)
+		}
+	}
+	d := tr.observeConnect("c1", now.Add(2*time.Second))
+	if !d.entered || !d.summary || d.suppress || d.connects != 3 {
+		t.Fatalf("third connect should enter flapping: %+v", d)
+	}
+
+	d = tr.observeConnect("c1", now.Add(3*time.Second))
+	if !d.suppress || d.summary || d.suppressed != 1 {
+		t.Fatalf("fourth connect should be suppressed without summary: %+v", d)
+	}
+	d = tr.observeConnect("c1", now.Add(4*time.Second))
+	if !d.suppress || !d.summary || d.suppressed != 2 {
+		t.Fatalf("fifth connect should be suppressed with summary: %+v", d)
+	}
+
+	if d := tr.observeConnect("c2", now); d.entered || d.suppress {
+		t.Fatalf("other client should not be affected: %+v", d)
+	}
+
+	clients := tr.flappingClients()
+	if len(clients) != 1 || clients[0].ClientID != "c1" || clients[0].Suppressed != 2 {
+		t.Fatalf("flapping clients mismatch: %+v", clients)
+	}
+}
+
+func TestFlapTrackerWindowExpires(t *testing.T) {
+	tr := newFlapTracker(flapConfig{window: 10 * time.Second, threshold: 2})
+	now := time.Unix(1000, 0)
+
+	tr.observeConnect("c1", now)
+	if d := tr.observeConnect("c1", now.Add(20*time.Second)); d.entered {
+		t.Fatalf("connects outside window should not flag: %+v", d)
+	}
+	if d := tr.observeConnect("c1", now.Add(21*time.Second)); !d.entered {
+		t.Fatalf("connects inside window should flag: %+v", d)
+	}
+
+	if recovered := tr.prune(now.Add(40 * time.Second)); len(recovered) != 1 || recovered[0] != "c1" {
+		t.Fatalf("prune should recover c1, got %v", recovered)
+	}
+	if clients := tr.flappingClients(); len(clients) != 0 {
+		t.Fatalf("no client should be flapping after prune: %+v", clients)
+	}
+	if recovered := tr.prune(now.Add(40*time.Second + 100*time.Millisecond)); recovered != nil {
+		t.Fatalf("prune should be rate limited, got %v", recovered)
+	}
+}
+
+func TestFlapTrackerQuarantine(t *testing.T) {
+	tr := newFlapTracker(flapConfig{window: time.Minute, threshold: 2, quarantine: 30 * time.Second})
+	now := time.Unix(1000, 0)
+
+	tr.observeConnect("c1", now)
+	d := tr.observeConnect("c1", now.Add(time.Second))
+	if !d.entered || d.quarantineUntil.IsZero() {
+		t.Fatalf("entering flapping should set quarantine: %+v", d)
+	}
+	if _, ok := tr.quarantined("c1", now.Add(10*time.Second)); !ok {
+		t.Fatal("client should be quarantined during cool-down")
+	}
+	if _, ok := tr.quarantined("c1", now.Add(31*time.Second)); ok {
+		t.Fatal("quarantine should expire after cool-down")
+	}
+	if _, ok := tr.quarantined("c2", now); ok {
+		t.Fatal("unknown client should not be quarantined")
+	}
+}
+
+func TestFlapStatusPayload(t *testing.T) {
+	data, err := flapStatusPayload(nil, time.Unix(0, 0))
+	if err != nil {
+		t.Fatalf("flapStatusPayload failed: %v", err)
+	}
+	if !strings.Contains(string(data), `"clients":[]`) {
+		t.Fatalf("empty status should encode empty list, got %s", data)
+	}
+}
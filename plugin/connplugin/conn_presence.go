@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"mosquitto-plugin/internal/pluginutil"
+)
+
+const (
+	presenceStateOnline  = "online"
+	presenceStateOffline = "offline"
+
+	defaultPresenceQoS = 1
+)
+
+// presenceMessage 是发布到在线状态主题的 JSON 文档（retained）。
+type presenceMessage struct {
+	State      string `json:"state"`
+	TS         string `json:"ts"`
+	ClientID   string `json:"client_id"`
+	Username   string `json:"username,omitempty"`
+	Peer       string `json:"peer,omitempty"`
+	Protocol   string `json:"protocol,omitempty"`
+	ReasonCode *int   `json:"reason_code,omitempty"`
+}
+
+// parsePresenceQoS 解析在线状态消息的 QoS（0/1/2）。
+func parsePresenceQoS(v string) (int, bool) {
+	return pluginutil.ParseQoS(v)
+}
+
+// presenceTopic 按模板展开在线状态主题：%u 为 username，%c 为 client_id。
+// 与 Mosquitto ACL pattern 一致，取值为空或包含 / + # 时不展开，避免写入非预期主题。
+func presenceTopic(tmpl string, info pluginutil.ClientInfo) (string, bool) {
+	if tmpl == "" {
+		return "", false
+	}
+	if strings.Contains(tmpl, "%u") && !safeTopicLevel(info.Username) {
+		return "", false
+	}
+	if strings.Contains(tmpl, "%c") && !safeTopicLevel(info.ClientID) {
+		return "", false
+	}
+	return strings.NewReplacer("%u", info.Username, "%c", info.ClientID).Replace(tmpl), true
+}
+
+func safeTopicLevel(v string) bool {
+	return v != "" && !strings.ContainsAny(v, "/+#")
+}
+
+// presencePayload 生成在线状态 JSON；reasonCode 仅 offline 时传入。
+func presencePayload(state string, info pluginutil.ClientInfo, reasonCode *int, now time.Time) ([]byte, error) {
+	return json.Marshal(presenceMessage{
+		State:      state,
+		TS:         now.UTC().Format(time.RFC3339),
+		ClientID:   info.ClientID,
+		Username:   info.Username,
+		Peer:       info.Peer,
+		Protocol:   info.Protocol,
+		ReasonCode: reasonCode,
+	})
+}
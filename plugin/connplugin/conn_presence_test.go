@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"mosquitto-plugin/internal/pluginutil"
+)
+
+func TestPresenceTopic(t *testing.T) {
+	info := pluginutil.ClientInfo{ClientID: "dev-1", Username: "alice"}
+
+	tests := []struct {
+		name   string
+		tmpl   string
+		info   pluginutil.ClientInfo
+		want   string
+		wantOK bool
+	}{
+		{"user and client", "$presence/%u/%c", info, "$presence/alice/dev-1", true},
+		{"client only", "v1/d/%c/presence", pluginutil.ClientInfo{ClientID: "dev-1"}, "v1/d/dev-1/presence", true},
+		{"disabled", "", info, "", false},
+		{"missing username", "$presence/%u/%c", pluginutil.ClientInfo{ClientID: "dev-1"}, "", false},
+		{"wildcard in client id", "$presence/%c", pluginutil.ClientInfo{ClientID: "dev/+"}, "", false},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := presenceTopic(tc.tmpl, tc.info)
+			if got != tc.want || ok != tc.wantOK {
+				t.Fatalf("presenceTopic(%q) = (%q, %v), want (%q, %v)", tc.tmpl, got, ok, tc.want, tc.wantOK)
+			}
+		})
+	}
+}
+
+func TestPresencePayload(t *testing.T) {
+	info := pluginutil.ClientInfo{ClientID: "dev-1", Username: "alice", Peer: "10.0.0.1", Protocol: "MQTT/5.0"}
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	data, err := presencePayload(presenceStateOnline, info, nil, now)
+	if err != nil {
+		t.Fatalf("presencePayload failed: %v", err)
+	}
+	want := `{"state":"online","ts":"2026-01-02T03:04:05Z","client_id":"dev-1","username":"alice","peer":"10.0.0.1","protocol":"MQTT/5.0"}`
+	if string(data) != want {
+		t.Fatalf("online payload mismatch: got=%s want=%s", data, want)
+	}
+
+	reason := 7
+	data, err = presencePayload(presenceStateOffline, pluginutil.ClientInfo{ClientID: "dev-1"}, &reason, now)
+	if err != nil {
+		t.Fatalf("presencePayload failed: %v", err)
+	}
+	want = `{"state":"offline","ts":"2026-01-02T03:04:05Z","client_id":"dev-1","reason_code":7}`
+	if string(data) != want {
+		t.Fatalf("offline payload mismatch: got=%s want=%s", data, want)
+	}
+}
+
+func TestParsePresenceQoS(t *testing.T) {
+	for _, v := range []string{"0", "1", " 2 "} {
+		if _, ok := parsePresenceQoS(v); !ok {
+			t.Fatalf("parsePresenceQoS(%q) should be valid", v)
+		}
+	}
+	for _, v := range []string{"3", "-1", "x"} {
+		if _, ok := parsePresenceQoS(v); ok {
+			t.Fatalf("parsePresenceQoS(%q) should be invalid", v)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// activeClientRow 是写入 broker_active_clients 的一行。
+type activeClientRow struct {
+	ClientID    string
+	Username    string
+	Peer        string
+	Protocol    string
+	ConnectedAt time.Time
+}
+
+// activeClientsSnapshot 复制当前在线连接（每个 client_id 一行，按 client_id 排序）。
+func activeClientsSnapshot() []activeClientRow {
+	activeConnMu.Lock()
+	rows := make([]activeClientRow, 0, len(activeByClientID))
+	for _, key := range activeByClientID {
+		state := activeConn[key]
+		if state == nil {
+			continue
+		}
+		rows = append(rows, activeClientRow{
+			ClientID:    state.info.ClientID,
+			Username:    state.info.Username,
+			Peer:        state.info.Peer,
+			Protocol:    state.info.Protocol,
+			ConnectedAt: state.connectedAt,
+		})
+	}
+	activeConnMu.Unlock()
+	sort.Slice(rows, func(i, j int) bool { return rows[i].ClientID < rows[j].ClientID })
+	return rows
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"mosquitto-plugin/internal/pluginutil"
+)
+
+func TestActiveClientsSnapshotOneRowPerClientID(t *testing.T) {
+	resetConnState()
+	t.Cleanup(resetConnState)
+
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	addConnectedByKey(uintptr(1), &connState{info: pluginutil.ClientInfo{ClientID: "dev-b", Peer: "10.0.0.1"}, connectedAt: at})
+	addConnectedByKey(uintptr(2), &connState{info: pluginutil.ClientInfo{ClientID: "dev-a", Username: "u", Peer: "10.0.0.2", Protocol: "MQTT/5.0"}, connectedAt: at})
+	// 接管：同一 client_id 仅保留新连接。
+	addConnectedByKey(uintptr(3), &connState{info: pluginutil.ClientInfo{ClientID: "dev-b", Peer: "10.0.0.3"}, connectedAt: at})
+
+	rows := activeClientsSnapshot()
+	if len(rows) != 2 {
+		t.Fatalf("rows = %d, want 2", len(rows))
+	}
+	if rows[0].ClientID != "dev-a" || rows[0].Username != "u" || rows[0].Protocol != "MQTT/5.0" {
+		t.Fatalf("rows[0] = %+v", rows[0])
+	}
+	if rows[1].ClientID != "dev-b" || rows[1].Peer != "10.0.0.3" || !rows[1].ConnectedAt.Equal(at) {
+		t.Fatalf("rows[1] = %+v", rows[1])
+	}
+
+	handleDisconnectByKey(uintptr(2), func(*connState) error { return nil })
+	if rows := activeClientsSnapshot(); len(rows) != 1 || rows[0].ClientID != "dev-b" {
+		t.Fatalf("after disconnect rows = %+v", rows)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mosquitto-plugin/internal/pluginutil"
+)
+
+// connState 保存单个在线连接的运行期状态。
+type connState struct {
+	info           pluginutil.ClientInfo
+	connectedAt    time.Time
+	msgsPublished  atomic.Uint64
+	bytesPublished atomic.Uint64
+
+	// suppressed 表示该连接处于 flapping 抑制期，connect/disconnect 均不单独写事件。
+	suppressed bool
+
+	// takenOverBy 非空表示该连接被同 client_id 的新连接接管（值为新连接的 peer）。
+	takenOver   bool
+	takenOverBy string
+}
+
+// sessionStats 汇总连接结束时的时长与流量计数。
+func (s *connState) sessionStats(now time.Time) (durationMS int64, msgs, bytes uint64) {
+	durationMS = now.Sub(s.connectedAt).Milliseconds()
+	if durationMS < 0 {
+		durationMS = 0
+	}
+	return durationMS, s.msgsPublished.Load(), s.bytesPublished.Load()
+}
+
+func setConnectedByKey(key uintptr, on bool) {
+	if on {
+		addConnectedByKey(key, &connState{connectedAt: time.Now()})
+		return
+	}
+	takeConnectedByKey(key)
+}
+
+// addConnectedByKey 登记在线连接；若同 client_id 已有在线连接，则标记旧连接被接管并返回它。
+// 旧连接已先断开的情况（Mosquitto 的实际顺序）由 claimDisconnect 识别。
+func addConnectedByKey(key uintptr, state *connState) (previous *connState) {
+	activeConnMu.Lock()
+	defer activeConnMu.Unlock()
+	activeConn[key] = state
+	clientID := state.info.ClientID
+	if clientID == "" {
+		return nil
+	}
+	if oldKey, ok := activeByClientID[clientID]; ok && oldKey != key {
+		if old := activeConn[oldKey]; old != nil {
+			old.takenOver = true
+			old.takenOverBy = state.info.Peer
+			previous = old
+		}
+	}
+	activeByClientID[clientID] = key
+	return previous
+}
+
+// resetActiveConns 清空在线连接状态（插件初始化/清理时调用）。
+func resetActiveConns() {
+	activeConnMu.Lock()
+	activeConn = map[uintptr]*connState{}
+	activeByClientID = map[string]uintptr{}
+	activeConnMu.Unlock()
+	recentMu.Lock()
+	recentDisconnects = map[string]*closedConn{}
+	recentMu.Unlock()
+}
+
+// takeoverWindow 为旧连接 DISCONNECT 之后、同 client_id 新连接 CONNECT 仍被认定为接管的最长间隔。
+// Mosquitto 接管时先在处理新连接 CONNECT 的同一调用中断开旧连接，再触发新连接的 CONNECT 事件，两者通常相隔不到 1ms。
+const takeoverWindow = 20 * time.Millisecond
+
+// closedConn 是已断开、尚未写入 disconnect 事件的连接：等待 takeoverWindow 判断是否被接管。
+type closedConn struct {
+	state  *connState
+	info   pluginutil.ClientInfo
+	reason int
+	extra  connExtra
+	at     time.Time
+}
+
+var (
+	recentMu sync.Mutex
+	// recentDisconnects 以 client_id 为 key 暂存刚断开的连接。
+	recentDisconnects = map[string]*closedConn{}
+)
+
+// parkDisconnect 暂存带 client_id 的断开连接；同 client_id 已有暂存项时将其返回，由调用方按普通断开写入。
+func parkDisconnect(c *closedConn) (evicted *closedConn) {
+	recentMu.Lock()
+	defer recentMu.Unlock()
+	evicted = recentDisconnects[c.info.ClientID]
+	recentDisconnects[c.info.ClientID] = c
+	return evicted
+}
+
+// claimDisconnect 取出同 client_id 的暂存断开连接；在 takeoverWindow 内时标记为被 peer 接管并返回 takeover=true。
+func claimDisconnect(clientID, peer string, now time.Time) (c *closedConn, takeover bool) {
+	if clientID == "" {
+		return nil, false
+	}
+	recentMu.Lock()
+	defer recentMu.Unlock()
+	c = recentDisconnects[clientID]
+	if c == nil {
+		return nil, false
+	}
+	delete(recentDisconnects, clientID)
+	if now.Sub(c.at) <= takeoverWindow {
+		c.state.takenOver = true
+		c.state.takenOverBy = peer
+		return c, true
+	}
+	return c, false
+}
+
+// expiredDisconnects 取出超过 takeoverWindow 的暂存断开连接（按断开时间排序）；all=true 时取出全部。
+func expiredDisconnects(now time.Time, all bool) []*closedConn {
+	recentMu.Lock()
+	var out []*closedConn
+	for id, c := range recentDisconnects {
+		if all || now.Sub(c.at) > takeoverWindow {
+			out = append(out, c)
+			delete(recentDisconnects, id)
+		}
+	}
+	recentMu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].at.Before(out[j].at) })
+	return out
+}
+
+func connectedByKey(key uintptr) bool {
+	activeConnMu.Lock()
+	_, ok := activeConn[key]
+	activeConnMu.Unlock()
+	return ok
+}
+
+func takeConnectedByKey(key uintptr) (*connState, bool) {
+	activeConnMu.Lock()
+	state, ok := activeConn[key]
+	if ok {
+		delete(activeConn, key)
+		if id := state.info.ClientID; id != "" && activeByClientID[id] == key {
+			delete(activeByClientID, id)
+		}
+	}
+	activeConnMu.Unlock()
+	return state, ok
+}
+
+// addPublishedByKey 累加连接上行消息数与字节数；未知连接直接忽略。
+func addPublishedByKey(key uintptr, payloadLen int) {
+	activeConnMu.Lock()
+	state := activeConn[key]
+	activeConnMu.Unlock()
+	if state == nil {
+		return
+	}
+	state.msgsPublished.Add(1)
+	state.bytesPublished.Add(uint64(payloadLen))
+}
+
+func handleDisconnectByKey(key uintptr, record func(state *connState) error) {
+	// 原子地 “检查并清除” 连接状态，避免并发下重复记录 disconnect。
+	state, ok := takeConnectedByKey(key)
+	if !ok {
+		if pluginutil.ShouldSample(&debugSkipCounter, debugSampleEvery) {
+			debugLogger("conn-plugin: skip disconnect record", map[string]any{"client_ptr": key})
+		}
+		return
+	}
+
+	if err := record(state); err != nil {
+		warnLogger("conn-plugin: record disconnect event failed", map[string]any{"error": err.Error()})
+	}
+}
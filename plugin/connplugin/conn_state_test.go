@@ -3,12 +3,13 @@ package main
 import (
 	"errors"
 	"testing"
+	"time"
+
+	"mosquitto-plugin/internal/pluginutil"
 )
 
 func resetConnState() {
-	activeConnMu.Lock()
-	activeConn = map[uintptr]struct{}{}
-	activeConnMu.Unlock()
+	resetActiveConns()
 	debugSkipCounter = 0
 	debugRecordCounter = 0
 }
@@ -28,7 +29,7 @@ func TestHandleDisconnectByKeyAlwaysClearsState(t *testing.T) {
 	setConnectedByKey(key, true)
 
 	called := false
-	handleDisconnectByKey(key, func() error {
+	handleDisconnectByKey(key, func(*connState) error {
 		called = true
 		return errors.New("write failed")
 	})
@@ -55,7 +56,7 @@ func TestHandleDisconnectByKeySkipWhenNotConnected(t *testing.T) {
 	key := uintptr(999)
 
 	called := false
-	handleDisconnectByKey(key, func() error {
+	handleDisconnectByKey(key, func(*connState) error {
 		called = true
 		return nil
 	})
@@ -80,11 +81,11 @@ func TestHandleDisconnectByKeyIdempotent(t *testing.T) {
 	setConnectedByKey(key, true)
 
 	called := 0
-	handleDisconnectByKey(key, func() error {
+	handleDisconnectByKey(key, func(*connState) error {
 		called++
 		return nil
 	})
-	handleDisconnectByKey(key, func() error {
+	handleDisconnectByKey(key, func(*connState) error {
 		called++
 		return nil
 	})
@@ -93,3 +94,133 @@ func TestHandleDisconnectByKeyIdempotent(t *testing.T) {
 		t.Fatalf("record callback should be called once, got=%d", called)
 	}
 }
+
+func TestAddPublishedByKeyAccumulates(t *testing.T) {
+	resetConnState()
+	key := uintptr(77)
+	setConnectedByKey(key, true)
+	addPublishedByKey(key, 10)
+	addPublishedByKey(key, 5)
+	addPublishedByKey(uintptr(78), 100)
+
+	state, ok := takeConnectedByKey(key)
+	if !ok {
+		t.Fatal("connection state should exist")
+	}
+	durationMS, msgs, bytes := state.sessionStats(state.connectedAt.Add(1500 * time.Millisecond))
+	if durationMS != 1500 || msgs != 2 || bytes != 15 {
+		t.Fatalf("session stats mismatch: duration=%d msgs=%d bytes=%d", durationMS, msgs, bytes)
+	}
+}
+
+func TestConnExtraSessionStats(t *testing.T) {
+	oldTrack := trackMessages
+	t.Cleanup(func() { trackMessages = oldTrack })
+
+	state := &connState{connectedAt: time.Unix(100, 0)}
+	state.msgsPublished.Store(3)
+	state.bytesPublished.Store(42)
+
+	trackMessages = false
+	var extra connExtra
+	extra.setSessionStats(state, time.Unix(102, 0))
+	if durationMS, msgs, bytes := extra.sessionTotals(); durationMS != 2000 || msgs != 0 || bytes != 0 {
+		t.Fatalf("totals without tracking mismatch: %d/%d/%d", durationMS, msgs, bytes)
+	}
+
+	trackMessages = true
+	extra = connExtra{}
+	extra.setSessionStats(state, time.Unix(102, 0))
+	if durationMS, msgs, bytes := extra.sessionTotals(); durationMS != 2000 || msgs != 3 || bytes != 42 {
+		t.Fatalf("totals with tracking mismatch: %d/%d/%d", durationMS, msgs, bytes)
+	}
+}
+
+func TestAddConnectedByKeyDetectsTakeover(t *testing.T) {
+	resetConnState()
+	oldKey, newKey := uintptr(1), uintptr(2)
+
+	if prev := addConnectedByKey(oldKey, &connState{info: pluginutil.ClientInfo{ClientID: "dev-1", Peer: "10.0.0.1"}}); prev != nil {
+		t.Fatalf("first connect should not be a takeover: %+v", prev)
+	}
+	prev := addConnectedByKey(newKey, &connState{info: pluginutil.ClientInfo{ClientID: "dev-1", Peer: "10.0.0.2"}})
+	if prev == nil || prev.info.Peer != "10.0.0.1" {
+		t.Fatalf("second connect should report previous connection, got %+v", prev)
+	}
+
+	old, ok := takeConnectedByKey(oldKey)
+	if !ok || !old.takenOver || old.takenOverBy != "10.0.0.2" {
+		t.Fatalf("old connection should be marked taken over: %+v", old)
+	}
+	var extra connExtra
+	extra.setTakeover(old)
+	if extra.DisconnectReason != disconnectReasonTakeover || extra.TakenOverBy != "10.0.0.2" {
+		t.Fatalf("takeover extra mismatch: %+v", extra)
+	}
+
+	// 旧连接断开不能清除新连接的索引。
+	if prev := addConnectedByKey(uintptr(3), &connState{info: pluginutil.ClientInfo{ClientID: "dev-1", Peer: "10.0.0.3"}}); prev == nil || prev.info.Peer != "10.0.0.2" {
+		t.Fatalf("third connect should take over the second connection, got %+v", prev)
+	}
+}
+
+func TestAddConnectedByKeyWithoutClientID(t *testing.T) {
+	resetConnState()
+	addConnectedByKey(uintptr(1), &connState{})
+	if prev := addConnectedByKey(uintptr(2), &connState{}); prev != nil {
+		t.Fatalf("empty client id should never be a takeover: %+v", prev)
+	}
+}
+
+// Mosquitto 的实际顺序：先断开旧连接，再触发新连接的 CONNECT。
+func TestDisconnectThenConnectIsTakeover(t *testing.T) {
+	resetConnState()
+	now := time.Now()
+	oldInfo := pluginutil.ClientInfo{ClientID: "dev-1", Peer: "10.0.0.1"}
+	addConnectedByKey(uintptr(1), &connState{info: oldInfo, connectedAt: now.Add(-time.Minute)})
+	state, ok := takeConnectedByKey(uintptr(1))
+	if !ok {
+		t.Fatal("connection state should exist")
+	}
+	if evicted := parkDisconnect(&closedConn{state: state, info: oldInfo, at: now}); evicted != nil {
+		t.Fatalf("nothing should be evicted: %+v", evicted)
+	}
+
+	c, takeover := claimDisconnect("dev-1", "10.0.0.2", now.Add(time.Millisecond))
+	if c == nil || !takeover {
+		t.Fatalf("connect right after disconnect should be a takeover: %+v %v", c, takeover)
+	}
+	var extra connExtra
+	extra.setTakeover(c.state)
+	if extra.DisconnectReason != disconnectReasonTakeover || extra.TakenOverBy != "10.0.0.2" {
+		t.Fatalf("takeover extra mismatch: %+v", extra)
+	}
+	if c, _ := claimDisconnect("dev-1", "10.0.0.3", now.Add(2*time.Millisecond)); c != nil {
+		t.Fatalf("claimed disconnect should be removed: %+v", c)
+	}
+}
+
+func TestRecentDisconnectExpires(t *testing.T) {
+	resetConnState()
+	now := time.Now()
+	for i, id := range []string{"dev-1", "dev-2"} {
+		info := pluginutil.ClientInfo{ClientID: id}
+		parkDisconnect(&closedConn{state: &connState{info: info}, info: info, at: now.Add(time.Duration(i) * takeoverWindow)})
+	}
+
+	// 超过窗口的重连按普通断开处理。
+	c, takeover := claimDisconnect("dev-1", "10.0.0.2", now.Add(takeoverWindow+time.Millisecond))
+	if c == nil || takeover || c.state.takenOver {
+		t.Fatalf("late connect should not be a takeover: %+v %v", c, takeover)
+	}
+	if got := expiredDisconnects(now.Add(takeoverWindow+time.Millisecond), false); len(got) != 0 {
+		t.Fatalf("dev-2 is still within the window: %+v", got)
+	}
+	got := expiredDisconnects(now.Add(3*takeoverWindow), false)
+	if len(got) != 1 || got[0].info.ClientID != "dev-2" {
+		t.Fatalf("expired disconnects = %+v", got)
+	}
+	if got := expiredDisconnects(now, true); len(got) != 0 {
+		t.Fatalf("nothing should be left: %+v", got)
+	}
+}
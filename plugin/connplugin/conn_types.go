@@ -5,6 +5,9 @@ import (
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
+
+	"mosquitto-plugin/internal/pluginutil"
+	"mosquitto-plugin/internal/schema"
 )
 
 const recordEventSQL = `
@@ -16,8 +19,9 @@ WITH ins AS (
 )
 INSERT INTO client_sessions
   (client_id, username, last_event_ts, last_event_type, last_connect_ts, last_disconnect_ts,
-   last_peer, last_protocol, last_reason_code, extra)
-SELECT $3, $4, $1, $2, $9, $10, $5, $6, $7, $8
+   last_peer, last_protocol, last_reason_code, extra,
+   total_connected_ms, total_msgs_published, total_bytes_published)
+SELECT $3, $4, $1, $2, $9, $10, $5, $6, $7, $8, $11, $12, $13
 FROM ins
 ON CONFLICT (client_id) DO UPDATE SET
   username = EXCLUDED.username,
@@ -28,26 +32,154 @@ ON CONFLICT (client_id) DO UPDATE SET
   last_peer = EXCLUDED.last_peer,
   last_protocol = EXCLUDED.last_protocol,
   last_reason_code = EXCLUDED.last_reason_code,
-  extra = EXCLUDED.extra
+  extra = EXCLUDED.extra,
+  total_connected_ms = client_sessions.total_connected_ms + EXCLUDED.total_connected_ms,
+  total_msgs_published = client_sessions.total_msgs_published + EXCLUDED.total_msgs_published,
+  total_bytes_published = client_sessions.total_bytes_published + EXCLUDED.total_bytes_published
 `
 
 const (
-	connEventTypeConnect    = "connect"
-	connEventTypeDisconnect = "disconnect"
+	connEventTypeConnect    = schema.ConnEventConnect
+	connEventTypeDisconnect = schema.ConnEventDisconnect
+	connEventTypeFlapping   = schema.ConnEventFlapping
+	connEventTypeTakeover   = schema.ConnEventTakeover
+
+	disconnectReasonTakeover = "takeover"
+
+	connEventsTable = schema.ConnEventsTable
 
-	defaultTimeout   = 1000 * time.Millisecond
-	debugSampleEvery = uint64(128)
+	defaultTimeout         = 1000 * time.Millisecond
+	debugSampleEvery       = uint64(128)
+	takeoverLogSampleEvery = uint64(16)
+	snapshotLogSampleEvery = uint64(10)
 )
 
+// insertEventSQL 仅写入事件明细，用于 flapping 等不改变会话状态的事件。
+const insertEventSQL = `
+INSERT INTO client_conn_events
+  (ts, event_type, client_id, username, peer, protocol, reason_code, extra)
+VALUES ($1, $2, $3, $4, $5, $6, NULL, $7)
+`
+
+// deleteActiveClientsSQL 清除本节点上一轮快照。
+const deleteActiveClientsSQL = `DELETE FROM broker_active_clients WHERE node_id=$1`
+
+// upsertActiveSnapshotSQL 记录本节点快照时间与在线数。
+const upsertActiveSnapshotSQL = `
+INSERT INTO broker_active_snapshots (node_id, snapshot_ts, client_count)
+VALUES ($1, $2, $3)
+ON CONFLICT (node_id) DO UPDATE SET
+  snapshot_ts = EXCLUDED.snapshot_ts,
+  client_count = EXCLUDED.client_count
+`
+
+// connExtra 是写入 extra JSONB 列的连接元数据，便于按固件/接入方式排查问题。
+type connExtra struct {
+	Keepalive    int    `json:"keepalive"`
+	CleanSession bool   `json:"clean_session"`
+	Transport    string `json:"transport,omitempty"`
+	CertSubject  string `json:"cert_subject,omitempty"`
+
+	// 以下字段仅在 disconnect 事件写入；计数字段仅在 conn_track_messages 开启时存在。
+	DurationMS     *int64  `json:"duration_ms,omitempty"`
+	MsgsPublished  *uint64 `json:"msgs_published,omitempty"`
+	BytesPublished *uint64 `json:"bytes_published,omitempty"`
+
+	// DisconnectReason 为 takeover 时表示连接被同 client_id 的新连接接管。
+	DisconnectReason string `json:"disconnect_reason,omitempty"`
+	TakenOverBy      string `json:"taken_over_by,omitempty"`
+}
+
+// takeoverExtra 是 takeover 事件写入 extra 列的内容。
+type takeoverExtra struct {
+	OldPeer        string `json:"old_peer,omitempty"`
+	OldUsername    string `json:"old_username,omitempty"`
+	OldConnectedAt string `json:"old_connected_at"`
+	NewPeer        string `json:"new_peer,omitempty"`
+}
+
+func newTakeoverExtra(previous *connState, info pluginutil.ClientInfo) takeoverExtra {
+	return takeoverExtra{
+		OldPeer:        previous.info.Peer,
+		OldUsername:    previous.info.Username,
+		OldConnectedAt: previous.connectedAt.UTC().Format(time.RFC3339),
+		NewPeer:        info.Peer,
+	}
+}
+
+// setTakeover 在被接管连接的 disconnect 事件中标记原因。
+func (e *connExtra) setTakeover(state *connState) {
+	if state == nil || !state.takenOver {
+		return
+	}
+	e.DisconnectReason = disconnectReasonTakeover
+	e.TakenOverBy = state.takenOverBy
+}
+
+// setSessionStats 写入连接时长与流量计数。
+func (e *connExtra) setSessionStats(state *connState, now time.Time) {
+	if state == nil {
+		return
+	}
+	durationMS, msgs, bytes := state.sessionStats(now)
+	e.DurationMS = &durationMS
+	if trackMessages {
+		e.MsgsPublished = &msgs
+		e.BytesPublished = &bytes
+	}
+}
+
+// sessionTotals 返回累加到 client_sessions 的时长与计数（缺失时为 0）。
+func (e connExtra) sessionTotals() (durationMS int64, msgs, bytes int64) {
+	if e.DurationMS != nil {
+		durationMS = *e.DurationMS
+	}
+	if e.MsgsPublished != nil {
+		msgs = int64(*e.MsgsPublished)
+	}
+	if e.BytesPublished != nil {
+		bytes = int64(*e.BytesPublished)
+	}
+	return durationMS, msgs, bytes
+}
+
 var (
 	pool    *pgxpool.Pool
 	poolMu  sync.RWMutex
 	pgDSN   string
 	timeout = defaultTimeout
 
-	activeConnMu sync.Mutex
-	activeConn   = map[uintptr]struct{}{}
+	// schemaCheck 为 true 时初始化阶段校验 schema 版本（conn_schema_check）。
+	schemaCheck = true
+
+	// trackMessages 开启后注册 MOSQ_EVT_MESSAGE，按连接累计上行消息数与字节数。
+	trackMessages bool
+
+	// presenceTopicTemplate 非空时在 connect/disconnect 发布 retained 在线状态。
+	presenceTopicTemplate string
+	presenceQoS           = defaultPresenceQoS
+
+	// flaps 按 client_id 检测重连抖动；flapStatusTopic 非空时发布 flapping 客户端列表。
+	flapCfg         = flapConfig{window: defaultFlapWindow, summaryEvery: defaultFlapSummaryEvery}
+	flaps           = newFlapTracker(flapCfg)
+	flapStatusTopic string
+
+	// snapshots 按 conn_snapshot_interval_ms 将在线连接写入 broker_active_clients（按 nodeID 整体替换）。
+	snapshots = &pluginutil.PeriodicJob{}
+	nodeID    string
+
+	// partitions 按 partitionCfg 维护 client_conn_events 的时间分区。
+	partitionCfg pluginutil.PartitionConfig
+	partitions   = &pluginutil.PeriodicJob{}
+
+	// activeConn 以 struct mosquitto 指针为 key；activeByClientID 用于识别 client_id 接管。
+	activeConnMu     sync.Mutex
+	activeConn       = map[uintptr]*connState{}
+	activeByClientID = map[string]uintptr{}
 
-	debugSkipCounter   uint64
-	debugRecordCounter uint64
+	debugSkipCounter       uint64
+	debugRecordCounter     uint64
+	debugQuarantineCounter uint64
+	debugTakeoverCounter   uint64
+	debugSnapshotCounter   uint64
 )
@@ -10,6 +10,7 @@ package main
 typedef int (*mosq_event_cb)(int event, void *event_data, void *userdata);
 
 int message_cb_c(int event, void *event_data, void *userdata);
+int tick_cb_c(int event, void *event_data, void *userdata);
 
 int register_event_callback(mosquitto_plugin_id_t *id, int event, mosq_event_cb cb);
 int unregister_event_callback(mosquitto_plugin_id_t *id, int event, mosq_event_cb cb);
@@ -18,15 +19,18 @@ void go_mosq_log(int level, const char* msg);
 import "C"
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"runtime/debug"
 	"strings"
 	"time"
 	"unsafe"
 
+	"github.com/nats-io/nuid"
+
+	"mosquitto-plugin/internal/pluginconf"
 	"mosquitto-plugin/internal/pluginutil"
 )
 
@@ -56,17 +60,6 @@ func isBackpressureError(err error) bool {
 	return errors.Is(err, errQueueFull) || errors.Is(err, errEnqueueTimeout) || errors.Is(err, errDispatcherStopped)
 }
 
-func normalizePayloadJSON(payload []byte) (json.RawMessage, error) {
-	trimmed := bytes.TrimSpace(payload)
-	if len(trimmed) == 0 {
-		return nil, errors.New("payload is empty or whitespace, not valid JSON")
-	}
-	if json.Valid(trimmed) {
-		return trimmed, nil
-	}
-	return nil, errors.New("payload is not valid JSON")
-}
-
 // extractUserProperties 从事件中读取 MQTT v5 用户属性。
 func extractUserProperties(props *C.mosquitto_property) []userProperty {
 	if props == nil {
@@ -132,6 +125,33 @@ func go_mosq_plugin_version(count C.int, versions *C.int) C.int {
 	return -1
 }
 
+// prepareRabbitMQConfig 校验 queue_backend=rabbitmq 的 exchange、拓扑与 routing key 配置。
+func prepareRabbitMQConfig() C.int {
+	if err := pluginconf.CheckExchangeType(cfg.exchangeType); err != nil {
+		log(mosqLogError, "queue-plugin: unsupported exchange_type", map[string]any{"exchange_type": cfg.exchangeType, "error": err})
+		return C.MOSQ_ERR_INVAL
+	}
+	if cfg.declare && cfg.exchangeType == "headers" {
+		for _, b := range cfg.declareQueues {
+			if _, err := b.HeaderArgs(); err != nil {
+				log(mosqLogError, "queue-plugin: invalid queue_declare_queues", map[string]any{"error": err})
+				return C.MOSQ_ERR_INVAL
+			}
+		}
+	}
+	tmpl, err := pluginutil.ParseKeyTemplate(cfg.routingKey)
+	if err != nil {
+		log(mosqLogError, "queue-plugin: invalid queue_routing_key", map[string]any{"value": cfg.routingKey, "error": err})
+		return C.MOSQ_ERR_INVAL
+	}
+	cfg.routingKeyTmpl = tmpl
+	if cfg.dsn == "" || cfg.exchange == "" {
+		log(mosqLogError, "queue-plugin: queue_dsn and queue_exchange must be set")
+		return C.MOSQ_ERR_INVAL
+	}
+	return C.MOSQ_ERR_SUCCESS
+}
+
 // go_mosq_plugin_init 解析配置、校验参数并注册回调。
 //
 //export go_mosq_plugin_init
@@ -150,18 +170,39 @@ func go_mosq_plugin_init(id *C.mosquitto_plugin_id_t, userdata *unsafe.Pointer,
 	debugPublishCounter = 0
 	workerWarnCounter = 0
 	backpressureCounter = 0
-	publisher.mu.Lock()
-	publisher.closeLocked()
-	publisher.nextDial = time.Time{}
-	publisher.mu.Unlock()
+	retryWarnCounter = 0
+	returnWarnCounter = 0
+	stats.reset()
 	stopDispatcher()
+	if publisher != nil {
+		publisher.Close()
+		publisher = nil
+	}
+	closeSpool()
 
 	cfg = config{
-		backend:        "rabbitmq",
-		exchangeType:   "direct",
-		enqueueTimeout: 1000 * time.Millisecond,
-		publishTimeout: 1000 * time.Millisecond,
-		failMode:       failModeDrop,
+		backend:           "rabbitmq",
+		exchangeType:      "direct",
+		queueType:         "classic",
+		enqueueTimeout:    1000 * time.Millisecond,
+		publishTimeout:    1000 * time.Millisecond,
+		failMode:          failModeDrop,
+		payloadEncoding:   pluginconf.PayloadJSON,
+		confirmTimeout:    5000 * time.Millisecond,
+		retryMax:          3,
+		retryBackoff:      200 * time.Millisecond,
+		retryBackoffMax:   5000 * time.Millisecond,
+		workers:           1,
+		ordering:          orderingClient,
+		buffer:            defaultDispatchBuffer,
+		spoolMaxBytes:     1 << 30,
+		spoolSegmentBytes: 64 << 20,
+		kafka: kafkaConfig{
+			key:         "client_id",
+			acks:        pluginconf.KafkaAcksAll,
+			idempotent:  true,
+			compression: "none",
+		},
 	}
 
 	if env := os.Getenv("QUEUE_DSN"); env != "" {
@@ -184,6 +225,27 @@ func go_mosq_plugin_init(id *C.mosquitto_plugin_id_t, userdata *unsafe.Pointer,
 			}
 		case "queue_routing_key":
 			cfg.routingKey = v
+		case "queue_declare":
+			if b, ok := pluginutil.ParseBoolOption(v); ok {
+				cfg.declare = b
+			} else {
+				log(mosqLogWarning, "queue-plugin: invalid queue_declare", map[string]any{"value": v, "declare": cfg.declare})
+			}
+		case "queue_declare_queues":
+			bindings, err := pluginconf.ParseQueueBindings(v)
+			if err != nil {
+				log(mosqLogError, "queue-plugin: invalid queue_declare_queues", map[string]any{"value": v, "error": err})
+				return C.MOSQ_ERR_INVAL
+			}
+			cfg.declareQueues = bindings
+		case "queue_declare_queue_type":
+			if t, ok := pluginconf.ParseQueueType(v); ok {
+				cfg.queueType = t
+			} else {
+				log(mosqLogWarning, "queue-plugin: invalid queue_declare_queue_type", map[string]any{"value": v, "queue_type": cfg.queueType})
+			}
+		case "queue_declare_dlx":
+			cfg.dlx = v
 		case "queue_timeout_ms":
 			if dur, ok := pluginutil.ParseTimeoutMS(v); ok {
 				// 兼容旧配置：同时设置入队与发送超时。
@@ -210,45 +272,325 @@ func go_mosq_plugin_init(id *C.mosquitto_plugin_id_t, userdata *unsafe.Pointer,
 			} else {
 				log(mosqLogWarning, "queue-plugin: invalid queue_fail_mode", map[string]any{"value": v, "fail_mode": failModeString(cfg.failMode)})
 			}
+		case "queue_confirm":
+			if b, ok := pluginutil.ParseBoolOption(v); ok {
+				cfg.confirm = b
+			} else {
+				log(mosqLogWarning, "queue-plugin: invalid queue_confirm", map[string]any{"value": v, "confirm": cfg.confirm})
+			}
+		case "queue_confirm_timeout_ms":
+			if dur, ok := pluginutil.ParseTimeoutMS(v); ok {
+				cfg.confirmTimeout = dur
+			} else {
+				log(mosqLogWarning, "queue-plugin: invalid queue_confirm_timeout_ms", map[string]any{"value": v, "confirm_timeout_ms": int(cfg.confirmTimeout / time.Millisecond)})
+			}
+		case "queue_retry_max":
+			if n, ok := pluginutil.ParsePositiveInt(v); ok {
+				cfg.retryMax = n
+			} else {
+				log(mosqLogWarning, "queue-plugin: invalid queue_retry_max", map[string]any{"value": v, "retry_max": cfg.retryMax})
+			}
+		case "queue_retry_backoff_ms":
+			if dur, ok := pluginutil.ParseTimeoutMS(v); ok {
+				cfg.retryBackoff = dur
+			} else {
+				log(mosqLogWarning, "queue-plugin: invalid queue_retry_backoff_ms", map[string]any{"value": v, "retry_backoff_ms": int(cfg.retryBackoff / time.Millisecond)})
+			}
+		case "queue_retry_backoff_max_ms":
+			if dur, ok := pluginutil.ParseTimeoutMS(v); ok {
+				cfg.retryBackoffMax = dur
+			} else {
+				log(mosqLogWarning, "queue-plugin: invalid queue_retry_backoff_max_ms", map[string]any{"value": v, "retry_backoff_max_ms": int(cfg.retryBackoffMax / time.Millisecond)})
+			}
+		case "queue_stats_interval_ms":
+			if dur, ok := pluginutil.ParseTimeoutMS(v); ok {
+				cfg.statsInterval = dur
+			} else {
+				log(mosqLogWarning, "queue-plugin: invalid queue_stats_interval_ms", map[string]any{"value": v})
+			}
+		case "queue_buffer":
+			if n, ok := pluginutil.ParsePositiveInt(v); ok {
+				cfg.buffer = n
+			} else {
+				log(mosqLogWarning, "queue-plugin: invalid queue_buffer", map[string]any{"value": v, "buffer": cfg.buffer})
+			}
+		case "queue_workers":
+			if n, ok := pluginutil.ParsePositiveInt(v); ok {
+				cfg.workers = n
+			} else {
+				log(mosqLogWarning, "queue-plugin: invalid queue_workers", map[string]any{"value": v, "workers": cfg.workers})
+			}
+		case "queue_channels":
+			if n, ok := pluginutil.ParsePositiveInt(v); ok {
+				cfg.channels = n
+			} else {
+				log(mosqLogWarning, "queue-plugin: invalid queue_channels", map[string]any{"value": v})
+			}
+		case "queue_ordering":
+			if o, ok := pluginconf.ParseOrdering(v); ok {
+				cfg.ordering = o
+			} else {
+				log(mosqLogWarning, "queue-plugin: invalid queue_ordering", map[string]any{"value": v, "ordering": cfg.ordering})
+			}
+		case "queue_shutdown_drain_ms":
+			if dur, ok := pluginutil.ParseTimeoutMS(v); ok {
+				cfg.shutdownDrain = dur
+			} else {
+				log(mosqLogWarning, "queue-plugin: invalid queue_shutdown_drain_ms", map[string]any{"value": v, "shutdown_drain_ms": int(cfg.shutdownDrain / time.Millisecond)})
+			}
+		case "queue_spool_dir":
+			cfg.spoolDir = strings.TrimSpace(v)
+		case "queue_spool_max_bytes":
+			if n, ok := pluginutil.ParsePositiveInt(v); ok {
+				cfg.spoolMaxBytes = int64(n)
+			} else {
+				log(mosqLogWarning, "queue-plugin: invalid queue_spool_max_bytes", map[string]any{"value": v, "spool_max_bytes": cfg.spoolMaxBytes})
+			}
+		case "queue_spool_segment_bytes":
+			if n, ok := pluginutil.ParsePositiveInt(v); ok {
+				cfg.spoolSegmentBytes = int64(n)
+			} else {
+				log(mosqLogWarning, "queue-plugin: invalid queue_spool_segment_bytes", map[string]any{"value": v, "spool_segment_bytes": cfg.spoolSegmentBytes})
+			}
+		case "queue_kafka_brokers":
+			brokers, err := pluginconf.ParseKafkaBrokers(v)
+			if err != nil {
+				log(mosqLogError, "queue-plugin: invalid queue_kafka_brokers", map[string]any{"value": v, "error": err})
+				return C.MOSQ_ERR_INVAL
+			}
+			cfg.kafka.brokers = brokers
+		case "queue_kafka_topic":
+			cfg.kafka.topic = v
+		case "queue_kafka_key":
+			cfg.kafka.key = v
+		case "queue_kafka_acks":
+			if acks, ok := pluginconf.ParseKafkaAcks(v); ok {
+				cfg.kafka.acks = acks
+			} else {
+				log(mosqLogWarning, "queue-plugin: invalid queue_kafka_acks", map[string]any{"value": v, "acks": cfg.kafka.acks})
+			}
+		case "queue_kafka_idempotent":
+			if b, ok := pluginutil.ParseBoolOption(v); ok {
+				cfg.kafka.idempotent = b
+			} else {
+				log(mosqLogWarning, "queue-plugin: invalid queue_kafka_idempotent", map[string]any{"value": v, "idempotent": cfg.kafka.idempotent})
+			}
+		case "queue_kafka_compression":
+			if c, ok := pluginconf.ParseKafkaCompression(v); ok {
+				cfg.kafka.compression = c
+			} else {
+				log(mosqLogWarning, "queue-plugin: invalid queue_kafka_compression", map[string]any{"value": v, "compression": cfg.kafka.compression})
+			}
+		case "queue_kafka_tls":
+			if b, ok := pluginutil.ParseBoolOption(v); ok {
+				cfg.kafka.tls = b
+			} else {
+				log(mosqLogWarning, "queue-plugin: invalid queue_kafka_tls", map[string]any{"value": v, "tls": cfg.kafka.tls})
+			}
+		case "queue_kafka_tls_ca_file":
+			cfg.kafka.tlsCAFile = v
+		case "queue_kafka_tls_cert_file":
+			cfg.kafka.tlsCertFile = v
+		case "queue_kafka_tls_key_file":
+			cfg.kafka.tlsKeyFile = v
+		case "queue_kafka_sasl_mechanism":
+			m, ok := pluginconf.ParseKafkaSASLMechanism(v)
+			if !ok {
+				log(mosqLogError, "queue-plugin: invalid queue_kafka_sasl_mechanism", map[string]any{"value": v, "expected": pluginconf.KafkaSASLMechanisms})
+				return C.MOSQ_ERR_INVAL
+			}
+			cfg.kafka.saslMechanism = m
+		case "queue_kafka_sasl_username":
+			cfg.kafka.saslUsername = v
+		case "queue_kafka_sasl_password":
+			cfg.kafka.saslPassword = v
+		case "queue_nats_url":
+			urls, err := pluginconf.ParseNATSURLs(v)
+			if err != nil {
+				log(mosqLogError, "queue-plugin: invalid queue_nats_url", map[string]any{"error": err})
+				return C.MOSQ_ERR_INVAL
+			}
+			cfg.nats.urls = urls
+		case "queue_nats_subject":
+			cfg.nats.subject = v
+		case "queue_nats_creds_file":
+			cfg.nats.credsFile = v
+		case "queue_nats_tls":
+			if b, ok := pluginutil.ParseBoolOption(v); ok {
+				cfg.nats.tls = b
+			} else {
+				log(mosqLogWarning, "queue-plugin: invalid queue_nats_tls", map[string]any{"value": v, "tls": cfg.nats.tls})
+			}
+		case "queue_nats_tls_ca_file":
+			cfg.nats.tlsCAFile = v
+		case "queue_nats_tls_cert_file":
+			cfg.nats.tlsCertFile = v
+		case "queue_nats_tls_key_file":
+			cfg.nats.tlsKeyFile = v
+		case "queue_include_topics", "queue_exclude_topics":
+			filters, err := pluginconf.ParseTopicFilters(v)
+			if err != nil {
+				log(mosqLogError, "queue-plugin: invalid "+k, map[string]any{"value": v, "error": err})
+				return C.MOSQ_ERR_INVAL
+			}
+			if k == "queue_include_topics" {
+				cfg.filter.includeTopics = filters
+			} else {
+				cfg.filter.excludeTopics = filters
+			}
+		case "queue_include_users":
+			cfg.filter.includeUsers = pluginconf.SplitList(v)
+		case "queue_exclude_users":
+			cfg.filter.excludeUsers = pluginconf.SplitList(v)
+		case "queue_include_clients":
+			cfg.filter.includeClients = pluginconf.SplitList(v)
+		case "queue_exclude_clients":
+			cfg.filter.excludeClients = pluginconf.SplitList(v)
+		case "queue_include_retained":
+			if b, ok := pluginutil.ParseBoolOption(v); ok {
+				cfg.filter.dropRetained = !b
+			} else {
+				log(mosqLogWarning, "queue-plugin: invalid queue_include_retained", map[string]any{"value": v, "include_retained": !cfg.filter.dropRetained})
+			}
+		case "queue_payload_encoding":
+			if enc, ok := pluginconf.ParsePayloadEncoding(v); ok {
+				cfg.payloadEncoding = enc
+			} else {
+				log(mosqLogWarning, "queue-plugin: invalid queue_payload_encoding", map[string]any{"value": v, "payload_encoding": cfg.payloadEncoding})
+			}
+		default:
+			log(mosqLogWarning, "queue-plugin: "+pluginconf.UnknownKeyMessage(pluginconf.Queue, k), map[string]any{"key": k})
 		}
 	}
 
-	if cfg.backend != "rabbitmq" {
-		log(mosqLogError, "queue-plugin: unsupported backend", map[string]any{"backend": cfg.backend, "expected": "rabbitmq"})
+	if err := pluginconf.CheckQueueBackend(cfg.backend); err != nil {
+		log(mosqLogError, "queue-plugin: unsupported backend", map[string]any{"backend": cfg.backend, "expected": pluginconf.QueueBackends})
 		return C.MOSQ_ERR_INVAL
 	}
-	if cfg.exchangeType != "direct" {
-		log(mosqLogError, "queue-plugin: exchange_type must be direct")
-		return C.MOSQ_ERR_INVAL
+	if cfg.channels == 0 {
+		cfg.channels = cfg.workers
 	}
-	if cfg.dsn == "" || cfg.exchange == "" {
-		log(mosqLogError, "queue-plugin: queue_dsn and queue_exchange must be set")
-		return C.MOSQ_ERR_INVAL
+	switch cfg.backend {
+	case pluginconf.QueueBackendKafka:
+		if err := cfg.kafka.prepare(); err != nil {
+			log(mosqLogError, "queue-plugin: invalid kafka config", map[string]any{"error": err})
+			return C.MOSQ_ERR_INVAL
+		}
+		if cfg.kafka.idempotent && cfg.kafka.acks != pluginconf.KafkaAcksAll {
+			log(mosqLogWarning, "queue-plugin: queue_kafka_idempotent requires queue_kafka_acks=all, disabled", map[string]any{"acks": cfg.kafka.acks})
+			cfg.kafka.idempotent = false
+		}
+	case pluginconf.QueueBackendNATS:
+		if err := cfg.nats.prepare(); err != nil {
+			log(mosqLogError, "queue-plugin: invalid nats config", map[string]any{"error": err})
+			return C.MOSQ_ERR_INVAL
+		}
+	default:
+		if rc := prepareRabbitMQConfig(); rc != C.MOSQ_ERR_SUCCESS {
+			return rc
+		}
 	}
 
-	log(mosqLogInfo, "queue-plugin: init", map[string]any{
+	fields := map[string]any{
 		"backend":            cfg.backend,
 		"dsn":                pluginutil.SafeDSN(cfg.dsn),
 		"exchange":           cfg.exchange,
 		"exchange_type":      cfg.exchangeType,
 		"routing_key":        cfg.routingKey,
+		"declare":            cfg.declare,
+		"declare_queues":     len(cfg.declareQueues),
 		"enqueue_timeout_ms": int(cfg.enqueueTimeout / time.Millisecond),
 		"publish_timeout_ms": int(cfg.publishTimeout / time.Millisecond),
 		"fail_mode":          failModeString(cfg.failMode),
-	})
+		"payload_encoding":   cfg.payloadEncoding,
+		"confirm":            cfg.confirm,
+		"buffer":             cfg.buffer,
+		"workers":            cfg.workers,
+		"channels":           cfg.channels,
+		"ordering":           cfg.ordering,
+		"stats_interval_ms":  int(cfg.statsInterval / time.Millisecond),
+		"shutdown_drain_ms":  int(cfg.shutdownDrain / time.Millisecond),
+		"spool_dir":          cfg.spoolDir,
+		"spool_max_bytes":    cfg.spoolMaxBytes,
+		"include_topics":     cfg.filter.includeTopics,
+		"exclude_topics":     cfg.filter.excludeTopics,
+		"include_users":      cfg.filter.includeUsers,
+		"exclude_users":      cfg.filter.excludeUsers,
+		"include_clients":    cfg.filter.includeClients,
+		"exclude_clients":    cfg.filter.excludeClients,
+		"include_retained":   !cfg.filter.dropRetained,
+	}
+	if cfg.backend != pluginconf.QueueBackendRabbitMQ {
+		// RabbitMQ 的 dsn/exchange/routing key 不生效，改为输出对应后端的配置。
+		for _, k := range []string{"dsn", "exchange", "exchange_type", "routing_key", "declare", "declare_queues", "channels"} {
+			delete(fields, k)
+		}
+	}
+	switch cfg.backend {
+	case pluginconf.QueueBackendKafka:
+		fields["kafka_brokers"] = cfg.kafka.brokers
+		fields["kafka_topic"] = cfg.kafka.topic
+		fields["kafka_key"] = cfg.kafka.key
+		fields["kafka_acks"] = cfg.kafka.acks
+		fields["kafka_idempotent"] = cfg.kafka.idempotent
+		fields["kafka_compression"] = cfg.kafka.compression
+		fields["kafka_tls"] = cfg.kafka.tls
+		fields["kafka_sasl_mechanism"] = cfg.kafka.saslMechanism
+	case pluginconf.QueueBackendNATS:
+		fields["nats_url"] = cfg.nats.safeURLs()
+		fields["nats_subject"] = cfg.nats.subject
+		fields["nats_creds_file"] = cfg.nats.credsFile
+		fields["nats_tls"] = cfg.nats.tls
+	}
+	log(mosqLogInfo, "queue-plugin: init", fields)
 
-	publisher.mu.Lock()
-	if err := publisher.ensureLocked(); err != nil {
-		log(mosqLogWarning, "queue-plugin: initial connect failed", map[string]any{"error": err})
-		publisher.closeLocked()
+	switch cfg.backend {
+	case pluginconf.QueueBackendKafka:
+		p, err := newKafkaPublisher(cfg.kafka)
+		if err != nil {
+			log(mosqLogError, "queue-plugin: kafka client init failed", map[string]any{"error": err})
+			return C.MOSQ_ERR_INVAL
+		}
+		publisher = p
+	case pluginconf.QueueBackendNATS:
+		p, err := newNATSPublisher(cfg.nats)
+		if err != nil {
+			log(mosqLogError, "queue-plugin: nats client init failed", map[string]any{"error": err})
+			return C.MOSQ_ERR_INVAL
+		}
+		publisher = p
+	default:
+		publisher = newAMQPPublisher(cfg.channels)
+	}
+
+	if cfg.spoolDir != "" {
+		sp, err := openSpool(cfg.spoolDir, cfg.spoolMaxBytes, cfg.spoolSegmentBytes)
+		if err != nil {
+			log(mosqLogError, "queue-plugin: open spool failed", map[string]any{"dir": cfg.spoolDir, "error": err})
+			return C.MOSQ_ERR_UNKNOWN
+		}
+		spool = sp
+		if n := sp.Len(); n > 0 {
+			spoolActive.Store(true)
+			log(mosqLogInfo, "queue-plugin: spool has pending messages", map[string]any{"spool_bytes": n})
+		}
 	}
-	publisher.mu.Unlock()
 
-	startDispatcher(defaultDispatchBuffer)
+	startDispatcher(cfg.buffer)
 	if rc := C.register_event_callback(pid, C.MOSQ_EVT_MESSAGE, C.mosq_event_cb(C.message_cb_c)); rc != C.MOSQ_ERR_SUCCESS {
 		stopDispatcher()
+		closeSpool()
 		return rc
 	}
+	statsJob = pluginutil.PeriodicJob{Interval: cfg.statsInterval}
+	if statsJob.Enabled() {
+		if rc := C.register_event_callback(pid, C.MOSQ_EVT_TICK, C.mosq_event_cb(C.tick_cb_c)); rc != C.MOSQ_ERR_SUCCESS {
+			C.unregister_event_callback(pid, C.MOSQ_EVT_MESSAGE, C.mosq_event_cb(C.message_cb_c))
+			stopDispatcher()
+			closeSpool()
+			return rc
+		}
+	}
 
 	log(mosqLogInfo, "queue-plugin: plugin initialized")
 	return C.MOSQ_ERR_SUCCESS
@@ -259,12 +601,21 @@ func go_mosq_plugin_init(id *C.mosquitto_plugin_id_t, userdata *unsafe.Pointer,
 //export go_mosq_plugin_cleanup
 func go_mosq_plugin_cleanup(userdata unsafe.Pointer, opts *C.struct_mosquitto_opt, optCount C.int) C.int {
 	C.unregister_event_callback(pid, C.MOSQ_EVT_MESSAGE, C.mosq_event_cb(C.message_cb_c))
+	if statsJob.Enabled() {
+		C.unregister_event_callback(pid, C.MOSQ_EVT_TICK, C.mosq_event_cb(C.tick_cb_c))
+		statsJob.Wait()
+	}
+	if cfg.shutdownDrain > 0 {
+		drainDispatcher(cfg.shutdownDrain)
+	}
 	stopDispatcher()
-	publisher.mu.Lock()
-	publisher.closeLocked()
-	publisher.nextDial = time.Time{}
-	publisher.mu.Unlock()
-	log(mosqLogInfo, "queue-plugin: plugin cleaned up")
+	if publisher != nil {
+		// kafka 客户端关闭前在 queue_publish_timeout_ms 内发出缓冲区中的记录，nats 在该时间内等待确认，失败的计入 failed。
+		publisher.Close()
+		publisher = nil
+	}
+	log(mosqLogInfo, "queue-plugin: plugin cleaned up", stats.fields())
+	closeSpool()
 	return C.MOSQ_ERR_SUCCESS
 }
 
@@ -289,10 +640,10 @@ func message_cb_c(event C.int, event_data unsafe.Pointer, userdata unsafe.Pointe
 		protocol = pluginutil.ProtocolString(int(C.mosquitto_client_protocol_version(ed.client)))
 	}
 
-	allow, reason := allowMessage(topic)
+	allow, reason := allowMessage(topic, username, clientID, bool(ed.retain))
 	if !allow {
 		if pluginutil.ShouldSample(&debugFilterCounter, debugSampleEvery) {
-			log(mosqLogDebug, "queue-plugin: filtered", map[string]any{"topic": topic, "reason": reason})
+			log(mosqLogDebug, "queue-plugin: filtered", map[string]any{"topic": topic, "client_id": clientID, "username": username, "reason": reason})
 		}
 		return C.MOSQ_ERR_SUCCESS
 	}
@@ -302,24 +653,17 @@ func message_cb_c(event C.int, event_data unsafe.Pointer, userdata unsafe.Pointe
 	if ed.payloadlen > C.uint32_t(maxPayloadLen) {
 		return failResult(errors.New("payload too large"))
 	}
-	var payload json.RawMessage
+	var raw []byte
 	if payloadLen > 0 {
 		if ed.payload == nil {
 			return failResult(errors.New("payload is nil"))
 		}
-		var err error
-		payload, err = normalizePayloadJSON(C.GoBytes(ed.payload, C.int(payloadLen)))
-		if err != nil {
-			return failResult(err)
-		}
-	} else {
-		return failResult(errors.New("payload is empty, valid JSON required"))
+		raw = C.GoBytes(ed.payload, C.int(payloadLen))
 	}
 
 	msg := queueMessage{
 		TS:       time.Now().UTC().Format(time.RFC3339),
 		Topic:    topic,
-		Payload:  payload,
 		QoS:      uint8(ed.qos),
 		Retain:   bool(ed.retain),
 		ClientID: clientID,
@@ -331,11 +675,54 @@ func message_cb_c(event C.int, event_data unsafe.Pointer, userdata unsafe.Pointe
 	if pluginutil.ShouldSample(&debugPublishCounter, debugSampleEvery) {
 		log(mosqLogDebug, "queue-plugin: publish", map[string]any{"topic": topic, "qos": ed.qos, "retain": bool(ed.retain), "len": payloadLen, "client_id": clientID, "username": username, "user_props": len(msg.UserProperties)})
 	}
-	body, err := json.Marshal(msg)
+	out := queuedMessage{clientID: clientID}
+	switch cfg.backend {
+	case pluginconf.QueueBackendKafka:
+		out.routingKey = renderTemplate(cfg.kafka.topicTmpl, &msg)
+		if err := checkKafkaTopic(out.routingKey); err != nil {
+			return failResult(err)
+		}
+		out.key = renderTemplate(cfg.kafka.keyTmpl, &msg)
+	case pluginconf.QueueBackendNATS:
+		out.routingKey = renderTemplate(cfg.nats.subjectTmpl, &msg)
+		if err := checkNATSSubject(out.routingKey); err != nil {
+			return failResult(err)
+		}
+		// 入队时生成 Nats-Msg-Id，重试与溢出队列重放沿用同一 ID，由 JetStream 在去重窗口内去重。
+		out.key = nuid.Next()
+	default:
+		out.routingKey = renderRoutingKey(&msg)
+		if len(out.routingKey) > maxRoutingKeyLen {
+			return failResult(fmt.Errorf("routing key longer than %d bytes", maxRoutingKeyLen))
+		}
+	}
+	if cfg.payloadEncoding == pluginconf.PayloadRaw {
+		// raw：原始字节作为 body，元数据只放在头部（AMQP 头部、Kafka 记录头部或 NATS 消息头部）。
+		out.contentType, out.headers, out.body = contentTypeRaw, messageHeaders(&msg), raw
+		return failResult(enqueueMessage(out))
+	}
+	payload, encoding, err := encodePayload(cfg.payloadEncoding, raw)
 	if err != nil {
 		return failResult(err)
 	}
-	return failResult(enqueueMessage(body))
+	msg.Payload, msg.PayloadEncoding = payload, encoding
+	if out.body, err = json.Marshal(msg); err != nil {
+		return failResult(err)
+	}
+	if cfg.backend == pluginconf.QueueBackendRabbitMQ && cfg.exchangeType == "headers" {
+		out.headers = messageHeaders(&msg)
+	}
+	return failResult(enqueueMessage(out))
+}
+
+// tick_cb_c 按 queue_stats_interval_ms 输出发送计数。
+//
+//export tick_cb_c
+func tick_cb_c(event C.int, event_data unsafe.Pointer, userdata unsafe.Pointer) C.int {
+	statsJob.Start(time.Now(), func() {
+		log(mosqLogInfo, "queue-plugin: stats", stats.fields())
+	})
+	return C.MOSQ_ERR_SUCCESS
 }
 
 func main() {}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"errors"
+	"hash/fnv"
 	"sync"
 	"time"
 
@@ -10,12 +11,39 @@ import (
 )
 
 var (
-	dispatchMu   sync.RWMutex
-	dispatchCh   chan []byte
+	dispatchMu sync.RWMutex
+	// dispatchChs 为内存队列：queue_ordering=none 时所有 worker 共用一个，
+	// client 时每个 worker 一个，按 client_id 哈希选择。
+	dispatchChs  []chan queuedMessage
 	dispatchStop chan struct{}
 	dispatchDone chan struct{}
+	// dispatchDrain 由 drainDispatcher 关闭：worker 发完内存队列后退出。
+	dispatchDrain chan struct{}
+	// confirmCh 仅在 queue_confirm=true 时创建：按发布顺序排队等待确认的消息。
+	confirmCh chan pendingConfirm
+	// overflow 为启动时的磁盘溢出队列（spool），未配置时为 nil。
+	overflow *diskSpool
+
+	// retryMu 保护等待重试的消息：retryTimers 为尚未触发的退避定时器，
+	// abandoned 为 dispatcher 停止后无法放回内存队列的消息，二者都由 stopDispatcher 取出处理。
+	retryMu     sync.Mutex
+	retryTimers = map[*time.Timer]queuedMessage{}
+	abandoned   []queuedMessage
 )
 
+// confirmation 是发布确认的最小接口（*amqp.DeferredConfirmation），便于测试替换。
+type confirmation interface {
+	Done() <-chan struct{}
+	Acked() bool
+}
+
+// pendingConfirm 是已写入通道、等待 broker 确认的消息；deadline 为发布时刻加 queue_confirm_timeout_ms。
+type pendingConfirm struct {
+	msg      queuedMessage
+	confirm  confirmation
+	deadline time.Time
+}
+
 var dispatchPublishFn = publishQueued
 var dispatcherStopWait = 3 * time.Second
 var dispatcherStopTimeoutLogFn = func(wait time.Duration, pending int) {
@@ -31,33 +59,118 @@ var (
 	errEnqueueTimeout    = errors.New("queue-plugin: enqueue timeout")
 )
 
+// startDispatcher 按 cfg.workers/cfg.ordering 启动 worker；buffer 为内存队列总容量。
 func startDispatcher(buffer int) {
 	stopDispatcher()
 
-	ch := make(chan []byte, buffer)
+	workers := max(cfg.workers, 1)
+	chs := []chan queuedMessage{make(chan queuedMessage, buffer)}
+	if cfg.ordering == orderingClient && workers > 1 {
+		chs = make([]chan queuedMessage, workers)
+		for i := range chs {
+			chs[i] = make(chan queuedMessage, max(buffer/workers, 1))
+		}
+	}
 	stop := make(chan struct{})
 	done := make(chan struct{})
+	drain := make(chan struct{})
+	var confirms chan pendingConfirm
+	if cfg.confirm {
+		confirms = make(chan pendingConfirm, confirmWindow)
+	}
 
 	dispatchMu.Lock()
-	dispatchCh = ch
+	dispatchChs = chs
 	dispatchStop = stop
 	dispatchDone = done
+	dispatchDrain = drain
+	confirmCh = confirms
+	overflow = spool
+	sp := spool
 	dispatchMu.Unlock()
 
-	go dispatchWorker(ch, stop, done)
+	var wg, workerWG sync.WaitGroup
+	workersDone := make(chan struct{})
+	for i := 0; i < workers; i++ {
+		workerWG.Add(1)
+		go func(id int) {
+			defer workerWG.Done()
+			dispatchWorker(id, chs[id%len(chs)], stop, drain)
+		}(i)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		workerWG.Wait()
+		close(workersDone)
+	}()
+	if confirms != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			confirmWorker(confirms, stop, workersDone)
+		}()
+	}
+	if sp != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			replayWorker(sp, stop, drain)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
 }
 
+// drainDispatcher 让 worker 继续发送内存队列中的消息，直到队列为空（含等待中的确认）或超过 wait；
+// 之后仍需调用 stopDispatcher。drain 期间溢出队列不再重放。
+func drainDispatcher(wait time.Duration) {
+	dispatchMu.Lock()
+	drain := dispatchDrain
+	done := dispatchDone
+	pending := bufferedLocked()
+	dispatchDrain = nil
+	dispatchMu.Unlock()
+
+	if drain == nil {
+		return
+	}
+
+	start := time.Now()
+	close(drain)
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-done:
+		log(mosqLogInfo, "queue-plugin: dispatcher drained", map[string]any{
+			"pending":  pending,
+			"drain_ms": int(time.Since(start) / time.Millisecond),
+		})
+	case <-timer.C:
+		log(mosqLogWarning, "queue-plugin: dispatcher drain timeout", map[string]any{
+			"wait_ms":   int(wait / time.Millisecond),
+			"remaining": dispatchBuffered(),
+		})
+	}
+}
+
+// stopDispatcher 停止所有 worker，并把未发出的消息（内存队列、等待确认、等待重试）写入溢出队列（如已配置），否则计入 lost。
 func stopDispatcher() {
 	dispatchMu.Lock()
 	stop := dispatchStop
 	done := dispatchDone
-	pending := 0
-	if dispatchCh != nil {
-		pending = len(dispatchCh)
-	}
+	chs := dispatchChs
+	confirms := confirmCh
+	sp := overflow
+	pending := bufferedLocked()
 	dispatchStop = nil
-	dispatchCh = nil
+	dispatchChs = nil
 	dispatchDone = nil
+	dispatchDrain = nil
+	confirmCh = nil
+	overflow = nil
 	dispatchMu.Unlock()
 
 	if stop == nil {
@@ -72,11 +185,52 @@ func stopDispatcher() {
 	case <-timer.C:
 		dispatcherStopTimeoutLogFn(dispatcherStopWait, pending)
 	}
+	flushLeftovers(chs, confirms, sp)
 }
 
-func dispatchWorker(ch <-chan []byte, stop <-chan struct{}, done chan<- struct{}) {
-	defer close(done)
+// flushLeftovers 取出停止后仍未发出的消息：内存队列中的、已发布但未确认的（已 ack 的计为 confirmed）
+// 以及等待重试的。能写入溢出队列的落盘，其余计入 stats.lost。
+func flushLeftovers(chs []chan queuedMessage, confirms chan pendingConfirm, sp *diskSpool) {
+	var msgs []queuedMessage
+	for _, ch := range chs {
+		for empty := false; !empty; {
+			select {
+			case msg := <-ch:
+				msgs = append(msgs, msg)
+			default:
+				empty = true
+			}
+		}
+	}
+	for empty := confirms == nil; !empty; {
+		select {
+		case p := <-confirms:
+			if !confirmedAtStop(p) {
+				msgs = append(msgs, p.msg)
+			}
+		default:
+			empty = true
+		}
+	}
+	msgs = append(msgs, takeRetries()...)
+
+	spooled, lost := 0, 0
+	for _, msg := range msgs {
+		if sp != nil && spillMessage(sp, msg) == nil {
+			spooled++
+			continue
+		}
+		lost++
+		stats.lost.Add(1)
+	}
+	if spooled > 0 || lost > 0 {
+		log(mosqLogWarning, "queue-plugin: unsent messages at shutdown", map[string]any{"spooled": spooled, "lost": lost})
+	}
+}
 
+// dispatchWorker 是编号为 id 的发送 worker，id 决定使用通道池中的哪个 AMQP 通道。
+// drain 关闭后继续发送，直到队列为空再退出。
+func dispatchWorker(id int, ch <-chan queuedMessage, stop, drain <-chan struct{}) {
 	for {
 		select {
 		case <-stop:
@@ -85,28 +239,257 @@ func dispatchWorker(ch <-chan []byte, stop <-chan struct{}, done chan<- struct{}
 		}
 
 		select {
-		case body := <-ch:
-			dispatchPublishFn(body)
+		case msg := <-ch:
+			dispatchPublishFn(id, msg)
+		case <-drain:
+			select {
+			case msg := <-ch:
+				dispatchPublishFn(id, msg)
+			default:
+				return
+			}
 		case <-stop:
 			return
 		}
 	}
 }
 
-func publishQueued(body []byte) {
+func publishQueued(worker int, msg queuedMessage) {
 	ctx, cancel := context.WithTimeout(context.Background(), cfg.publishTimeout)
 	defer cancel()
-	if err := publisher.Publish(ctx, body); err != nil {
-		if pluginutil.ShouldSample(&workerWarnCounter, debugSampleEvery) {
-			log(mosqLogWarning, "queue-plugin worker publish failed", map[string]any{"error": err})
+	dc, err := publisher.Publish(ctx, worker, msg)
+	if err != nil {
+		publishFailed(msg, err)
+		return
+	}
+	stats.published.Add(1)
+	if dc != nil {
+		trackConfirm(msg, dc)
+	}
+}
+
+// publishFailed 处理发送失败的消息：配置了溢出队列时落盘，恢复后由 replayWorker 重放；
+// 否则 queue_confirm=true 时按重试策略重新入队，其余情况只计数。
+// kafka 后端在 queue_confirm=false 时由投递回调异步调用。
+func publishFailed(msg queuedMessage, err error) {
+	stats.failed.Add(1)
+	if pluginutil.ShouldSample(&workerWarnCounter, debugSampleEvery) {
+		log(mosqLogWarning, "queue-plugin worker publish failed", map[string]any{"error": err, "routing_key": msg.routingKey})
+	}
+	if sp := dispatchSpool(); sp != nil {
+		if err := spillMessage(sp, msg); err != nil {
+			stats.dropped.Add(1)
+			if pluginutil.ShouldSample(&retryWarnCounter, debugSampleEvery) {
+				log(mosqLogWarning, "queue-plugin: spool write failed", map[string]any{"error": err, "routing_key": msg.routingKey})
+			}
+		}
+		return
+	}
+	if cfg.confirm {
+		retryMessage(msg)
+	}
+}
+
+// trackConfirm 把消息交给 confirmWorker；窗口已满时阻塞 worker，形成背压。
+// 确认超时从发布时刻起算，不受排在前面的消息等待确认的影响。
+func trackConfirm(msg queuedMessage, c confirmation) {
+	deadline := time.Now().Add(cfg.confirmTimeout)
+	dispatchMu.RLock()
+	confirms := confirmCh
+	stop := dispatchStop
+	dispatchMu.RUnlock()
+	if confirms == nil {
+		return
+	}
+	select {
+	case confirms <- pendingConfirm{msg: msg, confirm: c, deadline: deadline}:
+	case <-stop:
+	}
+}
+
+// confirmWorker 按发布顺序等待确认：ack 计为成功，nack（含通道关闭）与超过 deadline 未确认的按 queue_retry_max 重新入队。
+// workersDone 关闭（drain 结束）后处理完剩余的确认再退出；stop 关闭时正在等待的消息交给 stopDispatcher。
+func confirmWorker(confirms <-chan pendingConfirm, stop, workersDone <-chan struct{}) {
+	for {
+		var p pendingConfirm
+		select {
+		case p = <-confirms:
+		case <-workersDone:
+			select {
+			case p = <-confirms:
+			default:
+				return
+			}
+		case <-stop:
+			return
+		}
+		timer := time.NewTimer(time.Until(p.deadline))
+		select {
+		case <-p.confirm.Done():
+			timer.Stop()
+			if p.confirm.Acked() {
+				stats.confirmed.Add(1)
+				continue
+			}
+			stats.nacked.Add(1)
+		case <-timer.C:
+			stats.unconfirmed.Add(1)
+		case <-stop:
+			timer.Stop()
+			if !confirmedAtStop(p) {
+				abandonMessage(p.msg)
+			}
+			return
+		}
+		retryMessage(p.msg)
+	}
+}
+
+// confirmedAtStop 在停止时检查消息是否已经 ack：是则计为 confirmed，否则由调用方按未发出处理。
+func confirmedAtStop(p pendingConfirm) bool {
+	select {
+	case <-p.confirm.Done():
+		if p.confirm.Acked() {
+			stats.confirmed.Add(1)
+			return true
+		}
+	default:
+	}
+	return false
+}
+
+// retryMessage 在退避后把消息重新放入内存队列；超过 queue_retry_max 或队列已满时放弃并计数。
+func retryMessage(msg queuedMessage) {
+	msg.attempt++
+	if msg.attempt > cfg.retryMax {
+		stats.dropped.Add(1)
+		if pluginutil.ShouldSample(&retryWarnCounter, debugSampleEvery) {
+			log(mosqLogWarning, "queue-plugin: message dropped after retries", map[string]any{"attempts": msg.attempt, "routing_key": msg.routingKey})
+		}
+		return
+	}
+	stats.retried.Add(1)
+	retryMu.Lock()
+	defer retryMu.Unlock()
+	var timer *time.Timer
+	timer = time.AfterFunc(retryBackoff(msg.attempt), func() {
+		// 持有 retryMu 直到处理完，takeRetries 返回后不会再有定时器改动消息的去向。
+		retryMu.Lock()
+		defer retryMu.Unlock()
+		if _, ok := retryTimers[timer]; !ok {
+			return
+		}
+		delete(retryTimers, timer)
+		err := requeueMessage(msg)
+		switch {
+		case err == nil:
+		case errors.Is(err, errDispatcherStopped):
+			abandoned = append(abandoned, msg)
+		default:
+			stats.dropped.Add(1)
+			if pluginutil.ShouldSample(&retryWarnCounter, debugSampleEvery) {
+				log(mosqLogWarning, "queue-plugin: requeue failed", map[string]any{"error": err, "routing_key": msg.routingKey})
+			}
+		}
+	})
+	retryTimers[timer] = msg
+}
+
+// abandonMessage 记录 dispatcher 停止时仍未处理完的消息，由 stopDispatcher 落盘或计入 lost。
+func abandonMessage(msg queuedMessage) {
+	retryMu.Lock()
+	defer retryMu.Unlock()
+	abandoned = append(abandoned, msg)
+}
+
+// takeRetries 停止尚未触发的重试定时器，返回它们的消息以及已放弃的消息。
+func takeRetries() []queuedMessage {
+	retryMu.Lock()
+	defer retryMu.Unlock()
+	msgs := abandoned
+	abandoned = nil
+	for timer, msg := range retryTimers {
+		timer.Stop()
+		msgs = append(msgs, msg)
+	}
+	clear(retryTimers)
+	return msgs
+}
+
+// retryBackoff 返回第 attempt 次重试前的等待：queue_retry_backoff_ms 起按 2 倍递增，不超过 queue_retry_backoff_max_ms。
+func retryBackoff(attempt int) time.Duration {
+	d := cfg.retryBackoff
+	for i := 1; i < attempt && d < cfg.retryBackoffMax; i++ {
+		d *= 2
+	}
+	return min(d, cfg.retryBackoffMax)
+}
+
+// requeueMessage 把重试消息放回内存队列，不等待（与 fail_mode 无关）；队列已满时写入溢出队列（如已配置）。
+func requeueMessage(msg queuedMessage) error {
+	dispatchMu.RLock()
+	ch := pickQueue(dispatchChs, msg.clientID)
+	stop := dispatchStop
+	sp := overflow
+	dispatchMu.RUnlock()
+	if ch == nil || stop == nil {
+		return errDispatcherStopped
+	}
+	select {
+	case ch <- msg:
+		return nil
+	case <-stop:
+		return errDispatcherStopped
+	default:
+		if sp != nil {
+			return spillMessage(sp, msg)
 		}
+		return errQueueFull
 	}
 }
 
-func enqueueMessage(body []byte) error {
+// dispatchSpool 返回当前使用的溢出队列，未配置或 dispatcher 未运行时为 nil。
+func dispatchSpool() *diskSpool {
 	dispatchMu.RLock()
-	ch := dispatchCh
+	defer dispatchMu.RUnlock()
+	return overflow
+}
+
+// dispatchBuffered 返回内存队列中的消息数。
+func dispatchBuffered() int {
+	dispatchMu.RLock()
+	defer dispatchMu.RUnlock()
+	return bufferedLocked()
+}
+
+func bufferedLocked() int {
+	n := 0
+	for _, ch := range dispatchChs {
+		n += len(ch)
+	}
+	return n
+}
+
+// pickQueue 选择消息进入的内存队列：多个队列时按 client_id 的 FNV-1a 哈希，保证同一客户端的消息由同一 worker 顺序发送。
+func pickQueue(chs []chan queuedMessage, clientID string) chan queuedMessage {
+	switch len(chs) {
+	case 0:
+		return nil
+	case 1:
+		return chs[0]
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(clientID))
+	return chs[h.Sum32()%uint32(len(chs))]
+}
+
+// enqueueMessage 把消息放入内存队列。配置了溢出队列时：溢出队列非空则直接追加（保持顺序），
+// 内存队列已满时写入溢出队列；溢出队列写满后按 fail_mode 处理。
+func enqueueMessage(msg queuedMessage) error {
+	dispatchMu.RLock()
+	ch := pickQueue(dispatchChs, msg.clientID)
 	stop := dispatchStop
+	sp := overflow
 	mode := cfg.failMode
 	wait := cfg.enqueueTimeout
 	dispatchMu.RUnlock()
@@ -121,10 +504,23 @@ func enqueueMessage(body []byte) error {
 	default:
 	}
 
+	if sp != nil {
+		if sp.Len() == 0 {
+			select {
+			case ch <- msg:
+				return nil
+			default:
+			}
+		}
+		if err := spillMessage(sp, msg); err == nil {
+			return nil
+		}
+	}
+
 	switch mode {
 	case failModeDrop, failModeDisconnect:
 		select {
-		case ch <- body:
+		case ch <- msg:
 			return nil
 		case <-stop:
 			return errDispatcherStopped
@@ -135,7 +531,7 @@ func enqueueMessage(body []byte) error {
 		timer := time.NewTimer(wait)
 		defer timer.Stop()
 		select {
-		case ch <- body:
+		case ch <- msg:
 			return nil
 		case <-stop:
 			return errDispatcherStopped
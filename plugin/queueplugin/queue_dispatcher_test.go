@@ -2,6 +2,8 @@ package main
 
 import (
 	"errors"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 )
@@ -15,7 +17,7 @@ func withDispatcherTestSetup(t *testing.T, fn func(release chan struct{})) {
 
 	cfg.enqueueTimeout = 20 * time.Millisecond
 	cfg.publishTimeout = 20 * time.Millisecond
-	dispatchPublishFn = func([]byte) { <-release }
+	dispatchPublishFn = func(int, queuedMessage) { <-release }
 	stopDispatcher()
 	startDispatcher(1)
 
@@ -32,7 +34,7 @@ func withDispatcherTestSetup(t *testing.T, fn func(release chan struct{})) {
 func fillQueueUntilFull(t *testing.T) {
 	t.Helper()
 	for i := 0; i < 256; i++ {
-		err := enqueueMessage([]byte("x"))
+		err := enqueueMessage(queuedMessage{body: []byte("x")})
 		if errors.Is(err, errQueueFull) || errors.Is(err, errEnqueueTimeout) {
 			return
 		}
@@ -45,7 +47,7 @@ func TestEnqueueMessageDropWhenFull(t *testing.T) {
 		cfg.failMode = failModeDrop
 		fillQueueUntilFull(t)
 
-		err := enqueueMessage([]byte("x"))
+		err := enqueueMessage(queuedMessage{body: []byte("x")})
 		if !errors.Is(err, errQueueFull) {
 			t.Fatalf("enqueueMessage(drop) got err=%v want=%v", err, errQueueFull)
 		}
@@ -57,7 +59,7 @@ func TestEnqueueMessageDisconnectWhenFull(t *testing.T) {
 		cfg.failMode = failModeDisconnect
 		fillQueueUntilFull(t)
 
-		err := enqueueMessage([]byte("x"))
+		err := enqueueMessage(queuedMessage{body: []byte("x")})
 		if !errors.Is(err, errQueueFull) {
 			t.Fatalf("enqueueMessage(disconnect) got err=%v want=%v", err, errQueueFull)
 		}
@@ -71,7 +73,7 @@ func TestEnqueueMessageBlockTimeout(t *testing.T) {
 		fillQueueUntilFull(t)
 
 		start := time.Now()
-		err := enqueueMessage([]byte("x"))
+		err := enqueueMessage(queuedMessage{body: []byte("x")})
 		if !errors.Is(err, errEnqueueTimeout) {
 			t.Fatalf("enqueueMessage(block) got err=%v want=%v", err, errEnqueueTimeout)
 		}
@@ -89,7 +91,7 @@ func TestEnqueueMessageStopped(t *testing.T) {
 	cfg.failMode = failModeDrop
 	cfg.enqueueTimeout = 10 * time.Millisecond
 
-	err := enqueueMessage([]byte("x"))
+	err := enqueueMessage(queuedMessage{body: []byte("x")})
 	if !errors.Is(err, errDispatcherStopped) {
 		t.Fatalf("enqueueMessage(stopped) got err=%v want=%v", err, errDispatcherStopped)
 	}
@@ -105,7 +107,7 @@ func TestStopDispatcherBoundedWhenPublisherBlocked(t *testing.T) {
 	timeoutLogged := false
 
 	cfg.enqueueTimeout = 10 * time.Millisecond
-	dispatchPublishFn = func([]byte) {
+	dispatchPublishFn = func(int, queuedMessage) {
 		select {
 		case <-started:
 		default:
@@ -129,7 +131,7 @@ func TestStopDispatcherBoundedWhenPublisherBlocked(t *testing.T) {
 
 	stopDispatcher()
 	startDispatcher(1)
-	if err := enqueueMessage([]byte("x")); err != nil {
+	if err := enqueueMessage(queuedMessage{body: []byte("x")}); err != nil {
 		t.Fatalf("enqueueMessage failed: %v", err)
 	}
 	select {
@@ -147,3 +149,396 @@ func TestStopDispatcherBoundedWhenPublisherBlocked(t *testing.T) {
 		t.Fatal("expected timeout warning hook to be called")
 	}
 }
+
+func TestDispatcherCarriesRoutingKey(t *testing.T) {
+	oldPublish := dispatchPublishFn
+	got := make(chan queuedMessage, 1)
+	dispatchPublishFn = func(_ int, msg queuedMessage) { got <- msg }
+	t.Cleanup(func() {
+		stopDispatcher()
+		dispatchPublishFn = oldPublish
+	})
+
+	startDispatcher(1)
+	if err := enqueueMessage(queuedMessage{routingKey: "mqtt.v1.d.dev01.up", body: []byte("x")}); err != nil {
+		t.Fatalf("enqueueMessage failed: %v", err)
+	}
+	select {
+	case msg := <-got:
+		if msg.routingKey != "mqtt.v1.d.dev01.up" || string(msg.body) != "x" {
+			t.Fatalf("published %+v", msg)
+		}
+	case <-time.After(200 * time.Millisecond):
+		t.Fatal("dispatcher did not publish in time")
+	}
+}
+
+// fakeConfirmation 模拟 broker 的 ack/nack。
+type fakeConfirmation struct {
+	done chan struct{}
+	ack  bool
+}
+
+func newFakeConfirmation(ack bool) *fakeConfirmation {
+	c := &fakeConfirmation{done: make(chan struct{}), ack: ack}
+	close(c.done)
+	return c
+}
+
+func (c *fakeConfirmation) Done() <-chan struct{} { return c.done }
+func (c *fakeConfirmation) Acked() bool           { return c.ack }
+
+func TestRetryBackoff(t *testing.T) {
+	oldCfg := cfg
+	t.Cleanup(func() { cfg = oldCfg })
+
+	cfg.retryBackoff, cfg.retryBackoffMax = 100*time.Millisecond, 500*time.Millisecond
+	want := []time.Duration{100, 200, 400, 500, 500}
+	for i, w := range want {
+		if got := retryBackoff(i + 1); got != w*time.Millisecond {
+			t.Errorf("retryBackoff(%d) = %v, want %v", i+1, got, w*time.Millisecond)
+		}
+	}
+}
+
+func TestConfirmWorkerRetries(t *testing.T) {
+	oldCfg := cfg
+	oldPublish := dispatchPublishFn
+	published := make(chan queuedMessage, 8)
+	dispatchPublishFn = func(_ int, msg queuedMessage) {
+		published <- msg
+		// 第一次 nack，重试后 ack。
+		trackConfirm(msg, newFakeConfirmation(msg.attempt > 0))
+	}
+	cfg.confirm = true
+	cfg.confirmTimeout = time.Second
+	cfg.retryMax = 2
+	cfg.retryBackoff, cfg.retryBackoffMax = time.Millisecond, time.Millisecond
+	stats.reset()
+	t.Cleanup(func() {
+		stopDispatcher()
+		dispatchPublishFn = oldPublish
+		cfg = oldCfg
+		stats.reset()
+	})
+
+	startDispatcher(4)
+	if err := enqueueMessage(queuedMessage{routingKey: "k", body: []byte("x")}); err != nil {
+		t.Fatal(err)
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		select {
+		case msg := <-published:
+			if msg.attempt != attempt {
+				t.Fatalf("publish #%d attempt = %d", attempt, msg.attempt)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("publish #%d not seen", attempt)
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for stats.confirmed.Load() != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if stats.nacked.Load() != 1 || stats.retried.Load() != 1 || stats.confirmed.Load() != 1 || stats.dropped.Load() != 0 {
+		t.Fatalf("stats = %v", stats.fields())
+	}
+}
+
+func TestRetryMessageGivesUp(t *testing.T) {
+	oldCfg := cfg
+	t.Cleanup(func() {
+		cfg = oldCfg
+		stats.reset()
+	})
+	stats.reset()
+	cfg.retryMax = 1
+	retryMessage(queuedMessage{attempt: 1})
+	if stats.dropped.Load() != 1 || stats.retried.Load() != 0 {
+		t.Fatalf("stats = %v", stats.fields())
+	}
+}
+
+func TestConfirmWorkerTimeout(t *testing.T) {
+	oldCfg := cfg
+	t.Cleanup(func() {
+		cfg = oldCfg
+		stats.reset()
+	})
+	stats.reset()
+	// 超时按发布时记录的 deadline 计算，与 confirmWorker 取到消息的时间无关。
+	cfg.confirmTimeout = time.Hour
+	cfg.retryMax = 1
+
+	confirms := make(chan pendingConfirm, 2)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		confirmWorker(confirms, stop, nil)
+		close(done)
+	}()
+	// 永不确认；超过 retry_max 后放弃。
+	for i := 0; i < 2; i++ {
+		confirms <- pendingConfirm{
+			msg:      queuedMessage{attempt: 1},
+			confirm:  &fakeConfirmation{done: make(chan struct{})},
+			deadline: time.Now().Add(5 * time.Millisecond),
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for stats.dropped.Load() != 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(stop)
+	<-done
+	if stats.unconfirmed.Load() != 2 || stats.dropped.Load() != 2 {
+		t.Fatalf("stats = %v", stats.fields())
+	}
+}
+
+func TestDispatcherClientOrdering(t *testing.T) {
+	oldCfg := cfg
+	oldPublish := dispatchPublishFn
+	type record struct {
+		worker int
+		msg    queuedMessage
+	}
+	var mu sync.Mutex
+	var records []record
+	dispatchPublishFn = func(worker int, msg queuedMessage) {
+		mu.Lock()
+		records = append(records, record{worker, msg})
+		mu.Unlock()
+	}
+	cfg.workers, cfg.ordering = 4, orderingClient
+	t.Cleanup(func() {
+		stopDispatcher()
+		dispatchPublishFn = oldPublish
+		cfg = oldCfg
+	})
+
+	startDispatcher(256)
+	const clients, perClient = 8, 20
+	for i := 0; i < perClient; i++ {
+		for c := 0; c < clients; c++ {
+			msg := queuedMessage{clientID: fmt.Sprintf("dev%02d", c), body: []byte{byte(i)}}
+			if err := enqueueMessage(msg); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		n := len(records)
+		mu.Unlock()
+		if n == clients*perClient {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(records) != clients*perClient {
+		t.Fatalf("published %d messages", len(records))
+	}
+	workerOf := map[string]int{}
+	next := map[string]byte{}
+	usedWorkers := map[int]bool{}
+	for _, r := range records {
+		if w, ok := workerOf[r.msg.clientID]; ok && w != r.worker {
+			t.Fatalf("client %s moved from worker %d to %d", r.msg.clientID, w, r.worker)
+		}
+		workerOf[r.msg.clientID] = r.worker
+		usedWorkers[r.worker] = true
+		if r.msg.body[0] != next[r.msg.clientID] {
+			t.Fatalf("client %s out of order: got %d want %d", r.msg.clientID, r.msg.body[0], next[r.msg.clientID])
+		}
+		next[r.msg.clientID]++
+	}
+	if len(usedWorkers) < 2 {
+		t.Fatalf("expected messages spread over workers, used %v", usedWorkers)
+	}
+}
+
+func TestDispatcherSharedQueue(t *testing.T) {
+	oldCfg := cfg
+	oldPublish := dispatchPublishFn
+	release := make(chan struct{})
+	started := make(chan int, 4)
+	dispatchPublishFn = func(worker int, _ queuedMessage) {
+		started <- worker
+		<-release
+	}
+	cfg.workers, cfg.ordering = 3, orderingNone
+	t.Cleanup(func() {
+		close(release)
+		stopDispatcher()
+		dispatchPublishFn = oldPublish
+		cfg = oldCfg
+	})
+
+	startDispatcher(8)
+	for i := 0; i < 3; i++ {
+		if err := enqueueMessage(queuedMessage{clientID: "same", body: []byte("x")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 共享队列下同一客户端的消息也会并行发送。
+	seen := map[int]bool{}
+	for i := 0; i < 3; i++ {
+		select {
+		case w := <-started:
+			seen[w] = true
+		case <-time.After(time.Second):
+			t.Fatalf("only %d workers busy", len(seen))
+		}
+	}
+	if len(seen) != 3 {
+		t.Fatalf("workers = %v", seen)
+	}
+}
+
+func TestDrainDispatcherPublishesBuffered(t *testing.T) {
+	oldCfg := cfg
+	oldPublish := dispatchPublishFn
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var published int
+	dispatchPublishFn = func(int, queuedMessage) {
+		<-release
+		mu.Lock()
+		published++
+		mu.Unlock()
+	}
+	cfg.workers, cfg.ordering = 2, orderingClient
+	t.Cleanup(func() {
+		stopDispatcher()
+		dispatchPublishFn = oldPublish
+		cfg = oldCfg
+		stats.reset()
+	})
+	stats.reset()
+
+	startDispatcher(16)
+	for i := 0; i < 10; i++ {
+		if err := enqueueMessage(queuedMessage{clientID: fmt.Sprintf("dev%02d", i), body: []byte("x")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(release)
+	drainDispatcher(time.Second)
+	stopDispatcher()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if published != 10 || stats.lost.Load() != 0 {
+		t.Fatalf("published=%d lost=%d, want 10 and 0", published, stats.lost.Load())
+	}
+}
+
+func TestStopDispatcherCountsLeftovers(t *testing.T) {
+	oldCfg := cfg
+	oldPublish := dispatchPublishFn
+	oldWait := dispatcherStopWait
+	oldLog := dispatcherStopTimeoutLogFn
+	release := make(chan struct{})
+	// 每轮有一个 worker 阻塞在发送中，停止超时后仍在运行；清理时等它们返回。
+	returned := make(chan struct{}, 2)
+	dispatchPublishFn = func(int, queuedMessage) {
+		<-release
+		returned <- struct{}{}
+	}
+	dispatcherStopWait = 10 * time.Millisecond
+	dispatcherStopTimeoutLogFn = func(time.Duration, int) {}
+	t.Cleanup(func() {
+		close(release)
+		<-returned
+		<-returned
+		stopDispatcher()
+		closeSpool()
+		dispatchPublishFn = oldPublish
+		dispatcherStopWait = oldWait
+		dispatcherStopTimeoutLogFn = oldLog
+		cfg = oldCfg
+		stats.reset()
+	})
+
+	for _, withSpool := range []bool{false, true} {
+		stats.reset()
+		if withSpool {
+			sp, err := openSpool(t.TempDir(), 1<<20, 1<<20)
+			if err != nil {
+				t.Fatal(err)
+			}
+			spool = sp
+		}
+		startDispatcher(8)
+		// 第一条被阻塞的 worker 取走，其余留在内存队列。
+		for i := 0; i < 5; i++ {
+			if err := enqueueMessage(queuedMessage{body: []byte("x")}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		drainDispatcher(10 * time.Millisecond)
+		stopDispatcher()
+
+		if withSpool {
+			if stats.lost.Load() != 0 || stats.spooled.Load() != 4 || len(drainSpool(t, spool)) != 4 {
+				t.Fatalf("with spool: stats = %v", stats.fields())
+			}
+			closeSpool()
+		} else if stats.lost.Load() != 4 {
+			t.Fatalf("without spool: lost = %d, want 4", stats.lost.Load())
+		}
+	}
+}
+
+func TestStopDispatcherFlushesConfirmsAndRetries(t *testing.T) {
+	oldCfg := cfg
+	t.Cleanup(func() {
+		stopDispatcher()
+		closeSpool()
+		cfg = oldCfg
+		stats.reset()
+	})
+	cfg.confirm = true
+	cfg.confirmTimeout = time.Hour
+	cfg.retryBackoff, cfg.retryBackoffMax = time.Hour, time.Hour
+	cfg.retryMax = 3
+
+	for _, withSpool := range []bool{false, true} {
+		stats.reset()
+		if withSpool {
+			sp, err := openSpool(t.TempDir(), 1<<20, 1<<20)
+			if err != nil {
+				t.Fatal(err)
+			}
+			spool = sp
+		}
+		startDispatcher(8)
+		// 第一条永不确认，confirmWorker 停在它上面；第二条已 ack 但排在后面；第三条在等待重试。
+		trackConfirm(queuedMessage{body: []byte("1")}, &fakeConfirmation{done: make(chan struct{})})
+		trackConfirm(queuedMessage{body: []byte("2")}, newFakeConfirmation(true))
+		retryMessage(queuedMessage{body: []byte("3")})
+		time.Sleep(10 * time.Millisecond)
+		stopDispatcher()
+
+		if stats.confirmed.Load() != 1 {
+			t.Fatalf("confirmed = %d, want 1", stats.confirmed.Load())
+		}
+		if withSpool {
+			if stats.lost.Load() != 0 || stats.spooled.Load() != 2 || len(drainSpool(t, spool)) != 2 {
+				t.Fatalf("with spool: stats = %v", stats.fields())
+			}
+			closeSpool()
+		} else if stats.lost.Load() != 2 {
+			t.Fatalf("without spool: lost = %d, want 2", stats.lost.Load())
+		}
+		if len(takeRetries()) != 0 {
+			t.Fatal("retry timers left after stop")
+		}
+	}
+}
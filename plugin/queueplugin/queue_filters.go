@@ -1,11 +1,93 @@
 package main
 
-import "strings"
+import (
+	"strings"
 
-// allowMessage 仅内置过滤系统主题：$SYS/#。
-func allowMessage(topic string) (bool, string) {
-	if topic == "$SYS" || strings.HasPrefix(topic, "$SYS/") {
+	"mosquitto-plugin/internal/pluginutil"
+)
+
+// messageFilter 是 queue_include_* / queue_exclude_* 配置编译后的过滤规则。
+// 主题使用 MQTT 过滤器（+/#），用户名与 client_id 使用 * / ? 通配。
+type messageFilter struct {
+	includeTopics  []string
+	excludeTopics  []string
+	includeUsers   []string
+	excludeUsers   []string
+	includeClients []string
+	excludeClients []string
+	dropRetained   bool
+}
+
+// allow 按固定顺序判定：$SYS → retained → exclude（topic/user/client）→ include（topic/user/client）。
+// include 列表为空表示不限制；reason 用于调试日志。
+func (f *messageFilter) allow(topic, username, clientID string, retain bool) (bool, string) {
+	switch {
+	case topic == "$SYS" || strings.HasPrefix(topic, "$SYS/"):
 		return false, "sys_topic"
+	case retain && f.dropRetained:
+		return false, "retained"
+	case matchAnyTopic(f.excludeTopics, topic):
+		return false, "exclude_topics"
+	case matchAnyGlob(f.excludeUsers, username):
+		return false, "exclude_users"
+	case matchAnyGlob(f.excludeClients, clientID):
+		return false, "exclude_clients"
+	case len(f.includeTopics) > 0 && !matchAnyTopic(f.includeTopics, topic):
+		return false, "include_topics"
+	case len(f.includeUsers) > 0 && !matchAnyGlob(f.includeUsers, username):
+		return false, "include_users"
+	case len(f.includeClients) > 0 && !matchAnyGlob(f.includeClients, clientID):
+		return false, "include_clients"
 	}
 	return true, ""
 }
+
+// allowMessage 使用当前配置的过滤规则；在拷贝 payload 与 JSON 校验之前调用。
+func allowMessage(topic, username, clientID string, retain bool) (bool, string) {
+	return cfg.filter.allow(topic, username, clientID, retain)
+}
+
+func matchAnyTopic(filters []string, topic string) bool {
+	for _, f := range filters {
+		if pluginutil.TopicMatches(f, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAnyGlob(patterns []string, s string) bool {
+	for _, p := range patterns {
+		if globMatch(p, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// globMatch 支持 *（任意长度）与 ?（单个字符）；与 path.Match 不同，/ 不作特殊处理。
+func globMatch(pattern, s string) bool {
+	p, str := []rune(pattern), []rune(s)
+	pi, si := 0, 0
+	star, mark := -1, 0
+	for si < len(str) {
+		switch {
+		case pi < len(p) && p[pi] == '*':
+			star, mark = pi, si
+			pi++
+		case pi < len(p) && (p[pi] == '?' || p[pi] == str[si]):
+			pi++
+			si++
+		case star >= 0:
+			pi = star + 1
+			mark++
+			si = mark
+		default:
+			return false
+		}
+	}
+	for pi < len(p) && p[pi] == '*' {
+		pi++
+	}
+	return pi == len(p)
+}
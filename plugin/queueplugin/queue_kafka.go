@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+	"github.com/twmb/franz-go/pkg/sasl/scram"
+
+	"mosquitto-plugin/internal/pluginconf"
+	"mosquitto-plugin/internal/pluginutil"
+)
+
+// maxKafkaTopicLen 是 Kafka topic 名的长度上限。
+const maxKafkaTopicLen = 249
+
+// minKafkaDeliveryTimeout 是客户端允许的最小投递超时。
+const minKafkaDeliveryTimeout = time.Second
+
+// kafkaReadyTTL 为 Ready 缓存成功探测结果的时间，避免重放时逐条探测 broker。
+const kafkaReadyTTL = time.Second
+
+// kafkaConfig 是 queue_backend=kafka 时的 queue_kafka_* 配置。
+type kafkaConfig struct {
+	brokers   []string
+	topic     string
+	topicTmpl *pluginutil.KeyTemplate
+	// keyTmpl 为 nil 时不设置消息键（按 Kafka 默认策略分区）。
+	key         string
+	keyTmpl     *pluginutil.KeyTemplate
+	acks        string
+	idempotent  bool
+	compression string
+	tls         bool
+	tlsCAFile   string
+	tlsCertFile string
+	tlsKeyFile  string
+	// saslMechanism 为空表示不认证。
+	saslMechanism string
+	saslUsername  string
+	saslPassword  string
+}
+
+// kafkaPublisher 使用一个 franz-go 客户端发送；客户端自行管理连接、分区与重试，多个 worker 共用。
+type kafkaPublisher struct {
+	client *kgo.Client
+
+	mu         sync.Mutex
+	readyUntil time.Time
+}
+
+// newKafkaPublisher 按 cfg.kafka 创建客户端；extra 供测试追加选项。客户端按需连接，broker 不可用时不会失败。
+func newKafkaPublisher(kc kafkaConfig, extra ...kgo.Opt) (*kafkaPublisher, error) {
+	opts, err := kafkaOptions(kc)
+	if err != nil {
+		return nil, err
+	}
+	client, err := kgo.NewClient(append(opts, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+	return &kafkaPublisher{client: client}, nil
+}
+
+// kafkaOptions 把配置转换为客户端选项。
+func kafkaOptions(kc kafkaConfig) ([]kgo.Opt, error) {
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(kc.brokers...),
+		kgo.DialTimeout(cfg.publishTimeout),
+		// franz-go 要求投递超时不小于 1s。
+		kgo.RecordDeliveryTimeout(max(cfg.publishTimeout, minKafkaDeliveryTimeout)),
+	}
+	switch kc.acks {
+	case pluginconf.KafkaAcksLeader:
+		opts = append(opts, kgo.RequiredAcks(kgo.LeaderAck()))
+	case pluginconf.KafkaAcksNone:
+		opts = append(opts, kgo.RequiredAcks(kgo.NoAck()))
+	default:
+		opts = append(opts, kgo.RequiredAcks(kgo.AllISRAcks()))
+	}
+	// 幂等写入要求 acks=all。
+	if !kc.idempotent || kc.acks != pluginconf.KafkaAcksAll {
+		opts = append(opts, kgo.DisableIdempotentWrite())
+	}
+	switch kc.compression {
+	case "gzip":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.GzipCompression()))
+	case "snappy":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.SnappyCompression()))
+	case "lz4":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.Lz4Compression()))
+	case "zstd":
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.ZstdCompression()))
+	default:
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.NoCompression()))
+	}
+	if kc.tls {
+		tc, err := clientTLSConfig("queue_kafka", kc.tlsCAFile, kc.tlsCertFile, kc.tlsKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, kgo.DialTLSConfig(tc))
+	}
+	if kc.saslMechanism != "" {
+		m, err := kafkaSASL(kc)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, kgo.SASL(m))
+	}
+	return opts, nil
+}
+
+func kafkaSASL(kc kafkaConfig) (sasl.Mechanism, error) {
+	if kc.saslUsername == "" || kc.saslPassword == "" {
+		return nil, errors.New("queue_kafka_sasl_username and queue_kafka_sasl_password must be set")
+	}
+	switch kc.saslMechanism {
+	case "plain":
+		return plain.Auth{User: kc.saslUsername, Pass: kc.saslPassword}.AsMechanism(), nil
+	case "scram-sha-256":
+		return scram.Auth{User: kc.saslUsername, Pass: kc.saslPassword}.AsSha256Mechanism(), nil
+	case "scram-sha-512":
+		return scram.Auth{User: kc.saslUsername, Pass: kc.saslPassword}.AsSha512Mechanism(), nil
+	default:
+		return nil, fmt.Errorf("unsupported sasl mechanism %q", kc.saslMechanism)
+	}
+}
+
+// prepare 校验必填项并解析 topic 与消息键模板；固定的 topic 在此提前校验。
+func (kc *kafkaConfig) prepare() error {
+	if len(kc.brokers) == 0 || kc.topic == "" {
+		return errors.New("queue_kafka_brokers and queue_kafka_topic must be set")
+	}
+	tmpl, err := pluginutil.ParseKeyTemplate(kc.topic)
+	if err != nil {
+		return fmt.Errorf("invalid queue_kafka_topic: %w", err)
+	}
+	if tmpl.Static() {
+		if err := checkKafkaTopic(kc.topic); err != nil {
+			return err
+		}
+	}
+	kc.topicTmpl = tmpl
+	key, err := pluginconf.ParseKafkaKey(kc.key)
+	if err != nil {
+		return fmt.Errorf("invalid queue_kafka_key: %w", err)
+	}
+	kc.keyTmpl = nil
+	if key != "" {
+		if kc.keyTmpl, err = pluginutil.ParseKeyTemplate(key); err != nil {
+			return fmt.Errorf("invalid queue_kafka_key: %w", err)
+		}
+	}
+	if kc.saslMechanism != "" && (kc.saslUsername == "" || kc.saslPassword == "") {
+		return errors.New("queue_kafka_sasl_mechanism requires queue_kafka_sasl_username and queue_kafka_sasl_password")
+	}
+	if (kc.tlsCertFile == "") != (kc.tlsKeyFile == "") {
+		return errors.New("queue_kafka_tls_cert_file and queue_kafka_tls_key_file must be set together")
+	}
+	return nil
+}
+
+func (p *kafkaPublisher) Name() string { return pluginconf.QueueBackendKafka }
+
+// Publish 把记录交给客户端缓冲区后立即返回，投递结果（含缓冲区已满的 kgo.ErrMaxBuffered）均在回调中异步给出。
+// queue_confirm=true 时返回投递结果对应的 confirmation；否则投递失败在回调中按 publishFailed 处理。
+func (p *kafkaPublisher) Publish(_ context.Context, _ int, msg queuedMessage) (confirmation, error) {
+	rec := kafkaRecord(msg)
+	// 记录的生命周期长于本次调用，不使用调用方的 ctx（投递超时由 RecordDeliveryTimeout 控制）。
+	if cfg.confirm {
+		c := &kafkaConfirmation{done: make(chan struct{})}
+		p.client.TryProduce(context.Background(), rec, c.complete)
+		return c, nil
+	}
+	p.client.TryProduce(context.Background(), rec, func(_ *kgo.Record, err error) {
+		if err != nil {
+			publishFailed(msg, err)
+		}
+	})
+	return nil, nil
+}
+
+// Ready 探测 broker 是否可达；成功结果缓存 kafkaReadyTTL。
+func (p *kafkaPublisher) Ready() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if time.Now().Before(p.readyUntil) {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.publishTimeout)
+	defer cancel()
+	if err := p.client.Ping(ctx); err != nil {
+		return false
+	}
+	p.readyUntil = time.Now().Add(kafkaReadyTTL)
+	return true
+}
+
+// Close 在 queue_publish_timeout_ms 内尽量发出缓冲区中的记录后关闭客户端；未发出的记录按投递失败回调。
+func (p *kafkaPublisher) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.publishTimeout)
+	defer cancel()
+	if err := p.client.Flush(ctx); err != nil {
+		log(mosqLogWarning, "queue-plugin: kafka flush failed", map[string]any{"error": err})
+	}
+	p.client.Close()
+}
+
+// kafkaConfirmation 在投递回调后完成；Acked 表示写入成功（按 queue_kafka_acks）。
+type kafkaConfirmation struct {
+	done chan struct{}
+	err  error
+}
+
+func (c *kafkaConfirmation) complete(_ *kgo.Record, err error) {
+	c.err = err
+	close(c.done)
+}
+
+func (c *kafkaConfirmation) Done() <-chan struct{} { return c.done }
+
+func (c *kafkaConfirmation) Acked() bool {
+	<-c.done
+	return c.err == nil
+}
+
+// kafkaRecord 构造 Kafka 记录：value 与 RabbitMQ 相同（JSON 信封或 raw 字节），
+// 头部为 content-type 加上 msg.headers（raw 模式下的消息元数据），值统一转为字符串。
+func kafkaRecord(msg queuedMessage) *kgo.Record {
+	contentType := msg.contentType
+	if contentType == "" {
+		contentType = contentTypeJSON
+	}
+	rec := &kgo.Record{
+		Topic:   msg.routingKey,
+		Value:   msg.body,
+		Headers: []kgo.RecordHeader{{Key: "content-type", Value: []byte(contentType)}},
+	}
+	if msg.key != "" {
+		rec.Key = []byte(msg.key)
+	}
+	keys := make([]string, 0, len(msg.headers))
+	for k := range msg.headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		rec.Headers = append(rec.Headers, kgo.RecordHeader{Key: k, Value: []byte(headerString(msg.headers[k]))})
+	}
+	return rec
+}
+
+// checkKafkaTopic 校验渲染后的 topic：非空、不超过 249 字节、只含 [a-zA-Z0-9._-]，且不是 . 或 ..。
+func checkKafkaTopic(topic string) error {
+	if topic == "" || topic == "." || topic == ".." {
+		return fmt.Errorf("invalid kafka topic %q", topic)
+	}
+	if len(topic) > maxKafkaTopicLen {
+		return fmt.Errorf("kafka topic longer than %d bytes", maxKafkaTopicLen)
+	}
+	for i := 0; i < len(topic); i++ {
+		c := topic[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-') {
+			return fmt.Errorf("invalid character %q in kafka topic %q", c, topic)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+
+	"mosquitto-plugin/internal/pluginconf"
+)
+
+func TestKafkaConfigPrepare(t *testing.T) {
+	t.Parallel()
+	kc := kafkaConfig{brokers: []string{"k1:9092"}, topic: "mqtt.{topic[0]}", key: "client_id"}
+	if err := kc.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if kc.topicTmpl == nil || kc.keyTmpl == nil {
+		t.Fatal("templates not parsed")
+	}
+	kc.key = "none"
+	if err := kc.prepare(); err != nil || kc.keyTmpl != nil {
+		t.Fatalf("key none: tmpl=%v err=%v", kc.keyTmpl, err)
+	}
+
+	for name, bad := range map[string]kafkaConfig{
+		"no brokers":     {topic: "t"},
+		"no topic":       {brokers: []string{"k1:9092"}},
+		"bad topic":      {brokers: []string{"k1:9092"}, topic: "a/b"},
+		"bad key":        {brokers: []string{"k1:9092"}, topic: "t", key: "{peer}"},
+		"sasl no creds":  {brokers: []string{"k1:9092"}, topic: "t", saslMechanism: "plain", saslUsername: "u"},
+		"cert no key":    {brokers: []string{"k1:9092"}, topic: "t", tlsCertFile: "c.pem"},
+		"template error": {brokers: []string{"k1:9092"}, topic: "{topic"},
+	} {
+		if err := bad.prepare(); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
+
+func TestCheckKafkaTopic(t *testing.T) {
+	t.Parallel()
+	for _, topic := range []string{"mqtt", "mqtt.v1_up-2", strings.Repeat("a", maxKafkaTopicLen)} {
+		if err := checkKafkaTopic(topic); err != nil {
+			t.Fatalf("checkKafkaTopic(%q): %v", topic, err)
+		}
+	}
+	for _, topic := range []string{"", ".", "..", "v1/up", "a b", strings.Repeat("a", maxKafkaTopicLen+1)} {
+		if err := checkKafkaTopic(topic); err == nil {
+			t.Fatalf("checkKafkaTopic(%q) should fail", topic)
+		}
+	}
+}
+
+func TestKafkaRecord(t *testing.T) {
+	t.Parallel()
+	rec := kafkaRecord(queuedMessage{
+		routingKey:  "mqtt.up",
+		key:         "dev01",
+		contentType: contentTypeRaw,
+		headers:     amqp.Table{"mqtt_topic": "v1/up", "mqtt_qos": int32(1), "mqtt_retain": false},
+		body:        []byte("hi"),
+	})
+	if rec.Topic != "mqtt.up" || string(rec.Key) != "dev01" || string(rec.Value) != "hi" {
+		t.Fatalf("record = %+v", rec)
+	}
+	var got []string
+	for _, h := range rec.Headers {
+		got = append(got, h.Key+"="+string(h.Value))
+	}
+	want := "content-type=" + contentTypeRaw + ",mqtt_qos=1,mqtt_retain=false,mqtt_topic=v1/up"
+	if strings.Join(got, ",") != want {
+		t.Fatalf("headers = %v, want %s", got, want)
+	}
+	if rec := kafkaRecord(queuedMessage{routingKey: "t"}); rec.Key != nil || string(rec.Headers[0].Value) != contentTypeJSON {
+		t.Fatalf("default record = %+v", rec)
+	}
+}
+
+func TestKafkaPublisherDelivers(t *testing.T) {
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(1, "mqtt.up"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+
+	oldCfg := cfg
+	t.Cleanup(func() { cfg = oldCfg })
+	cfg.publishTimeout = 5 * time.Second
+	cfg.confirm = true
+
+	p, err := newKafkaPublisher(kafkaConfig{
+		brokers:    cluster.ListenAddrs(),
+		acks:       pluginconf.KafkaAcksAll,
+		idempotent: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if !p.Ready() {
+		t.Fatal("publisher should be ready")
+	}
+
+	c, err := p.Publish(context.Background(), 0, queuedMessage{
+		routingKey: "mqtt.up",
+		key:        "dev01",
+		headers:    amqp.Table{"mqtt_qos": int32(1)},
+		body:       []byte(`{"n":1}`),
+	})
+	if err != nil || c == nil {
+		t.Fatalf("Publish = %v, %v", c, err)
+	}
+	select {
+	case <-c.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("confirmation timeout")
+	}
+	if !c.Acked() {
+		t.Fatal("record not acked")
+	}
+
+	consumer, err := kgo.NewClient(
+		kgo.SeedBrokers(cluster.ListenAddrs()...),
+		kgo.ConsumeTopics("mqtt.up"),
+		kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	fetches := consumer.PollFetches(ctx)
+	if errs := fetches.Errors(); len(errs) > 0 {
+		t.Fatalf("fetch: %v", errs)
+	}
+	recs := fetches.Records()
+	if len(recs) != 1 {
+		t.Fatalf("consumed %d records, want 1", len(recs))
+	}
+	rec := recs[0]
+	if string(rec.Key) != "dev01" || string(rec.Value) != `{"n":1}` {
+		t.Fatalf("record = key %q value %q", rec.Key, rec.Value)
+	}
+	headers := map[string]string{}
+	for _, h := range rec.Headers {
+		headers[h.Key] = string(h.Value)
+	}
+	if headers["content-type"] != contentTypeJSON || headers["mqtt_qos"] != "1" {
+		t.Fatalf("headers = %v", headers)
+	}
+}
+
+func TestKafkaPublisherCountsFailures(t *testing.T) {
+	oldCfg := cfg
+	oldFailed := stats.failed.Load()
+	t.Cleanup(func() { cfg = oldCfg })
+	cfg.publishTimeout = 200 * time.Millisecond
+	cfg.confirm = false
+
+	// 保留地址且无监听，连接必然失败。
+	p, err := newKafkaPublisher(kafkaConfig{brokers: []string{"127.0.0.1:1"}, acks: pluginconf.KafkaAcksLeader})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Ready() {
+		t.Fatal("publisher should not be ready without a broker")
+	}
+	if c, err := p.Publish(context.Background(), 0, queuedMessage{routingKey: "mqtt.up", body: []byte("x")}); c != nil || err != nil {
+		t.Fatalf("Publish = %v, %v; want asynchronous failure", c, err)
+	}
+	// 等待记录按投递超时失败（Flush 返回时回调已执行完）。
+	if err := p.client.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+	if stats.failed.Load() <= oldFailed {
+		t.Fatal("delivery failure should be counted in failed")
+	}
+}
+
+func TestKafkaPublisherBufferFull(t *testing.T) {
+	oldCfg := cfg
+	oldFailed := stats.failed.Load()
+	t.Cleanup(func() { cfg = oldCfg })
+	cfg.publishTimeout = 2 * time.Second
+	cfg.confirm = false
+
+	// 缓冲区只容纳一条记录，第一条在投递超时前一直占用缓冲区。
+	p, err := newKafkaPublisher(kafkaConfig{brokers: []string{"127.0.0.1:1"}, acks: pluginconf.KafkaAcksLeader},
+		kgo.MaxBufferedRecords(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	for i := 0; i < 2; i++ {
+		if c, err := p.Publish(context.Background(), 0, queuedMessage{routingKey: "mqtt.up", body: []byte("x")}); c != nil || err != nil {
+			t.Fatalf("Publish = %v, %v; want asynchronous result", c, err)
+		}
+	}
+	// kgo.ErrMaxBuffered 由其他 goroutine 回调，第二条应在第一条超时前计入 failed。
+	deadline := time.Now().Add(time.Second)
+	for stats.failed.Load() <= oldFailed {
+		if time.Now().After(deadline) {
+			t.Fatal("record rejected by a full buffer should be counted in failed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"mosquitto-plugin/internal/pluginconf"
+	"mosquitto-plugin/internal/pluginutil"
+)
+
+// natsReconnectWait 为连接断开后客户端自动重连的间隔（测试中缩短）。
+var natsReconnectWait = time.Second
+
+// natsConfig 是 queue_backend=nats 时的 queue_nats_* 配置。
+type natsConfig struct {
+	urls        []string
+	subject     string
+	subjectTmpl *pluginutil.KeyTemplate
+	credsFile   string
+	tls         bool
+	tlsCAFile   string
+	tlsCertFile string
+	tlsKeyFile  string
+}
+
+// prepare 校验必填项并解析 subject 模板；固定的 subject 在此提前校验。
+func (nc *natsConfig) prepare() error {
+	if len(nc.urls) == 0 || nc.subject == "" {
+		return errors.New("queue_nats_url and queue_nats_subject must be set")
+	}
+	tmpl, err := pluginutil.ParseKeyTemplate(nc.subject)
+	if err != nil {
+		return fmt.Errorf("invalid queue_nats_subject: %w", err)
+	}
+	if tmpl.Static() {
+		if err := checkNATSSubject(nc.subject); err != nil {
+			return err
+		}
+	}
+	nc.subjectTmpl = tmpl
+	if (nc.tlsCertFile == "") != (nc.tlsKeyFile == "") {
+		return errors.New("queue_nats_tls_cert_file and queue_nats_tls_key_file must be set together")
+	}
+	return nil
+}
+
+// safeURLs 返回脱敏后的服务器列表，用于日志。
+func (nc *natsConfig) safeURLs() []string {
+	out := make([]string, len(nc.urls))
+	for i, u := range nc.urls {
+		out[i] = pluginutil.SafeDSN(u)
+	}
+	return out
+}
+
+// natsPublisher 通过 JetStream 异步发布：所有 worker 共用一个连接，消息带 Nats-Msg-Id（msg.key）由服务端去重。
+// 连接建立后断线由客户端自动重连；首次连接失败或连接关闭后按 ensureLocked 退避重连。
+type natsPublisher struct {
+	servers string
+	opts    []nats.Option
+
+	mu   sync.Mutex
+	conn *nats.Conn
+	js   jetstream.JetStream
+
+	nextDial time.Time
+}
+
+// newNATSPublisher 按配置创建发布器并尝试首次连接；连接失败时在发送时重连。extra 供测试追加选项。
+func newNATSPublisher(nc natsConfig, extra ...nats.Option) (*natsPublisher, error) {
+	opts, err := natsOptions(nc)
+	if err != nil {
+		return nil, err
+	}
+	p := &natsPublisher{servers: strings.Join(nc.urls, ","), opts: append(opts, extra...)}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.ensureLocked(); err != nil {
+		log(mosqLogWarning, "queue-plugin: initial connect failed", map[string]any{"error": err})
+	}
+	return p, nil
+}
+
+// natsOptions 把配置转换为客户端选项。重连缓冲关闭，断线期间的发布直接失败，由 publishFailed 处理。
+func natsOptions(nc natsConfig) ([]nats.Option, error) {
+	opts := []nats.Option{
+		nats.Name("mosquitto-queue-plugin"),
+		nats.Timeout(cfg.publishTimeout),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(natsReconnectWait),
+		nats.ReconnectBufSize(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				log(mosqLogWarning, "queue-plugin: disconnected from nats", map[string]any{"error": err})
+			}
+		}),
+		nats.ReconnectHandler(func(c *nats.Conn) {
+			log(mosqLogInfo, "queue-plugin: reconnected to nats", map[string]any{"url": c.ConnectedUrlRedacted()})
+		}),
+	}
+	if nc.tls {
+		tc, err := clientTLSConfig("queue_nats", nc.tlsCAFile, nc.tlsCertFile, nc.tlsKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, nats.Secure(tc))
+	}
+	if nc.credsFile != "" {
+		opts = append(opts, nats.UserCredentials(nc.credsFile))
+	}
+	return opts, nil
+}
+
+func (p *natsPublisher) Name() string { return pluginconf.QueueBackendNATS }
+
+func (p *natsPublisher) closeLocked() {
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn, p.js = nil, nil
+	}
+}
+
+// ensureLocked 确保连接可用：连接关闭后按 1 秒退避重新拨号；断线重连中的连接由客户端处理，不重复拨号。
+func (p *natsPublisher) ensureLocked() error {
+	if p.conn != nil && p.conn.IsClosed() {
+		p.conn, p.js = nil, nil
+	}
+	if p.conn != nil {
+		return nil
+	}
+	if !p.nextDial.IsZero() && time.Now().Before(p.nextDial) {
+		return errors.New("queue-plugin: reconnect backoff")
+	}
+	conn, err := nats.Connect(p.servers, p.opts...)
+	if err != nil {
+		p.nextDial = time.Now().Add(1 * time.Second)
+		return err
+	}
+	js, err := jetstream.New(conn, jetstream.WithPublishAsyncTimeout(cfg.publishTimeout))
+	if err != nil {
+		conn.Close()
+		p.nextDial = time.Now().Add(1 * time.Second)
+		return err
+	}
+	p.nextDial = time.Time{}
+	p.conn, p.js = conn, js
+	log(mosqLogInfo, "queue-plugin: connected to nats", map[string]any{"url": conn.ConnectedUrlRedacted()})
+	return nil
+}
+
+// Ready 报告连接是否可用（断线重连中为 false），必要时重新拨号。
+func (p *natsPublisher) Ready() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ensureLocked() == nil && p.conn.IsConnected()
+}
+
+func (p *natsPublisher) jetStream() (jetstream.JetStream, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.ensureLocked(); err != nil {
+		return nil, err
+	}
+	return p.js, nil
+}
+
+// Publish 异步发布到 JetStream，待确认消息过多时最多等待 queue_publish_timeout_ms。
+// queue_confirm=true 时返回 PubAck 对应的 confirmation；否则未确认（出错或超时）的消息在后台按 publishFailed 处理。
+func (p *natsPublisher) Publish(_ context.Context, _ int, msg queuedMessage) (confirmation, error) {
+	js, err := p.jetStream()
+	if err != nil {
+		return nil, err
+	}
+	f, err := js.PublishMsgAsync(natsMessage(msg), jetstream.WithMsgID(msg.key), jetstream.WithStallWait(cfg.publishTimeout))
+	if err != nil {
+		return nil, err
+	}
+	confirm := cfg.confirm
+	c := &natsConfirmation{done: make(chan struct{})}
+	go c.wait(f, msg, confirm)
+	if !confirm {
+		return nil, nil
+	}
+	return c, nil
+}
+
+// Close 在 queue_publish_timeout_ms 内等待已发布消息的确认后关闭连接。
+func (p *natsPublisher) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.js != nil {
+		select {
+		case <-p.js.PublishAsyncComplete():
+		case <-time.After(cfg.publishTimeout):
+			log(mosqLogWarning, "queue-plugin: nats acks pending at close", map[string]any{"pending": p.js.PublishAsyncPending()})
+		}
+	}
+	p.closeLocked()
+	p.nextDial = time.Time{}
+}
+
+// natsConfirmation 在收到 PubAck 或出错（含确认超时、断线）后完成；重复消息的 PubAck 同样视为成功。
+type natsConfirmation struct {
+	done chan struct{}
+	err  error
+}
+
+// wait 等待确认结果；非 confirm 模式下失败的消息交给 publishFailed。
+func (c *natsConfirmation) wait(f jetstream.PubAckFuture, msg queuedMessage, confirm bool) {
+	select {
+	case <-f.Ok():
+	case err := <-f.Err():
+		c.err = err
+	}
+	close(c.done)
+	if c.err != nil && !confirm {
+		publishFailed(msg, c.err)
+	}
+}
+
+func (c *natsConfirmation) Done() <-chan struct{} { return c.done }
+
+func (c *natsConfirmation) Acked() bool {
+	<-c.done
+	return c.err == nil
+}
+
+// natsMessage 构造 NATS 消息：data 与 RabbitMQ 消息体相同，头部为 Content-Type 加上 msg.headers（值统一转为字符串）。
+// 头部名按原样写入，不做 MIME 规范化。
+func natsMessage(msg queuedMessage) *nats.Msg {
+	contentType := msg.contentType
+	if contentType == "" {
+		contentType = contentTypeJSON
+	}
+	m := &nats.Msg{
+		Subject: msg.routingKey,
+		Data:    msg.body,
+		Header:  nats.Header{"Content-Type": []string{contentType}},
+	}
+	keys := make([]string, 0, len(msg.headers))
+	for k := range msg.headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		m.Header[k] = []string{headerString(msg.headers[k])}
+	}
+	return m
+}
+
+// checkNATSSubject 校验渲染后的 subject：非空、不含空白，各层（以 . 分隔）非空且不是通配符 * 或 >。
+func checkNATSSubject(subject string) error {
+	if subject == "" {
+		return errors.New("empty nats subject")
+	}
+	if strings.ContainsFunc(subject, func(r rune) bool { return r <= ' ' || r == 0x7f }) {
+		return fmt.Errorf("nats subject %q contains whitespace or control characters", subject)
+	}
+	for _, tok := range strings.Split(subject, ".") {
+		switch tok {
+		case "":
+			return fmt.Errorf("nats subject %q has an empty token", subject)
+		case "*", ">":
+			return fmt.Errorf("nats subject %q contains wildcard %q", subject, tok)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"unicode/utf8"
+
+	"mosquitto-plugin/internal/pluginconf"
+)
+
+// 消息的 content-type：默认 JSON 信封，raw 模式为原始字节。
+const (
+	contentTypeJSON = "application/json"
+	contentTypeRaw  = "application/octet-stream"
+)
+
+func normalizePayloadJSON(payload []byte) (json.RawMessage, error) {
+	trimmed := bytes.TrimSpace(payload)
+	if len(trimmed) == 0 {
+		return nil, errors.New("payload is empty or whitespace, not valid JSON")
+	}
+	if json.Valid(trimmed) {
+		return trimmed, nil
+	}
+	return nil, errors.New("payload is not valid JSON")
+}
+
+// encodePayload 按 queue_payload_encoding 把 MQTT payload 转为信封中的 payload 字段，
+// 并返回 payload_encoding（JSON 原样内嵌时为空）。raw 模式不经过此函数。
+func encodePayload(mode string, payload []byte) (json.RawMessage, string, error) {
+	switch mode {
+	case pluginconf.PayloadJSONOrBase64:
+		if p, err := normalizePayloadJSON(payload); err == nil {
+			return p, "", nil
+		}
+		return base64Payload(payload), pluginconf.PayloadBase64, nil
+	case pluginconf.PayloadBase64:
+		return base64Payload(payload), pluginconf.PayloadBase64, nil
+	case pluginconf.PayloadText:
+		if !utf8.Valid(payload) {
+			return nil, "", errors.New("payload is not valid UTF-8")
+		}
+		p, err := json.Marshal(string(payload))
+		return p, pluginconf.PayloadText, err
+	default:
+		if len(payload) == 0 {
+			return nil, "", errors.New("payload is empty, valid JSON required")
+		}
+		p, err := normalizePayloadJSON(payload)
+		return p, "", err
+	}
+}
+
+// base64Payload 返回标准 base64 编码后的 JSON 字符串。
+func base64Payload(payload []byte) json.RawMessage {
+	out := make([]byte, 0, base64.StdEncoding.EncodedLen(len(payload))+2)
+	out = append(out, '"')
+	out = base64.StdEncoding.AppendEncode(out, payload)
+	return append(out, '"')
+}
@@ -4,20 +4,89 @@ import (
 	"encoding/json"
 	"strings"
 	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"mosquitto-plugin/internal/pluginconf"
+	"mosquitto-plugin/internal/pluginutil"
 )
 
 func TestAllowMessage(t *testing.T) {
-	if allow, reason := allowMessage("$SYS/broker/uptime"); allow || reason != "sys_topic" {
+	oldCfg := cfg
+	t.Cleanup(func() { cfg = oldCfg })
+	cfg.filter = messageFilter{}
+
+	if allow, reason := allowMessage("$SYS/broker/uptime", "", "", false); allow || reason != "sys_topic" {
 		t.Fatalf("expected $SYS/# to be filtered, allow=%v reason=%q", allow, reason)
 	}
-	if allow, reason := allowMessage("$SYS"); allow || reason != "sys_topic" {
+	if allow, reason := allowMessage("$SYS", "", "", false); allow || reason != "sys_topic" {
 		t.Fatalf("expected $SYS to be filtered, allow=%v reason=%q", allow, reason)
 	}
-	if allow, reason := allowMessage("devices/a/up"); !allow || reason != "" {
+	if allow, reason := allowMessage("devices/a/up", "alice", "c1", true); !allow || reason != "" {
 		t.Fatalf("expected normal topic to pass, allow=%v reason=%q", allow, reason)
 	}
 }
 
+func TestMessageFilter(t *testing.T) {
+	f := messageFilter{
+		includeTopics:  []string{"v1/d/+/up", "v1/d/+/evt/#"},
+		excludeTopics:  []string{"v1/d/test/#"},
+		excludeUsers:   []string{"_*"},
+		includeClients: []string{"dev-*", "gw-??"},
+		dropRetained:   true,
+	}
+	cases := []struct {
+		topic, user, client string
+		retain              bool
+		want                string
+	}{
+		{"v1/d/dev01/up", "dev01", "dev-01", false, ""},
+		{"v1/d/dev01/evt/alarm", "dev01", "gw-01", false, ""},
+		{"v1/d/dev01/up", "dev01", "dev-01", true, "retained"},
+		{"v1/d/test/up", "dev01", "dev-01", false, "exclude_topics"},
+		{"v1/d/dev01/up", "_ops", "dev-01", false, "exclude_users"},
+		{"v1/d/dev01/down", "dev01", "dev-01", false, "include_topics"},
+		{"v1/d/dev01/up", "dev01", "gw-001", false, "include_clients"},
+		{"$SYS/broker/uptime", "", "", false, "sys_topic"},
+	}
+	for _, c := range cases {
+		allow, reason := f.allow(c.topic, c.user, c.client, c.retain)
+		if allow != (c.want == "") || reason != c.want {
+			t.Errorf("allow(%q, %q, %q, %v) = (%v, %q), want reason %q", c.topic, c.user, c.client, c.retain, allow, reason, c.want)
+		}
+	}
+
+	// include 列表中的 # 不匹配 $ 开头的主题。
+	f = messageFilter{includeTopics: []string{"#"}}
+	if allow, _ := f.allow("$aws/things/x", "", "", false); allow {
+		t.Fatal("# should not match $ topics")
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	cases := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"dev-*", "dev-01", true},
+		{"dev-*", "dev-", true},
+		{"dev-*", "gw-01", false},
+		{"*/up", "a/b/up", true},
+		{"gw-??", "gw-01", true},
+		{"gw-??", "gw-1", false},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{"", "", true},
+		{"*", "", true},
+	}
+	for _, c := range cases {
+		if got := globMatch(c.pattern, c.s); got != c.want {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", c.pattern, c.s, got, c.want)
+		}
+	}
+}
+
 func TestParseFailMode(t *testing.T) {
 	if mode, ok := parseFailMode("drop"); !ok || mode != failModeDrop {
 		t.Fatal("expected drop mode")
@@ -75,3 +144,148 @@ func TestNormalizePayloadJSON(t *testing.T) {
 		t.Fatal("empty payload should be rejected as invalid JSON")
 	}
 }
+
+func TestRenderRoutingKey(t *testing.T) {
+	oldCfg := cfg
+	t.Cleanup(func() { cfg = oldCfg })
+
+	msg := &queueMessage{
+		Topic:          "v1/d/dev01/up",
+		Username:       "alice",
+		ClientID:       "dev01",
+		UserProperties: []userProperty{{Key: "tenant", Value: "acme"}, {Key: "tenant", Value: "other"}},
+	}
+	cases := []struct{ tmpl, want string }{
+		{"mqtt.messages", "mqtt.messages"},
+		{"mqtt.{topic_dotted}", "mqtt.v1.d.dev01.up"},
+		{"{username}.{topic[1]}", "alice.d"},
+		{"tenant.{user_prop:tenant}", "tenant.acme"},
+	}
+	for _, c := range cases {
+		tmpl, err := pluginutil.ParseKeyTemplate(c.tmpl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.routingKey, cfg.routingKeyTmpl = c.tmpl, tmpl
+		if got := renderRoutingKey(msg); got != c.want {
+			t.Errorf("renderRoutingKey(%q) = %q, want %q", c.tmpl, got, c.want)
+		}
+	}
+}
+
+func TestTopologyArgs(t *testing.T) {
+	oldCfg := cfg
+	t.Cleanup(func() { cfg = oldCfg })
+
+	cfg.queueType, cfg.dlx = "quorum", "mqtt.dlx"
+	args := queueArgs()
+	if args["x-queue-type"] != "quorum" || args["x-dead-letter-exchange"] != "mqtt.dlx" {
+		t.Fatalf("queueArgs = %v", args)
+	}
+	cfg.queueType, cfg.dlx = "classic", ""
+	if args := queueArgs(); len(args) != 0 {
+		t.Fatalf("classic queueArgs = %v", args)
+	}
+
+	b := pluginconf.QueueBinding{Queue: "q_alice", Key: "mqtt_username=alice&mqtt_client_id=dev01"}
+	cfg.exchangeType = "topic"
+	if key, args, err := bindingArgs(b); err != nil || key != b.Key || args != nil {
+		t.Fatalf("topic bindingArgs = %q, %v, %v", key, args, err)
+	}
+	cfg.exchangeType = "headers"
+	key, args, err := bindingArgs(b)
+	if err != nil || key != "" || args["x-match"] != "all" || args["mqtt_username"] != "alice" || args["mqtt_client_id"] != "dev01" {
+		t.Fatalf("headers bindingArgs = %q, %v, %v", key, args, err)
+	}
+	if _, _, err := bindingArgs(pluginconf.QueueBinding{Queue: "q", Key: "mqtt.#"}); err == nil {
+		t.Fatal("non-header binding on headers exchange should fail")
+	}
+}
+
+func TestMessageHeaders(t *testing.T) {
+	h := messageHeaders(&queueMessage{
+		Topic:          "v1/d/dev01/up",
+		QoS:            1,
+		Retain:         true,
+		ClientID:       "dev01",
+		UserProperties: []userProperty{{Key: "tenant", Value: "acme"}, {Key: "tenant", Value: "other"}},
+	})
+	if h["mqtt_topic"] != "v1/d/dev01/up" || h["mqtt_qos"] != int32(1) || h["mqtt_retain"] != true ||
+		h["mqtt_client_id"] != "dev01" || h["mqtt_prop_tenant"] != "acme" {
+		t.Fatalf("messageHeaders = %v", h)
+	}
+	if _, ok := h["mqtt_username"]; ok {
+		t.Fatal("empty username should be omitted")
+	}
+	if err := h.Validate(); err != nil {
+		t.Fatalf("headers are not a valid AMQP table: %v", err)
+	}
+}
+
+func TestEncodePayload(t *testing.T) {
+	cases := []struct {
+		mode    string
+		payload string
+		want    string
+		wantEnc string
+		wantErr bool
+	}{
+		{pluginconf.PayloadJSON, ` {"k":1} `, `{"k":1}`, "", false},
+		{pluginconf.PayloadJSON, "hello", "", "", true},
+		{pluginconf.PayloadJSON, "", "", "", true},
+		{pluginconf.PayloadJSONOrBase64, `[1,2]`, `[1,2]`, "", false},
+		{pluginconf.PayloadJSONOrBase64, "\x08\x96\x01", `"CJYB"`, "base64", false},
+		{pluginconf.PayloadJSONOrBase64, "", `""`, "base64", false},
+		{pluginconf.PayloadBase64, `{"k":1}`, `"eyJrIjoxfQ=="`, "base64", false},
+		{pluginconf.PayloadText, "温度 \"21\"", `"温度 \"21\""`, "text", false},
+		{pluginconf.PayloadText, "", `""`, "text", false},
+		{pluginconf.PayloadText, "\xff\xfe", "", "", true},
+	}
+	for _, c := range cases {
+		got, enc, err := encodePayload(c.mode, []byte(c.payload))
+		if (err != nil) != c.wantErr {
+			t.Errorf("encodePayload(%s, %q) err = %v", c.mode, c.payload, err)
+			continue
+		}
+		if !c.wantErr && (string(got) != c.want || enc != c.wantEnc) {
+			t.Errorf("encodePayload(%s, %q) = (%s, %q), want (%s, %q)", c.mode, c.payload, got, enc, c.want, c.wantEnc)
+		}
+	}
+
+	data, err := json.Marshal(queueMessage{Topic: "t", Payload: base64Payload([]byte{0}), PayloadEncoding: "base64"})
+	if err != nil || !strings.Contains(string(data), `"payload":"AA==","payload_encoding":"base64"`) {
+		t.Fatalf("envelope = %s, %v", data, err)
+	}
+}
+
+func TestRawPublishing(t *testing.T) {
+	msg := queuedMessage{contentType: contentTypeRaw, headers: messageHeaders(&queueMessage{TS: "2026-01-24T04:00:19Z", Topic: "t"}), body: []byte{0, 1}}
+	p := msg.publishing()
+	if p.ContentType != contentTypeRaw || p.Headers["mqtt_ts"] != "2026-01-24T04:00:19Z" || len(p.Body) != 2 {
+		t.Fatalf("publishing = %+v", p)
+	}
+	if p := (queuedMessage{body: []byte("{}")}).publishing(); p.ContentType != "application/json" {
+		t.Fatalf("default content type = %q", p.ContentType)
+	}
+}
+
+func TestAMQPReturns(t *testing.T) {
+	t.Cleanup(stats.reset)
+	stats.reset()
+	r := &amqpReturns{ch: make(chan amqp.Return, 2), ids: map[string]time.Time{}}
+	// 退回先于 ack 到达：确认完成时查询到的一定是已写入 ch 的记录。
+	r.ch <- amqp.Return{MessageId: "a", ReplyCode: amqp.NoRoute, RoutingKey: "mqtt.up"}
+	if !r.returned("a") {
+		t.Fatal("returned message not reported")
+	}
+	if r.returned("a") || r.returned("b") {
+		t.Fatal("returned should report each message once")
+	}
+	if stats.returned.Load() != 1 {
+		t.Fatalf("stats = %v", stats.fields())
+	}
+	close(r.ch)
+	if r.drainLocked() {
+		t.Fatal("drainLocked should report a closed channel")
+	}
+}
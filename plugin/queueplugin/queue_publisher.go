@@ -2,96 +2,314 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"fmt"
+	"os"
 	"sync"
 	"time"
 
+	"github.com/nats-io/nuid"
 	amqp "github.com/rabbitmq/amqp091-go"
+
+	"mosquitto-plugin/internal/pluginconf"
+	"mosquitto-plugin/internal/pluginutil"
 )
 
-// amqpPublisher 管理连接/通道并负责重连。
+// amqpReturnsSweep 为取出退回记录、清理过期记录的间隔。
+const amqpReturnsSweep = 50 * time.Millisecond
+
+// Publisher 是发送后端（queue_backend）的接口，dispatcher 只通过它发送消息。
+type Publisher interface {
+	// Name 返回后端名（rabbitmq/kafka）。
+	Name() string
+	// Publish 由编号为 worker 的 worker 调用；返回的 confirmation 非 nil 时由 confirmWorker 等待确认（仅 queue_confirm=true）。
+	Publish(ctx context.Context, worker int, msg queuedMessage) (confirmation, error)
+	// Ready 报告后端是否可用，必要时重连；供溢出队列判断是否可以重放。
+	Ready() bool
+	// Close 释放连接。
+	Close()
+}
+
+// amqpPublisher 管理连接与通道池并负责重连：所有 worker 共用一个连接，
+// worker i 使用第 i%queue_channels 个通道。
 type amqpPublisher struct {
 	mu   sync.Mutex
 	conn *amqp.Connection
-	ch   *amqp.Channel
+	// chans 在 plugin_init 中按 queue_channels 创建；通道随连接关闭而失效，使用时按需重新打开。
+	chans []*amqpChannel
 
 	nextDial time.Time
 }
 
-func (p *amqpPublisher) closeLocked() {
-	if p.ch != nil {
-		_ = p.ch.Close()
-		p.ch = nil
+// amqpChannel 是通道池中的一个通道，mu 保证同一通道上的发布串行。
+type amqpChannel struct {
+	mu sync.Mutex
+	ch *amqp.Channel
+	// returns 仅在 queue_confirm=true 时创建，记录以 mandatory 发布后被退回的消息。
+	returns *amqpReturns
+}
+
+// newAMQPPublisher 创建通道池大小为 channels 的发布器并尝试首次连接；连接失败时在发送时重连。
+func newAMQPPublisher(channels int) *amqpPublisher {
+	p := &amqpPublisher{}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.resizeLocked(channels)
+	if err := p.ensureLocked(); err != nil {
+		log(mosqLogWarning, "queue-plugin: initial connect failed", map[string]any{"error": err})
+		p.closeLocked()
 	}
+	return p
+}
+
+func (p *amqpPublisher) Name() string { return pluginconf.QueueBackendRabbitMQ }
+
+// Close 关闭连接并清除重连退避。
+func (p *amqpPublisher) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closeLocked()
+	p.nextDial = time.Time{}
+}
+
+// resizeLocked 重建通道池（旧通道随连接关闭）。
+func (p *amqpPublisher) resizeLocked(n int) {
+	p.chans = make([]*amqpChannel, max(n, 1))
+	for i := range p.chans {
+		p.chans[i] = &amqpChannel{}
+	}
+}
+
+func (p *amqpPublisher) closeLocked() {
 	if p.conn != nil {
+		// 关闭连接会同时关闭其上的全部通道。
 		_ = p.conn.Close()
 		p.conn = nil
 	}
 }
 
+// ensureLocked 确保连接可用；新连接建立后按 queue_declare 声明拓扑。
 func (p *amqpPublisher) ensureLocked() error {
 	if p.conn != nil && p.conn.IsClosed() {
 		p.conn = nil
-		p.ch = nil
 	}
-	if p.ch != nil && p.ch.IsClosed() {
-		p.ch = nil
+	if p.conn != nil {
+		return nil
 	}
-	if p.conn == nil {
-		if !p.nextDial.IsZero() && time.Now().Before(p.nextDial) {
-			return errors.New("queue-plugin: reconnect backoff")
-		}
-		conn, err := amqp.DialConfig(cfg.dsn, amqp.Config{
-			Dial: amqp.DefaultDial(cfg.publishTimeout),
-		})
-		if err != nil {
-			p.nextDial = time.Now().Add(1 * time.Second)
-			return err
-		}
-		p.nextDial = time.Time{}
-		p.conn = conn
-		log(mosqLogInfo, "queue-plugin: connected to rabbitmq")
+	if !p.nextDial.IsZero() && time.Now().Before(p.nextDial) {
+		return errors.New("queue-plugin: reconnect backoff")
 	}
-	if p.ch == nil {
-		ch, err := p.conn.Channel()
-		if err != nil {
-			_ = p.conn.Close()
-			p.conn = nil
+	conn, err := amqp.DialConfig(cfg.dsn, amqp.Config{
+		Dial: amqp.DefaultDial(cfg.publishTimeout),
+	})
+	if err != nil {
+		p.nextDial = time.Now().Add(1 * time.Second)
+		return err
+	}
+	if cfg.declare {
+		if err := declareOnConn(conn); err != nil {
+			// 声明失败（如参数与已有队列冲突）通常需要人工处理，按拨号失败退避，避免每条消息重试。
+			_ = conn.Close()
+			p.nextDial = time.Now().Add(1 * time.Second)
 			return err
 		}
-		p.ch = ch
-		log(mosqLogDebug, "queue-plugin: channel opened")
 	}
+	p.nextDial = time.Time{}
+	p.conn = conn
+	log(mosqLogInfo, "queue-plugin: connected to rabbitmq", map[string]any{"channels": len(p.chans)})
 	return nil
 }
 
-// Publish 发送消息，如果连接/通道关闭会重试一次。
-func (p *amqpPublisher) Publish(ctx context.Context, body []byte) error {
+// Ready 报告连接是否可用，必要时（退避结束后）重连。
+func (p *amqpPublisher) Ready() bool {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	return p.ensureLocked() == nil
+}
 
-	if err := p.ensureLocked(); err != nil {
-		return err
+// channel 返回 worker 对应的通道槽位。
+func (p *amqpPublisher) channel(worker int) *amqpChannel {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.chans) == 0 {
+		p.resizeLocked(1)
 	}
+	return p.chans[worker%len(p.chans)]
+}
 
-	err := p.ch.PublishWithContext(ctx, cfg.exchange, cfg.routingKey, false, false, amqp.Publishing{
-		ContentType: "application/json",
-		Body:        body,
-	})
-	if err == nil {
+// openLocked 在调用方持有 c.mu 时确保通道可用；锁顺序为 c.mu → p.mu。
+func (p *amqpPublisher) openLocked(c *amqpChannel) error {
+	if c.ch != nil && !c.ch.IsClosed() {
 		return nil
 	}
-
-	if errors.Is(err, amqp.ErrClosed) || (p.conn != nil && p.conn.IsClosed()) || (p.ch != nil && p.ch.IsClosed()) {
+	c.ch, c.returns = nil, nil
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.ensureLocked(); err != nil {
+		return err
+	}
+	ch, err := p.conn.Channel()
+	if err != nil {
 		p.closeLocked()
-		if err2 := p.ensureLocked(); err2 != nil {
+		return err
+	}
+	if cfg.confirm {
+		if err := ch.Confirm(false); err != nil {
+			_ = ch.Close()
 			return err
 		}
-		return p.ch.PublishWithContext(ctx, cfg.exchange, cfg.routingKey, false, false, amqp.Publishing{
-			ContentType: "application/json",
-			Body:        body,
-		})
+		c.returns = newAMQPReturns(ch)
+	}
+	c.ch = ch
+	log(mosqLogDebug, "queue-plugin: channel opened")
+	return nil
+}
+
+// Publish 通过 worker 对应的通道发送消息，如果连接/通道关闭会重试一次。
+// confirm 模式下以 mandatory 发布并返回待确认对象（无法路由而被退回的消息按未确认处理），否则为 nil。
+func (p *amqpPublisher) Publish(ctx context.Context, worker int, msg queuedMessage) (confirmation, error) {
+	c := p.channel(worker)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := p.openLocked(c); err != nil {
+		return nil, err
 	}
 
-	return err
+	pub := msg.publishing()
+	if cfg.confirm {
+		// MessageId 用于把 basic.return 对应到本次发布。
+		pub.MessageId = nuid.Next()
+	}
+	dc, err := c.ch.PublishWithDeferredConfirmWithContext(ctx, cfg.exchange, msg.routingKey, c.returns != nil, false, pub)
+	if err != nil && (errors.Is(err, amqp.ErrClosed) || c.ch.IsClosed()) {
+		if err2 := p.openLocked(c); err2 != nil {
+			return nil, err
+		}
+		dc, err = c.ch.PublishWithDeferredConfirmWithContext(ctx, cfg.exchange, msg.routingKey, c.returns != nil, false, pub)
+	}
+	if err != nil || dc == nil {
+		// 避免把 nil 指针包装成非 nil 接口。
+		return nil, err
+	}
+	return &amqpConfirmation{DeferredConfirmation: dc, returns: c.returns, id: pub.MessageId}, nil
+}
+
+// amqpConfirmation 在 broker 确认后再检查消息是否被退回：退回的消息同样会被 ack，但按 nack 处理。
+type amqpConfirmation struct {
+	*amqp.DeferredConfirmation
+	returns *amqpReturns
+	id      string
+}
+
+func (c *amqpConfirmation) Acked() bool {
+	return c.DeferredConfirmation.Acked() && !c.returns.returned(c.id)
+}
+
+// amqpReturns 记录一个通道上被退回（mandatory 且无法路由）的消息 ID。
+// RabbitMQ 先发送 basic.return 再发送对应的 basic.ack：ch 带缓冲，returned 与后台清理都在 mu 下非阻塞取出，
+// 因此确认完成时对应的退回一定已在 ch 或 ids 中。
+type amqpReturns struct {
+	mu  sync.Mutex
+	ch  chan amqp.Return
+	ids map[string]time.Time
+}
+
+func newAMQPReturns(ch *amqp.Channel) *amqpReturns {
+	r := &amqpReturns{ch: ch.NotifyReturn(make(chan amqp.Return, confirmWindow)), ids: map[string]time.Time{}}
+	go r.sweep()
+	return r
+}
+
+// sweep 定期取出退回记录，避免缓冲区写满阻塞连接，并清理超过两倍确认超时仍无人查询的记录；通道关闭后退出。
+func (r *amqpReturns) sweep() {
+	ticker := time.NewTicker(amqpReturnsSweep)
+	defer ticker.Stop()
+	for range ticker.C {
+		r.mu.Lock()
+		open := r.drainLocked()
+		cutoff := time.Now().Add(-2 * cfg.confirmTimeout)
+		for id, at := range r.ids {
+			if at.Before(cutoff) {
+				delete(r.ids, id)
+			}
+		}
+		r.mu.Unlock()
+		if !open {
+			return
+		}
+	}
+}
+
+// drainLocked 非阻塞地取出已到达的退回记录；通道关闭后返回 false。
+func (r *amqpReturns) drainLocked() bool {
+	for {
+		select {
+		case ret, ok := <-r.ch:
+			if !ok {
+				return false
+			}
+			r.ids[ret.MessageId] = time.Now()
+			stats.returned.Add(1)
+			if pluginutil.ShouldSample(&returnWarnCounter, debugSampleEvery) {
+				log(mosqLogWarning, "queue-plugin: message returned by broker", map[string]any{
+					"reply_code":  int(ret.ReplyCode),
+					"reply_text":  ret.ReplyText,
+					"exchange":    ret.Exchange,
+					"routing_key": ret.RoutingKey,
+				})
+			}
+		default:
+			return true
+		}
+	}
+}
+
+// returned 报告 id 对应的消息是否被退回，并清除记录。
+func (r *amqpReturns) returned(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.drainLocked()
+	_, ok := r.ids[id]
+	delete(r.ids, id)
+	return ok
+}
+
+// publishing 构造 AMQP 消息。
+func (m queuedMessage) publishing() amqp.Publishing {
+	contentType := m.contentType
+	if contentType == "" {
+		contentType = contentTypeJSON
+	}
+	return amqp.Publishing{
+		ContentType: contentType,
+		Headers:     m.headers,
+		Body:        m.body,
+	}
+}
+
+// clientTLSConfig 按 <prefix>_tls_* 配置加载 CA 与客户端证书；未配置 CA 时使用系统根证书。
+func clientTLSConfig(prefix, caFile, certFile, keyFile string) (*tls.Config, error) {
+	tc := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read %s_tls_ca_file: %w", prefix, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s_tls_ca_file contains no PEM certificates", prefix)
+		}
+		tc.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load %s_tls_cert_file/%s_tls_key_file: %w", prefix, prefix, err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return tc, nil
 }
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"mosquitto-plugin/internal/pluginutil"
+)
+
+// maxRoutingKeyLen 是 AMQP 0-9-1 shortstr 的上限。
+const maxRoutingKeyLen = 255
+
+// renderRoutingKey 按 queue_routing_key 模板为消息生成 routing key；固定值不做逐条渲染。
+func renderRoutingKey(msg *queueMessage) string {
+	tmpl := cfg.routingKeyTmpl
+	if tmpl == nil || tmpl.Static() {
+		return cfg.routingKey
+	}
+	return renderTemplate(tmpl, msg)
+}
+
+// renderTemplate 按消息字段渲染键模板（kafka topic/消息键等）；tmpl 为 nil 时返回空串。
+func renderTemplate(tmpl *pluginutil.KeyTemplate, msg *queueMessage) string {
+	if tmpl == nil {
+		return ""
+	}
+	return tmpl.Render(pluginutil.KeyFields{
+		Topic:        msg.Topic,
+		Username:     msg.Username,
+		ClientID:     msg.ClientID,
+		UserProperty: msg.userProperty,
+	})
+}
+
+// userProperty 返回同名的第一个 MQTT v5 用户属性。
+func (m *queueMessage) userProperty(key string) (string, bool) {
+	for _, p := range m.UserProperties {
+		if p.Key == key {
+			return p.Value, true
+		}
+	}
+	return "", false
+}
+
+// headerString 把头部值转为字符串，供只支持字符串头部的后端（Kafka/NATS）使用。
+func headerString(v any) string {
+	switch hv := v.(type) {
+	case string:
+		return hv
+	case int32:
+		return strconv.Itoa(int(hv))
+	case bool:
+		return strconv.FormatBool(hv)
+	default:
+		return fmt.Sprint(hv)
+	}
+}
+
+// messageHeaders 返回 headers exchange 与 raw 模式使用的 AMQP 头部：消息元数据与 MQTT v5 用户属性（mqtt_prop_<name>，同名取第一个）。
+func messageHeaders(msg *queueMessage) amqp.Table {
+	h := amqp.Table{
+		"mqtt_ts":     msg.TS,
+		"mqtt_topic":  msg.Topic,
+		"mqtt_qos":    int32(msg.QoS),
+		"mqtt_retain": msg.Retain,
+	}
+	for k, v := range map[string]string{
+		"mqtt_client_id": msg.ClientID,
+		"mqtt_username":  msg.Username,
+		"mqtt_peer":      msg.Peer,
+		"mqtt_protocol":  msg.Protocol,
+	} {
+		if v != "" {
+			h[k] = v
+		}
+	}
+	for _, p := range msg.UserProperties {
+		if _, ok := h["mqtt_prop_"+p.Key]; !ok {
+			h["mqtt_prop_"+p.Key] = p.Value
+		}
+	}
+	return h
+}
@@ -0,0 +1,556 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	spoolSegmentExt = ".seg"
+	spoolCursorFile = "spool.pos"
+	// spoolRecordHeader 为每条记录的头部：4 字节长度 + 4 字节 CRC32（大端）。
+	spoolRecordHeader = 8
+	// spoolMaxRecord 防止损坏的长度字段导致超大分配。
+	spoolMaxRecord = 256 << 20
+)
+
+var errSpoolFull = errors.New("queue-plugin: spool full")
+
+// diskSpool 是 RabbitMQ 不可用或内存队列已满时使用的磁盘溢出队列：
+// 目录下按序号命名的分段文件（00000000000000000001.seg …）只追加写入，从最早的分段顺序读取，
+// 读完的分段即删除。读取位置在删除分段与关闭时写入 spool.pos；进程崩溃时从当前分段开头重放（可能重复）。
+type diskSpool struct {
+	dir          string
+	maxBytes     int64
+	segmentBytes int64
+
+	mu sync.Mutex
+	// segs 为现存分段序号（升序），sizes 为对应文件大小；最后一个为写入分段。
+	segs  []uint64
+	sizes []int64
+	w     *os.File
+	r     *os.File
+	rOff  int64
+	// pending 为 Peek 返回、尚未 Advance 的记录长度。
+	pending int64
+	// bytes 为未读取的字节数（含记录头）。
+	bytes int64
+
+	// notify 在追加记录时发送信号，唤醒重放协程。
+	notify chan struct{}
+}
+
+// openSpool 打开（或创建）溢出目录，截断最后一个分段中写了一半的记录，并从 spool.pos 恢复读取位置。
+func openSpool(dir string, maxBytes, segmentBytes int64) (*diskSpool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	s := &diskSpool{dir: dir, maxBytes: maxBytes, segmentBytes: segmentBytes, notify: make(chan struct{}, 1)}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), spoolSegmentExt)
+		if !ok || e.IsDir() {
+			continue
+		}
+		seq, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		s.segs = append(s.segs, seq)
+	}
+	sort.Slice(s.segs, func(i, j int) bool { return s.segs[i] < s.segs[j] })
+
+	headSeq, headOff := s.readCursor()
+	for len(s.segs) > 0 && s.segs[0] < headSeq {
+		// 已读完但删除前进程退出的分段。
+		_ = os.Remove(s.segPath(s.segs[0]))
+		s.segs = s.segs[1:]
+	}
+	if len(s.segs) == 0 {
+		s.segs = []uint64{max(headSeq, 1)}
+		headOff = 0
+	} else if s.segs[0] != headSeq {
+		headOff = 0
+	}
+
+	s.sizes = make([]int64, len(s.segs))
+	for i, seq := range s.segs {
+		if i == len(s.segs)-1 {
+			size, err := repairSegment(s.segPath(seq))
+			if err != nil {
+				return nil, err
+			}
+			s.sizes[i] = size
+			continue
+		}
+		st, err := os.Stat(s.segPath(seq))
+		if err != nil {
+			return nil, err
+		}
+		s.sizes[i] = st.Size()
+	}
+	s.rOff = min(headOff, s.sizes[0])
+	for _, size := range s.sizes {
+		s.bytes += size
+	}
+	s.bytes -= s.rOff
+
+	tail := s.segs[len(s.segs)-1]
+	if s.w, err = os.OpenFile(s.segPath(tail), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600); err != nil {
+		return nil, err
+	}
+	if s.bytes > 0 {
+		s.signal()
+	}
+	return s, nil
+}
+
+func (s *diskSpool) segPath(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, spoolSegmentExt))
+}
+
+// readCursor 读取 spool.pos（"序号 偏移"）；不存在或格式错误时从头读取。
+func (s *diskSpool) readCursor() (uint64, int64) {
+	data, err := os.ReadFile(filepath.Join(s.dir, spoolCursorFile))
+	if err != nil {
+		return 0, 0
+	}
+	var seq uint64
+	var off int64
+	if _, err := fmt.Sscanf(string(data), "%d %d", &seq, &off); err != nil || off < 0 {
+		return 0, 0
+	}
+	return seq, off
+}
+
+// writeCursorLocked 原子地写入读取位置。
+func (s *diskSpool) writeCursorLocked() error {
+	tmp := filepath.Join(s.dir, spoolCursorFile+".tmp")
+	if err := os.WriteFile(tmp, []byte(fmt.Sprintf("%d %d\n", s.segs[0], s.rOff)), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.dir, spoolCursorFile))
+}
+
+// repairSegment 返回分段中完整记录的总长度，并截断末尾不完整或校验失败的记录（崩溃时写了一半）。
+func repairSegment(path string) (int64, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	var good int64
+	for {
+		_, n, err := readSpoolRecord(br)
+		if err != nil {
+			break
+		}
+		good += n
+	}
+	st, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if st.Size() != good {
+		if err := f.Truncate(good); err != nil {
+			return 0, err
+		}
+	}
+	return good, nil
+}
+
+func (s *diskSpool) signal() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Len 返回未读取的字节数。
+func (s *diskSpool) Len() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bytes
+}
+
+// Append 把消息追加到写入分段；超过 maxBytes 时返回 errSpoolFull。
+// 不逐条 fsync：进程崩溃不丢数据，主机掉电可能丢失最近写入的记录。
+func (s *diskSpool) Append(msg queuedMessage) error {
+	rec := encodeSpoolRecord(msg)
+	n := int64(len(rec))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.w == nil {
+		return errSpoolClosed
+	}
+	if s.bytes+n > s.maxBytes {
+		return errSpoolFull
+	}
+	tail := len(s.segs) - 1
+	if s.sizes[tail] > 0 && s.sizes[tail]+n > s.segmentBytes {
+		if err := s.rotateLocked(); err != nil {
+			return err
+		}
+		tail++
+	}
+	if _, err := s.w.Write(rec); err != nil {
+		return err
+	}
+	s.sizes[tail] += n
+	s.bytes += n
+	s.signal()
+	return nil
+}
+
+var errSpoolClosed = errors.New("queue-plugin: spool closed")
+
+// rotateLocked 结束当前写入分段并新建下一个分段。
+func (s *diskSpool) rotateLocked() error {
+	_ = s.w.Sync()
+	_ = s.w.Close()
+	seq := s.segs[len(s.segs)-1] + 1
+	w, err := os.OpenFile(s.segPath(seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		s.w = nil
+		return err
+	}
+	s.w = w
+	s.segs = append(s.segs, seq)
+	s.sizes = append(s.sizes, 0)
+	return nil
+}
+
+// Peek 读取下一条记录但不移动读取位置；ok=false 表示没有数据。
+// 分段无法读取或记录损坏时跳过所在分段的剩余部分并返回错误。
+func (s *diskSpool) Peek() (msg queuedMessage, ok bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.bytes == 0 || s.w == nil {
+		return queuedMessage{}, false, nil
+	}
+	var n int64
+	if s.r == nil {
+		s.r, err = os.Open(s.segPath(s.segs[0]))
+	}
+	if err == nil {
+		_, err = s.r.Seek(s.rOff, io.SeekStart)
+	}
+	if err == nil {
+		var payload []byte
+		if payload, n, err = readSpoolRecord(io.LimitReader(s.r, s.sizes[0]-s.rOff)); err == nil {
+			msg, err = decodeSpoolRecord(payload)
+		}
+	}
+	if err != nil {
+		skipped := s.sizes[0] - s.rOff
+		s.pending = skipped
+		s.advanceLocked()
+		return queuedMessage{}, false, fmt.Errorf("segment %d corrupt, skipped %d bytes: %w", s.segs[0], skipped, err)
+	}
+	s.pending = n
+	return msg, true, nil
+}
+
+// Advance 确认 Peek 返回的记录已交给内存队列。
+func (s *diskSpool) Advance() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.advanceLocked()
+}
+
+func (s *diskSpool) advanceLocked() {
+	s.rOff += s.pending
+	s.bytes -= s.pending
+	s.pending = 0
+	if s.rOff < s.sizes[0] {
+		return
+	}
+	if len(s.segs) == 1 {
+		if s.sizes[0] == 0 {
+			return
+		}
+		// 唯一的分段已读完：换新分段，避免文件持续增长。
+		if err := s.rotateLocked(); err != nil {
+			return
+		}
+	}
+	if s.r != nil {
+		_ = s.r.Close()
+		s.r = nil
+	}
+	_ = os.Remove(s.segPath(s.segs[0]))
+	s.segs, s.sizes, s.rOff = s.segs[1:], s.sizes[1:], 0
+	_ = s.writeCursorLocked()
+}
+
+// Close 同步写入分段并保存读取位置。
+func (s *diskSpool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.w == nil {
+		return nil
+	}
+	_ = s.w.Sync()
+	err := s.w.Close()
+	s.w = nil
+	if s.r != nil {
+		_ = s.r.Close()
+		s.r = nil
+	}
+	if cerr := s.writeCursorLocked(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// readSpoolRecord 读取一条记录，返回负载与记录总长度。
+func readSpoolRecord(r io.Reader) ([]byte, int64, error) {
+	var hdr [spoolRecordHeader]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, 0, err
+	}
+	size := binary.BigEndian.Uint32(hdr[0:4])
+	if size > spoolMaxRecord {
+		return nil, 0, fmt.Errorf("record length %d too large", size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, 0, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(hdr[4:8]) {
+		return nil, 0, errors.New("record checksum mismatch")
+	}
+	return payload, spoolRecordHeader + int64(size), nil
+}
+
+// AMQP 头部值的类型标记（messageHeaders 只产生这三种）。
+const (
+	spoolHeaderString = 's'
+	spoolHeaderInt    = 'i'
+	spoolHeaderBool   = 'b'
+)
+
+// encodeSpoolRecord 编码一条记录（含记录头）：
+// routing key、client_id、content-type、kafka 键、attempt、头部（键、类型、值）后接 body。
+func encodeSpoolRecord(msg queuedMessage) []byte {
+	buf := make([]byte, spoolRecordHeader, spoolRecordHeader+64+len(msg.body))
+	for _, s := range []string{msg.routingKey, msg.clientID, msg.contentType, msg.key} {
+		buf = appendSpoolString(buf, s)
+	}
+	buf = binary.AppendUvarint(buf, uint64(msg.attempt))
+	keys := make([]string, 0, len(msg.headers))
+	for k := range msg.headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	buf = binary.AppendUvarint(buf, uint64(len(keys)))
+	for _, k := range keys {
+		buf = appendSpoolString(buf, k)
+		switch v := msg.headers[k].(type) {
+		case int32:
+			buf = append(buf, spoolHeaderInt)
+			buf = binary.AppendVarint(buf, int64(v))
+		case bool:
+			buf = append(buf, spoolHeaderBool)
+			if v {
+				buf = append(buf, 1)
+			} else {
+				buf = append(buf, 0)
+			}
+		default:
+			buf = append(buf, spoolHeaderString)
+			buf = appendSpoolString(buf, fmt.Sprint(v))
+		}
+	}
+	buf = append(buf, msg.body...)
+	payload := buf[spoolRecordHeader:]
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(payload))
+	return buf
+}
+
+func appendSpoolString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// spoolDecoder 按 encodeSpoolRecord 的顺序读取字段。
+type spoolDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *spoolDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = errors.New("truncated record")
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *spoolDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = errors.New("truncated record")
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *spoolDecoder) bytes(n uint64) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if uint64(len(d.buf)) < n {
+		d.err = errors.New("truncated record")
+		return nil
+	}
+	out := d.buf[:n]
+	d.buf = d.buf[n:]
+	return out
+}
+
+func (d *spoolDecoder) string() string {
+	return string(d.bytes(d.uvarint()))
+}
+
+func decodeSpoolRecord(payload []byte) (queuedMessage, error) {
+	d := &spoolDecoder{buf: payload}
+	msg := queuedMessage{routingKey: d.string(), clientID: d.string(), contentType: d.string(), key: d.string()}
+	msg.attempt = int(d.uvarint())
+	if count := d.uvarint(); count > 0 && d.err == nil {
+		msg.headers = make(amqp.Table, count)
+		for i := uint64(0); i < count && d.err == nil; i++ {
+			k := d.string()
+			switch t := d.bytes(1); {
+			case d.err != nil:
+			case t[0] == spoolHeaderInt:
+				msg.headers[k] = int32(d.varint())
+			case t[0] == spoolHeaderBool:
+				msg.headers[k] = d.bytes(1)[0] == 1
+			default:
+				msg.headers[k] = d.string()
+			}
+		}
+	}
+	if d.err != nil {
+		return queuedMessage{}, d.err
+	}
+	msg.body = append([]byte(nil), d.buf...)
+	return msg, nil
+}
+
+var (
+	// spool 在 queue_spool_dir 非空时由 plugin_init 打开；startDispatcher 读取它并启动重放协程。
+	spool *diskSpool
+	// spoolActive 记录溢出队列是否有数据，仅用于输出“开始落盘/已排空”日志。
+	spoolActive atomic.Bool
+	// spoolReadyFn 判断后端是否可用（可以重放），便于测试替换。
+	spoolReadyFn = func() bool { return publisher != nil && publisher.Ready() }
+	// spoolRetryWait 为后端不可用时重放协程的检查间隔。
+	spoolRetryWait = time.Second
+)
+
+// closeSpool 关闭溢出队列；需在 stopDispatcher 之后调用。
+func closeSpool() {
+	if spool == nil {
+		return
+	}
+	if err := spool.Close(); err != nil {
+		log(mosqLogWarning, "queue-plugin: spool close failed", map[string]any{"error": err})
+	}
+	spool = nil
+	spoolActive.Store(false)
+}
+
+// spillMessage 把消息写入溢出队列；首次由空变为非空时输出日志。
+func spillMessage(sp *diskSpool, msg queuedMessage) error {
+	if err := sp.Append(msg); err != nil {
+		return err
+	}
+	stats.spooled.Add(1)
+	if spoolActive.CompareAndSwap(false, true) {
+		log(mosqLogWarning, "queue-plugin: spooling to disk", map[string]any{"spool_bytes": sp.Len()})
+	}
+	return nil
+}
+
+// replayWorker 在后端可用时按写入顺序把溢出队列中的消息移回内存队列。
+// 内存队列已满时阻塞，溢出队列非空期间新消息继续写入溢出队列，保证顺序。
+func replayWorker(sp *diskSpool, stop, drain <-chan struct{}) {
+	for {
+		if sp.Len() == 0 {
+			if spoolActive.CompareAndSwap(true, false) {
+				log(mosqLogInfo, "queue-plugin: spool drained", map[string]any{"replayed": stats.replayed.Load()})
+			}
+			select {
+			case <-sp.notify:
+			case <-stop:
+				return
+			case <-drain:
+				return
+			}
+			continue
+		}
+		if !spoolReadyFn() {
+			select {
+			case <-time.After(spoolRetryWait):
+			case <-stop:
+				return
+			case <-drain:
+				return
+			}
+			continue
+		}
+		msg, ok, err := sp.Peek()
+		if err != nil {
+			log(mosqLogError, "queue-plugin: spool read failed", map[string]any{"error": err})
+			continue
+		}
+		if !ok {
+			continue
+		}
+		dispatchMu.RLock()
+		ch := pickQueue(dispatchChs, msg.clientID)
+		dispatchMu.RUnlock()
+		if ch == nil {
+			return
+		}
+		select {
+		case ch <- msg:
+			sp.Advance()
+			stats.replayed.Add(1)
+		case <-stop:
+			return
+		case <-drain:
+			return
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func spoolMessage(i int) queuedMessage {
+	return queuedMessage{
+		routingKey: fmt.Sprintf("rk.%d", i),
+		clientID:   "dev01",
+		body:       []byte(fmt.Sprintf(`{"n":%d}`, i)),
+	}
+}
+
+// drainSpool 读出全部记录。
+func drainSpool(t *testing.T, sp *diskSpool) []queuedMessage {
+	t.Helper()
+	var out []queuedMessage
+	for sp.Len() > 0 {
+		msg, ok, err := sp.Peek()
+		if err != nil {
+			t.Fatalf("Peek: %v", err)
+		}
+		if !ok {
+			t.Fatal("Peek returned no record with bytes pending")
+		}
+		sp.Advance()
+		out = append(out, msg)
+	}
+	return out
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*"+spoolSegmentExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestSpoolRecordRoundTrip(t *testing.T) {
+	t.Parallel()
+	msg := queuedMessage{
+		routingKey:  "mqtt.v1.up",
+		clientID:    "dev01",
+		contentType: contentTypeRaw,
+		key:         "dev01",
+		attempt:     2,
+		headers:     amqp.Table{"mqtt_topic": "v1/up", "mqtt_qos": int32(1), "mqtt_retain": true},
+		body:        []byte{0, 1, 2, 0xff},
+	}
+	rec := encodeSpoolRecord(msg)
+	got, err := decodeSpoolRecord(rec[spoolRecordHeader:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, msg) {
+		t.Fatalf("decoded %+v, want %+v", got, msg)
+	}
+	if _, err := decodeSpoolRecord(rec[spoolRecordHeader : len(rec)-len(msg.body)-3]); err == nil {
+		t.Fatal("truncated record should fail")
+	}
+}
+
+func TestSpoolSegmentsAndCursor(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	sp, err := openSpool(dir, 1<<20, 128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := sp.Append(spoolMessage(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(segmentFiles(t, dir)); n < 3 {
+		t.Fatalf("expected rotation into several segments, got %d", n)
+	}
+	for i := 0; i < 4; i++ {
+		msg, ok, err := sp.Peek()
+		if err != nil || !ok || msg.routingKey != spoolMessage(i).routingKey {
+			t.Fatalf("Peek #%d = %+v %v %v", i, msg, ok, err)
+		}
+		sp.Advance()
+	}
+	if err := sp.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重新打开后从保存的位置继续，且顺序不变。
+	sp, err = openSpool(dir, 1<<20, 128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+	got := drainSpool(t, sp)
+	if len(got) != 6 {
+		t.Fatalf("replayed %d messages after reopen, want 6", len(got))
+	}
+	for i, msg := range got {
+		if want := spoolMessage(i + 4); !reflect.DeepEqual(msg, want) {
+			t.Fatalf("message %d = %+v, want %+v", i, msg, want)
+		}
+	}
+	if n := len(segmentFiles(t, dir)); n != 1 {
+		t.Fatalf("consumed segments should be removed, %d left", n)
+	}
+}
+
+func TestSpoolFull(t *testing.T) {
+	t.Parallel()
+	rec := int64(len(encodeSpoolRecord(spoolMessage(0))))
+	sp, err := openSpool(t.TempDir(), 2*rec, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+	for i := 0; i < 2; i++ {
+		if err := sp.Append(spoolMessage(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sp.Append(spoolMessage(2)); !errors.Is(err, errSpoolFull) {
+		t.Fatalf("Append over limit err = %v, want %v", err, errSpoolFull)
+	}
+	if _, ok, _ := sp.Peek(); !ok {
+		t.Fatal("expected a record")
+	}
+	sp.Advance()
+	if err := sp.Append(spoolMessage(2)); err != nil {
+		t.Fatalf("Append after read: %v", err)
+	}
+}
+
+func TestSpoolTruncatesPartialRecord(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	sp, err := openSpool(dir, 1<<20, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := sp.Append(spoolMessage(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sp.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// 模拟崩溃时写了一半的记录。
+	seg := segmentFiles(t, dir)[0]
+	partial := encodeSpoolRecord(spoolMessage(3))
+	f, err := os.OpenFile(seg, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(partial[:len(partial)/2]); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	sp, err = openSpool(dir, 1<<20, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+	if err := sp.Append(spoolMessage(4)); err != nil {
+		t.Fatal(err)
+	}
+	got := drainSpool(t, sp)
+	var keys []string
+	for _, msg := range got {
+		keys = append(keys, msg.routingKey)
+	}
+	if want := []string{"rk.0", "rk.1", "rk.2", "rk.4"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("replayed %v, want %v", keys, want)
+	}
+}
+
+func TestDispatcherSpillsAndReplaysInOrder(t *testing.T) {
+	oldCfg := cfg
+	oldPublish := dispatchPublishFn
+	oldReady := spoolReadyFn
+	oldWait := spoolRetryWait
+	sp, err := openSpool(t.TempDir(), 1<<20, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spool = sp
+
+	var ready atomic.Bool
+	spoolReadyFn = ready.Load
+	spoolRetryWait = 5 * time.Millisecond
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var published []string
+	dispatchPublishFn = func(_ int, msg queuedMessage) {
+		<-release
+		mu.Lock()
+		published = append(published, msg.routingKey)
+		mu.Unlock()
+	}
+	cfg.failMode = failModeDrop
+	cfg.workers, cfg.ordering = 1, orderingClient
+	t.Cleanup(func() {
+		stopDispatcher()
+		closeSpool()
+		dispatchPublishFn = oldPublish
+		spoolReadyFn = oldReady
+		spoolRetryWait = oldWait
+		cfg = oldCfg
+	})
+
+	startDispatcher(1)
+	const total = 20
+	for i := 0; i < total; i++ {
+		if err := enqueueMessage(spoolMessage(i)); err != nil {
+			t.Fatalf("enqueue %d: %v", i, err)
+		}
+	}
+	if sp.Len() == 0 || stats.spooled.Load() == 0 {
+		t.Fatal("expected messages to spill to disk while the queue is full")
+	}
+
+	close(release)
+	ready.Store(true)
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		n := len(published)
+		mu.Unlock()
+		if n == total {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(published) != total {
+		t.Fatalf("published %d messages, want %d", len(published), total)
+	}
+	for i, key := range published {
+		if key != spoolMessage(i).routingKey {
+			t.Fatalf("message %d = %s, out of order: %v", i, key, published)
+		}
+	}
+	if sp.Len() != 0 {
+		t.Fatalf("spool not drained: %d bytes", sp.Len())
+	}
+}
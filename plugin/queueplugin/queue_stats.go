@@ -0,0 +1,62 @@
+package main
+
+import (
+	"sync/atomic"
+
+	"mosquitto-plugin/internal/pluginutil"
+)
+
+// queueStats 是 worker 侧的累计计数，按 queue_stats_interval_ms 与插件停止时输出到日志。
+type queueStats struct {
+	// published 为写入通道成功的次数（含重试）。
+	published atomic.Uint64
+	// failed 为写入通道失败的次数（连接不可用、超时等）。
+	failed atomic.Uint64
+	// confirmed/nacked 仅在 queue_confirm=true 时计数；unconfirmed 为等待确认超时。
+	confirmed   atomic.Uint64
+	nacked      atomic.Uint64
+	unconfirmed atomic.Uint64
+	// returned 为 mandatory 发布后无法路由、被 broker 退回的次数（仅 rabbitmq 且 queue_confirm=true），同时计入 nacked。
+	returned atomic.Uint64
+	// retried 为重新入队的次数，dropped 为超过 queue_retry_max 或重新入队失败而放弃的消息数。
+	retried atomic.Uint64
+	dropped atomic.Uint64
+	// spooled/replayed 为写入与移出磁盘溢出队列的消息数（queue_spool_dir）。
+	spooled  atomic.Uint64
+	replayed atomic.Uint64
+	// lost 为插件停止时未发出（内存队列、等待确认、等待重试）且未落盘的消息数。
+	lost atomic.Uint64
+}
+
+var (
+	stats    queueStats
+	statsJob pluginutil.PeriodicJob
+)
+
+func (s *queueStats) reset() {
+	for _, c := range []*atomic.Uint64{&s.published, &s.failed, &s.confirmed, &s.nacked, &s.unconfirmed, &s.returned, &s.retried, &s.dropped, &s.spooled, &s.replayed, &s.lost} {
+		c.Store(0)
+	}
+}
+
+// fields 返回用于日志的计数快照。
+func (s *queueStats) fields() map[string]any {
+	f := map[string]any{
+		"published":   s.published.Load(),
+		"failed":      s.failed.Load(),
+		"confirmed":   s.confirmed.Load(),
+		"nacked":      s.nacked.Load(),
+		"unconfirmed": s.unconfirmed.Load(),
+		"returned":    s.returned.Load(),
+		"retried":     s.retried.Load(),
+		"dropped":     s.dropped.Load(),
+		"buffered":    dispatchBuffered(),
+		"lost":        s.lost.Load(),
+	}
+	if sp := spool; sp != nil {
+		f["spooled"] = s.spooled.Load()
+		f["replayed"] = s.replayed.Load()
+		f["spool_bytes"] = sp.Len()
+	}
+	return f
+}
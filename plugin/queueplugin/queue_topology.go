@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"mosquitto-plugin/internal/pluginconf"
+)
+
+// declareOnConn 在新连接上用临时通道声明拓扑。
+func declareOnConn(conn *amqp.Connection) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+	return declareTopology(ch)
+}
+
+// declareTopology 在 queue_declare=true 时声明 exchange、队列与绑定（均为 durable，重复声明是幂等的）。
+func declareTopology(ch *amqp.Channel) error {
+	if err := ch.ExchangeDeclare(cfg.exchange, cfg.exchangeType, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("declare exchange %s: %w", cfg.exchange, err)
+	}
+	for _, b := range cfg.declareQueues {
+		if _, err := ch.QueueDeclare(b.Queue, true, false, false, false, queueArgs()); err != nil {
+			return fmt.Errorf("declare queue %s: %w", b.Queue, err)
+		}
+		key, args, err := bindingArgs(b)
+		if err != nil {
+			return err
+		}
+		if err := ch.QueueBind(b.Queue, key, cfg.exchange, false, args); err != nil {
+			return fmt.Errorf("bind queue %s: %w", b.Queue, err)
+		}
+	}
+	log(mosqLogInfo, "queue-plugin: topology declared", map[string]any{
+		"exchange":      cfg.exchange,
+		"exchange_type": cfg.exchangeType,
+		"queues":        len(cfg.declareQueues),
+	})
+	return nil
+}
+
+// queueArgs 返回声明队列时的参数（x-queue-type、x-dead-letter-exchange）。
+func queueArgs() amqp.Table {
+	args := amqp.Table{}
+	if cfg.queueType == "quorum" {
+		args["x-queue-type"] = "quorum"
+	}
+	if cfg.dlx != "" {
+		args["x-dead-letter-exchange"] = cfg.dlx
+	}
+	return args
+}
+
+// bindingArgs 返回绑定键与参数；headers exchange 忽略绑定键，改用 x-match=all 的头部匹配。
+func bindingArgs(b pluginconf.QueueBinding) (string, amqp.Table, error) {
+	if cfg.exchangeType != "headers" {
+		return b.Key, nil, nil
+	}
+	headers, err := b.HeaderArgs()
+	if err != nil {
+		return "", nil, err
+	}
+	args := amqp.Table{"x-match": "all"}
+	for k, v := range headers {
+		args[k] = v
+	}
+	return "", args, nil
+}
@@ -3,18 +3,29 @@ package main
 import (
 	"encoding/json"
 	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"mosquitto-plugin/internal/pluginconf"
+	"mosquitto-plugin/internal/pluginutil"
 )
 
 // failMode 控制发布到 RabbitMQ 失败时的处理策略。
-type failMode int
+type failMode = pluginconf.FailMode
 
 const (
-	failModeDrop failMode = iota
-	failModeBlock
-	failModeDisconnect
+	failModeDrop       = pluginconf.FailModeDrop
+	failModeBlock      = pluginconf.FailModeBlock
+	failModeDisconnect = pluginconf.FailModeDisconnect
 
 	defaultDispatchBuffer = 4096
-	debugSampleEvery      = uint64(128)
+
+	orderingNone     = pluginconf.OrderingNone
+	orderingClient   = pluginconf.OrderingClient
+	debugSampleEvery = uint64(128)
+
+	// confirmWindow 是 queue_confirm=true 时已发布未确认消息的上限。
+	confirmWindow = 1024
 )
 
 // config 保存从 Mosquitto 配置解析出的运行参数。
@@ -24,23 +35,74 @@ type config struct {
 	exchange       string
 	exchangeType   string
 	routingKey     string
+	routingKeyTmpl *pluginutil.KeyTemplate
+	// declare=true 时连接后声明 exchange 与 declareQueues 中的队列及绑定。
+	declare        bool
+	declareQueues  []pluginconf.QueueBinding
+	queueType      string
+	dlx            string
 	enqueueTimeout time.Duration
 	publishTimeout time.Duration
 	failMode       failMode
+	// payloadEncoding 为 queue_payload_encoding（pluginconf.Payload*）。
+	payloadEncoding string
+	filter          messageFilter
+	// confirm=true 时通道进入 publisher confirm 模式，nack/超时的消息按 retry* 重新入队。
+	confirm         bool
+	confirmTimeout  time.Duration
+	retryMax        int
+	retryBackoff    time.Duration
+	retryBackoffMax time.Duration
+	statsInterval   time.Duration
+	// workers 为发送 worker 数，channels 为 AMQP 通道池大小，ordering 为 none/client。
+	workers  int
+	channels int
+	ordering string
+	buffer   int
+	// shutdownDrain 为插件停止时继续发送内存队列的最长时间，0 表示不等待。
+	shutdownDrain time.Duration
+	// spoolDir 非空时启用磁盘溢出队列，spoolMaxBytes 为总大小上限，spoolSegmentBytes 为单个分段大小。
+	spoolDir          string
+	spoolMaxBytes     int64
+	spoolSegmentBytes int64
+	// kafka 仅在 backend=kafka 时使用。
+	kafka kafkaConfig
+	// nats 仅在 backend=nats 时使用。
+	nats natsConfig
 }
 
 // queueMessage 是发送到 RabbitMQ 的 JSON 负载。
 type queueMessage struct {
-	TS             string          `json:"ts"`
-	Topic          string          `json:"topic"`
-	Payload        json.RawMessage `json:"payload"`
-	QoS            uint8           `json:"qos"`
-	Retain         bool            `json:"retain"`
-	ClientID       string          `json:"client_id,omitempty"`
-	Username       string          `json:"username,omitempty"`
-	Peer           string          `json:"peer,omitempty"`
-	Protocol       string          `json:"protocol,omitempty"`
-	UserProperties []userProperty  `json:"user_properties,omitempty"`
+	TS      string          `json:"ts"`
+	Topic   string          `json:"topic"`
+	Payload json.RawMessage `json:"payload"`
+	// PayloadEncoding 在 payload 不是原样内嵌的 JSON 时给出编码（base64/text）。
+	PayloadEncoding string         `json:"payload_encoding,omitempty"`
+	QoS             uint8          `json:"qos"`
+	Retain          bool           `json:"retain"`
+	ClientID        string         `json:"client_id,omitempty"`
+	Username        string         `json:"username,omitempty"`
+	Peer            string         `json:"peer,omitempty"`
+	Protocol        string         `json:"protocol,omitempty"`
+	UserProperties  []userProperty `json:"user_properties,omitempty"`
+}
+
+// queuedMessage 是内存队列中的一条待发送消息。
+type queuedMessage struct {
+	// routingKey 为按 queue_routing_key 模板渲染后的结果；kafka 后端为按 queue_kafka_topic 渲染的 topic，
+	// nats 后端为按 queue_nats_subject 渲染的 subject。
+	routingKey string
+	// key 为 kafka 消息键（queue_kafka_key）或 nats 的 Nats-Msg-Id，空表示不设置。
+	key string
+	// headers 在 headers exchange 或 raw 模式下设置（消息元数据）。
+	headers amqp.Table
+	// contentType 为空时按 application/json 发送。
+	contentType string
+	body        []byte
+	// clientID 用于 queue_ordering=client 时选择 worker。
+	clientID string
+	// attempt 为已重试次数（仅 queue_confirm=true）。
+	attempt int
 }
 
 // userProperty 对应 MQTT v5 的用户属性。
@@ -50,11 +112,14 @@ type userProperty struct {
 }
 
 var (
-	cfg       config
-	publisher amqpPublisher
+	cfg config
+	// publisher 由 plugin_init 按 queue_backend 创建。
+	publisher Publisher
 
 	debugFilterCounter  uint64
 	debugPublishCounter uint64
 	workerWarnCounter   uint64
 	backpressureCounter uint64
+	retryWarnCounter    uint64
+	returnWarnCounter   uint64
 )